@@ -0,0 +1,489 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/zyriu/portfolio/backend"
+	"github.com/zyriu/portfolio/backend/helpers/backup"
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+	"github.com/zyriu/portfolio/backend/helpers/performance"
+	"github.com/zyriu/portfolio/backend/helpers/rebuild"
+	"github.com/zyriu/portfolio/backend/helpers/settings"
+	"github.com/zyriu/portfolio/backend/helpers/tax"
+	"github.com/zyriu/portfolio/backend/helpers/trades"
+	"github.com/zyriu/portfolio/backend/helpers/webhook"
+)
+
+var commands = map[string]func(args []string) error{
+	"backups": runBackups,
+	"import":  runImport,
+	"rebuild": runRebuild,
+	"report":  runReport,
+	"serve":   runServe,
+}
+
+// IsCommand reports whether name is a command handled without starting the desktop app
+func IsCommand(name string) bool {
+	_, ok := commands[name]
+	return ok
+}
+
+// Run executes the command named by args[0] with the remaining arguments
+func Run(args []string) error {
+	if len(args) == 0 {
+		return errors.New("no command given")
+	}
+
+	run, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	return run(args[1:])
+}
+
+func runBackups(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: portfolio backups <list|restore|keygen> [options]")
+	}
+
+	switch args[0] {
+	case "list":
+		return listBackups()
+	case "restore":
+		return restoreBackup(args[1:])
+	case "keygen":
+		return generateKey(args[1:])
+	default:
+		return fmt.Errorf("unknown backups subcommand %q", args[0])
+	}
+}
+
+func listBackups() error {
+	settingsData, err := settings.LoadSettings()
+	if err != nil {
+		return fmt.Errorf("failed to load settings: %v", err)
+	}
+
+	if settingsData.Grist.BackupPath == "" {
+		return errors.New("grist backup path not configured in settings")
+	}
+
+	backups, err := backup.List(backup.Location(settingsData.Grist.BackupPath))
+	if err != nil {
+		return err
+	}
+
+	for _, b := range backups {
+		fmt.Printf("%s\t%s\t%d bytes\n", time.Unix(b.Time, 0).UTC().Format(time.RFC3339), b.Name, b.Size)
+	}
+
+	return nil
+}
+
+func restoreBackup(args []string) error {
+	fs := flag.NewFlagSet("backups restore", flag.ContinueOnError)
+	passphrase := fs.String("passphrase", "", "passphrase used to encrypt the backup (defaults to settings)")
+	keyFile := fs.String("key-file", "", "key file used to encrypt the backup (defaults to settings)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: portfolio backups restore [-passphrase p | -key-file f] <backup> <output.grist>")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("restore expects a backup and an output path")
+	}
+
+	src, dst := fs.Arg(0), fs.Arg(1)
+
+	secret := backup.Secret{Passphrase: *passphrase, KeyFile: *keyFile}
+	settingsData, err := settings.LoadSettings()
+	if err == nil {
+		if secret.Passphrase == "" && secret.KeyFile == "" {
+			secret.Passphrase = settingsData.Grist.BackupEncryption.Passphrase
+			secret.KeyFile = settingsData.Grist.BackupEncryption.KeyFile
+		}
+
+		// Accept a bare backup name as listed by "backups list"
+		if _, statErr := os.Stat(src); os.IsNotExist(statErr) && settingsData.Grist.BackupPath != "" {
+			dir, _ := backup.Location(settingsData.Grist.BackupPath)
+			src = filepath.Join(dir, src)
+		}
+	}
+
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+
+	if err := backup.Restore(src, dst, secret); err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Restored %s to %s\n", src, dst)
+	return nil
+}
+
+func generateKey(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: portfolio backups keygen <path>")
+	}
+
+	if err := backup.GenerateKeyFile(args[0]); err != nil {
+		return fmt.Errorf("generate key file: %w", err)
+	}
+
+	fmt.Printf("✓ Key written to %s, set it as the backup key file in settings and keep a copy off the NAS\n", args[0])
+	return nil
+}
+
+func runReport(args []string) error {
+	if len(args) > 0 && args[0] == "unmatched" {
+		return runUnmatchedReport(args[1:])
+	}
+
+	if len(args) > 0 && args[0] == "performance" {
+		return runPerformanceReport(args[1:])
+	}
+
+	if len(args) == 0 || args[0] != "gains" {
+		return errors.New("usage: portfolio report <gains|unmatched|performance> [options]")
+	}
+
+	method, includeFees := "", false
+	if settingsData, err := settings.LoadSettings(); err == nil {
+		method = settingsData.Settings.Tax.LotMethod
+		includeFees = settingsData.Settings.Tax.IncludeFees
+	}
+
+	fs := flag.NewFlagSet("report gains", flag.ContinueOnError)
+	year := fs.Int("year", time.Now().Year()-1, "tax year to report")
+	lotMethod := fs.String("method", method, "lot matching method: FIFO, LIFO, HIFO or SpecificID")
+	fees := fs.Bool("fees", includeFees, "report gains net of fees")
+	format := fs.String("format", "csv", "output format: csv or html")
+	source := fs.String("backup", "", "read trades from a restored .grist backup instead of the Grist API")
+	output := fs.String("o", "", "output file (defaults to stdout)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	var g *grist.Grist
+	if *source != "" {
+		local, err := grist.OpenBackup(*source)
+		if err != nil {
+			return err
+		}
+		defer local.Close()
+		g = local
+	} else {
+		client, err := grist.InitiateClient()
+		if err != nil {
+			return err
+		}
+		g = &client
+	}
+
+	report, err := tax.Load(context.Background(), g, *year, *lotMethod, *fees)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return tax.Write(os.Stdout, report, *format)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+
+	if err := tax.Write(f, report, *format); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Wrote %d disposals of %d to %s\n", len(report.Disposals), report.Year, *output)
+	return nil
+}
+
+// runUnmatchedReport lists the sells that exceeded the booked position, as recorded by the
+// exchange jobs and book rebuilds
+func runUnmatchedReport(args []string) error {
+	fs := flag.NewFlagSet("report unmatched", flag.ContinueOnError)
+	source := fs.String("backup", "", "read from a restored .grist backup instead of the Grist API")
+	output := fs.String("o", "", "output file (defaults to stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var g *grist.Grist
+	if *source != "" {
+		local, err := grist.OpenBackup(*source)
+		if err != nil {
+			return err
+		}
+		defer local.Close()
+		g = local
+	} else {
+		client, err := grist.InitiateClient()
+		if err != nil {
+			return err
+		}
+		g = &client
+	}
+
+	disposals, err := g.FetchUnmatchedDisposals(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return tax.WriteUnmatched(os.Stdout, disposals)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+
+	if err := tax.WriteUnmatched(f, disposals); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Wrote %d unmatched disposals to %s\n", len(disposals), *output)
+	return nil
+}
+
+// runImport upserts opening balances from a CSV into the Opening_Balances table
+func runImport(args []string) error {
+	if len(args) != 2 || args[0] != "openings" {
+		return errors.New("usage: portfolio import openings <file.csv>")
+	}
+
+	f, err := os.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	balances, err := trades.ReadOpeningBalances(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[1], err)
+	}
+
+	g, err := grist.InitiateClient()
+	if err != nil {
+		return err
+	}
+
+	if err := g.SaveOpeningBalances(context.Background(), balances); err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Imported %d opening balances, run \"portfolio rebuild book\" to book them\n", len(balances))
+	return nil
+}
+
+// runPerformanceReport writes the time and money weighted returns of the portfolio, its wallets
+// or its exchanges from the snapshot history and transfers
+func runPerformanceReport(args []string) error {
+	now := time.Now().UTC()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	fs := flag.NewFlagSet("report performance", flag.ContinueOnError)
+	from := fs.String("from", monthStart.AddDate(0, -1, 0).Format(time.DateOnly), "start of the period, YYYY-MM-DD")
+	to := fs.String("to", monthStart.Format(time.DateOnly), "end of the period, YYYY-MM-DD")
+	by := fs.String("by", "portfolio", "scope: portfolio, wallet or exchange")
+	monthly := fs.Bool("monthly", false, "one row per calendar month of the period")
+	source := fs.String("backup", "", "read from a restored .grist backup instead of the Grist API")
+	output := fs.String("o", "", "output file (defaults to stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	start, err := time.Parse(time.DateOnly, *from)
+	if err != nil {
+		return fmt.Errorf("invalid -from: %w", err)
+	}
+
+	end, err := time.Parse(time.DateOnly, *to)
+	if err != nil {
+		return fmt.Errorf("invalid -to: %w", err)
+	}
+
+	if !end.After(start) {
+		return errors.New("-to must be after -from")
+	}
+
+	periods := []performance.Period{{From: start, To: end}}
+	if *monthly {
+		periods = performance.Monthly(start, end)
+	}
+
+	var g *grist.Grist
+	if *source != "" {
+		local, err := grist.OpenBackup(*source)
+		if err != nil {
+			return err
+		}
+		defer local.Close()
+		g = local
+	} else {
+		client, err := grist.InitiateClient()
+		if err != nil {
+			return err
+		}
+		g = &client
+	}
+
+	settingsData, err := settings.LoadSettings()
+	if err != nil {
+		return err
+	}
+
+	labels := make(map[string]string)
+	for _, w := range settingsData.Wallets {
+		labels[strings.ToLower(w.Address)] = w.Label
+	}
+
+	returns, err := performance.Load(context.Background(), g, *by, periods, labels)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return performance.Write(os.Stdout, returns)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+
+	if err := performance.Write(f, returns); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Wrote %d returns to %s\n", len(returns), *output)
+	return nil
+}
+
+// runRebuild replays the stored trades to rewrite the Book and the PnL of every trade
+func runRebuild(args []string) error {
+	if len(args) == 0 || args[0] != "book" {
+		return errors.New("usage: portfolio rebuild book [options]")
+	}
+
+	currency, includeFees, method := "", false, ""
+	if settingsData, err := settings.LoadSettings(); err == nil {
+		currency = settingsData.Settings.FX.ReportingCurrency
+		includeFees = settingsData.Settings.Tax.IncludeFees
+		method = settingsData.Settings.Tax.LotMethod
+	}
+
+	fs := flag.NewFlagSet("rebuild book", flag.ContinueOnError)
+	exchange := fs.String("exchange", "", "exchange to rebuild, as stored in Trades (defaults to every exchange)")
+	dryRun := fs.Bool("dry-run", false, "print the differences without writing them")
+	reporting := fs.String("currency", currency, "currency to book in")
+	fees := fs.Bool("fees", includeFees, "book net of fees")
+	source := fs.String("backup", "", "read from a restored .grist backup instead of the Grist API, implies -dry-run")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	var g *grist.Grist
+	if *source != "" {
+		local, err := grist.OpenBackup(*source)
+		if err != nil {
+			return err
+		}
+		defer local.Close()
+		g = local
+		*dryRun = true
+	} else {
+		client, err := grist.InitiateClient()
+		if err != nil {
+			return err
+		}
+		g = &client
+	}
+
+	ctx := context.Background()
+	plan, err := rebuild.Load(ctx, g, *exchange, *reporting, *fees, method)
+	if err != nil {
+		return err
+	}
+
+	if err := rebuild.Write(os.Stdout, plan); err != nil {
+		return err
+	}
+
+	if *dryRun || len(plan.Changes) == 0 {
+		return nil
+	}
+
+	if err := rebuild.Apply(ctx, g, plan); err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Rewrote %d book entries, %d trades and %d lots\n", len(plan.Book), len(plan.Trades), len(plan.Lots))
+	return nil
+}
+
+// runServe runs the enabled jobs and the webhook receiver without the desktop window
+func runServe(args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: portfolio serve")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	m := backend.NewManager()
+	m.Startup(ctx)
+
+	if err := m.SyncJobsWithSettings(); err != nil {
+		return fmt.Errorf("failed to sync jobs with settings: %v", err)
+	}
+	defer m.StopWebhookReceiver()
+
+	for _, job := range m.Jobs() {
+		fmt.Printf("✓ Running %s every %ds\n", job.Name, job.Interval)
+	}
+
+	settingsData, err := settings.LoadSettings()
+	if err == nil && settingsData.Grist.Webhook.Enabled {
+		fmt.Printf("✓ Listening for Grist webhooks on http://%s%s<table>\n", settingsData.Grist.Webhook.Address, webhook.PathPrefix)
+	}
+
+	<-ctx.Done()
+	fmt.Println("Shutting down...")
+	return nil
+}
@@ -0,0 +1,330 @@
+package backup
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	timestampLayout = "20060102T150405Z"
+	extension       = ".grist"
+	gzipExtension   = ".gz"
+	encExtension    = ".enc"
+)
+
+type Backup struct {
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	Time       int64  `json:"time"`
+	Size       int64  `json:"size"`
+	Compressed bool   `json:"compressed"`
+	Encrypted  bool   `json:"encrypted"`
+}
+
+type Retention struct {
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+// DefaultRetention keeps a week of dailies, a month of weeklies and a year of monthlies
+var DefaultRetention = Retention{Daily: 7, Weekly: 4, Monthly: 12}
+
+// Location splits the configured backup path into the backup directory and the file name stem
+func Location(backupPath string) (string, string) {
+	base := filepath.Base(backupPath)
+	stem, _, _ := strings.Cut(base, ".")
+	if stem == "" {
+		stem = "backup"
+	}
+
+	return filepath.Dir(backupPath), stem
+}
+
+// FileName returns the timestamped backup file name for stem at t
+func FileName(stem string, t time.Time, compressed bool, encrypted bool) string {
+	name := fmt.Sprintf("%s-%s%s", stem, t.UTC().Format(timestampLayout), extension)
+	if compressed {
+		name += gzipExtension
+	}
+	if encrypted {
+		name += encExtension
+	}
+
+	return name
+}
+
+func parseFileName(stem string, name string) (time.Time, string, bool) {
+	rest, ok := strings.CutPrefix(name, stem+"-")
+	if !ok || len(rest) < len(timestampLayout) {
+		return time.Time{}, "", false
+	}
+
+	t, err := time.Parse(timestampLayout, rest[:len(timestampLayout)])
+	if err != nil {
+		return time.Time{}, "", false
+	}
+
+	suffix := rest[len(timestampLayout):]
+	if !strings.HasPrefix(suffix, extension) {
+		return time.Time{}, "", false
+	}
+
+	return t, suffix, true
+}
+
+// List returns the backups of stem found in dir, newest first
+func List(dir string, stem string) ([]Backup, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var backups []Backup
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		t, suffix, ok := parseFileName(stem, e.Name())
+		if !ok {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		backups = append(backups, Backup{
+			Name:       e.Name(),
+			Path:       filepath.Join(dir, e.Name()),
+			Time:       t.Unix(),
+			Size:       info.Size(),
+			Compressed: strings.Contains(suffix, gzipExtension),
+			Encrypted:  strings.HasSuffix(suffix, encExtension),
+		})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Time > backups[j].Time
+	})
+
+	return backups, nil
+}
+
+// Expired returns the backups not covered by the retention policy. The newest backup of
+// each of the last Daily days, Weekly ISO weeks and Monthly months is kept, as is the
+// newest backup overall. An empty policy, as left by settings predating retention, falls
+// back to DefaultRetention. backups must be sorted newest first, as returned by List.
+func Expired(backups []Backup, policy Retention) []Backup {
+	if policy == (Retention{}) {
+		policy = DefaultRetention
+	}
+
+	keep := make(map[string]bool)
+	if len(backups) > 0 {
+		keep[backups[0].Name] = true
+	}
+
+	buckets := []struct {
+		limit int
+		key   func(time.Time) string
+	}{
+		{policy.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{policy.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{policy.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+
+	for _, bucket := range buckets {
+		seen := make(map[string]bool)
+		for _, b := range backups {
+			if len(seen) >= bucket.limit {
+				break
+			}
+
+			key := bucket.key(time.Unix(b.Time, 0).UTC())
+			if seen[key] {
+				continue
+			}
+
+			seen[key] = true
+			keep[b.Name] = true
+		}
+	}
+
+	var expired []Backup
+	for _, b := range backups {
+		if !keep[b.Name] {
+			expired = append(expired, b)
+		}
+	}
+
+	return expired
+}
+
+// Prune deletes the backups of stem in dir that fall outside the retention policy
+func Prune(dir string, stem string, policy Retention) ([]Backup, error) {
+	backups, err := List(dir, stem)
+	if err != nil {
+		return nil, err
+	}
+
+	expired := Expired(backups, policy)
+	for _, b := range expired {
+		if err := os.Remove(b.Path); err != nil {
+			return nil, fmt.Errorf("remove %s: %w", b.Name, err)
+		}
+	}
+
+	return expired, nil
+}
+
+// Verify checks that path is an intact SQLite database holding a Grist document
+func Verify(path string) error {
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("integrity check: %w", err)
+	}
+
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM _grist_Tables").Scan(&tables); err != nil {
+		return fmt.Errorf("not a grist document: %w", err)
+	}
+
+	if tables == 0 {
+		return fmt.Errorf("grist document has no tables")
+	}
+
+	return nil
+}
+
+// Compress writes a gzip copy of src to dst, with the permissions of src
+func Compress(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(out)
+	zw.Name = filepath.Base(strings.TrimSuffix(dst, gzipExtension))
+
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// Decompress writes the gunzipped content of src to dst
+func Decompress(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, zr); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// Restore decrypts and decompresses the backup at src as needed, verifies it and writes
+// the resulting Grist document to dst
+func Restore(src string, dst string, secret Secret) error {
+	tmp, err := os.MkdirTemp(filepath.Dir(dst), ".restore-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	current := src
+
+	encrypted, err := IsEncrypted(current)
+	if err != nil {
+		return err
+	}
+
+	if encrypted {
+		next := filepath.Join(tmp, "decrypted")
+		if err := Decrypt(current, next, secret); err != nil {
+			return err
+		}
+		current = next
+	}
+
+	if strings.Contains(filepath.Base(src), extension+gzipExtension) {
+		next := filepath.Join(tmp, "decompressed")
+		if err := Decompress(current, next); err != nil {
+			return fmt.Errorf("decompress backup: %w", err)
+		}
+		current = next
+	}
+
+	if err := Verify(current); err != nil {
+		return fmt.Errorf("restored document is invalid: %w", err)
+	}
+
+	in, err := os.ReadFile(current)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(dst, in, 0600)
+}
@@ -0,0 +1,203 @@
+package backup
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const templatePath = "../../../template.grist"
+
+func touch(t *testing.T, dir string, name string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+}
+
+func TestLocation(t *testing.T) {
+	dir, stem := Location("/data/backups/portfolio.grist")
+	if dir != "/data/backups" || stem != "portfolio" {
+		t.Errorf("unexpected location %q %q", dir, stem)
+	}
+}
+
+func TestFileNameRoundTrip(t *testing.T) {
+	at := time.Date(2026, 3, 1, 14, 30, 5, 0, time.UTC)
+	name := FileName("portfolio", at, true, false)
+
+	if name != "portfolio-20260301T143005Z.grist.gz" {
+		t.Fatalf("unexpected file name %s", name)
+	}
+
+	parsed, _, ok := parseFileName("portfolio", name)
+	if !ok || !parsed.Equal(at) {
+		t.Errorf("expected %v, got %v (ok=%v)", at, parsed, ok)
+	}
+}
+
+func TestListSortsNewestFirstAndIgnoresOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	touch(t, dir, "portfolio-20260101T000000Z.grist")
+	touch(t, dir, "portfolio-20260301T000000Z.grist.gz")
+	touch(t, dir, "portfolio-20260201T000000Z.grist")
+	touch(t, dir, "portfolio.grist")
+	touch(t, dir, "other-20260401T000000Z.grist")
+
+	backups, err := List(dir, "portfolio")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(backups) != 3 {
+		t.Fatalf("expected 3 backups, got %d", len(backups))
+	}
+	if backups[0].Name != "portfolio-20260301T000000Z.grist.gz" || !backups[0].Compressed {
+		t.Errorf("expected newest compressed backup first, got %+v", backups[0])
+	}
+	if backups[2].Name != "portfolio-20260101T000000Z.grist" {
+		t.Errorf("expected oldest backup last, got %s", backups[2].Name)
+	}
+}
+
+func TestExpired_KeepsNewestPerBucket(t *testing.T) {
+	var backups []Backup
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	// Two backups per day for 90 days, newest first
+	for day := 89; day >= 0; day-- {
+		for _, hour := range []int{18, 6} {
+			at := start.AddDate(0, 0, day).Add(time.Duration(hour-12) * time.Hour)
+			backups = append(backups, Backup{Name: FileName("p", at, false, false), Time: at.Unix()})
+		}
+	}
+
+	expired := Expired(backups, Retention{Daily: 7, Weekly: 4, Monthly: 3})
+
+	kept := len(backups) - len(expired)
+	// 7 daily, up to 4 weekly and 3 monthly, with overlaps between the buckets
+	if kept < 7 || kept > 14 {
+		t.Fatalf("expected between 7 and 14 backups kept, got %d", kept)
+	}
+
+	expiredNames := make(map[string]bool)
+	for _, b := range expired {
+		expiredNames[b.Name] = true
+	}
+
+	if expiredNames[backups[0].Name] {
+		t.Error("newest backup must never expire")
+	}
+	if !expiredNames[backups[1].Name] {
+		t.Error("older backup of the same day should expire")
+	}
+	if expiredNames[backups[2].Name] {
+		t.Error("newest backup of the previous day should be kept")
+	}
+}
+
+func TestExpired_ZeroPolicyFallsBackToDefault(t *testing.T) {
+	// one backup a day for 10 days, newest first
+	var backups []Backup
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		backups = append(backups, Backup{Name: fmt.Sprint(i), Time: start.AddDate(0, 0, -i).Unix()})
+	}
+
+	got := Expired(backups, Retention{})
+	want := Expired(backups, DefaultRetention)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected the default policy, got %+v want %+v", got, want)
+	}
+	if len(got) >= len(backups)-1 {
+		t.Errorf("expected the last week of backups to be kept, got %d expired", len(got))
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	touch(t, dir, "p-20260101T000000Z.grist")
+	touch(t, dir, "p-20260101T120000Z.grist")
+
+	expired, err := Prune(dir, "p", Retention{Daily: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(expired) != 1 {
+		t.Fatalf("expected 1 expired backup, got %d", len(expired))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "p-20260101T000000Z.grist")); !os.IsNotExist(err) {
+		t.Error("expected expired backup to be removed")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	if err := Verify(templatePath); err != nil {
+		t.Fatalf("expected template to verify, got %v", err)
+	}
+
+	dir := t.TempDir()
+	corrupted := filepath.Join(dir, "corrupted.grist")
+	if err := os.WriteFile(corrupted, []byte("<html>502 Bad Gateway</html>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Verify(corrupted); err == nil {
+		t.Error("expected corrupted backup to fail verification")
+	}
+}
+
+func TestCompress(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "p-20260101T000000Z.grist.gz")
+
+	if err := Compress(templatePath, dst); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	f, err := os.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("expected gzip stream: %v", err)
+	}
+
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := os.ReadFile(templatePath)
+	if len(got) != len(want) {
+		t.Errorf("expected %d bytes after decompression, got %d", len(want), len(got))
+	}
+}
+
+func TestCompress_KeepsPermissions(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "plain.grist")
+	if err := os.WriteFile(src, []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := src + ".gz"
+	if err := Compress(src, dst); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("expected the compressed copy to stay private, got %o", perm)
+	}
+}
@@ -0,0 +1,198 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted backups are a single AES-256-GCM message prefixed by a header that is
+// also authenticated as additional data:
+//
+//	magic (8) | kdf (1) | salt (16) | nonce (12) | ciphertext + tag
+const (
+	encryptedMagic = "PFBKENC1"
+	saltSize       = 16
+	keySize        = 32
+	headerSize     = len(encryptedMagic) + 1 + saltSize + 12
+
+	kdfKeyFile byte = 0
+	kdfScrypt  byte = 1
+)
+
+var ErrNoSecret = errors.New("backup encryption requires a passphrase or a key file")
+
+// Secret holds the passphrase or key file used to encrypt backups. A key file takes
+// precedence and must contain 32 bytes, either raw or hex/base64 encoded.
+type Secret struct {
+	Passphrase string
+	KeyFile    string
+}
+
+func (s Secret) kdf() (byte, error) {
+	switch {
+	case s.KeyFile != "":
+		return kdfKeyFile, nil
+	case s.Passphrase != "":
+		return kdfScrypt, nil
+	default:
+		return 0, ErrNoSecret
+	}
+}
+
+func (s Secret) key(kdf byte, salt []byte) ([]byte, error) {
+	switch kdf {
+	case kdfKeyFile:
+		if s.KeyFile == "" {
+			return nil, errors.New("backup was encrypted with a key file")
+		}
+		return readKeyFile(s.KeyFile)
+	case kdfScrypt:
+		if s.Passphrase == "" {
+			return nil, errors.New("backup was encrypted with a passphrase")
+		}
+		return scrypt.Key([]byte(s.Passphrase), salt, 1<<15, 8, 1, keySize)
+	default:
+		return nil, fmt.Errorf("unknown key derivation %d", kdf)
+	}
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+
+	if len(raw) == keySize {
+		return raw, nil
+	}
+
+	text := strings.TrimSpace(string(raw))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == keySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == keySize {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("key file must contain %d bytes, raw or hex/base64 encoded", keySize)
+}
+
+// GenerateKeyFile writes a new random hex-encoded key to path, refusing to overwrite
+func GenerateKeyFile(path string) error {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// IsEncrypted reports whether the file at path starts with the encrypted backup header
+func IsEncrypted(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(encryptedMagic))
+	if _, err := f.Read(magic); err != nil {
+		return false, nil
+	}
+
+	return string(magic) == encryptedMagic, nil
+}
+
+// Encrypt seals the content of src into dst with a key derived from secret
+func Encrypt(src string, dst string, secret Secret) error {
+	kdf, err := secret.kdf()
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, encryptedMagic)
+	header[len(encryptedMagic)] = kdf
+
+	salt := header[len(encryptedMagic)+1 : len(encryptedMagic)+1+saltSize]
+	nonce := header[len(encryptedMagic)+1+saltSize:]
+	if _, err := rand.Read(header[len(encryptedMagic)+1:]); err != nil {
+		return err
+	}
+
+	aead, err := newAEAD(secret, kdf, salt)
+	if err != nil {
+		return err
+	}
+
+	sealed := aead.Seal(bytes.Clone(header), nonce, plaintext, header)
+	return os.WriteFile(dst, sealed, 0600)
+}
+
+// Decrypt opens the encrypted backup src into dst, failing if it was tampered with
+func Decrypt(src string, dst string, secret Secret) error {
+	sealed, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	if len(sealed) < headerSize || string(sealed[:len(encryptedMagic)]) != encryptedMagic {
+		return errors.New("not an encrypted backup")
+	}
+
+	header := sealed[:headerSize]
+	kdf := header[len(encryptedMagic)]
+	salt := header[len(encryptedMagic)+1 : len(encryptedMagic)+1+saltSize]
+	nonce := header[len(encryptedMagic)+1+saltSize:]
+
+	aead, err := newAEAD(secret, kdf, salt)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := aead.Open(nil, nonce, sealed[headerSize:], header)
+	if err != nil {
+		return errors.New("decrypt backup: wrong key or corrupted file")
+	}
+
+	return os.WriteFile(dst, plaintext, 0600)
+}
+
+func newAEAD(secret Secret, kdf byte, salt []byte) (cipher.AEAD, error) {
+	key, err := secret.key(kdf, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
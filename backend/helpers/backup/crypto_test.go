@@ -0,0 +1,140 @@
+package backup
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path string, content []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestEncryptDecrypt_Passphrase(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain")
+	sealed := filepath.Join(dir, "sealed")
+	opened := filepath.Join(dir, "opened")
+	writeFile(t, plain, []byte("wallets and balances"))
+
+	secret := Secret{Passphrase: "correct horse"}
+	if err := Encrypt(plain, sealed, secret); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	raw, _ := os.ReadFile(sealed)
+	if bytes.Contains(raw, []byte("wallets")) {
+		t.Fatal("encrypted backup contains plaintext")
+	}
+
+	if ok, err := IsEncrypted(sealed); err != nil || !ok {
+		t.Fatalf("expected sealed file to be detected as encrypted (err=%v)", err)
+	}
+
+	if err := Decrypt(sealed, opened, secret); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, _ := os.ReadFile(opened)
+	if string(got) != "wallets and balances" {
+		t.Errorf("unexpected decrypted content %q", got)
+	}
+
+	if err := Decrypt(sealed, opened, Secret{Passphrase: "wrong"}); err == nil {
+		t.Error("expected wrong passphrase to fail")
+	}
+}
+
+func TestEncryptDecrypt_KeyFile(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "backup.key")
+	if err := GenerateKeyFile(keyFile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := GenerateKeyFile(keyFile); err == nil {
+		t.Error("expected existing key file not to be overwritten")
+	}
+
+	plain := filepath.Join(dir, "plain")
+	sealed := filepath.Join(dir, "sealed")
+	opened := filepath.Join(dir, "opened")
+	writeFile(t, plain, []byte("trades"))
+
+	secret := Secret{KeyFile: keyFile}
+	if err := Encrypt(plain, sealed, secret); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := Decrypt(sealed, opened, Secret{Passphrase: "trades"}); err == nil {
+		t.Error("expected passphrase not to open a key file backup")
+	}
+
+	if err := Decrypt(sealed, opened, secret); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestEncrypt_RequiresSecret(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain")
+	writeFile(t, plain, []byte("x"))
+
+	if err := Encrypt(plain, filepath.Join(dir, "sealed"), Secret{}); err != ErrNoSecret {
+		t.Errorf("expected ErrNoSecret, got %v", err)
+	}
+}
+
+func TestDecrypt_DetectsTampering(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain")
+	sealed := filepath.Join(dir, "sealed")
+	writeFile(t, plain, []byte("balances"))
+
+	secret := Secret{Passphrase: "p"}
+	if err := Encrypt(plain, sealed, secret); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := os.ReadFile(sealed)
+	for _, i := range []int{len(encryptedMagic), headerSize, len(raw) - 1} {
+		tampered := bytes.Clone(raw)
+		tampered[i] ^= 0x01
+		writeFile(t, sealed, tampered)
+
+		if err := Decrypt(sealed, filepath.Join(dir, "opened"), secret); err == nil {
+			t.Errorf("expected tampering at byte %d to be detected", i)
+		}
+	}
+}
+
+func TestRestore_EncryptedCompressed(t *testing.T) {
+	dir := t.TempDir()
+	compressed := filepath.Join(dir, "p.grist.gz")
+	sealed := filepath.Join(dir, "p-20260101T000000Z.grist.gz.enc")
+	restored := filepath.Join(dir, "restored.grist")
+
+	secret := Secret{Passphrase: "p"}
+	if err := Compress(templatePath, compressed); err != nil {
+		t.Fatal(err)
+	}
+	if err := Encrypt(compressed, sealed, secret); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Restore(sealed, restored, Secret{Passphrase: "wrong"}); err == nil {
+		t.Error("expected restore with the wrong passphrase to fail")
+	}
+
+	if err := Restore(sealed, restored, secret); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := Verify(restored); err != nil {
+		t.Errorf("expected restored document to verify, got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package fx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+	"github.com/zyriu/portfolio/backend/helpers/misc"
+)
+
+// Frankfurter serves the daily ECB reference rates without an API key
+const apiBaseUrl = "https://api.frankfurter.app"
+
+const dateLayout = "2006-01-02"
+
+type series struct {
+	Base  string                        `json:"base"`
+	Rates map[string]map[string]float64 `json:"rates"`
+}
+
+// FetchRates returns the daily rates of currencies between start and end, as the USD value
+// of one unit. Days without a publication, such as weekends, are absent.
+func FetchRates(ctx context.Context, start time.Time, end time.Time, currencies []string) ([]grist.FXRate, error) {
+	var symbols []string
+	for _, c := range currencies {
+		if c = Normalize(c); c != Base && IsFiat(c) {
+			symbols = append(symbols, c)
+		}
+	}
+
+	if len(symbols) == 0 {
+		return nil, nil
+	}
+
+	q := url.Values{}
+	q.Set("from", Base)
+	q.Set("to", strings.Join(symbols, ","))
+	u := fmt.Sprintf("%s/%s..%s?%s", apiBaseUrl, start.UTC().Format(dateLayout), end.UTC().Format(dateLayout), q.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := misc.DoWithRetry(ctx, req)
+	if resp.Err != nil {
+		return nil, fmt.Errorf("send request: %w", resp.Err)
+	}
+
+	var s series
+	if err := json.Unmarshal(resp.Body, &s); err != nil {
+		return nil, fmt.Errorf("unmarshal response: %w", err)
+	}
+
+	return parseSeries(s)
+}
+
+// parseSeries inverts the quoted amounts per USD into USD per unit
+func parseSeries(s series) ([]grist.FXRate, error) {
+	var rates []grist.FXRate
+	for day, quotes := range s.Rates {
+		date, err := time.Parse(dateLayout, day)
+		if err != nil {
+			return nil, fmt.Errorf("parse date %q: %w", day, err)
+		}
+
+		for currency, perUSD := range quotes {
+			if perUSD <= 0 {
+				continue
+			}
+			rates = append(rates, grist.FXRate{Date: date.Unix(), Currency: currency, Rate: 1 / perUSD})
+		}
+	}
+
+	return rates, nil
+}
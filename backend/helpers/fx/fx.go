@@ -0,0 +1,254 @@
+package fx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+// Base is the currency rates are quoted against and the one exchange prices default to
+const Base = "USD"
+
+var fiat = map[string]bool{
+	"AUD": true, "CAD": true, "CHF": true, "EUR": true, "GBP": true, "HKD": true,
+	"JPY": true, "SGD": true, "USD": true,
+}
+
+// stablecoins pegged to a fiat currency other than the one their name suggests
+var pegged = map[string]string{
+	"EURC": "EUR", "EURT": "EUR", "EURS": "EUR", "XSGD": "SGD",
+}
+
+// Normalize maps exchange specific currency codes and stablecoins to their fiat currency,
+// and leaves any other asset untouched
+func Normalize(code string) string {
+	c := strings.ToUpper(strings.TrimSpace(code))
+
+	if p, ok := pegged[c]; ok {
+		return p
+	}
+
+	// Kraken prefixes fiat currencies with Z, as in ZEUR and ZUSD
+	if len(c) == 4 && c[0] == 'Z' && fiat[c[1:]] {
+		return c[1:]
+	}
+
+	if c == "DAI" || c == "USX" || strings.Contains(c, "USD") {
+		return Base
+	}
+
+	return c
+}
+
+// Reporting returns the configured reporting currency, Base when unset
+func Reporting(currency string) string {
+	if strings.TrimSpace(currency) == "" {
+		return Base
+	}
+
+	return Normalize(currency)
+}
+
+// IsFiat reports whether code normalizes to a fiat currency
+func IsFiat(code string) bool {
+	return fiat[Normalize(code)]
+}
+
+type point struct {
+	date int64
+	rate float64
+}
+
+// Rates holds daily rates per currency, each as the amount of Base one unit buys
+type Rates struct {
+	series map[string][]point
+}
+
+// NewRates indexes the rates stored in Grist
+func NewRates(rows []grist.FXRate) *Rates {
+	r := &Rates{series: make(map[string][]point)}
+	for _, row := range rows {
+		r.Add(row.Currency, row.Date, row.Rate)
+	}
+
+	return r
+}
+
+// Load reads the rates stored in Grist. A read-only backup without the FX_Rates table
+// yields no rates, so that USD-only histories still load.
+func Load(ctx context.Context, g *grist.Grist) (*Rates, error) {
+	rows, err := g.FetchFXRates(ctx)
+	if err != nil && !errors.Is(err, grist.ErrReadOnly) {
+		return nil, fmt.Errorf("failed to fetch FX rates: %w", err)
+	}
+
+	return NewRates(rows), nil
+}
+
+// Add records the Base value of one unit of currency on date, in unix seconds
+func (r *Rates) Add(currency string, date int64, rate float64) {
+	if rate <= 0 {
+		return
+	}
+
+	c := Normalize(currency)
+	s := r.series[c]
+	i := sort.Search(len(s), func(i int) bool { return s[i].date >= date })
+	if i < len(s) && s[i].date == date {
+		s[i].rate = rate
+		return
+	}
+
+	s = append(s, point{})
+	copy(s[i+1:], s[i:])
+	s[i] = point{date: date, rate: rate}
+	r.series[c] = s
+}
+
+// Latest returns the date, in unix seconds, of the newest rate of currency
+func (r *Rates) Latest(currency string) (int64, bool) {
+	if r == nil {
+		return 0, false
+	}
+
+	s := r.series[Normalize(currency)]
+	if len(s) == 0 {
+		return 0, false
+	}
+
+	return s[len(s)-1].date, true
+}
+
+// ToBase returns the Base value of one unit of currency at t, using the latest rate
+// published on or before t, or the earliest one when t predates the series
+func (r *Rates) ToBase(currency string, t time.Time) (float64, error) {
+	c := Normalize(currency)
+	if c == Base {
+		return 1, nil
+	}
+
+	var s []point
+	if r != nil {
+		s = r.series[c]
+	}
+	if len(s) == 0 {
+		return 0, fmt.Errorf("no %s rate, enable the FX prices job", c)
+	}
+
+	at := t.Unix()
+	i := sort.Search(len(s), func(i int) bool { return s[i].date > at })
+	if i == 0 {
+		return s[0].rate, nil
+	}
+
+	return s[i-1].rate, nil
+}
+
+// Rate returns how many units of to one unit of from is worth at t
+func (r *Rates) Rate(from string, to string, t time.Time) (float64, error) {
+	if Normalize(from) == Normalize(to) {
+		return 1, nil
+	}
+
+	fromBase, err := r.ToBase(from, t)
+	if err != nil {
+		return 0, err
+	}
+
+	toBase, err := r.ToBase(to, t)
+	if err != nil {
+		return 0, err
+	}
+
+	return fromBase / toBase, nil
+}
+
+// TradeRate returns how many units of the currency of trade one unit of currency is worth at
+// the trade time
+func (r *Rates) TradeRate(currency string, trade grist.Trade) (float64, error) {
+	rate, err := r.Rate(currency, Reporting(trade.Currency), time.UnixMilli(trade.Time))
+	if err != nil {
+		return 0, fmt.Errorf("trade %s: %w", trade.TradeID, err)
+	}
+
+	return rate, nil
+}
+
+// Denominate expresses the price and value of a trade quoted in quote in currency, at the
+// rate of the trade time. Fee_USD_ stays in USD. Pairs quoted in a crypto asset have no FX
+// rate and are kept in their quote.
+func Denominate(trade grist.Trade, quote string, currency string, rates *Rates) (grist.Trade, error) {
+	if !IsFiat(quote) {
+		trade.QuoteCurrency = Normalize(quote)
+		trade.Currency = trade.QuoteCurrency
+		return trade, nil
+	}
+
+	rate, err := rates.Rate(quote, Reporting(currency), time.UnixMilli(trade.Time))
+	if err != nil {
+		return trade, fmt.Errorf("trade %s: %w", trade.TradeID, err)
+	}
+
+	trade.QuoteCurrency = Normalize(quote)
+	trade.Currency = Reporting(currency)
+	trade.FXRate = rate
+	trade.Price *= rate
+	trade.OrderValue *= rate
+
+	return trade, nil
+}
+
+// Fee returns the fee of a trade in the currency the trade is denominated in, converting
+// Fee_USD_ at the rate of the trade time unless the fee was charged in that currency
+func Fee(trade grist.Trade, rates *Rates) (float64, error) {
+	currency := Reporting(trade.Currency)
+	if trade.FeeCurrency != "" && Normalize(trade.FeeCurrency) == currency {
+		return trade.Fee, nil
+	}
+
+	if trade.FeeUSD == 0 {
+		return 0, nil
+	}
+
+	rate, err := rates.Rate(Base, currency, time.UnixMilli(trade.Time))
+	if err != nil {
+		return 0, fmt.Errorf("trade %s fee: %w", trade.TradeID, err)
+	}
+
+	return trade.FeeUSD * rate, nil
+}
+
+// FundingAmount returns a funding payment, paid in USD, in currency at the rate of its time
+func FundingAmount(payment grist.Funding, currency string, rates *Rates) (float64, error) {
+	rate, err := rates.Rate(Base, Reporting(currency), time.UnixMilli(payment.Time))
+	if err != nil {
+		return 0, fmt.Errorf("funding of %s at %d: %w", payment.Ticker, payment.Time, err)
+	}
+
+	return payment.Amount * rate, nil
+}
+
+// Redenominate converts a stored trade to currency, undoing the conversion applied when it was
+// synced. Trades stored before they carried a currency are in USD, crypto quoted trades are
+// left untouched.
+func Redenominate(trade grist.Trade, currency string, rates *Rates) (grist.Trade, error) {
+	held := Reporting(trade.Currency)
+	if !IsFiat(held) || held == Reporting(currency) {
+		return trade, nil
+	}
+
+	quote := held
+	if trade.FXRate != 0 && trade.QuoteCurrency != "" {
+		quote = trade.QuoteCurrency
+		trade.Price /= trade.FXRate
+		trade.OrderValue /= trade.FXRate
+	}
+	trade.FXRate = 0
+
+	return Denominate(trade, quote, currency, rates)
+}
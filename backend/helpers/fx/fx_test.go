@@ -0,0 +1,172 @@
+package fx
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+func day(year int, month time.Month, d int) int64 {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC).Unix()
+}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"ZEUR": "EUR", "zusd": "USD", "USDC": "USD", "DAI": "USD", "EURC": "EUR",
+		"SGD": "SGD", "ZSGD": "SGD", "BTC": "BTC", "ZRX": "ZRX",
+	}
+
+	for in, want := range tests {
+		if got := Normalize(in); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	if IsFiat("BTC") || !IsFiat("ZEUR") || !IsFiat("USDT") {
+		t.Error("unexpected IsFiat result")
+	}
+}
+
+func TestRates_UsesLatestRateOnOrBefore(t *testing.T) {
+	rates := NewRates([]grist.FXRate{
+		{Date: day(2024, 1, 5), Currency: "EUR", Rate: 1.10},
+		{Date: day(2024, 1, 2), Currency: "EUR", Rate: 1.00},
+		{Date: day(2024, 1, 2), Currency: "SGD", Rate: 0.75},
+	})
+
+	tests := []struct {
+		at   time.Time
+		want float64
+	}{
+		{time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC), 1.00}, // before the series
+		{time.Date(2024, 1, 3, 15, 0, 0, 0, time.UTC), 1.00}, // between publications
+		{time.Date(2024, 1, 6, 0, 0, 0, 0, time.UTC), 1.10},  // weekend after the last one
+	}
+
+	for _, tt := range tests {
+		got, err := rates.ToBase("ZEUR", tt.at)
+		if err != nil || got != tt.want {
+			t.Errorf("ToBase(EUR, %s) = %f (%v), want %f", tt.at, got, err, tt.want)
+		}
+	}
+
+	if rate, err := rates.Rate("EUR", "SGD", time.Unix(day(2024, 1, 3), 0)); err != nil || !approx(rate, 1/0.75) {
+		t.Errorf("expected EUR/SGD cross rate, got %f (%v)", rate, err)
+	}
+
+	if _, err := rates.ToBase("GBP", time.Now()); err == nil {
+		t.Error("expected a missing currency to fail")
+	}
+
+	if latest, ok := rates.Latest("EUR"); !ok || latest != day(2024, 1, 5) {
+		t.Errorf("unexpected latest EUR date %d", latest)
+	}
+}
+
+func TestDenominate(t *testing.T) {
+	rates := NewRates([]grist.FXRate{{Date: day(2024, 1, 1), Currency: "EUR", Rate: 1.25}})
+	trade := grist.Trade{TradeID: "t1", Time: day(2024, 2, 1) * 1000, Price: 100, OrderSize: 2, OrderValue: 200}
+
+	usd, err := Denominate(trade, "ZEUR", "", rates)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if usd.Currency != "USD" || usd.QuoteCurrency != "EUR" || usd.FXRate != 1.25 || usd.Price != 125 || usd.OrderValue != 250 {
+		t.Errorf("expected the EUR trade in USD, got %+v", usd)
+	}
+
+	eur, err := Denominate(grist.Trade{Time: trade.Time, Price: 125}, "USDC", "EUR", rates)
+	if err != nil || eur.Currency != "EUR" || !approx(eur.Price, 100) {
+		t.Errorf("expected the USDC trade in EUR, got %+v (%v)", eur, err)
+	}
+
+	btc, err := Denominate(trade, "XBT", "EUR", nil)
+	if err != nil || btc.Currency != "XBT" || btc.Price != 100 || btc.FXRate != 0 {
+		t.Errorf("expected a crypto quoted trade to stay in its quote, got %+v (%v)", btc, err)
+	}
+
+	if _, err := Denominate(trade, "SGD", "USD", rates); err == nil {
+		t.Error("expected a trade without a rate to fail")
+	}
+}
+
+func TestParseSeries_InvertsRates(t *testing.T) {
+	rows, err := parseSeries(series{Base: "USD", Rates: map[string]map[string]float64{
+		"2024-01-02": {"EUR": 0.8},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(rows) != 1 || rows[0].Date != day(2024, 1, 2) || rows[0].Currency != "EUR" || !approx(rows[0].Rate, 1.25) {
+		t.Errorf("unexpected rows %+v", rows)
+	}
+}
+
+func TestFee(t *testing.T) {
+	rates := NewRates([]grist.FXRate{{Date: day(2024, 1, 1), Currency: "EUR", Rate: 1.25}})
+	trade := grist.Trade{Time: day(2024, 2, 1) * 1000, Fee: 10, FeeCurrency: "EUR", FeeUSD: 12.5, Currency: "EUR"}
+
+	if fee, err := Fee(trade, rates); err != nil || fee != 10 {
+		t.Errorf("expected the fee charged in the trade currency, got %f (%v)", fee, err)
+	}
+
+	trade.Currency = "USD"
+	if fee, err := Fee(trade, rates); err != nil || fee != 12.5 {
+		t.Errorf("expected the USD fee, got %f (%v)", fee, err)
+	}
+
+	trade.Currency, trade.FeeCurrency, trade.Fee = "EUR", "USDC", 12.5
+	if fee, err := Fee(trade, rates); err != nil || !approx(fee, 10) {
+		t.Errorf("expected the USD fee converted to EUR, got %f (%v)", fee, err)
+	}
+}
+
+func TestFundingAmount(t *testing.T) {
+	rates := NewRates([]grist.FXRate{{Date: day(2024, 1, 1), Currency: "EUR", Rate: 1.25}})
+	payment := grist.Funding{Time: day(2024, 2, 1) * 1000, Ticker: "ETH", Amount: -2.5}
+
+	if amount, err := FundingAmount(payment, "", rates); err != nil || amount != -2.5 {
+		t.Errorf("expected the USD amount, got %f (%v)", amount, err)
+	}
+	if amount, err := FundingAmount(payment, "EUR", rates); err != nil || !approx(amount, -2) {
+		t.Errorf("expected the amount in EUR, got %f (%v)", amount, err)
+	}
+	if _, err := FundingAmount(payment, "SGD", rates); err == nil {
+		t.Error("expected a missing rate to fail")
+	}
+}
+
+func TestRedenominate(t *testing.T) {
+	rates := NewRates([]grist.FXRate{{Date: day(2024, 1, 1), Currency: "EUR", Rate: 1.25}})
+	time := day(2024, 2, 1) * 1000
+
+	eur, err := Denominate(grist.Trade{Time: time, Price: 100, OrderValue: 100}, "ZEUR", "SGD", NewRates([]grist.FXRate{
+		{Date: day(2024, 1, 1), Currency: "EUR", Rate: 1.25},
+		{Date: day(2024, 1, 1), Currency: "SGD", Rate: 0.75},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	usd, err := Redenominate(eur, "USD", rates)
+	if err != nil || usd.Currency != "USD" || usd.QuoteCurrency != "EUR" || !approx(usd.Price, 125) || !approx(usd.OrderValue, 125) {
+		t.Errorf("expected the EUR quote converted to USD, got %+v (%v)", usd, err)
+	}
+
+	legacy, err := Redenominate(grist.Trade{Time: time, Price: 125}, "EUR", rates)
+	if err != nil || legacy.Currency != "EUR" || legacy.QuoteCurrency != "USD" || !approx(legacy.Price, 100) {
+		t.Errorf("expected a trade without currency to be read as USD, got %+v (%v)", legacy, err)
+	}
+
+	btc := grist.Trade{Time: time, Price: 0.05, Currency: "XBT"}
+	if same, err := Redenominate(btc, "EUR", rates); err != nil || same != btc {
+		t.Errorf("expected a crypto quoted trade to be left as is, got %+v (%v)", same, err)
+	}
+}
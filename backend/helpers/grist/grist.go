@@ -34,6 +34,10 @@ func InitiateClient() (Grist, error) {
 }
 
 func (g *Grist) BackupDocument(ctx context.Context, outputPath string) error {
+	if g.local != nil {
+		return ErrReadOnly
+	}
+
 	path := fmt.Sprintf("/api/docs/%s/download", g.DocId)
 	endpoint := apiBaseURL + path
 
@@ -66,6 +70,10 @@ func (g *Grist) BackupDocument(ctx context.Context, outputPath string) error {
 }
 
 func (g *Grist) DeleteRecords(ctx context.Context, table string, recordsIDs []int64) error {
+	if g.local != nil {
+		return ErrReadOnly
+	}
+
 	path := fmt.Sprintf("/api/docs/%s/tables/%s/data/delete", g.DocId, table)
 	endpoint := apiBaseURL + path
 
@@ -87,33 +95,82 @@ func (g *Grist) DeleteRecords(ctx context.Context, table string, recordsIDs []in
 }
 
 func (g *Grist) GetExchangeLatestTrades(ctx context.Context, exchange string, limit uint64) ([]Trade, error) {
-	var latestTrades []Trade
-
 	query := fmt.Sprintf("filter={\"Exchange\":[\"%s\"]}&sort=Time&limit=%d", exchange, limit)
-	resp, err := g.GetRecords(ctx, "Trades", query)
-	if err != nil {
-		return latestTrades, err
+	return FetchTable[Trade](ctx, g, "Trades", query)
+}
+
+// EnsureTradeColumns adds the columns introduced since the Trades and Book tables were
+// created, such as their currency
+func (g *Grist) EnsureTradeColumns(ctx context.Context) error {
+	if err := EnsureTable[Trade](ctx, g, "Trades"); err != nil {
+		return err
 	}
 
-	latestTrades = make([]Trade, 0, len(resp.Records))
-	for _, rec := range resp.Records {
-		b, err := json.Marshal(rec.Fields)
-		if err != nil {
-			return nil, err
-		}
+	return EnsureTable[BookEntry](ctx, g, "Book")
+}
 
-		var gt Trade
-		if err := json.Unmarshal(b, &gt); err != nil {
-			return nil, err
-		}
+// GetLatestTradeTime returns the time of the newest trade stored for the wallet on exchange,
+// 0 when there is none
+func (g *Grist) GetLatestTradeTime(ctx context.Context, exchange string, wallet string) (int64, error) {
+	if err := EnsureTable[Trade](ctx, g, "Trades"); err != nil {
+		return 0, err
+	}
 
-		latestTrades = append(latestTrades, gt)
+	query := fmt.Sprintf("filter={\"Exchange\":[\"%s\"],\"Wallet\":[\"%s\"]}&sort=-Time&limit=1", exchange, wallet)
+	rows, err := FetchTable[Trade](ctx, g, "Trades", query)
+	if err != nil || len(rows) == 0 {
+		return 0, err
 	}
 
-	return latestTrades, nil
+	return rows[0].Time, nil
+}
+
+// FetchExchangeTrades returns the trades stored for exchange, oldest first
+func (g *Grist) FetchExchangeTrades(ctx context.Context, exchange string) ([]Trade, error) {
+	query := fmt.Sprintf("filter={\"Exchange\":[\"%s\"]}&sort=Time", exchange)
+	return FetchTable[Trade](ctx, g, "Trades", query)
+}
+
+// GetSyncCursor returns where the next sync of stream resumes for the wallet on exchange, and
+// false when the stream was never synced
+func (g *Grist) GetSyncCursor(ctx context.Context, exchange string, wallet string, stream string) (int64, bool, error) {
+	if err := EnsureTable[SyncCursor](ctx, g, "Sync_Cursors"); err != nil {
+		return 0, false, err
+	}
+
+	query := fmt.Sprintf("filter={\"Exchange\":[\"%s\"],\"Wallet\":[\"%s\"],\"Stream\":[\"%s\"]}&limit=1", exchange, wallet, stream)
+	rows, err := FetchTable[SyncCursor](ctx, g, "Sync_Cursors", query)
+	if err != nil || len(rows) == 0 {
+		return 0, false, err
+	}
+
+	return rows[0].Cursor, true, nil
+}
+
+// SetSyncCursor stores where the next sync of stream resumes for the wallet on exchange
+func (g *Grist) SetSyncCursor(ctx context.Context, exchange string, wallet string, stream string, cursor int64) error {
+	if err := EnsureTable[SyncCursor](ctx, g, "Sync_Cursors"); err != nil {
+		return err
+	}
+
+	row := SyncCursor{Exchange: exchange, Wallet: wallet, Stream: stream, Cursor: cursor, Updated: time.Now().Unix()}
+	return UpsertTable(ctx, g, "Sync_Cursors", []SyncCursor{row}, UpsertOpts{})
+}
+
+// FetchTrades returns the whole trade history of every exchange, oldest first
+func (g *Grist) FetchTrades(ctx context.Context) ([]Trade, error) {
+	return FetchTable[Trade](ctx, g, "Trades", "sort=Time")
 }
 
 func (g *Grist) getCount(ctx context.Context, method string, query string) (int64, error) {
+	if g.local != nil {
+		n, err := g.localCount(query)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %s", method, err)
+		}
+		return n, nil
+	}
+
 	u := g.generateSqlUrl(query)
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
@@ -157,103 +214,199 @@ func (g *Grist) GetAggregatedTradesCount(ctx context.Context, exchange string) (
 	return g.getCount(ctx, "grist.GetAggregatedTradesCount", query)
 }
 
-func (g *Grist) GetRecords(ctx context.Context, table string, query string) (Records, error) {
-	var records Records
+func (g *Grist) fetchRecords(ctx context.Context, method string, table string, query string) ([]byte, error) {
+	if g.local != nil {
+		body, err := g.localRecords(table, query)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", method, err)
+		}
+		return body, nil
+	}
 
 	endpoint := g.generateRecordsUrl(table, query)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
 	if err != nil {
-		return records, err
+		return nil, err
 	}
 
 	req.Header.Set("Authorization", "Bearer "+g.ApiKey)
 
 	resp := misc.DoWithRetry(ctx, req)
 	if resp.Err != nil {
-		return records, fmt.Errorf("grist.GetRecords: %s", resp.Err)
+		return nil, fmt.Errorf("%s: %s", method, resp.Err)
 	}
 
 	if resp.Status != http.StatusOK {
-		return records, fmt.Errorf("grist.GetRecords: code %d: %s", resp.Status, resp.Err)
+		return nil, fmt.Errorf("%s: code %d: %s", method, resp.Status, resp.Err)
 	}
 
-	if err := json.Unmarshal(resp.Body, &records); err != nil {
-		return records, err
-	}
-
-	return records, nil
+	return resp.Body, nil
 }
 
-func (g *Grist) FetchBook(ctx context.Context) (Book, error) {
-	book := make(Book)
+// tableColumns returns the column ids of table, or nil if the table does not exist
+func (g *Grist) tableColumns(ctx context.Context, table string) (map[string]bool, error) {
+	ids := make(map[string]bool)
 
-	endpoint := g.generateRecordsUrl("Book", "")
+	if g.local != nil {
+		columns, err := g.localColumns(table)
+		if err != nil {
+			return nil, nil
+		}
+		for _, c := range columns {
+			ids[c.name] = true
+		}
+		return ids, nil
+	}
+
+	endpoint := fmt.Sprintf("%s/api/docs/%s/tables/%s/columns", apiBaseURL, g.DocId, table)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
 	if err != nil {
-		return book, err
+		return nil, err
 	}
 
 	req.Header.Set("Authorization", "Bearer "+g.ApiKey)
 
 	resp := misc.DoWithRetry(ctx, req)
-	if resp.Err != nil {
-		return book, fmt.Errorf("grist.GetRecords: %s", resp.Err)
+	if resp.Status == http.StatusNotFound {
+		return nil, nil
 	}
 
-	if resp.Status != http.StatusOK {
-		return book, fmt.Errorf("grist.GetRecords: code %d: %s", resp.Status, resp.Err)
+	if resp.Err != nil {
+		return nil, resp.Err
 	}
 
-	var records struct {
-		Records []struct {
-			Fields BookEntry `json:"fields"`
-		} `json:"records"`
+	var out struct {
+		Columns []struct {
+			ID string `json:"id"`
+		} `json:"columns"`
 	}
 
-	if err := json.Unmarshal(resp.Body, &records); err != nil {
-		return book, err
+	if err := json.Unmarshal(resp.Body, &out); err != nil {
+		return nil, err
 	}
 
-	for _, r := range records.Records {
-		key := fmt.Sprintf("%s-%s-%s", r.Fields.Exchange, r.Fields.Market, r.Fields.Ticker)
-		book[key] = r.Fields
+	for _, c := range out.Columns {
+		ids[c.ID] = true
 	}
 
-	return book, nil
+	return ids, nil
 }
 
-func (g *Grist) FetchPrices(ctx context.Context) (Prices, error) {
-	prices := make(Prices)
+// postJSON posts payload to path under the document endpoint
+func (g *Grist) postJSON(ctx context.Context, method string, path string, payload any) error {
+	if g.local != nil {
+		return ErrReadOnly
+	}
 
-	endpoint := g.generateRecordsUrl("Prices", "")
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	body, err := json.Marshal(payload)
 	if err != nil {
-		return prices, err
+		return err
+	}
+
+	endpoint := fmt.Sprintf("%s/api/docs/%s%s", apiBaseURL, g.DocId, path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, io.NopCloser(bytes.NewReader(body)))
+	if err != nil {
+		return err
 	}
 
 	req.Header.Set("Authorization", "Bearer "+g.ApiKey)
+	req.Header.Set("Content-Type", "application/json")
 
 	resp := misc.DoWithRetry(ctx, req)
 	if resp.Err != nil {
-		return prices, fmt.Errorf("grist.GetRecords: %s", resp.Err)
+		return fmt.Errorf("%s: %s", method, resp.Err)
 	}
 
 	if resp.Status != http.StatusOK {
-		return prices, fmt.Errorf("grist.GetRecords: code %d: %s", resp.Status, resp.Err)
+		return fmt.Errorf("%s: code %d", method, resp.Status)
 	}
 
-	var records struct {
-		Records []struct {
-			Fields Price `json:"fields"`
-		} `json:"records"`
+	return nil
+}
+
+func (g *Grist) GetRecords(ctx context.Context, table string, query string) (Records, error) {
+	var records Records
+
+	body, err := g.fetchRecords(ctx, "grist.GetRecords", table, query)
+	if err != nil {
+		return records, err
 	}
 
-	if err := json.Unmarshal(resp.Body, &records); err != nil {
-		return prices, err
+	if err := json.Unmarshal(body, &records); err != nil {
+		return records, err
+	}
+
+	return records, nil
+}
+
+func (g *Grist) FetchBook(ctx context.Context) (Book, error) {
+	book := make(Book)
+
+	entries, err := FetchTable[BookEntry](ctx, g, "Book", "")
+	if err != nil {
+		return book, err
+	}
+
+	for _, e := range entries {
+		book[BookKey(e.Exchange, e.Wallet, e.Market, e.Ticker)] = e
 	}
 
+	return book, nil
+}
+
+// BookKey identifies a Book entry. Entries held from no wallet keep the key they had before
+// Book tracked wallets.
+func BookKey(exchange string, wallet string, market string, ticker string) string {
+	if wallet == "" {
+		return fmt.Sprintf("%s-%s-%s", exchange, market, ticker)
+	}
+
+	return fmt.Sprintf("%s-%s-%s-%s", exchange, wallet, market, ticker)
+}
+
+// DeleteBookEntries removes the Book rows of entries, matched on exchange, wallet, market and
+// ticker
+func (g *Grist) DeleteBookEntries(ctx context.Context, entries []BookEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	records, err := g.GetRecords(ctx, "Book", "")
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		keys[BookKey(e.Exchange, e.Wallet, e.Market, e.Ticker)] = true
+	}
+
+	var ids []int64
 	for _, r := range records.Records {
-		prices[r.Fields.Ticker] = r.Fields.Price
+		// the Wallet column is missing from a Book created before wallets were tracked
+		wallet, _ := r.Fields["Wallet"].(string)
+		if keys[BookKey(fmt.Sprint(r.Fields["Exchange"]), wallet, fmt.Sprint(r.Fields["Market"]), fmt.Sprint(r.Fields["Ticker"]))] {
+			ids = append(ids, r.RecordID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return g.DeleteRecords(ctx, "Book", ids)
+}
+
+func (g *Grist) FetchPrices(ctx context.Context) (Prices, error) {
+	prices := make(Prices)
+
+	rows, err := FetchTable[Price](ctx, g, "Prices", "")
+	if err != nil {
+		return prices, err
+	}
+
+	for _, p := range rows {
+		prices[p.Ticker] = p.Price
 	}
 
 	return prices, nil
@@ -262,42 +415,290 @@ func (g *Grist) FetchPrices(ctx context.Context) (Prices, error) {
 func (g *Grist) FetchTokens(ctx context.Context) (Tokens, error) {
 	tokens := make(Tokens)
 
-	endpoint := g.generateRecordsUrl("Tokens", "")
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	rows, err := FetchTable[Token](ctx, g, "Tokens", "")
 	if err != nil {
 		return tokens, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+g.ApiKey)
+	for _, t := range rows {
+		key := fmt.Sprintf("%d-%s", chain.NameToID(t.Chain), t.Address)
+		tokens[key] = t
+	}
 
-	resp := misc.DoWithRetry(ctx, req)
-	if resp.Err != nil {
-		return tokens, fmt.Errorf("grist.GetRecords: %s", resp.Err)
+	return tokens, nil
+}
+
+// GetLatestFundingTime returns the time of the newest funding payment stored for the wallet
+// on exchange, 0 when there is none
+func (g *Grist) GetLatestFundingTime(ctx context.Context, exchange string, wallet string) (int64, error) {
+	if err := EnsureTable[Funding](ctx, g, "Funding"); err != nil {
+		return 0, err
 	}
 
-	if resp.Status != http.StatusOK {
-		return tokens, fmt.Errorf("grist.GetRecords: code %d: %s", resp.Status, resp.Err)
+	query := fmt.Sprintf("filter={\"Exchange\":[\"%s\"],\"Wallet\":[\"%s\"]}&sort=-Time&limit=1", exchange, wallet)
+	rows, err := FetchTable[Funding](ctx, g, "Funding", query)
+	if err != nil || len(rows) == 0 {
+		return 0, err
 	}
 
-	var records struct {
-		Records []struct {
-			Fields Token `json:"fields"`
-		} `json:"records"`
+	return rows[0].Time, nil
+}
+
+// FetchFunding returns every stored funding payment
+func (g *Grist) FetchFunding(ctx context.Context) ([]Funding, error) {
+	if err := EnsureTable[Funding](ctx, g, "Funding"); err != nil {
+		return nil, err
 	}
 
-	if err := json.Unmarshal(resp.Body, &records); err != nil {
-		return tokens, err
+	return FetchTable[Funding](ctx, g, "Funding", "")
+}
+
+// GetLatestTransferTime returns the time of the newest transfer stored for the wallet on
+// exchange, 0 when there is none
+func (g *Grist) GetLatestTransferTime(ctx context.Context, exchange string, wallet string) (int64, error) {
+	if err := EnsureTable[Transfer](ctx, g, "Transfers"); err != nil {
+		return 0, err
+	}
+
+	query := fmt.Sprintf("filter={\"Exchange\":[\"%s\"],\"Wallet\":[\"%s\"]}&sort=-Time&limit=1", exchange, wallet)
+	rows, err := FetchTable[Transfer](ctx, g, "Transfers", query)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+
+	return rows[0].Time, nil
+}
+
+// FetchTransfers returns every stored transfer, oldest first
+func (g *Grist) FetchTransfers(ctx context.Context) ([]Transfer, error) {
+	if err := EnsureTable[Transfer](ctx, g, "Transfers"); err != nil {
+		return nil, err
+	}
+
+	return FetchTable[Transfer](ctx, g, "Transfers", "sort=Time")
+}
+
+// FetchFXRates returns the stored daily currency rates
+func (g *Grist) FetchFXRates(ctx context.Context) ([]FXRate, error) {
+	if err := EnsureTable[FXRate](ctx, g, "FX_Rates"); err != nil {
+		return nil, err
+	}
+
+	return FetchTable[FXRate](ctx, g, "FX_Rates", "")
+}
+
+// FetchLots returns the persisted lots, including the exhausted ones
+func (g *Grist) FetchLots(ctx context.Context) ([]Lot, error) {
+	if err := EnsureTable[Lot](ctx, g, "Lots"); err != nil {
+		return nil, err
+	}
+
+	return FetchTable[Lot](ctx, g, "Lots", "")
+}
+
+// FetchLotSelections returns the lot ids selected for each disposal trade id
+func (g *Grist) FetchLotSelections(ctx context.Context) (map[string][]string, error) {
+	selections := make(map[string][]string)
+
+	if err := EnsureTable[LotSelection](ctx, g, "Lot_Selections"); err != nil {
+		return selections, err
+	}
+
+	rows, err := FetchTable[LotSelection](ctx, g, "Lot_Selections", "")
+	if err != nil {
+		return selections, err
 	}
 
+	for _, row := range rows {
+		for _, id := range strings.Split(row.LotIDs, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				selections[row.TradeID] = append(selections[row.TradeID], id)
+			}
+		}
+	}
+
+	return selections, nil
+}
+
+// DeleteUnmatchedDisposals removes the rows of disposals a rebuild matched after all
+func (g *Grist) DeleteUnmatchedDisposals(ctx context.Context, disposals []UnmatchedDisposal) error {
+	if len(disposals) == 0 {
+		return nil
+	}
+
+	records, err := g.GetRecords(ctx, "Unmatched_Disposals", "")
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]bool, len(disposals))
+	for _, d := range disposals {
+		keys[d.Exchange+"-"+d.TradeID] = true
+	}
+
+	var ids []int64
 	for _, r := range records.Records {
-		key := fmt.Sprintf("%d-%s", chain.NameToID(r.Fields.Chain), r.Fields.Address)
-		tokens[key] = r.Fields
+		if keys[fmt.Sprintf("%v-%v", r.Fields["Exchange"], r.Fields["Trade_ID"])] {
+			ids = append(ids, r.RecordID)
+		}
 	}
 
-	return tokens, nil
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return g.DeleteRecords(ctx, "Unmatched_Disposals", ids)
+}
+
+// FetchOpeningBalances returns the holdings entered by hand as acquired outside the trade
+// history, those entered without a tranche ID given the one FillTrancheIDs derives
+func (g *Grist) FetchOpeningBalances(ctx context.Context) ([]OpeningBalance, error) {
+	if err := EnsureTable[OpeningBalance](ctx, g, "Opening_Balances"); err != nil {
+		return nil, err
+	}
+
+	balances, err := FetchTable[OpeningBalance](ctx, g, "Opening_Balances", "")
+	if err != nil {
+		return nil, err
+	}
+
+	FillTrancheIDs(balances)
+	return balances, nil
+}
+
+// SaveOpeningBalances upserts opening balances by tranche ID. Rows stored before tranches had
+// an ID, unique by date, exchange and ticker, are given theirs first so that importing the
+// same balances again updates them.
+func (g *Grist) SaveOpeningBalances(ctx context.Context, balances []OpeningBalance) error {
+	if err := EnsureTable[OpeningBalance](ctx, g, "Opening_Balances"); err != nil {
+		return err
+	}
+
+	stored, err := FetchTable[OpeningBalance](ctx, g, "Opening_Balances", "")
+	if err != nil {
+		return err
+	}
+
+	var legacy []Upsert
+	for _, b := range stored {
+		if b.TrancheID != "" {
+			continue
+		}
+
+		legacy = append(legacy, Upsert{
+			Require: map[string]any{"Tranche_ID": "", "Date": b.Date, "Exchange": b.Exchange, "Ticker": b.Ticker},
+			Fields:  map[string]any{"Tranche_ID": TrancheID(b, 1)},
+		})
+	}
+
+	if len(legacy) > 0 {
+		if err := g.UpsertRecords(ctx, "Opening_Balances", legacy, UpsertOpts{}); err != nil {
+			return err
+		}
+	}
+
+	FillTrancheIDs(balances)
+	return UpsertTable(ctx, g, "Opening_Balances", balances, UpsertOpts{})
+}
+
+// TrancheID derives the ID of the nth tranche, from 1, of an asset held on an exchange since a date
+func TrancheID(b OpeningBalance, n int) string {
+	parts := []string{time.UnixMilli(b.Date).UTC().Format("2006-01-02")}
+	if b.Exchange != "" {
+		parts = append(parts, b.Exchange)
+	}
+
+	return fmt.Sprintf("%s-%s-%d", strings.Join(parts, "-"), b.Ticker, n)
+}
+
+// FillTrancheIDs gives the balances without a tranche ID the one TrancheID derives, numbering
+// the tranches sharing a date, exchange and ticker in order
+func FillTrancheIDs(balances []OpeningBalance) {
+	seen := make(map[string]int)
+	for i, b := range balances {
+		if b.TrancheID != "" {
+			continue
+		}
+
+		key := fmt.Sprintf("%d-%s-%s", b.Date, b.Exchange, b.Ticker)
+		seen[key]++
+		balances[i].TrancheID = TrancheID(b, seen[key])
+	}
+}
+
+// FetchUnmatchedDisposals returns the recorded unmatched disposals, oldest first
+func (g *Grist) FetchUnmatchedDisposals(ctx context.Context) ([]UnmatchedDisposal, error) {
+	if err := EnsureTable[UnmatchedDisposal](ctx, g, "Unmatched_Disposals"); err != nil {
+		return nil, err
+	}
+
+	return FetchTable[UnmatchedDisposal](ctx, g, "Unmatched_Disposals", "sort=Time")
+}
+
+// SaveUnmatchedDisposals upserts unmatched disposals by exchange and trade id
+func (g *Grist) SaveUnmatchedDisposals(ctx context.Context, disposals []UnmatchedDisposal) error {
+	if len(disposals) == 0 {
+		return nil
+	}
+
+	if err := EnsureTable[UnmatchedDisposal](ctx, g, "Unmatched_Disposals"); err != nil {
+		return err
+	}
+
+	return UpsertTable(ctx, g, "Unmatched_Disposals", disposals, UpsertOpts{})
+}
+
+// SaveLots upserts changed lots and new disposal matches
+func (g *Grist) SaveLots(ctx context.Context, lots []Lot, matches []LotMatch) error {
+	if err := UpsertTable(ctx, g, "Lots", lots, UpsertOpts{}); err != nil {
+		return err
+	}
+
+	if err := EnsureTable[LotMatch](ctx, g, "Lot_Matches"); err != nil {
+		return err
+	}
+
+	return UpsertTable(ctx, g, "Lot_Matches", matches, UpsertOpts{})
+}
+
+// ReplaceLots replaces the lots and lot matches of exchange, of every exchange when empty,
+// with lots and matches
+func (g *Grist) ReplaceLots(ctx context.Context, exchange string, lots []Lot, matches []LotMatch) error {
+	if err := EnsureTable[Lot](ctx, g, "Lots"); err != nil {
+		return err
+	}
+	if err := EnsureTable[LotMatch](ctx, g, "Lot_Matches"); err != nil {
+		return err
+	}
+
+	for _, table := range []string{"Lots", "Lot_Matches"} {
+		records, err := g.GetRecords(ctx, table, "")
+		if err != nil {
+			return err
+		}
+
+		var ids []int64
+		for _, r := range records.Records {
+			if exchange == "" || r.Fields["Exchange"] == exchange {
+				ids = append(ids, r.RecordID)
+			}
+		}
+
+		if len(ids) > 0 {
+			if err := g.DeleteRecords(ctx, table, ids); err != nil {
+				return err
+			}
+		}
+	}
+
+	return g.SaveLots(ctx, lots, matches)
 }
 
 func (g *Grist) UpsertRecords(ctx context.Context, table string, records []Upsert, opts UpsertOpts) error {
+	if g.local != nil {
+		return ErrReadOnly
+	}
+
 	endpoint := g.generateRecordsUrl(table, "")
 
 	q := url.Values{}
@@ -339,25 +740,17 @@ func (g *Grist) UpsertRecords(ctx context.Context, table string, records []Upser
 func (g *Grist) GetLatestExecutionTimes(ctx context.Context) (map[string]time.Time, error) {
 	executionTimes := make(map[string]time.Time)
 
-	resp, err := g.GetRecords(ctx, "DATA_", "filter={\"Type\":[\"job_execution_time\"]}")
+	type jobExecutionTime struct {
+		JobName string `json:"Key"`
+		LastRun string `json:"Value"`
+	}
+
+	rows, err := FetchTable[jobExecutionTime](ctx, g, "DATA_", "filter={\"Type\":[\"job_execution_time\"]}")
 	if err != nil {
 		return executionTimes, fmt.Errorf("grist.GetLatestExecutionTimes: %s", err)
 	}
 
-	for _, rec := range resp.Records {
-		b, err := json.Marshal(rec.Fields)
-		if err != nil {
-			return executionTimes, err
-		}
-
-		var jobExecutionTime struct {
-			JobName string `json:"Key"`
-			LastRun string `json:"Value"`
-		}
-		if err := json.Unmarshal(b, &jobExecutionTime); err != nil {
-			return nil, err
-		}
-
+	for _, jobExecutionTime := range rows {
 		if lastRun, err := time.Parse(time.RFC3339, jobExecutionTime.LastRun); err == nil {
 			executionTimes[jobExecutionTime.JobName] = lastRun
 		}
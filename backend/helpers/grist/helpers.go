@@ -6,24 +6,27 @@ import (
 	"strings"
 )
 
-func (g *Grist) generateRecordsUrl(table string, query string) string {
-	path := fmt.Sprintf("/api/docs/%s/tables/%s/records", g.DocId, table)
-	endpoint := apiBaseURL + path
-
+// splitQuery parses the unescaped "key=value&key=value" queries built by callers
+func splitQuery(query string) url.Values {
 	v := url.Values{}
-	if query != "" {
-		for _, kv := range strings.Split(query, "&") {
-			if kv == "" {
-				continue
-			}
-			parts := strings.SplitN(kv, "=", 2)
-			if len(parts) == 2 {
-				v.Set(parts[0], parts[1])
-			}
+	for _, kv := range strings.Split(query, "&") {
+		if kv == "" {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			v.Set(parts[0], parts[1])
 		}
 	}
 
-	if enc := v.Encode(); enc != "" {
+	return v
+}
+
+func (g *Grist) generateRecordsUrl(table string, query string) string {
+	path := fmt.Sprintf("/api/docs/%s/tables/%s/records", g.DocId, table)
+	endpoint := apiBaseURL + path
+
+	if enc := splitQuery(query).Encode(); enc != "" {
 		return endpoint + "?" + enc
 	}
 
@@ -34,20 +37,7 @@ func (g *Grist) generateSqlUrl(query string) string {
 	path := fmt.Sprintf("/api/docs/%s/sql", g.DocId)
 	endpoint := apiBaseURL + path
 
-	v := url.Values{}
-	if query != "" {
-		for _, kv := range strings.Split(query, "&") {
-			if kv == "" {
-				continue
-			}
-			parts := strings.SplitN(kv, "=", 2)
-			if len(parts) == 2 {
-				v.Set(parts[0], parts[1])
-			}
-		}
-	}
-
-	if enc := v.Encode(); enc != "" {
+	if enc := splitQuery(query).Encode(); enc != "" {
 		return endpoint + "?" + enc
 	}
 
@@ -0,0 +1,223 @@
+package grist
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// A .grist document is a SQLite database holding one table per Grist table, next to
+// the _grist_* metadata tables describing their columns. A client opened with
+// OpenBackup serves reads from such a file and refuses every write.
+
+var ErrReadOnly = errors.New("grist document opened from a backup is read-only")
+
+type localColumn struct {
+	name string
+	kind string
+}
+
+// OpenBackup opens the uncompressed, unencrypted .grist document at path as a read-only client
+func OpenBackup(path string) (*Grist, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM _grist_Tables").Scan(new(int)); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("grist.OpenBackup: not a grist document: %w", err)
+	}
+
+	return &Grist{local: db}, nil
+}
+
+// Close releases the backup opened by OpenBackup, it is a no-op for API clients
+func (g *Grist) Close() error {
+	if g.local == nil {
+		return nil
+	}
+
+	return g.local.Close()
+}
+
+// IsOffline reports whether the client reads from a local backup instead of the API
+func (g *Grist) IsOffline() bool {
+	return g.local != nil
+}
+
+func (g *Grist) localColumns(table string) ([]localColumn, error) {
+	rows, err := g.local.Query(`
+		SELECT c.colId, c.type FROM _grist_Tables_column c
+		JOIN _grist_Tables t ON t.id = c.parentId
+		WHERE t.tableId = ? AND c.colId != 'manualSort' AND c.colId NOT LIKE 'gristHelper\_%' ESCAPE '\'
+		ORDER BY c.id`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []localColumn
+	for rows.Next() {
+		var c localColumn
+		if err := rows.Scan(&c.name, &c.kind); err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s not found", table)
+	}
+
+	return columns, nil
+}
+
+// localRecords answers a records query from the backup with the same JSON body as the
+// API. The filter, sort and limit parameters are supported.
+func (g *Grist) localRecords(table string, query string) ([]byte, error) {
+	columns, err := g.localColumns(table)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]localColumn, len(columns))
+	names := []string{"id"}
+	for _, c := range columns {
+		known[c.name] = c
+		names = append(names, quoteIdent(c.name))
+	}
+
+	stmt := fmt.Sprintf("SELECT %s FROM %s", strings.Join(names, ", "), quoteIdent(table))
+	params := splitQuery(query)
+
+	var args []any
+	if filter := params.Get("filter"); filter != "" {
+		var conditions map[string][]any
+		if err := json.Unmarshal([]byte(filter), &conditions); err != nil {
+			return nil, fmt.Errorf("invalid filter: %w", err)
+		}
+
+		var clauses []string
+		for name, values := range conditions {
+			if _, ok := known[name]; !ok && name != "id" {
+				return nil, fmt.Errorf("unknown column %s in filter", name)
+			}
+			if len(values) == 0 {
+				clauses = append(clauses, "0")
+				continue
+			}
+
+			placeholders := make([]string, len(values))
+			for i, v := range values {
+				placeholders[i] = "?"
+				if b, ok := v.(bool); ok {
+					v = map[bool]int{false: 0, true: 1}[b]
+				}
+				args = append(args, v)
+			}
+			clauses = append(clauses, fmt.Sprintf("%s IN (%s)", quoteIdent(name), strings.Join(placeholders, ", ")))
+		}
+		stmt += " WHERE " + strings.Join(clauses, " AND ")
+	}
+
+	var order []string
+	for _, key := range strings.Split(params.Get("sort"), ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+
+		dir := "ASC"
+		if name, ok := strings.CutPrefix(key, "-"); ok {
+			key, dir = name, "DESC"
+		}
+		if _, ok := known[key]; !ok && key != "id" {
+			return nil, fmt.Errorf("unknown column %s in sort", key)
+		}
+		order = append(order, quoteIdent(key)+" "+dir)
+	}
+	stmt += " ORDER BY " + strings.Join(append(order, "id"), ", ")
+
+	if limit := params.Get("limit"); limit != "" {
+		stmt += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := g.local.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := Records{Records: []Record{}}
+	for rows.Next() {
+		values := make([]any, len(columns)+1)
+		targets := make([]any, len(values))
+		for i := range values {
+			targets[i] = &values[i]
+		}
+
+		if err := rows.Scan(targets...); err != nil {
+			return nil, err
+		}
+
+		id, _ := values[0].(int64)
+		fields := make(map[string]any, len(columns))
+		for i, c := range columns {
+			fields[c.name] = localValue(c.kind, values[i+1])
+		}
+		records.Records = append(records.Records, Record{RecordID: id, Fields: fields})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(map[string]any{"records": records.Records})
+}
+
+// localValue converts a stored cell to the value the API would serve for a column of kind
+func localValue(kind string, v any) any {
+	switch v := v.(type) {
+	case []byte:
+		// Grist stores errors and values of mismatched type as encoded blobs
+		return nil
+	case int64:
+		if kind == "Bool" {
+			return v != 0
+		}
+		return v
+	case string:
+		if strings.HasPrefix(kind, "ChoiceList") || strings.HasPrefix(kind, "RefList:") {
+			var list []any
+			if err := json.Unmarshal([]byte(v), &list); err == nil {
+				return append([]any{"L"}, list...)
+			}
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func (g *Grist) localCount(query string) (int64, error) {
+	var n sql.NullFloat64
+	if err := g.local.QueryRow(splitQuery(query).Get("q")).Scan(&n); err != nil {
+		return 0, err
+	}
+
+	return int64(n.Float64), nil
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,171 @@
+package grist
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openFixture copies template.grist, fills it with rows and opens it as a backup
+func openFixture(t *testing.T) *Grist {
+	t.Helper()
+
+	template, err := os.ReadFile("../../../template.grist")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "backup.grist")
+	if err := os.WriteFile(path, template, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statements := []string{
+		`INSERT INTO Book (Exchange, Market, Ticker, Asset_Type, Average_Price, Position_Size, Cost_Basis) VALUES ('kraken', 'spot', 'BTC', 'crypto', 40000, 0.5, 20000)`,
+		`INSERT INTO Prices (Ticker, Coingecko_ID, Price) VALUES ('BTC', 'bitcoin', 65000.5)`,
+		`INSERT INTO Tokens (Chain, Address, Ticker, Decimal) VALUES ('Ethereum', '0xa0b8', 'USDC', 6.0)`,
+		`INSERT INTO Trades (Trade_ID, Exchange, Ticker, Time, Price, Aggregated_Trades) VALUES ('t1', 'kraken', 'BTC', 1000.0, 100, 2)`,
+		`INSERT INTO Trades (Trade_ID, Exchange, Ticker, Time, Price, Aggregated_Trades) VALUES ('t2', 'kraken', 'ETH', 3000.0, 10, 0)`,
+		`INSERT INTO Trades (Trade_ID, Exchange, Ticker, Time, Price, Aggregated_Trades) VALUES ('t3', 'hyperliquid', 'BTC', 2000.0, 101, 1)`,
+		`INSERT INTO Pendle (Name, Is_New, Categories) VALUES ('PT-sUSDe', 1, '["stables","ethena"]')`,
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	db.Close()
+
+	g, err := OpenBackup(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { g.Close() })
+
+	return g
+}
+
+func TestOpenBackup_RejectsNonGristFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "not.grist")
+	if err := os.WriteFile(path, []byte("not sqlite"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenBackup(path); err == nil {
+		t.Error("expected an error for a file that is not a grist document")
+	}
+}
+
+func TestOfflineFetchBookPricesTokens(t *testing.T) {
+	g := openFixture(t)
+	ctx := context.Background()
+
+	book, err := g.FetchBook(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e := book["kraken-spot-BTC"]; e.PositionSize != 0.5 || e.CostBasis != 20000 {
+		t.Errorf("unexpected book entry %+v", e)
+	}
+
+	prices, err := g.FetchPrices(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prices["BTC"] != 65000.5 {
+		t.Errorf("expected BTC price 65000.5, got %v", prices["BTC"])
+	}
+
+	tokens, err := g.FetchTokens(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tokens) != 1 {
+		t.Fatalf("expected 1 token, got %d", len(tokens))
+	}
+	for _, token := range tokens {
+		if token.Ticker != "USDC" || token.Decimal != 6 {
+			t.Errorf("unexpected token %+v", token)
+		}
+	}
+}
+
+func TestOfflineGetRecords_FilterSortLimit(t *testing.T) {
+	g := openFixture(t)
+	ctx := context.Background()
+
+	records, err := g.GetRecords(ctx, "Trades", `filter={"Ticker":["BTC"]}&sort=-Time&limit=1`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records.Records) != 1 || records.Records[0].Fields["Trade_ID"] != "t3" {
+		t.Fatalf("expected only the latest BTC trade, got %+v", records.Records)
+	}
+	if _, ok := records.Records[0].Fields["manualSort"]; ok {
+		t.Error("expected manualSort to be hidden like in the API")
+	}
+
+	trades, err := g.GetExchangeLatestTrades(ctx, "kraken", 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(trades) != 2 || trades[0].TradeID != "t1" || trades[1].Time != 3000 {
+		t.Errorf("unexpected kraken trades %+v", trades)
+	}
+
+	if _, err := g.GetRecords(ctx, "Trades", `sort=Nope`); err == nil {
+		t.Error("expected unknown sort column to fail")
+	}
+	if _, err := g.GetRecords(ctx, "Missing", ""); err == nil {
+		t.Error("expected unknown table to fail")
+	}
+}
+
+func TestOfflineGetRecords_ConvertsGristTypes(t *testing.T) {
+	g := openFixture(t)
+
+	records, err := g.GetRecords(context.Background(), "Pendle", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fields := records.Records[0].Fields
+	if fields["Is_New"] != true {
+		t.Errorf("expected Bool column to be a bool, got %#v", fields["Is_New"])
+	}
+
+	categories, ok := fields["Categories"].([]any)
+	if !ok || len(categories) != 3 || categories[0] != "L" || categories[2] != "ethena" {
+		t.Errorf("expected ChoiceList encoded as the API does, got %#v", fields["Categories"])
+	}
+}
+
+func TestOfflineCountAndWrites(t *testing.T) {
+	g := openFixture(t)
+	ctx := context.Background()
+
+	n, err := g.GetAggregatedTradesCount(ctx, "kraken")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 aggregated kraken trades, got %d", n)
+	}
+
+	err = g.UpsertRecords(ctx, "Prices", []Upsert{{Require: map[string]any{"Ticker": "ETH"}}}, UpsertOpts{})
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+
+	if err := g.DeleteRecords(ctx, "Prices", []int64{1}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+}
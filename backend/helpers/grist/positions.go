@@ -0,0 +1,32 @@
+package grist
+
+// Position is a balance of the Positions_Crypto_ table, written by the wallet and exchange
+// balance jobs
+type Position struct {
+	Wallet string  `json:"Wallet"`
+	Chain  string  `json:"Chain"`
+	Ticker string  `json:"Ticker"`
+	Amount float64 `json:"Amount"`
+}
+
+// KrakenFuturesWallet is the Positions_Crypto_ wallet of the Kraken Futures account balances,
+// stored on the Kraken chain. They are margin collateral rather than spot holdings.
+const KrakenFuturesWallet = "Kraken Futures"
+
+// positionExchanges are the exchanges whose balance jobs write to Positions_Crypto_, as the
+// chain (Hyperliquid, Lighter, Kraken Futures) or as the wallet (Kraken spot)
+var positionExchanges = map[string]bool{"Hyperliquid": true, "Kraken": true, "Lighter": true}
+
+// PositionExchange returns the exchange a Positions_Crypto_ row was synced from, ok being
+// unset for on-chain balances
+func PositionExchange(wallet string, chain string) (string, bool) {
+	if positionExchanges[chain] {
+		return chain, true
+	}
+
+	if chain == "" && positionExchanges[wallet] {
+		return wallet, true
+	}
+
+	return "", false
+}
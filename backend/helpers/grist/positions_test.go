@@ -0,0 +1,24 @@
+package grist
+
+import "testing"
+
+func TestPositionExchange(t *testing.T) {
+	tests := []struct {
+		wallet, chain string
+		want          string
+		ok            bool
+	}{
+		{"Main", "Hyperliquid", "Hyperliquid", true},
+		{"Kraken", "", "Kraken", true},
+		{"Kraken Futures", "Kraken", "Kraken", true},
+		{"Main", "Ethereum", "", false},
+		{"Kraken", "Ethereum", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := PositionExchange(tt.wallet, tt.chain)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("PositionExchange(%q, %q) = %q, %v, want %q, %v", tt.wallet, tt.chain, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package grist
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Struct tags drive the mapping between Go types and Grist tables: the json tag
+// names the column, and the grist tag carries options for writes.
+//
+//	TradeID string `json:"Trade_ID" grist:"require"`            // part of the upsert match
+//	Notes   string `json:"Notes" grist:"-"`                     // read-only, never written
+//	Date    int64  `json:"Date" grist:"type=DateTime:UTC"`      // column type for EnsureTable
+//
+// Fields without a json tag are ignored so that helper fields never leak into Grist.
+
+type column struct {
+	name     string
+	index    []int
+	kind     string
+	require  bool
+	readOnly bool
+}
+
+func columnsOf(t reflect.Type) []column {
+	var columns []column
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		c := column{name: name, index: f.Index, kind: gristType(f.Type)}
+		for _, opt := range strings.Split(f.Tag.Get("grist"), ",") {
+			switch {
+			case opt == "require":
+				c.require = true
+			case opt == "-":
+				c.readOnly = true
+			case strings.HasPrefix(opt, "type="):
+				c.kind = strings.TrimPrefix(opt, "type=")
+			}
+		}
+		columns = append(columns, c)
+	}
+
+	return columns
+}
+
+// gristType returns the Grist column type matching the Go type of a field
+func gristType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "Text"
+	case reflect.Float32, reflect.Float64:
+		return "Numeric"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "Int"
+	case reflect.Bool:
+		return "Bool"
+	default:
+		return "Any"
+	}
+}
+
+// decodeFields assigns Grist record fields to the tagged columns of dst. Grist
+// serves every Numeric column as a float, so numbers are coerced to the field kind.
+func decodeFields(fields map[string]any, dst reflect.Value) error {
+	for _, c := range columnsOf(dst.Type()) {
+		raw, ok := fields[c.name]
+		if !ok || raw == nil {
+			continue
+		}
+
+		if err := assign(dst.FieldByIndex(c.index), raw); err != nil {
+			return fmt.Errorf("column %s: %w", c.name, err)
+		}
+	}
+
+	return nil
+}
+
+func assign(field reflect.Value, raw any) error {
+	text := ""
+	switch v := raw.(type) {
+	case json.Number:
+		text = v.String()
+	case string:
+		text = strings.TrimSpace(v)
+	case bool:
+		text = strconv.FormatBool(v)
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		if _, ok := raw.(string); !ok && text == "" {
+			return fmt.Errorf("cannot decode %T into string", raw)
+		}
+		field.SetString(text)
+	case reflect.Float32, reflect.Float64:
+		if text == "" {
+			return nil
+		}
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if text == "" {
+			return nil
+		}
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(f))
+	case reflect.Bool:
+		if text == "" {
+			return nil
+		}
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	default:
+		b, err := json.Marshal(raw)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(b, field.Addr().Interface())
+	}
+
+	return nil
+}
+
+// Row is a decoded record along with its Grist row id, needed to delete it
+type Row[T any] struct {
+	ID    int64
+	Value T
+}
+
+// DecodeRows decodes a Grist records payload into T using the struct tags of T, keeping row ids
+func DecodeRows[T any](body []byte) ([]Row[T], error) {
+	var records struct {
+		Records []struct {
+			ID     int64          `json:"id"`
+			Fields map[string]any `json:"fields"`
+		} `json:"records"`
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&records); err != nil {
+		return nil, err
+	}
+
+	rows := make([]Row[T], len(records.Records))
+	for i, r := range records.Records {
+		rows[i].ID = r.ID
+		if err := decodeFields(r.Fields, reflect.ValueOf(&rows[i].Value).Elem()); err != nil {
+			return nil, err
+		}
+	}
+
+	return rows, nil
+}
+
+// DecodeRecords decodes a Grist records payload into T using the struct tags of T
+func DecodeRecords[T any](body []byte) ([]T, error) {
+	rows, err := DecodeRows[T](body)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]T, len(rows))
+	for i, r := range rows {
+		values[i] = r.Value
+	}
+
+	return values, nil
+}
+
+// FetchTable retrieves the records of table matching query and decodes their fields into T
+func FetchTable[T any](ctx context.Context, g *Grist, table string, query string) ([]T, error) {
+	body, err := g.fetchRecords(ctx, "grist.FetchTable", table, query)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := DecodeRecords[T](body)
+	if err != nil {
+		return nil, fmt.Errorf("grist.FetchTable %s: %w", table, err)
+	}
+
+	return rows, nil
+}
+
+// FetchRows is FetchTable for callers that also need the row ids
+func FetchRows[T any](ctx context.Context, g *Grist, table string, query string) ([]Row[T], error) {
+	body, err := g.fetchRecords(ctx, "grist.FetchRows", table, query)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := DecodeRows[T](body)
+	if err != nil {
+		return nil, fmt.Errorf("grist.FetchRows %s: %w", table, err)
+	}
+
+	return rows, nil
+}
+
+// RecordFromStruct builds an upsert whose Require and Fields come from the struct tags of T
+func RecordFromStruct[T any](row T) Upsert {
+	v := reflect.Indirect(reflect.ValueOf(row))
+
+	upsert := Upsert{
+		Require: map[string]any{},
+		Fields:  map[string]any{},
+	}
+
+	for _, c := range columnsOf(v.Type()) {
+		if c.readOnly {
+			continue
+		}
+
+		value := v.FieldByIndex(c.index).Interface()
+		if c.require {
+			upsert.Require[c.name] = value
+		} else {
+			upsert.Fields[c.name] = value
+		}
+	}
+
+	return upsert
+}
+
+// UpsertTable upserts rows into table, deriving the match and written columns from the struct tags of T
+func UpsertTable[T any](ctx context.Context, g *Grist, table string, rows []T, opts UpsertOpts) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	records := make([]Upsert, 0, len(rows))
+	for _, row := range rows {
+		records = append(records, RecordFromStruct(row))
+	}
+
+	return g.UpsertRecords(ctx, table, records, opts)
+}
+
+// EnsureTable creates table with the columns of T if it does not exist yet, and adds the
+// columns of T missing from an existing table. Existing columns are left untouched.
+func EnsureTable[T any](ctx context.Context, g *Grist, table string) error {
+	type tableColumn struct {
+		ID     string         `json:"id"`
+		Fields map[string]any `json:"fields"`
+	}
+
+	var wanted []tableColumn
+	for _, c := range columnsOf(reflect.TypeFor[T]()) {
+		wanted = append(wanted, tableColumn{ID: c.name, Fields: map[string]any{"type": c.kind}})
+	}
+
+	existing, err := g.tableColumns(ctx, table)
+	if err != nil {
+		return fmt.Errorf("grist.EnsureTable %s: %w", table, err)
+	}
+
+	if existing == nil {
+		payload := map[string]any{"tables": []any{map[string]any{"id": table, "columns": wanted}}}
+		if err := g.postJSON(ctx, "grist.EnsureTable", "/tables", payload); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	var missing []tableColumn
+	for _, c := range wanted {
+		if !existing[c.ID] {
+			missing = append(missing, c)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	return g.postJSON(ctx, "grist.EnsureTable", "/tables/"+table+"/columns", map[string]any{"columns": missing})
+}
@@ -0,0 +1,162 @@
+package grist
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeRecords_CoercesNumericColumns(t *testing.T) {
+	body := []byte(`{"records":[{"id":1,"fields":{
+		"Trade_ID":"abc","Exchange":"Kraken","Time":1640000000000.0,
+		"Price":50000.5,"Aggregated_Trades":3,"Date":1640000000,"Unknown":"x"}}]}`)
+
+	rows, err := DecodeRecords[Trade](body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(rows) != 1 {
+		t.Fatalf("expected 1 row, got %d", len(rows))
+	}
+
+	trade := rows[0]
+	if trade.Time != 1640000000000 {
+		t.Errorf("expected time 1640000000000, got %d", trade.Time)
+	}
+	if trade.Price != 50000.5 {
+		t.Errorf("expected price 50000.5, got %f", trade.Price)
+	}
+	if trade.AggregatedTrades != 3 {
+		t.Errorf("expected aggregated trades 3, got %d", trade.AggregatedTrades)
+	}
+	if trade.TradeID != "abc" || trade.Exchange != "Kraken" {
+		t.Errorf("expected string columns to decode, got %q %q", trade.TradeID, trade.Exchange)
+	}
+}
+
+func TestDecodeRecords_NullColumnsKeepZeroValue(t *testing.T) {
+	body := []byte(`{"records":[{"id":1,"fields":{"Chain":"Ethereum","Address":"0x1","Ticker":null,"Decimal":18.0}}]}`)
+
+	rows, err := DecodeRecords[Token](body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if rows[0].Ticker != "" {
+		t.Errorf("expected empty ticker, got %q", rows[0].Ticker)
+	}
+	if rows[0].Decimal != 18 {
+		t.Errorf("expected decimal 18, got %d", rows[0].Decimal)
+	}
+}
+
+func TestDecodeRecords_SurfacesTypeErrors(t *testing.T) {
+	body := []byte(`{"records":[{"id":1,"fields":{"Price":"not-a-number"}}]}`)
+
+	if _, err := DecodeRecords[Price](body); err == nil {
+		t.Fatal("expected error for non-numeric price")
+	}
+}
+
+func TestRecordFromStruct_SplitsRequireAndFields(t *testing.T) {
+	entry := BookEntry{
+		Exchange:     "Kraken",
+		Ticker:       "BTC",
+		Market:       "Spot",
+		AssetType:    "Token",
+		AveragePrice: 100,
+		PositionSize: 2,
+		CostBasis:    200,
+	}
+
+	record := RecordFromStruct(entry)
+
+	if len(record.Require) != 4 {
+		t.Fatalf("expected 4 require columns, got %d: %v", len(record.Require), record.Require)
+	}
+	if record.Require["Exchange"] != "Kraken" || record.Require["Wallet"] != "" || record.Require["Market"] != "Spot" || record.Require["Ticker"] != "BTC" {
+		t.Errorf("unexpected require columns: %v", record.Require)
+	}
+	if record.Fields["Cost_Basis"] != 200.0 || record.Fields["Asset_Type"] != "Token" {
+		t.Errorf("unexpected fields: %v", record.Fields)
+	}
+	if _, ok := record.Fields["Exchange"]; ok {
+		t.Error("require columns must not be duplicated in fields")
+	}
+}
+
+func TestRecordFromStruct_SkipsReadOnlyColumns(t *testing.T) {
+	type row struct {
+		Key      string  `json:"Key" grist:"require"`
+		Value    float64 `json:"Value"`
+		Formula  float64 `json:"Formula" grist:"-"`
+		Internal string
+	}
+
+	record := RecordFromStruct(row{Key: "a", Value: 1, Formula: 2, Internal: "x"})
+
+	if len(record.Fields) != 1 || record.Fields["Value"] != 1.0 {
+		t.Errorf("expected only Value in fields, got %v", record.Fields)
+	}
+}
+
+func TestCreateRecordFromTrade(t *testing.T) {
+	var g Grist
+	record := g.CreateRecordFromTrade(Trade{
+		Time:      1640000000000,
+		Exchange:  "Hyperliquid",
+		TradeID:   "42",
+		OrderType: "market",
+	})
+
+	if record.Require["Trade_ID"] != "42" || record.Require["Exchange"] != "Hyperliquid" {
+		t.Errorf("unexpected require columns: %v", record.Require)
+	}
+	if record.Fields["Order_Type"] != "Market" {
+		t.Errorf("expected capitalized order type, got %v", record.Fields["Order_Type"])
+	}
+	if record.Fields["Date"] != "20 Dec 2021 1133 am" {
+		t.Errorf("unexpected date %v", record.Fields["Date"])
+	}
+}
+
+func TestColumnsOf_ParsesTypeOption(t *testing.T) {
+	columns := columnsOf(reflect.TypeFor[Snapshot]())
+
+	kinds := make(map[string]column)
+	for _, c := range columns {
+		kinds[c.name] = c
+	}
+
+	if c := kinds["Date"]; c.kind != "DateTime:UTC" || !c.require {
+		t.Errorf("expected Date to be a required DateTime:UTC column, got %+v", c)
+	}
+	if c := kinds["USD_Value"]; c.kind != "Numeric" || c.require {
+		t.Errorf("expected USD_Value to be a Numeric field, got %+v", c)
+	}
+	if c := kinds["Ticker"]; c.kind != "Text" {
+		t.Errorf("expected Ticker to be Text, got %+v", c)
+	}
+}
+
+func TestDecodeRows_KeepsRowIDs(t *testing.T) {
+	body := []byte(`{"records":[{"id":7,"fields":{"Ticker":"BTC","Price":1.5}}]}`)
+
+	rows, err := DecodeRows[Price](body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(rows) != 1 || rows[0].ID != 7 || rows[0].Value.Price != 1.5 {
+		t.Errorf("unexpected rows %+v", rows)
+	}
+}
+
+func TestBookKey(t *testing.T) {
+	if got := BookKey("Kraken", "", "Spot", "BTC"); got != "Kraken-Spot-BTC" {
+		t.Errorf("expected entries without a wallet to keep their key, got %q", got)
+	}
+	if got := BookKey("Hyperliquid", "0xa", "Futures", "ETH"); got != "Hyperliquid-0xa-Futures-ETH" {
+		t.Errorf("unexpected wallet key %q", got)
+	}
+}
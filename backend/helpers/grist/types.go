@@ -1,30 +1,90 @@
 package grist
 
+import "database/sql"
+
 type Grist struct {
 	ApiKey string
 	DocId  string
+
+	// local is set when the client reads from a backup opened with OpenBackup
+	local *sql.DB
 }
 
 type Book map[string]BookEntry
 
+// BookEntry is the position held in a ticker on a market of an exchange. Wallet is the address
+// the position is held from on exchanges with one account per wallet, and empty otherwise.
 type BookEntry struct {
-	Exchange     string  `json:"exchange"`
-	Ticker       string  `json:"Ticker"`
-	Market       string  `json:"Market"`
+	Exchange     string  `json:"Exchange" grist:"require"`
+	Wallet       string  `json:"Wallet" grist:"require"`
+	Ticker       string  `json:"Ticker" grist:"require"`
+	Market       string  `json:"Market" grist:"require"`
 	AssetType    string  `json:"Asset_Type"`
 	AveragePrice float64 `json:"Average_Price"`
 	PositionSize float64 `json:"Position_Size"`
 	CostBasis    float64 `json:"Cost_Basis"`
+	Currency     string  `json:"Currency"`
+	Fees         float64 `json:"Fees"`         // fees paid since the position was opened
+	Funding      float64 `json:"Funding"`      // funding received over the lifetime of the entry
+	RealizedPnL  float64 `json:"Realized_PnL"` // realized trade PnL and funding over the lifetime of the entry
+}
+
+// Valuation prices a Book entry and is written to the Book table next to the columns the
+// exchange jobs own. Values are in the currency of the entry, Unrealized_PnL_ is the
+// "Unrealized PnL %" column, as a fraction of the cost basis.
+type Valuation struct {
+	Exchange         string  `json:"Exchange" grist:"require"`
+	Wallet           string  `json:"Wallet" grist:"require"`
+	Ticker           string  `json:"Ticker" grist:"require"`
+	Market           string  `json:"Market" grist:"require"`
+	MarkPrice        float64 `json:"Mark_Price"`
+	MarketValue      float64 `json:"Market_Value"`
+	UnrealizedPnL    float64 `json:"Unrealized_PnL"`
+	UnrealizedPnLPct float64 `json:"Unrealized_PnL_"`
 }
 
 type Price struct {
-	Ticker      string  `json:"Ticker"`
+	Ticker      string  `json:"Ticker" grist:"require"`
 	CoingeckoID string  `json:"Coingecko_ID"`
 	Price       float64 `json:"Price"`
 }
 
 type Prices map[string]float64
 
+// Funding is a perpetual funding payment, Amount is in USD and negative when paid
+type Funding struct {
+	Time         int64   `json:"Time" grist:"require"`
+	Exchange     string  `json:"Exchange" grist:"require"`
+	Wallet       string  `json:"Wallet" grist:"require"`
+	Ticker       string  `json:"Ticker" grist:"require"`
+	Amount       float64 `json:"Amount"`
+	FundingRate  float64 `json:"Funding_Rate"`
+	PositionSize float64 `json:"Position_Size"`
+}
+
+// Transfer is a deposit, withdrawal or transfer on an exchange ledger. Amount is positive,
+// Direction is In when it entered the account and Out when it left.
+type Transfer struct {
+	TransferID   string  `json:"Transfer_ID" grist:"require"`
+	Exchange     string  `json:"Exchange" grist:"require"`
+	Wallet       string  `json:"Wallet" grist:"require"`
+	Time         int64   `json:"Time"`
+	Type         string  `json:"Type"`
+	Direction    string  `json:"Direction"`
+	Ticker       string  `json:"Ticker"`
+	Amount       float64 `json:"Amount"`
+	Fee          float64 `json:"Fee"`
+	USDValue     float64 `json:"USD_Value"`
+	Counterparty string  `json:"Counterparty"`
+}
+
+// FXRate is the USD value of one unit of Currency on Date
+type FXRate struct {
+	Date     int64   `json:"Date" grist:"require,type=Date"`
+	Currency string  `json:"Currency" grist:"require"`
+	Rate     float64 `json:"Rate"`
+}
+
 type Record struct {
 	RecordID int64          `json:"id"`
 	Fields   map[string]any `json:"fields"`
@@ -35,8 +95,8 @@ type Records struct {
 }
 
 type Token struct {
-	Chain   string `json:"Chain"`
-	Address string `json:"Address"`
+	Chain   string `json:"Chain" grist:"require"`
+	Address string `json:"Address" grist:"require"`
 	Ticker  string `json:"Ticker"`
 	Decimal int32  `json:"Decimal"`
 }
@@ -47,7 +107,8 @@ type Trade struct {
 	Time             int64   `json:"Time"`
 	OrderValue       float64 `json:"Order_Value"`
 	Direction        string  `json:"Direction"`
-	Exchange         string  `json:"Exchange"`
+	Exchange         string  `json:"Exchange" grist:"require"`
+	Wallet           string  `json:"Wallet"` // address the trade was made from, empty on single-account exchanges
 	Market           string  `json:"Market"`
 	OrderType        string  `json:"Order_Type"`
 	Price            float64 `json:"Price"`
@@ -57,8 +118,21 @@ type Trade struct {
 	FeeCurrency      string  `json:"Fee_Currency"`
 	FeeUSD           float64 `json:"Fee_USD_"`
 	PnL              float64 `json:"PnL"`
-	TradeID          string  `json:"Trade_ID"`
+	TradeID          string  `json:"Trade_ID" grist:"require"`
 	AggregatedTrades int     `json:"Aggregated_Trades"`
+	Currency         string  `json:"Currency"`       // currency of Price, Order_Value and PnL
+	QuoteCurrency    string  `json:"Quote_Currency"` // currency the pair was quoted in
+	FXRate           float64 `json:"FX_Rate"`        // Currency per unit of Quote_Currency at Time
+}
+
+// SyncCursor is where the next sync of a stream of a wallet on an exchange resumes, Cursor
+// being a time in ms or an exchange-specific offset
+type SyncCursor struct {
+	Exchange string `json:"Exchange" grist:"require"`
+	Wallet   string `json:"Wallet" grist:"require"`
+	Stream   string `json:"Stream" grist:"require"`
+	Cursor   int64  `json:"Cursor"`
+	Updated  int64  `json:"Updated" grist:"type=DateTime:UTC"`
 }
 
 type UpsertOpts struct {
@@ -74,3 +148,118 @@ type Upsert struct {
 type upsertPayload struct {
 	Records []Upsert `json:"records"`
 }
+
+// Snapshot is one position valued at a point in time in the Snapshots history table
+type Snapshot struct {
+	Date      int64   `json:"Date" grist:"require,type=DateTime:UTC"`
+	Source    string  `json:"Source" grist:"require"`
+	Wallet    string  `json:"Wallet" grist:"require"`
+	Chain     string  `json:"Chain" grist:"require"`
+	Ticker    string  `json:"Ticker" grist:"require"`
+	AssetType string  `json:"Asset_Type"`
+	Amount    float64 `json:"Amount"`
+	Price     float64 `json:"Price"`
+	USDValue  float64 `json:"USD_Value"`
+	Currency  string  `json:"Currency"` // reporting currency of Value
+	Value     float64 `json:"Value"`
+}
+
+// Reconciliation compares the spot position an exchange's Book entries hold with the balance
+// the exchange reports, Difference being the balance less the Book position. Difference_ is
+// the "Difference %" column, as a fraction of the larger of the two.
+type Reconciliation struct {
+	Exchange      string  `json:"Exchange" grist:"require"`
+	Ticker        string  `json:"Ticker" grist:"require"`
+	BookSize      float64 `json:"Book_Size"`
+	Balance       float64 `json:"Balance"`
+	Difference    float64 `json:"Difference"`
+	DifferencePct float64 `json:"Difference_"`
+	DifferenceUSD float64 `json:"Difference_USD_"`
+	Flagged       bool    `json:"Flagged"`
+	Checked       int64   `json:"Checked" grist:"type=DateTime:UTC"`
+}
+
+// Exposure is the net quantity of an asset held across wallets and exchanges, from on-chain
+// balances, exchange spot balances, signed perpetual positions and the underlying of Pendle
+// positions. Net_USD_ values Net at Price.
+type Exposure struct {
+	Ticker       string  `json:"Ticker" grist:"require"`
+	OnChain      float64 `json:"On_Chain"`
+	ExchangeSpot float64 `json:"Exchange_Spot"`
+	Perps        float64 `json:"Perps"`
+	Pendle       float64 `json:"Pendle"`
+	Net          float64 `json:"Net"`
+	Price        float64 `json:"Price"`
+	NetUSD       float64 `json:"Net_USD_"`
+	Updated      int64   `json:"Updated" grist:"type=DateTime:UTC"`
+}
+
+// Lot is a spot acquisition tracked in the Lots table until fully disposed of. Wallet is the
+// wallet of its Book entry, empty on exchanges with a single account.
+type Lot struct {
+	LotID     string  `json:"Lot_ID" grist:"require"`
+	Wallet    string  `json:"Wallet" grist:"require"`
+	Exchange  string  `json:"Exchange"`
+	Market    string  `json:"Market"`
+	Ticker    string  `json:"Ticker"`
+	TradeID   string  `json:"Trade_ID"`
+	Acquired  int64   `json:"Acquired"`
+	Quantity  float64 `json:"Quantity"`
+	Remaining float64 `json:"Remaining"`
+	Price     float64 `json:"Price"`
+}
+
+// LotMatch is the part of a disposal matched against one lot, stored in the Lot_Matches table
+type LotMatch struct {
+	MatchID   string  `json:"Match_ID" grist:"require"`
+	LotID     string  `json:"Lot_ID"`
+	TradeID   string  `json:"Trade_ID"`
+	Exchange  string  `json:"Exchange"`
+	Market    string  `json:"Market"`
+	Ticker    string  `json:"Ticker"`
+	Method    string  `json:"Method"`
+	Acquired  int64   `json:"Acquired"`
+	Disposed  int64   `json:"Disposed"`
+	Quantity  float64 `json:"Quantity"`
+	CostBasis float64 `json:"Cost_Basis"`
+	Proceeds  float64 `json:"Proceeds"`
+	Gain      float64 `json:"Gain"`
+}
+
+// OpeningBalance is a holding acquired outside the trade history, such as coins bought on a
+// defunct exchange, kept in the Opening_Balances table. A balance held on an Exchange is
+// booked there as a spot buy at Date before the trade history is replayed; one without an
+// Exchange costs the spot sells no booked position covers, on any exchange.
+// Tranche_ID tells apart the tranches of one asset acquired on the same date and names the lot
+// the tranche opens.
+type OpeningBalance struct {
+	TrancheID string  `json:"Tranche_ID" grist:"require"`
+	Date      int64   `json:"Date"`
+	Exchange  string  `json:"Exchange"`
+	Ticker    string  `json:"Ticker"`
+	Quantity  float64 `json:"Quantity"`
+	Cost      float64 `json:"Cost"`     // total cost of Quantity
+	Currency  string  `json:"Currency"` // booking currency when empty
+}
+
+// UnmatchedDisposal is the part of a spot sell no booked position covered, stored in the
+// Unmatched_Disposals table. Opening_Quantity of it was costed from opening balances, the
+// rest has no cost basis and books no PnL.
+type UnmatchedDisposal struct {
+	TradeID         string  `json:"Trade_ID" grist:"require"`
+	Exchange        string  `json:"Exchange" grist:"require"`
+	Ticker          string  `json:"Ticker"`
+	Time            int64   `json:"Time"`
+	Quantity        float64 `json:"Quantity"`
+	Price           float64 `json:"Price"`
+	Proceeds        float64 `json:"Proceeds"`
+	OpeningQuantity float64 `json:"Opening_Quantity"`
+	OpeningCost     float64 `json:"Opening_Cost"`
+	Currency        string  `json:"Currency"`
+}
+
+// LotSelection names the lots a disposal consumes when lots are matched by specific ID
+type LotSelection struct {
+	TradeID string `json:"Trade_ID" grist:"require"`
+	LotIDs  string `json:"Lot_IDs"`
+}
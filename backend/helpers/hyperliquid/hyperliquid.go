@@ -6,9 +6,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/zyriu/portfolio/backend/helpers/misc"
+	"github.com/zyriu/portfolio/backend/helpers/trades"
 )
 
 const apiBaseURL = "https://api.hyperliquid.xyz"
@@ -19,6 +21,7 @@ type UserFill struct {
 	Coin      string `json:"coin"`
 	ClosedPnL string `json:"closedPnl"`
 	Tid       int64  `json:"tid"`
+	Oid       int64  `json:"oid"`
 	Time      int64  `json:"time"`
 	Fee       string `json:"fee"`
 	FeeToken  string `json:"feeToken"`
@@ -27,6 +30,55 @@ type UserFill struct {
 	Side      string `json:"side"`
 }
 
+func (f UserFill) AggregationKey() trades.AggregationKey {
+	key := trades.AggregationKey{Asset: f.Coin, TimeMin: f.Time / 60000, Direction: f.Side, Price: f.Px}
+	if f.Oid != 0 {
+		key.OrderID = strconv.FormatInt(f.Oid, 10)
+	}
+
+	return key
+}
+
+func (f UserFill) FillTime() int64 {
+	return f.Time
+}
+
+// Amounts has no cost, Hyperliquid does not report the notional of a fill
+func (f UserFill) Amounts() (string, string, string) {
+	return f.Sz, f.Fee, ""
+}
+
+// UserFunding is a funding payment on a perpetual position, USDC is negative when paid
+type UserFunding struct {
+	Time  int64  `json:"time"`
+	Hash  string `json:"hash"`
+	Delta struct {
+		Type        string `json:"type"`
+		Coin        string `json:"coin"`
+		USDC        string `json:"usdc"`
+		Szi         string `json:"szi"`
+		FundingRate string `json:"fundingRate"`
+	} `json:"delta"`
+}
+
+// LedgerUpdate is a deposit, withdrawal or transfer of the account, the fields set in Delta
+// depend on its type
+type LedgerUpdate struct {
+	Time  int64  `json:"time"`
+	Hash  string `json:"hash"`
+	Delta struct {
+		Type        string `json:"type"`
+		USDC        string `json:"usdc"`
+		Token       string `json:"token"`
+		Amount      string `json:"amount"`
+		USDCValue   string `json:"usdcValue"`
+		User        string `json:"user"`
+		Destination string `json:"destination"`
+		Vault       string `json:"vault"`
+		Fee         string `json:"fee"`
+	} `json:"delta"`
+}
+
 type SpotClearinghouseState struct {
 	Balances []struct {
 		Coin     string `json:"coin"`
@@ -109,6 +161,128 @@ func (h *Hyperliquid) GetUserFillsByTime(ctx context.Context, user string, start
 	return userFills, nil
 }
 
+// GetUserFunding returns up to 500 funding payments from startTime, in milliseconds
+func (h *Hyperliquid) GetUserFunding(ctx context.Context, user string, startTime int64) ([]UserFunding, error) {
+	path := "/info"
+	endpoint := apiBaseURL + path
+
+	body := struct {
+		Type      string `json:"type"`
+		User      string `json:"user"`
+		StartTime int64  `json:"startTime"`
+	}{
+		Type:      "userFunding",
+		User:      user,
+		StartTime: startTime,
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal payload: %w", err)
+	}
+
+	resp, err := h.queryAPI(ctx, b, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var funding []UserFunding
+	if err := json.Unmarshal(resp, &funding); err != nil {
+		return nil, err
+	}
+
+	return funding, nil
+}
+
+// GetLedgerUpdates returns up to 500 non-funding ledger updates from startTime, in milliseconds
+func (h *Hyperliquid) GetLedgerUpdates(ctx context.Context, user string, startTime int64) ([]LedgerUpdate, error) {
+	path := "/info"
+	endpoint := apiBaseURL + path
+
+	body := struct {
+		Type      string `json:"type"`
+		User      string `json:"user"`
+		StartTime int64  `json:"startTime"`
+	}{
+		Type:      "userNonFundingLedgerUpdates",
+		User:      user,
+		StartTime: startTime,
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal payload: %w", err)
+	}
+
+	resp, err := h.queryAPI(ctx, b, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []LedgerUpdate
+	if err := json.Unmarshal(resp, &updates); err != nil {
+		return nil, err
+	}
+
+	return updates, nil
+}
+
+// GetPerpMarks returns the mark price of every perpetual, keyed by normalized ticker
+func (h *Hyperliquid) GetPerpMarks(ctx context.Context) (map[string]float64, error) {
+	path := "/info"
+	endpoint := apiBaseURL + path
+
+	b, err := json.Marshal(struct {
+		Type string `json:"type"`
+	}{Type: "metaAndAssetCtxs"})
+	if err != nil {
+		return nil, fmt.Errorf("marshal payload: %w", err)
+	}
+
+	resp, err := h.queryAPI(ctx, b, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []json.RawMessage
+	if err := json.Unmarshal(resp, &parts); err != nil {
+		return nil, err
+	}
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("unexpected metaAndAssetCtxs response of %d parts", len(parts))
+	}
+
+	var meta struct {
+		Universe []struct {
+			Name string `json:"name"`
+		} `json:"universe"`
+	}
+	var assetCtxs []struct {
+		MarkPx string `json:"markPx"`
+	}
+	if err := json.Unmarshal(parts[0], &meta); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(parts[1], &assetCtxs); err != nil {
+		return nil, err
+	}
+
+	marks := make(map[string]float64, len(assetCtxs))
+	for i, asset := range assetCtxs {
+		if i >= len(meta.Universe) {
+			break
+		}
+
+		mark, err := strconv.ParseFloat(asset.MarkPx, 64)
+		if err != nil {
+			continue
+		}
+		marks[h.NormalizeTicker(meta.Universe[i].Name)] = mark
+	}
+
+	return marks, nil
+}
+
 func (h *Hyperliquid) GetSpotBalances(ctx context.Context, user string) (SpotClearinghouseState, error) {
 	path := "/info"
 	endpoint := apiBaseURL + path
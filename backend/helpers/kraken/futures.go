@@ -0,0 +1,171 @@
+package kraken
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/misc"
+	"github.com/zyriu/portfolio/backend/helpers/settings"
+)
+
+const futuresBaseURL = "https://futures.kraken.com"
+
+// FuturesLogPageSize is the number of account log entries GetAccountLog returns at most
+const FuturesLogPageSize = 500
+
+// InitiateFuturesClient returns a Kraken Futures client, ok being unset when no Futures keys
+// are configured
+func InitiateFuturesClient() (Futures, bool, error) {
+	var futures Futures
+
+	settingsData, err := settings.GetCurrentSettings()
+	if err != nil {
+		return futures, false, fmt.Errorf("failed to load settings: %v", err)
+	}
+
+	futures.ApiKey = strings.TrimSpace(settingsData.Exchanges.Kraken.FuturesAPIKey)
+	futures.ApiSecret = strings.TrimSpace(settingsData.Exchanges.Kraken.FuturesAPISecret)
+	return futures, futures.ApiKey != "" && futures.ApiSecret != "", nil
+}
+
+// GetFills returns the 100 fills preceding before, newest first. A zero before returns the
+// latest fills.
+func (f *Futures) GetFills(ctx context.Context, before time.Time) ([]FuturesFill, error) {
+	query := url.Values{}
+	if !before.IsZero() {
+		query.Set("lastFillTime", before.UTC().Format("2006-01-02T15:04:05.000Z"))
+	}
+
+	var resp FuturesFills
+	if err := f.get(ctx, "/derivatives/api/v3/fills", query, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Fills, nil
+}
+
+// GetOpenPositions returns the open position of every contract
+func (f *Futures) GetOpenPositions(ctx context.Context) ([]FuturesPosition, error) {
+	var resp FuturesPositions
+	if err := f.get(ctx, "/derivatives/api/v3/openpositions", url.Values{}, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.OpenPositions, nil
+}
+
+// GetAccounts returns the cash, margin and multi-collateral accounts by name
+func (f *Futures) GetAccounts(ctx context.Context) (map[string]FuturesAccount, error) {
+	var resp FuturesAccounts
+	if err := f.get(ctx, "/derivatives/api/v3/accounts", url.Values{}, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Accounts, nil
+}
+
+// GetAccountLog returns up to FuturesLogPageSize account log entries, oldest first, from the
+// entry with id from on, or from since in ms when from is 0
+func (f *Futures) GetAccountLog(ctx context.Context, since int64, from int64) ([]FuturesLogEntry, error) {
+	query := url.Values{}
+	query.Set("sort", "asc")
+	query.Set("count", strconv.Itoa(FuturesLogPageSize))
+	if from > 0 {
+		query.Set("from", strconv.FormatInt(from, 10))
+	} else if since > 0 {
+		query.Set("since", strconv.FormatInt(since, 10))
+	}
+
+	var resp FuturesAccountLog
+	if err := f.get(ctx, "/api/history/v3/account-log", query, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Logs, nil
+}
+
+// ParseSymbol returns the base asset of a contract symbol such as PF_XBTUSD, and whether the
+// contract is linear, margined and sized in the base asset. Inverse contracts are sized in USD.
+func (f *Futures) ParseSymbol(symbol string) (string, bool) {
+	parts := strings.Split(strings.ToUpper(symbol), "_")
+	if len(parts) < 2 {
+		return symbol, false
+	}
+
+	base := strings.TrimSuffix(parts[1], "USD")
+	if base == "XBT" {
+		base = "BTC"
+	}
+
+	return base, parts[0] == "PF" || parts[0] == "FF"
+}
+
+// NormalizeAsset returns the ticker of a Futures account currency such as xbt or USD
+func (f *Futures) NormalizeAsset(asset string) string {
+	asset = strings.ToUpper(asset)
+	if asset == "XBT" {
+		return "BTC"
+	}
+
+	return asset
+}
+
+// get calls a private endpoint, signed with Kraken Futures' scheme: the HMAC-SHA512 of the
+// SHA256 of the query, nonce and path, the path leaving out the /derivatives prefix
+func (f *Futures) get(ctx context.Context, path string, query url.Values, result any) error {
+	nonce := strconv.FormatInt(time.Now().UnixNano(), 10)
+	encoded := query.Encode()
+
+	sha := sha256.New()
+	sha.Write([]byte(encoded + nonce + strings.TrimPrefix(path, "/derivatives")))
+
+	decodedSecret, err := base64.StdEncoding.DecodeString(f.ApiSecret)
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha512.New, decodedSecret)
+	mac.Write(sha.Sum(nil))
+
+	endpoint := futuresBaseURL + path
+	if encoded != "" {
+		endpoint += "?" + encoded
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("APIKey", f.ApiKey)
+	req.Header.Set("Nonce", nonce)
+	req.Header.Set("Authent", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+
+	resp := misc.DoWithRetry(ctx, req)
+	if resp.Err != nil {
+		return fmt.Errorf("kraken futures %s: %w", path, resp.Err)
+	}
+
+	var status struct {
+		Result string `json:"result"`
+		Error  string `json:"error"`
+	}
+	if err := json.Unmarshal(resp.Body, &status); err != nil {
+		return err
+	}
+
+	if status.Result == "error" || status.Error != "" {
+		return fmt.Errorf("kraken futures error: %s", status.Error)
+	}
+
+	return json.Unmarshal(resp.Body, result)
+}
@@ -0,0 +1,24 @@
+package kraken
+
+import "testing"
+
+func TestFutures_ParseSymbol(t *testing.T) {
+	var f Futures
+	cases := []struct {
+		symbol string
+		base   string
+		linear bool
+	}{
+		{"PF_XBTUSD", "BTC", true},
+		{"pf_ethusd", "ETH", true},
+		{"FF_XBTUSD_240628", "BTC", true},
+		{"PI_XBTUSD", "BTC", false},
+		{"FI_ETHUSD_240628", "ETH", false},
+	}
+
+	for _, c := range cases {
+		if base, linear := f.ParseSymbol(c.symbol); base != c.base || linear != c.linear {
+			t.Errorf("%s: expected %s (linear %v), got %s (linear %v)", c.symbol, c.base, c.linear, base, linear)
+		}
+	}
+}
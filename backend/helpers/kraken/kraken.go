@@ -32,13 +32,18 @@ func InitiateClient() (Kraken, error) {
 
 	kraken.ApiKey = settingsData.Exchanges.Kraken.APIKey
 	kraken.ApiSecret = settingsData.Exchanges.Kraken.APISecret
+	kraken.counter = newCallCounter()
 	return kraken, nil
 }
 
 func (k *Kraken) GetBalances(ctx context.Context) (map[string]string, error) {
 	path := "/0/private/Balance"
 
-	body, _ := k.queryAPI(ctx, url.Values{}, path)
+	body, err := k.queryAPI(ctx, url.Values{}, path)
+	if err != nil {
+		return nil, err
+	}
+
 	var balances Balances
 	if err := json.Unmarshal(body, &balances); err != nil {
 		return nil, err
@@ -64,15 +69,56 @@ func (k *Kraken) GetBaseAndQuote(pair string) (string, string) {
 	return pair, ""
 }
 
-func (k *Kraken) GetTradesHistory(ctx context.Context, offset int64) (TradesHistory, error) {
+// GetTradesHistory returns a page of 50 trades between start and end, in unix seconds and
+// both exclusive, newest first. An end of 0 leaves the window open up to now. Count is the
+// number of trades in the window, stable across pages as long as end is fixed.
+func (k *Kraken) GetTradesHistory(ctx context.Context, start int64, end int64, offset int64) (TradesHistory, error) {
 	path := "/0/private/TradesHistory"
 
 	form := url.Values{}
 	form.Set("ofs", fmt.Sprintf("%d", offset))
-
-	body, _ := k.queryAPI(ctx, form, path)
+	if start > 0 {
+		form.Set("start", fmt.Sprintf("%d", start))
+	}
+	if end > 0 {
+		form.Set("end", fmt.Sprintf("%d", end))
+	}
 
 	var resp TradesHistory
+	body, err := k.queryAPI(ctx, form, path)
+	if err != nil {
+		return resp, err
+	}
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return resp, err
+	}
+
+	if len(resp.Error) > 0 {
+		return resp, fmt.Errorf("kraken error: %v", resp.Error)
+	}
+
+	return resp, nil
+}
+
+// GetLedgers returns a page of 50 ledger entries of ledgerType after start, in unix seconds,
+// newest first
+func (k *Kraken) GetLedgers(ctx context.Context, ledgerType string, start int64, offset int64) (Ledgers, error) {
+	path := "/0/private/Ledgers"
+
+	form := url.Values{}
+	form.Set("type", ledgerType)
+	form.Set("ofs", fmt.Sprintf("%d", offset))
+	if start > 0 {
+		form.Set("start", fmt.Sprintf("%d", start))
+	}
+
+	var resp Ledgers
+	body, err := k.queryAPI(ctx, form, path)
+	if err != nil {
+		return resp, err
+	}
+
 	if err := json.Unmarshal(body, &resp); err != nil {
 		return resp, err
 	}
@@ -99,7 +145,34 @@ func (k *Kraken) NormalizeTicker(ticker string) string {
 	}
 }
 
+// queryAPI calls a private endpoint once the call counter allows it, waiting out the counter
+// and retrying when Kraken still rejects the call for its rate limit
 func (k *Kraken) queryAPI(ctx context.Context, data url.Values, path string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		if err := k.counter.wait(ctx, callCost(path)); err != nil {
+			return nil, err
+		}
+
+		body, err := k.post(ctx, data, path)
+		if err != nil {
+			return nil, err
+		}
+
+		var status struct {
+			Error []string `json:"error"`
+		}
+		if json.Unmarshal(body, &status) != nil || !isRateLimit(status.Error) {
+			return body, nil
+		}
+
+		k.counter.exhausted()
+		if attempt == rateLimitRetries || k.counter == nil {
+			return nil, fmt.Errorf("%s: %w", path, ErrRateLimited)
+		}
+	}
+}
+
+func (k *Kraken) post(ctx context.Context, data url.Values, path string) ([]byte, error) {
 	endpoint := apiBaseURL + path
 
 	nonce := strconv.FormatInt(time.Now().UnixNano(), 10)
@@ -128,7 +201,7 @@ func (k *Kraken) queryAPI(ctx context.Context, data url.Values, path string) ([]
 
 	resp := misc.DoWithRetry(ctx, req)
 	if resp.Err != nil {
-		return nil, err
+		return nil, resp.Err
 	}
 
 	return resp.Body, nil
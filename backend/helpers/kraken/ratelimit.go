@@ -0,0 +1,112 @@
+package kraken
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned once Kraken keeps rejecting a call after the counter was waited out
+var ErrRateLimited = errors.New("kraken rate limit exceeded")
+
+// Kraken counts private calls per API key: each call adds its cost to a counter that decays
+// over time, and calls are rejected while it would exceed the maximum. The limits are those of
+// the Starter verification tier, the strictest, so they hold for every account.
+const (
+	counterMax   = 15.0
+	counterDecay = 0.33 // per second
+
+	historyCost = 2 // TradesHistory and Ledgers
+	defaultCost = 1
+
+	rateLimitRetries = 3
+)
+
+// callCounter mirrors Kraken's call counter to wait before a call would be rejected
+type callCounter struct {
+	mu      sync.Mutex
+	counter float64
+	updated time.Time
+	now     func() time.Time
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+func newCallCounter() *callCounter {
+	return &callCounter{now: time.Now, sleep: sleepContext}
+}
+
+// wait blocks until cost fits under the maximum, then counts the call
+func (c *callCounter) wait(ctx context.Context, cost float64) error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.decay()
+	if over := c.counter + cost - counterMax; over > 0 {
+		if err := c.sleep(ctx, time.Duration(over/counterDecay*float64(time.Second))); err != nil {
+			return err
+		}
+		c.decay()
+	}
+
+	c.counter += cost
+	return nil
+}
+
+// exhausted records a rejected call, Kraken's counter being full
+func (c *callCounter) exhausted() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.decay()
+	c.counter = counterMax
+}
+
+func (c *callCounter) decay() {
+	now := c.now()
+	if !c.updated.IsZero() {
+		c.counter = max(0, c.counter-now.Sub(c.updated).Seconds()*counterDecay)
+	}
+	c.updated = now
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// isRateLimit reports whether Kraken rejected a call for exceeding the call counter
+func isRateLimit(errs []string) bool {
+	for _, e := range errs {
+		if strings.Contains(e, "Rate limit exceeded") || strings.Contains(e, "Too many requests") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// callCost is the counter cost of a private endpoint
+func callCost(path string) float64 {
+	if strings.HasSuffix(path, "/TradesHistory") || strings.HasSuffix(path, "/Ledgers") {
+		return historyCost
+	}
+
+	return defaultCost
+}
@@ -0,0 +1,51 @@
+package kraken
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCallCounter_WaitsForTheCounterToDecay(t *testing.T) {
+	now := time.Unix(0, 0)
+	var slept time.Duration
+	c := &callCounter{
+		now: func() time.Time { return now },
+		sleep: func(_ context.Context, d time.Duration) error {
+			slept += d
+			now = now.Add(d)
+			return nil
+		},
+	}
+
+	// 7 history calls fit under the maximum of 15, the 8th waits for 1 to decay
+	for i := 0; i < 8; i++ {
+		if err := c.wait(context.Background(), historyCost); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	over := 1.0
+	if want := time.Duration(over / counterDecay * float64(time.Second)); slept != want {
+		t.Errorf("expected to wait %v, got %v", want, slept)
+	}
+
+	c.exhausted()
+	now = now.Add(10 * time.Second)
+	slept = 0
+	if err := c.wait(context.Background(), defaultCost); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if slept != 0 {
+		t.Errorf("expected 10s of decay to leave room for a call, waited %v", slept)
+	}
+}
+
+func TestIsRateLimit(t *testing.T) {
+	if !isRateLimit([]string{"EAPI:Rate limit exceeded"}) || !isRateLimit([]string{"EGeneral:Too many requests"}) {
+		t.Error("expected rate limit errors to be recognized")
+	}
+	if isRateLimit([]string{"EGeneral:Invalid arguments"}) {
+		t.Error("expected other errors to be left alone")
+	}
+}
@@ -1,8 +1,13 @@
 package kraken
 
+import "github.com/zyriu/portfolio/backend/helpers/trades"
+
 type Kraken struct {
 	ApiKey    string
 	ApiSecret string
+
+	// counter paces private calls, nil leaves them unpaced
+	counter *callCounter
 }
 
 type Balances struct {
@@ -12,6 +17,7 @@ type Balances struct {
 
 type Trade struct {
 	TradeID   any     `json:"trade_id"`
+	OrderTxID string  `json:"ordertxid"`
 	Pair      string  `json:"pair"`
 	Time      float64 `json:"time"`
 	Type      string  `json:"type"`
@@ -24,6 +30,18 @@ type Trade struct {
 	Maker     bool    `json:"maker"`
 }
 
+func (t Trade) AggregationKey() trades.AggregationKey {
+	return trades.AggregationKey{Asset: t.Pair, TimeMin: int64(t.Time) / 60, Direction: t.Type, Price: t.Price, OrderID: t.OrderTxID}
+}
+
+func (t Trade) FillTime() int64 {
+	return int64(t.Time * 1000)
+}
+
+func (t Trade) Amounts() (string, string, string) {
+	return t.Vol, t.Fee, t.Cost
+}
+
 type TradesHistory struct {
 	Error  []string `json:"error"`
 	Result struct {
@@ -31,3 +49,88 @@ type TradesHistory struct {
 		Trades map[string]Trade `json:"trades"`
 	} `json:"result"`
 }
+
+type LedgerEntry struct {
+	LedgerID string  `json:"-"`
+	RefID    string  `json:"refid"`
+	Time     float64 `json:"time"`
+	Type     string  `json:"type"`
+	Subtype  string  `json:"subtype"`
+	Aclass   string  `json:"aclass"`
+	Asset    string  `json:"asset"`
+	Amount   string  `json:"amount"`
+	Fee      string  `json:"fee"`
+	Balance  string  `json:"balance"`
+}
+
+type Ledgers struct {
+	Error  []string `json:"error"`
+	Result struct {
+		Count  int64                  `json:"count"`
+		Ledger map[string]LedgerEntry `json:"ledger"`
+	} `json:"result"`
+}
+
+type Futures struct {
+	ApiKey    string
+	ApiSecret string
+}
+
+type FuturesFill struct {
+	FillID   string  `json:"fill_id"`
+	Symbol   string  `json:"symbol"`
+	Side     string  `json:"side"`
+	OrderID  string  `json:"order_id"`
+	Size     float64 `json:"size"`
+	Price    float64 `json:"price"`
+	FillTime string  `json:"fillTime"`
+	FillType string  `json:"fillType"` // maker, taker or liquidation
+}
+
+type FuturesFills struct {
+	Fills []FuturesFill `json:"fills"`
+}
+
+type FuturesPosition struct {
+	Side              string  `json:"side"` // long or short
+	Symbol            string  `json:"symbol"`
+	Price             float64 `json:"price"`
+	Size              float64 `json:"size"`
+	UnrealizedFunding float64 `json:"unrealizedFunding"`
+}
+
+type FuturesPositions struct {
+	OpenPositions []FuturesPosition `json:"openPositions"`
+}
+
+// FuturesAccount holds Balances for cash and single-collateral margin accounts, and
+// Currencies for the multi-collateral flex account
+type FuturesAccount struct {
+	Type       string             `json:"type"`
+	Balances   map[string]float64 `json:"balances"`
+	Currencies map[string]struct {
+		Quantity float64 `json:"quantity"`
+	} `json:"currencies"`
+}
+
+type FuturesAccounts struct {
+	Accounts map[string]FuturesAccount `json:"accounts"`
+}
+
+// FuturesLogEntry is a line of the account log. Trades carry their fee and the fill they
+// executed, funding payments the realized funding in the margin currency.
+type FuturesLogEntry struct {
+	ID              int64   `json:"id"`
+	Date            string  `json:"date"`
+	Info            string  `json:"info"`
+	Asset           string  `json:"asset"`
+	Contract        string  `json:"contract"`
+	Execution       string  `json:"execution"`
+	Fee             float64 `json:"fee"`
+	FundingRate     float64 `json:"funding_rate"`
+	RealizedFunding float64 `json:"realized_funding"`
+}
+
+type FuturesAccountLog struct {
+	Logs []FuturesLogEntry `json:"logs"`
+}
@@ -0,0 +1,44 @@
+package performance
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+func amount(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// rate formats a return as a fraction, empty when it could not be computed
+func rate(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return ""
+	}
+
+	return strconv.FormatFloat(v, 'f', 6, 64)
+}
+
+// Write writes the returns as CSV, one row per scope and period
+func Write(w io.Writer, returns []Return) error {
+	cw := csv.NewWriter(w)
+
+	rows := [][]string{{"Scope", "Name", "From", "To", "Start", "End", "Start_Value", "End_Value", "Net_Flows", "Gain", "TWR", "IRR"}}
+	for _, r := range returns {
+		rows = append(rows, []string{
+			r.Scope, r.Name,
+			r.From.Format(time.DateOnly), r.To.Format(time.DateOnly),
+			r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339),
+			amount(r.StartValue), amount(r.EndValue), amount(r.NetFlows), amount(r.Gain),
+			rate(r.TWR), rate(r.IRR),
+		})
+	}
+
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+
+	return cw.Error()
+}
@@ -0,0 +1,388 @@
+package performance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+const (
+	Portfolio = "Portfolio"
+	Wallet    = "Wallet"
+	Exchange  = "Exchange"
+)
+
+const year = 365 * 24 * time.Hour
+
+// ParseScope validates a scope name, case insensitively
+func ParseScope(s string) (string, error) {
+	for _, scope := range []string{Portfolio, Wallet, Exchange} {
+		if strings.EqualFold(strings.TrimSpace(s), scope) {
+			return scope, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown scope %q, expected %s, %s or %s", s, Portfolio, Wallet, Exchange)
+}
+
+// Return is the performance of a scope over a period. Start and End are the snapshots the
+// period was valued at, NetFlows the transfers in less the transfers out between them, all
+// in USD. TWR is the time-weighted return over the period, IRR the money-weighted return
+// annualized, NaN when the flows have none.
+type Return struct {
+	Scope      string
+	Name       string
+	From       time.Time
+	To         time.Time
+	Start      time.Time
+	End        time.Time
+	StartValue float64
+	EndValue   float64
+	NetFlows   float64
+	Gain       float64
+	TWR        float64
+	IRR        float64
+}
+
+type flow struct {
+	at     time.Time
+	amount float64 // positive into the scope
+}
+
+// series is the value of a scope at every snapshot and the flows in and out of it
+type series struct {
+	dates  []time.Time
+	values map[time.Time]float64
+	flows  []flow
+}
+
+// scopeOf returns the wallet and exchange a snapshot row belongs to, empty when it has none
+func scopeOf(s grist.Snapshot) (string, string) {
+	if s.Source != "Positions_Crypto_" {
+		return s.Wallet, ""
+	}
+
+	exchange, _ := grist.PositionExchange(s.Wallet, s.Chain)
+	return s.Wallet, exchange
+}
+
+// build splits the snapshots and transfers into the series of every scope, by is Portfolio,
+// Wallet or Exchange
+func build(by string, snapshots []grist.Snapshot, transfers []grist.Transfer) (map[string]*series, error) {
+	all := make(map[time.Time]bool)
+	for _, s := range snapshots {
+		all[time.Unix(s.Date, 0).UTC()] = true
+	}
+
+	dates := make([]time.Time, 0, len(all))
+	for d := range all {
+		dates = append(dates, d)
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+
+	result := make(map[string]*series)
+	get := func(name string) *series {
+		if s, ok := result[name]; ok {
+			return s
+		}
+		// every scope is valued at every snapshot, at 0 when it held nothing
+		s := &series{dates: dates, values: make(map[time.Time]float64)}
+		result[name] = s
+		return s
+	}
+
+	name := func(wallet string, exchange string) (string, bool) {
+		switch by {
+		case Portfolio:
+			return Portfolio, true
+		case Wallet:
+			return wallet, wallet != ""
+		case Exchange:
+			return exchange, exchange != ""
+		}
+		return "", false
+	}
+
+	if _, err := ParseScope(by); err != nil {
+		return nil, err
+	}
+
+	for _, s := range snapshots {
+		if n, ok := name(scopeOf(s)); ok {
+			get(n).values[time.Unix(s.Date, 0).UTC()] += s.USDValue
+		}
+	}
+
+	for _, t := range transfers {
+		n, ok := name(t.Wallet, t.Exchange)
+		if !ok {
+			continue
+		}
+
+		amount := t.USDValue
+		if t.Direction == "Out" {
+			amount = -amount
+		}
+		get(n).flows = append(get(n).flows, flow{at: time.UnixMilli(t.Time).UTC(), amount: amount})
+	}
+
+	return result, nil
+}
+
+// at returns the latest snapshot at or before t, or the first one after it when there is none
+func (s *series) at(t time.Time) (time.Time, bool) {
+	i := sort.Search(len(s.dates), func(i int) bool { return s.dates[i].After(t) })
+	if i > 0 {
+		return s.dates[i-1], true
+	}
+
+	if len(s.dates) > 0 {
+		return s.dates[0], true
+	}
+
+	return time.Time{}, false
+}
+
+// between sums the flows after from up to and including to
+func (s *series) between(from time.Time, to time.Time) float64 {
+	total := 0.0
+	for _, f := range s.flows {
+		if f.at.After(from) && !f.at.After(to) {
+			total += f.amount
+		}
+	}
+
+	return total
+}
+
+// measure computes the return of the series from the snapshot at from to the snapshot at to.
+// Flows are taken to happen at the end of the sub-period between two snapshots they fall in.
+func (s *series) measure(from time.Time, to time.Time) (Return, bool) {
+	start, ok := s.at(from)
+	if !ok {
+		return Return{}, false
+	}
+
+	end, _ := s.at(to)
+	if !end.After(start) {
+		return Return{}, false
+	}
+
+	r := Return{
+		From: from, To: to, Start: start, End: end,
+		StartValue: s.values[start], EndValue: s.values[end],
+		NetFlows: s.between(start, end),
+	}
+	r.Gain = r.EndValue - r.StartValue - r.NetFlows
+
+	growth := 1.0
+	prev := start
+	for _, d := range s.dates {
+		if !d.After(start) || d.After(end) {
+			continue
+		}
+
+		if v := s.values[prev]; v > 0 {
+			growth *= (s.values[d] - s.between(prev, d)) / v
+		}
+		prev = d
+	}
+	r.TWR = growth - 1
+
+	cashflows := []flow{{at: start, amount: -r.StartValue}}
+	for _, f := range s.flows {
+		if f.at.After(start) && !f.at.After(end) {
+			cashflows = append(cashflows, flow{at: f.at, amount: -f.amount})
+		}
+	}
+	cashflows = append(cashflows, flow{at: end, amount: r.EndValue})
+	r.IRR = irr(start, cashflows)
+
+	return r, true
+}
+
+// irr solves the annual rate at which the cash flows, negative when invested and positive
+// when returned, have a net present value of zero at start
+func irr(start time.Time, cashflows []flow) float64 {
+	npv := func(rate float64) float64 {
+		total := 0.0
+		for _, c := range cashflows {
+			years := float64(c.at.Sub(start)) / float64(year)
+			total += c.amount / math.Pow(1+rate, years)
+		}
+		return total
+	}
+
+	low, high := -0.9999, 1.0
+	for npv(high) > 0 && high < 1e9 {
+		high *= 2
+	}
+
+	if npv(low)*npv(high) > 0 {
+		return math.NaN()
+	}
+
+	for i := 0; i < 200; i++ {
+		mid := (low + high) / 2
+		if npv(low)*npv(mid) <= 0 {
+			high = mid
+		} else {
+			low = mid
+		}
+	}
+
+	return (low + high) / 2
+}
+
+// Compute returns the performance over every period of each scope the snapshots and
+// transfers cover, sorted by scope name then period. Values are in USD.
+func Compute(by string, snapshots []grist.Snapshot, transfers []grist.Transfer, periods []Period) ([]Return, error) {
+	scopes, err := build(by, snapshots, transfers)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(scopes))
+	for name := range scopes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var result []Return
+	for _, name := range names {
+		for _, p := range periods {
+			r, ok := scopes[name].measure(p.From, p.To)
+			if !ok {
+				continue
+			}
+
+			r.Scope, r.Name = by, name
+			result = append(result, r)
+		}
+	}
+
+	return result, nil
+}
+
+// Period is a time range returns are measured over
+type Period struct {
+	From time.Time
+	To   time.Time
+}
+
+// Monthly splits from to to into calendar months, the first and last being partial
+func Monthly(from time.Time, to time.Time) []Period {
+	var periods []Period
+	for start := from; start.Before(to); {
+		next := time.Date(start.Year(), start.Month()+1, 1, 0, 0, 0, 0, start.Location())
+		if next.After(to) {
+			next = to
+		}
+		periods = append(periods, Period{From: start, To: next})
+		start = next
+	}
+
+	return periods
+}
+
+// Kraken ledger subtypes of moves between the spot, staking and futures wallets of the account
+var (
+	krakenStaking = map[string]bool{"spottostaking": true, "spotfromstaking": true, "stakingfromspot": true, "stakingtospot": true}
+	krakenFutures = map[string]bool{"spottofutures": true, "spotfromfutures": true}
+)
+
+// withinKraken settles the moves between the wallets of the Kraken account, which the ledger
+// records on the spot side only. Staked balances are held in the spot wallet, so staking moves
+// are dropped; moves to and from Futures get the opposite leg in the Futures wallet, and so
+// cancel out at the Exchange and Portfolio scopes.
+func withinKraken(transfers []grist.Transfer) []grist.Transfer {
+	var result []grist.Transfer
+	for _, t := range transfers {
+		subtype := strings.ToLower(t.Counterparty)
+		if t.Exchange != "Kraken" || !krakenStaking[subtype] && !krakenFutures[subtype] {
+			result = append(result, t)
+			continue
+		}
+
+		if krakenStaking[subtype] {
+			continue
+		}
+
+		leg := t
+		leg.TransferID = t.TransferID + "-futures"
+		leg.Wallet = grist.KrakenFuturesWallet
+		leg.Direction = "In"
+		if t.Direction == "In" {
+			leg.Direction = "Out"
+		}
+		result = append(result, t, leg)
+	}
+
+	return result
+}
+
+// external drops the transfers between the exchanges and the configured wallets, which move
+// value within the portfolio. Hyperliquid bridges to and from the same address on Arbitrum.
+// labels maps the configured wallet addresses, lowercased, to their label.
+func external(transfers []grist.Transfer, labels map[string]string) []grist.Transfer {
+	own := make(map[string]bool)
+	for address, label := range labels {
+		own[address] = true
+		own[strings.ToLower(label)] = true
+	}
+
+	var result []grist.Transfer
+	for _, t := range transfers {
+		counterparty := t.Counterparty
+		if t.Exchange == "Hyperliquid" && counterparty == "Bridge" {
+			counterparty = t.Wallet
+		}
+
+		if !own[strings.ToLower(counterparty)] {
+			result = append(result, t)
+		}
+	}
+
+	return result
+}
+
+// Load computes the returns from the Snapshots and Transfers stored in Grist. Transfers are
+// stored by wallet address and snapshots by label, labels maps the addresses, lowercased, to
+// their label. Moves between the Kraken wallets are settled by withinKraken, and at Portfolio
+// scope the transfers to and from configured wallets are left out.
+func Load(ctx context.Context, g *grist.Grist, by string, periods []Period, labels map[string]string) ([]Return, error) {
+	by, err := ParseScope(by)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots, err := grist.FetchTable[grist.Snapshot](ctx, g, "Snapshots", "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch snapshots: %w", err)
+	}
+
+	transfers, err := g.FetchTransfers(ctx)
+	// A backup taken before transfers were synced has no flows
+	if err != nil && !errors.Is(err, grist.ErrReadOnly) {
+		return nil, fmt.Errorf("failed to fetch transfers: %w", err)
+	}
+
+	transfers = withinKraken(transfers)
+	if by == Portfolio {
+		transfers = external(transfers, labels)
+	}
+
+	for i, t := range transfers {
+		if label, ok := labels[strings.ToLower(t.Wallet)]; ok {
+			transfers[i].Wallet = label
+		}
+	}
+
+	return Compute(by, snapshots, transfers, periods)
+}
@@ -0,0 +1,223 @@
+package performance
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func snapshot(at time.Time, wallet string, chain string, value float64) grist.Snapshot {
+	return grist.Snapshot{Date: at.Unix(), Source: "Positions_Crypto_", Wallet: wallet, Chain: chain, Ticker: "BTC", USDValue: value}
+}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestCompute_TimeAndMoneyWeighted(t *testing.T) {
+	snapshots := []grist.Snapshot{
+		snapshot(date(2025, 1, 1), "Main", "Ethereum", 1000),
+		snapshot(date(2025, 7, 1), "Main", "Ethereum", 1650),
+		snapshot(date(2026, 1, 1), "Main", "Ethereum", 1800),
+	}
+	transfers := []grist.Transfer{
+		{Exchange: "Kraken", Wallet: "Kraken", Time: date(2025, 6, 30).UnixMilli(), Direction: "In", USDValue: 500},
+	}
+
+	returns, err := Compute(Portfolio, snapshots, transfers, []Period{{From: date(2025, 1, 1), To: date(2026, 1, 1)}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(returns) != 1 {
+		t.Fatalf("expected one return, got %+v", returns)
+	}
+
+	r := returns[0]
+	if r.StartValue != 1000 || r.EndValue != 1800 || r.NetFlows != 500 || r.Gain != 300 {
+		t.Errorf("unexpected values %+v", r)
+	}
+
+	// (1650 - 500) / 1000 then 1800 / 1650
+	if want := 1.15*1800/1650 - 1; !approx(r.TWR, want) {
+		t.Errorf("expected a TWR of %f, got %f", want, r.TWR)
+	}
+
+	npv := -1000 - 500/math.Pow(1+r.IRR, float64(date(2025, 6, 30).Sub(r.Start))/float64(year)) + 1800/(1+r.IRR)
+	if math.IsNaN(r.IRR) || math.Abs(npv) > 1e-6 {
+		t.Errorf("expected the IRR to zero the cash flows, got %f (npv %f)", r.IRR, npv)
+	}
+}
+
+func TestCompute_WithoutFlowsIRRMatchesTWR(t *testing.T) {
+	snapshots := []grist.Snapshot{
+		snapshot(date(2025, 1, 1), "Main", "Ethereum", 1000),
+		snapshot(date(2026, 1, 1), "Main", "Ethereum", 1100),
+	}
+
+	returns, err := Compute(Portfolio, snapshots, nil, []Period{{From: date(2024, 6, 1), To: date(2026, 6, 1)}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(returns) != 1 || !approx(returns[0].TWR, 0.1) || !approx(returns[0].IRR, 0.1) {
+		t.Errorf("expected 10%% over the year both ways, got %+v", returns)
+	}
+}
+
+func TestCompute_ByWalletAndExchange(t *testing.T) {
+	snapshots := []grist.Snapshot{
+		snapshot(date(2025, 1, 1), "Kraken", "", 100),
+		snapshot(date(2025, 1, 1), "Main", "Hyperliquid", 200),
+		snapshot(date(2025, 1, 1), "Main", "Ethereum", 300),
+		snapshot(date(2025, 2, 1), "Kraken", "", 110),
+		snapshot(date(2025, 2, 1), "Main", "Hyperliquid", 300),
+		snapshot(date(2025, 2, 1), "Main", "Ethereum", 300),
+	}
+	transfers := []grist.Transfer{
+		{Exchange: "Hyperliquid", Wallet: "Main", Time: date(2025, 1, 15).UnixMilli(), Direction: "In", USDValue: 80},
+	}
+	periods := []Period{{From: date(2025, 1, 1), To: date(2025, 2, 1)}}
+
+	byExchange, err := Compute(Exchange, snapshots, transfers, periods)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(byExchange) != 2 || byExchange[0].Name != "Hyperliquid" || byExchange[1].Name != "Kraken" {
+		t.Fatalf("expected Hyperliquid and Kraken only, got %+v", byExchange)
+	}
+	if r := byExchange[0]; !approx(r.TWR, 0.1) || r.NetFlows != 80 {
+		t.Errorf("expected (300 - 80) / 200 for Hyperliquid, got %+v", r)
+	}
+
+	byWallet, err := Compute(Wallet, snapshots, transfers, periods)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(byWallet) != 2 || byWallet[1].Name != "Main" || byWallet[1].StartValue != 500 || byWallet[1].EndValue != 600 {
+		t.Errorf("expected the Main wallet across chains, got %+v", byWallet)
+	}
+
+	if _, err := Compute("Chain", snapshots, transfers, periods); err == nil {
+		t.Error("expected an unknown scope to fail")
+	}
+	if scope, err := ParseScope(" exchange"); err != nil || scope != Exchange {
+		t.Errorf("expected scopes to parse case insensitively, got %q, %v", scope, err)
+	}
+}
+
+func TestCompute_KrakenFuturesInTheExchangeScope(t *testing.T) {
+	snapshots := []grist.Snapshot{
+		snapshot(date(2025, 1, 1), "Kraken", "", 100),
+		snapshot(date(2025, 1, 1), "Kraken Futures", "Kraken", 100),
+		snapshot(date(2025, 2, 1), "Kraken", "", 100),
+		snapshot(date(2025, 2, 1), "Kraken Futures", "Kraken", 120),
+	}
+
+	returns, err := Compute(Exchange, snapshots, nil, []Period{{From: date(2025, 1, 1), To: date(2025, 2, 1)}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(returns) != 1 || returns[0].Name != "Kraken" || returns[0].StartValue != 200 || returns[0].EndValue != 220 {
+		t.Errorf("expected the Futures account in the Kraken scope, got %+v", returns)
+	}
+}
+
+func TestExternal_DropsTransfersWithinThePortfolio(t *testing.T) {
+	snapshots := []grist.Snapshot{
+		snapshot(date(2025, 1, 1), "Main", "Hyperliquid", 500),
+		snapshot(date(2025, 1, 1), "Main", "Arbitrum", 0),
+		snapshot(date(2025, 2, 1), "Main", "Hyperliquid", 300),
+		snapshot(date(2025, 2, 1), "Main", "Arbitrum", 200),
+	}
+	labels := map[string]string{"0xabc": "Main", "0xdef": "Cold"}
+	transfers := []grist.Transfer{
+		// withdrawn to the same address on Arbitrum, then sent to another configured wallet
+		{Exchange: "Hyperliquid", Wallet: "0xabc", Time: date(2025, 1, 10).UnixMilli(), Direction: "Out", USDValue: 200, Counterparty: "Bridge"},
+		{Exchange: "Hyperliquid", Wallet: "0xabc", Time: date(2025, 1, 12).UnixMilli(), Direction: "Out", USDValue: 50, Counterparty: "0xDEF"},
+		{Exchange: "Hyperliquid", Wallet: "0xabc", Time: date(2025, 1, 14).UnixMilli(), Direction: "In", USDValue: 50, Counterparty: "0x123"},
+		{Exchange: "Kraken", Wallet: "Kraken", Time: date(2025, 1, 16).UnixMilli(), Direction: "In", USDValue: 10, Counterparty: "Cold"},
+	}
+
+	kept := external(transfers, labels)
+	if len(kept) != 1 || kept[0].Counterparty != "0x123" {
+		t.Fatalf("expected only the transfer from outside the portfolio, got %+v", kept)
+	}
+
+	returns, err := Compute(Portfolio, snapshots, kept, []Period{{From: date(2025, 1, 1), To: date(2025, 2, 1)}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(returns) != 1 || returns[0].NetFlows != 50 || returns[0].Gain != -50 {
+		t.Errorf("expected the withdrawal to the own wallet to book no gain, got %+v", returns)
+	}
+}
+
+func TestWithinKraken_SettlesMovesBetweenItsWallets(t *testing.T) {
+	snapshots := []grist.Snapshot{
+		snapshot(date(2025, 1, 1), "Kraken", "", 1000),
+		snapshot(date(2025, 2, 1), "Kraken", "", 600),
+		snapshot(date(2025, 2, 1), grist.KrakenFuturesWallet, "Kraken", 400),
+	}
+	transfers := withinKraken([]grist.Transfer{
+		{TransferID: "f", Exchange: "Kraken", Wallet: "Kraken", Time: date(2025, 1, 10).UnixMilli(), Direction: "Out", USDValue: 400, Counterparty: "spottofutures"},
+		{TransferID: "s", Exchange: "Kraken", Wallet: "Kraken", Time: date(2025, 1, 12).UnixMilli(), Direction: "Out", USDValue: 100, Counterparty: "spottostaking"},
+		{TransferID: "w", Exchange: "Kraken", Wallet: "Kraken", Time: date(2025, 1, 14).UnixMilli(), Direction: "Out", USDValue: 0, Counterparty: ""},
+	})
+	if len(transfers) != 3 || transfers[1].Wallet != grist.KrakenFuturesWallet || transfers[1].Direction != "In" {
+		t.Fatalf("expected the futures move with its opposite leg and the staking move dropped, got %+v", transfers)
+	}
+
+	periods := []Period{{From: date(2025, 1, 1), To: date(2025, 2, 1)}}
+	for _, by := range []string{Exchange, Portfolio} {
+		returns, err := Compute(by, snapshots, transfers, periods)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(returns) != 1 || returns[0].NetFlows != 0 || returns[0].Gain != 0 {
+			t.Errorf("expected no flow or gain at %s scope, got %+v", by, returns)
+		}
+	}
+
+	byWallet, err := Compute(Wallet, snapshots, transfers, periods)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, r := range byWallet {
+		if r.Gain != 0 {
+			t.Errorf("expected the move to be a flow of both wallets, got %+v", r)
+		}
+	}
+}
+
+func TestMonthly(t *testing.T) {
+	periods := Monthly(date(2025, 1, 15), date(2025, 3, 10))
+
+	if len(periods) != 3 || periods[0].To != date(2025, 2, 1) || periods[1].From != date(2025, 2, 1) || periods[2].To != date(2025, 3, 10) {
+		t.Errorf("unexpected periods %+v", periods)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	returns := []Return{{Scope: Portfolio, Name: Portfolio, From: date(2025, 1, 1), To: date(2025, 2, 1), TWR: 0.05, IRR: math.NaN()}}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, returns); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid csv: %v", err)
+	}
+	if len(rows) != 2 || rows[1][2] != "2025-01-01" || rows[1][10] != "0.050000" || rows[1][11] != "" {
+		t.Errorf("unexpected rows %v", rows)
+	}
+}
@@ -0,0 +1,93 @@
+package rebuild
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+const (
+	Added   = "+"
+	Removed = "-"
+	Changed = "~"
+)
+
+// Column is a column whose stored value differs from the rebuilt one
+type Column struct {
+	Name string
+	Old  any
+	New  any
+}
+
+// Change is a Book entry, trade, lot or unmatched disposal the rebuild adds, removes or modifies
+type Change struct {
+	Table   string
+	Key     string
+	Kind    string
+	Columns []Column
+}
+
+// diff lists the written columns of two rows of the same table that differ, ignoring the
+// rounding picked up by floats stored in Grist
+func diff[T any](old T, rebuilt T) []Column {
+	before, after := grist.RecordFromStruct(old).Fields, grist.RecordFromStruct(rebuilt).Fields
+
+	var columns []Column
+	for name, value := range after {
+		if !equal(before[name], value) {
+			columns = append(columns, Column{Name: name, Old: before[name], New: value})
+		}
+	}
+	sort.Slice(columns, func(i, j int) bool { return columns[i].Name < columns[j].Name })
+
+	return columns
+}
+
+func equal(a, b any) bool {
+	x, ok := a.(float64)
+	y, ok2 := b.(float64)
+	if ok && ok2 {
+		return math.Abs(x-y) <= 1e-8*math.Max(1, math.Max(math.Abs(x), math.Abs(y)))
+	}
+
+	return a == b
+}
+
+// Write prints the changes of the plan as a diff, one row per entry followed by its changed
+// columns, and a summary line
+func Write(w io.Writer, plan Plan) error {
+	var book, trades, lots, disposals int
+	for _, c := range plan.Changes {
+		if _, err := fmt.Fprintf(w, "%s %s %s\n", c.Kind, c.Table, c.Key); err != nil {
+			return err
+		}
+
+		for _, col := range c.Columns {
+			if _, err := fmt.Fprintf(w, "    %s: %v -> %v\n", col.Name, col.Old, col.New); err != nil {
+				return err
+			}
+		}
+
+		switch c.Table {
+		case "Book":
+			book++
+		case "Lots":
+			lots++
+		case "Unmatched_Disposals":
+			disposals++
+		default:
+			trades++
+		}
+	}
+
+	scope := plan.Exchange
+	if scope == "" {
+		scope = "all exchanges"
+	}
+
+	_, err := fmt.Fprintf(w, "%s: %d book entries, %d trades, %d lots and %d unmatched disposals differ, booked in %s\n", scope, book, trades, lots, disposals, plan.Currency)
+	return err
+}
@@ -0,0 +1,332 @@
+package rebuild
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/zyriu/portfolio/backend/helpers/fx"
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+	"github.com/zyriu/portfolio/backend/helpers/trades"
+)
+
+// Options selects how the history is booked, mirroring the settings the exchange jobs use
+type Options struct {
+	Currency    string // reporting currency, USD when empty
+	IncludeFees bool
+	Rates       *fx.Rates
+	Openings    []grist.OpeningBalance
+	Disposals   []grist.UnmatchedDisposal // unmatched disposals recorded so far
+	Method      trades.Method             // lot matching method, FIFO when empty
+	Selections  map[string][]string       // lots selected per sell under SpecificID
+	Lots        []grist.Lot               // lots stored so far
+}
+
+// Plan is the Book and trade PnL obtained by replaying the stored trades, with the
+// differences from what is currently stored
+type Plan struct {
+	Exchange  string // every exchange when empty
+	Currency  string
+	Book      grist.Book
+	Removed   []grist.BookEntry // stored entries no trade books anymore
+	Trades    []grist.Trade     // trades whose booking changed
+	Unmatched []grist.UnmatchedDisposal
+	Resolved  []grist.UnmatchedDisposal // recorded disposals a booked position now covers
+	Lots      []grist.Lot               // every lot of the scope, replacing the stored ones
+	Matches   []grist.LotMatch
+	Changes   []Change
+}
+
+// Build replays the history of exchange through the booking engine and compares the result
+// with the stored Book and trades
+func Build(exchange string, history []grist.Trade, stored grist.Book, funding []grist.Funding, opts Options) (Plan, error) {
+	currency := fx.Reporting(opts.Currency)
+	rates := opts.Rates
+	if rates == nil {
+		rates = fx.NewRates(nil)
+	}
+
+	var scoped []grist.Trade
+	original := make(map[string]grist.Trade)
+	for _, trade := range history {
+		if exchange != "" && trade.Exchange != exchange {
+			continue
+		}
+
+		original[tradeKey(trade)] = trade
+
+		trade, err := fx.Redenominate(trade, currency, rates)
+		if err != nil {
+			return Plan{}, err
+		}
+		scoped = append(scoped, trade)
+	}
+
+	var seed []grist.Trade
+	for _, lot := range trades.OpeningLots(opts.Openings, currency) {
+		if exchange != "" && lot.Exchange != exchange {
+			continue
+		}
+
+		lot, err := fx.Redenominate(lot, currency, rates)
+		if err != nil {
+			return Plan{}, fmt.Errorf("opening balance: %w", err)
+		}
+		seed = append(seed, lot)
+	}
+
+	var payments []grist.Funding
+	for _, payment := range funding {
+		if exchange == "" || payment.Exchange == exchange {
+			payments = append(payments, payment)
+		}
+	}
+
+	// disposals of other exchanges keep the share of the opening balances they consumed
+	recorded := make(map[string]grist.UnmatchedDisposal)
+	var kept []grist.UnmatchedDisposal
+	for _, d := range opts.Disposals {
+		if exchange == "" || d.Exchange == exchange {
+			recorded[d.Exchange+"-"+d.TradeID] = d
+		} else {
+			kept = append(kept, d)
+		}
+	}
+
+	method := opts.Method
+	if method == "" {
+		method = trades.FIFO
+	}
+	lots := trades.NewLots(method, nil)
+	for tradeID, lotIDs := range opts.Selections {
+		lots.Select(tradeID, lotIDs)
+	}
+
+	replay := trades.ReplayOptions{
+		Lots:          lots,
+		Funding:       payments,
+		FundingAmount: func(p grist.Funding) (float64, error) { return fx.FundingAmount(p, currency, rates) },
+		Currency:      currency,
+		Openings:      trades.NewOpenings(opts.Openings, kept, rates.TradeRate),
+		Seed:          seed,
+	}
+	if opts.IncludeFees {
+		replay.Fee = func(trade grist.Trade) (float64, error) { return fx.Fee(trade, rates) }
+	}
+
+	replayed, err := trades.Replay(scoped, replay)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	book := replayed.Book
+	plan := Plan{
+		Exchange: exchange, Currency: currency, Book: book, Unmatched: replayed.Unmatched,
+		Lots: lots.Changed(), Matches: replayed.Matches,
+	}
+
+	for _, key := range sortedKeys(stored, book) {
+		old, had := stored[key]
+		if had && exchange != "" && old.Exchange != exchange {
+			continue
+		}
+
+		rebuilt, has := book[key]
+		switch {
+		case !had:
+			plan.Changes = append(plan.Changes, Change{Table: "Book", Key: key, Kind: Added, Columns: diff(grist.BookEntry{}, rebuilt)})
+		case !has:
+			plan.Removed = append(plan.Removed, old)
+			plan.Changes = append(plan.Changes, Change{Table: "Book", Key: key, Kind: Removed})
+		default:
+			if columns := diff(old, rebuilt); len(columns) > 0 {
+				plan.Changes = append(plan.Changes, Change{Table: "Book", Key: key, Kind: Changed, Columns: columns})
+			}
+		}
+	}
+
+	for _, trade := range replayed.Trades {
+		if columns := diff(original[tradeKey(trade)], trade); len(columns) > 0 {
+			plan.Trades = append(plan.Trades, trade)
+			plan.Changes = append(plan.Changes, Change{Table: "Trades", Key: tradeKey(trade), Kind: Changed, Columns: columns})
+		}
+	}
+
+	for _, d := range replayed.Unmatched {
+		key := d.Exchange + "-" + d.TradeID
+		old, had := recorded[key]
+		delete(recorded, key)
+
+		switch {
+		case !had:
+			plan.Changes = append(plan.Changes, Change{Table: "Unmatched_Disposals", Key: key, Kind: Added, Columns: diff(grist.UnmatchedDisposal{}, d)})
+		default:
+			if columns := diff(old, d); len(columns) > 0 {
+				plan.Changes = append(plan.Changes, Change{Table: "Unmatched_Disposals", Key: key, Kind: Changed, Columns: columns})
+			}
+		}
+	}
+
+	storedLots := make(map[string]grist.Lot)
+	for _, lot := range opts.Lots {
+		if exchange == "" || lot.Exchange == exchange {
+			storedLots[lot.LotID] = lot
+		}
+	}
+
+	for _, lot := range plan.Lots {
+		old, had := storedLots[lot.LotID]
+		delete(storedLots, lot.LotID)
+
+		switch {
+		case !had:
+			plan.Changes = append(plan.Changes, Change{Table: "Lots", Key: lot.LotID, Kind: Added, Columns: diff(grist.Lot{}, lot)})
+		default:
+			if columns := diff(old, lot); len(columns) > 0 {
+				plan.Changes = append(plan.Changes, Change{Table: "Lots", Key: lot.LotID, Kind: Changed, Columns: columns})
+			}
+		}
+	}
+
+	for _, lot := range opts.Lots {
+		if _, ok := storedLots[lot.LotID]; ok {
+			plan.Changes = append(plan.Changes, Change{Table: "Lots", Key: lot.LotID, Kind: Removed})
+		}
+	}
+
+	for _, d := range opts.Disposals {
+		if _, ok := recorded[d.Exchange+"-"+d.TradeID]; ok {
+			plan.Resolved = append(plan.Resolved, d)
+			plan.Changes = append(plan.Changes, Change{Table: "Unmatched_Disposals", Key: d.Exchange + "-" + d.TradeID, Kind: Removed})
+		}
+	}
+
+	return plan, nil
+}
+
+func tradeKey(trade grist.Trade) string {
+	return trade.Exchange + "-" + trade.TradeID
+}
+
+func sortedKeys(books ...grist.Book) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, book := range books {
+		for key := range book {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Load builds the plan of exchange from the trades, Book, funding and lots stored in Grist,
+// booking in currency, net of fees when includeFees is set and matching lots by method
+func Load(ctx context.Context, g *grist.Grist, exchange string, currency string, includeFees bool, method string) (Plan, error) {
+	m, err := trades.ParseMethod(method)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	history, err := g.FetchTrades(ctx)
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to fetch trades: %w", err)
+	}
+
+	stored, err := g.FetchBook(ctx)
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to fetch book: %w", err)
+	}
+
+	funding, err := g.FetchFunding(ctx)
+	// A backup taken before funding was synced has nothing to replay
+	if err != nil && !errors.Is(err, grist.ErrReadOnly) {
+		return Plan{}, fmt.Errorf("failed to fetch funding: %w", err)
+	}
+
+	rates, err := fx.Load(ctx, g)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	// neither table exists in a backup taken before unmatched disposals were recorded
+	openings, err := g.FetchOpeningBalances(ctx)
+	if err != nil && !errors.Is(err, grist.ErrReadOnly) {
+		return Plan{}, fmt.Errorf("failed to fetch opening balances: %w", err)
+	}
+
+	disposals, err := g.FetchUnmatchedDisposals(ctx)
+	if err != nil && !errors.Is(err, grist.ErrReadOnly) {
+		return Plan{}, fmt.Errorf("failed to fetch unmatched disposals: %w", err)
+	}
+
+	// nor do the lot tables in a backup taken before lots were tracked
+	lots, err := g.FetchLots(ctx)
+	if err != nil && !errors.Is(err, grist.ErrReadOnly) {
+		return Plan{}, fmt.Errorf("failed to fetch lots: %w", err)
+	}
+
+	var selections map[string][]string
+	if m == trades.SpecificID {
+		selections, err = g.FetchLotSelections(ctx)
+		if err != nil && !errors.Is(err, grist.ErrReadOnly) {
+			return Plan{}, fmt.Errorf("failed to fetch lot selections: %w", err)
+		}
+	}
+
+	return Build(exchange, history, stored, funding, Options{
+		Currency:    currency,
+		IncludeFees: includeFees,
+		Rates:       rates,
+		Openings:    openings,
+		Disposals:   disposals,
+		Method:      m,
+		Selections:  selections,
+		Lots:        lots,
+	})
+}
+
+// Apply rewrites the changed trades, the Book and the unmatched disposals of the plan,
+// deleting the entries no trade books anymore and the disposals now matched, and replaces
+// the lots and lot matches of its scope
+func Apply(ctx context.Context, g *grist.Grist, plan Plan) error {
+	if err := g.EnsureTradeColumns(ctx); err != nil {
+		return err
+	}
+
+	if len(plan.Trades) > 0 {
+		upserts := make([]grist.Upsert, 0, len(plan.Trades))
+		for _, trade := range plan.Trades {
+			upserts = append(upserts, g.CreateRecordFromTrade(trade))
+		}
+
+		if err := g.UpsertRecords(ctx, "Trades", upserts, grist.UpsertOpts{}); err != nil {
+			return err
+		}
+	}
+
+	if len(plan.Book) > 0 {
+		if err := g.UpsertRecords(ctx, "Book", g.CreateRecordsFromBook(plan.Book), grist.UpsertOpts{}); err != nil {
+			return err
+		}
+	}
+
+	if err := g.SaveUnmatchedDisposals(ctx, plan.Unmatched); err != nil {
+		return err
+	}
+
+	if err := g.DeleteUnmatchedDisposals(ctx, plan.Resolved); err != nil {
+		return err
+	}
+
+	if err := g.DeleteBookEntries(ctx, plan.Removed); err != nil {
+		return err
+	}
+
+	return g.ReplaceLots(ctx, plan.Exchange, plan.Lots, plan.Matches)
+}
@@ -0,0 +1,193 @@
+package rebuild
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+func trade(id string, exchange string, direction string, time int64, price, size float64) grist.Trade {
+	return grist.Trade{
+		TradeID: id, Exchange: exchange, Market: "Spot", Ticker: "BTC", Direction: direction,
+		Time: time, Price: price, OrderSize: size, OrderValue: price * size, Currency: "USD",
+	}
+}
+
+func TestBuild_DiffsStoredBookAndTrades(t *testing.T) {
+	sell := trade("2", "Kraken", "Sell", 2, 150, 1)
+	sell.PnL = 10 // booked by a buggy engine
+
+	history := []grist.Trade{sell, trade("1", "Kraken", "Buy", 1, 100, 2), trade("9", "Hyperliquid", "Buy", 1, 100, 1)}
+	stored := grist.Book{
+		"Kraken-Spot-BTC":      {Exchange: "Kraken", Market: "Spot", Ticker: "BTC", AssetType: "Token", PositionSize: 1, AveragePrice: 100, CostBasis: 100, Currency: "USD", RealizedPnL: 50},
+		"Kraken-Spot-XRP":      {Exchange: "Kraken", Market: "Spot", Ticker: "XRP", PositionSize: 10},
+		"Hyperliquid-Spot-BTC": {Exchange: "Hyperliquid", Market: "Spot", Ticker: "BTC", PositionSize: 7},
+	}
+
+	lots := []grist.Lot{{LotID: "Kraken-Spot-BTC-1", Exchange: "Kraken", Market: "Spot", Ticker: "BTC", TradeID: "1", Acquired: 1, Quantity: 2, Remaining: 1, Price: 100}}
+
+	plan, err := Build("Kraken", history, stored, nil, Options{Lots: lots})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(plan.Book) != 1 || plan.Book["Kraken-Spot-BTC"].PositionSize != 1 {
+		t.Errorf("expected only the Kraken entry to be rebuilt, got %+v", plan.Book)
+	}
+	if len(plan.Removed) != 1 || plan.Removed[0].Ticker != "XRP" {
+		t.Errorf("expected the XRP entry without trades to be removed, got %+v", plan.Removed)
+	}
+	if len(plan.Trades) != 1 || plan.Trades[0].TradeID != "2" || plan.Trades[0].PnL != 50 {
+		t.Fatalf("expected only the sell PnL to be rewritten, got %+v", plan.Trades)
+	}
+
+	if len(plan.Changes) != 2 {
+		t.Fatalf("expected the XRP removal and the sell PnL, got %+v", plan.Changes)
+	}
+	if c := plan.Changes[1]; c.Table != "Trades" || c.Kind != Changed || len(c.Columns) != 1 || c.Columns[0].Name != "PnL" {
+		t.Errorf("unexpected trade change %+v", c)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, plan); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"- Book Kraken-Spot-XRP", "~ Trades Kraken-2", "PnL: 10 -> 50", "Kraken: 1 book entries, 1 trades, 0 lots and 0 unmatched disposals differ, booked in USD"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected the diff to contain %q, got:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestBuild_IgnoresFloatRounding(t *testing.T) {
+	buy := trade("1", "Kraken", "Buy", 1, 0.1, 3)
+	stored := grist.Book{
+		"Kraken-Spot-BTC": {Exchange: "Kraken", Market: "Spot", Ticker: "BTC", AssetType: "Token", PositionSize: 3, AveragePrice: 0.1, CostBasis: 0.3, Currency: "USD"},
+	}
+
+	lots := []grist.Lot{{LotID: "Kraken-Spot-BTC-1", Exchange: "Kraken", Market: "Spot", Ticker: "BTC", TradeID: "1", Acquired: 1, Quantity: 3, Remaining: 3, Price: 0.30000000000000004 / 3}}
+
+	plan, err := Build("", []grist.Trade{buy}, stored, nil, Options{Lots: lots})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(plan.Changes) != 0 {
+		t.Errorf("expected no changes, got %+v", plan.Changes)
+	}
+}
+
+func TestBuild_IncludesFundingAndFees(t *testing.T) {
+	buy := trade("1", "Hyperliquid", "Buy", 1, 100, 1)
+	buy.Market, buy.FeeUSD = "Futures", 1
+	funding := []grist.Funding{
+		{Time: 2, Exchange: "Hyperliquid", Ticker: "BTC", Amount: -3},
+		{Time: 2, Exchange: "Kraken", Ticker: "BTC", Amount: -5},
+	}
+
+	plan, err := Build("Hyperliquid", []grist.Trade{buy}, grist.Book{}, funding, Options{IncludeFees: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry := plan.Book["Hyperliquid-Futures-BTC"]
+	if entry.Funding != -3 || entry.RealizedPnL != -4 || entry.Fees != 1 {
+		t.Errorf("expected the fee and the Hyperliquid funding only, got %+v", entry)
+	}
+	if len(plan.Changes) != 2 || plan.Changes[0].Kind != Added {
+		t.Errorf("expected the entry to be added and the trade PnL rewritten, got %+v", plan.Changes)
+	}
+}
+
+func TestBuild_RecordsAndResolvesUnmatchedDisposals(t *testing.T) {
+	history := []grist.Trade{trade("1", "Kraken", "Buy", 1, 100, 1), trade("2", "Kraken", "Sell", 2, 150, 3)}
+	opts := Options{
+		Openings: []grist.OpeningBalance{{Ticker: "BTC", Quantity: 1, Cost: 50}},
+		Disposals: []grist.UnmatchedDisposal{
+			{TradeID: "2", Exchange: "Kraken", Ticker: "BTC", Time: 2, Quantity: 3, Price: 150, Proceeds: 450, Currency: "USD"},
+			{TradeID: "7", Exchange: "Kraken", Ticker: "BTC", Time: 2, Quantity: 1},
+			{TradeID: "8", Exchange: "Hyperliquid", Ticker: "BTC", Time: 1, Quantity: 1, OpeningQuantity: 1, OpeningCost: 50},
+		},
+	}
+
+	plan, err := Build("Kraken", history, grist.Book{}, nil, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the Hyperliquid disposal already consumed the opening balance
+	if len(plan.Unmatched) != 1 || plan.Unmatched[0].Quantity != 2 || plan.Unmatched[0].OpeningQuantity != 0 {
+		t.Fatalf("expected 2 BTC left uncosted, got %+v", plan.Unmatched)
+	}
+	if len(plan.Resolved) != 1 || plan.Resolved[0].TradeID != "7" {
+		t.Errorf("expected the disposal without a trade to be resolved, got %+v", plan.Resolved)
+	}
+
+	var kinds []string
+	for _, c := range plan.Changes {
+		if c.Table == "Unmatched_Disposals" {
+			kinds = append(kinds, c.Kind+c.Key)
+		}
+	}
+	if strings.Join(kinds, " ") != "~Kraken-2 -Kraken-7" {
+		t.Errorf("unexpected disposal changes %v", kinds)
+	}
+}
+
+func TestBuild_SeedsOpeningLots(t *testing.T) {
+	history := []grist.Trade{trade("1", "Kraken", "Sell", 2, 150, 1), trade("9", "Hyperliquid", "Sell", 2, 150, 1)}
+	opts := Options{Openings: []grist.OpeningBalance{
+		{Date: 1, Exchange: "Kraken", Ticker: "BTC", Quantity: 2, Cost: 200},
+		{Date: 1, Exchange: "Hyperliquid", Ticker: "BTC", Quantity: 1, Cost: 100},
+	}}
+
+	plan, err := Build("Kraken", history, grist.Book{}, nil, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(plan.Unmatched) != 0 || len(plan.Trades) != 1 || plan.Trades[0].PnL != 50 {
+		t.Errorf("expected the sell to be booked against the opening lot, got %+v", plan)
+	}
+	if entry := plan.Book["Kraken-Spot-BTC"]; entry.PositionSize != 1 || entry.CostBasis != 100 {
+		t.Errorf("expected 1 BTC left from the opening lot, got %+v", entry)
+	}
+	if _, ok := plan.Book["Hyperliquid-Spot-BTC"]; ok {
+		t.Error("expected the Hyperliquid opening lot to be out of scope")
+	}
+}
+
+func TestBuild_ReplaysLots(t *testing.T) {
+	history := []grist.Trade{
+		trade("1", "Kraken", "Buy", 2, 120, 1),
+		trade("2", "Kraken", "Sell", 3, 150, 2),
+	}
+	opts := Options{
+		Openings: []grist.OpeningBalance{{TrancheID: "t1", Date: 1, Exchange: "Kraken", Ticker: "BTC", Quantity: 2, Cost: 200}},
+		Lots:     []grist.Lot{{LotID: "Kraken-Spot-BTC-stale", Exchange: "Kraken", Market: "Spot", Ticker: "BTC", Quantity: 5, Remaining: 5}},
+	}
+
+	plan, err := Build("Kraken", history, grist.Book{}, nil, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(plan.Lots) != 2 || plan.Lots[0].TradeID != "Opening-t1" || plan.Lots[0].Remaining != 0 || plan.Lots[1].Remaining != 1 {
+		t.Errorf("expected the opening lot consumed first, got %+v", plan.Lots)
+	}
+	if len(plan.Matches) != 1 || plan.Matches[0].LotID != plan.Lots[0].LotID || plan.Matches[0].Gain != 100 {
+		t.Errorf("expected the sell matched against the opening lot, got %+v", plan.Matches)
+	}
+
+	var kinds []string
+	for _, c := range plan.Changes {
+		if c.Table == "Lots" {
+			kinds = append(kinds, c.Kind+c.Key)
+		}
+	}
+	if strings.Join(kinds, " ") != "+Kraken-Spot-BTC-Opening-t1 +Kraken-Spot-BTC-1 -Kraken-Spot-BTC-stale" {
+		t.Errorf("unexpected lot changes %v", kinds)
+	}
+}
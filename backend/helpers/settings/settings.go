@@ -27,10 +27,18 @@ type Settings struct {
 			Interval  int    `json:"interval"`
 			APIKey    string `json:"apiKey"`
 			APISecret string `json:"apiSecret"`
+
+			// Kraken Futures keys are issued apart from the spot ones, futures are skipped without them
+			FuturesAPIKey    string `json:"futuresApiKey"`
+			FuturesAPISecret string `json:"futuresApiSecret"`
+
+			Aggregation Aggregation `json:"aggregation"`
 		} `json:"kraken"`
 		Hyperliquid struct {
 			Enabled  bool `json:"enabled"`
 			Interval int  `json:"interval"`
+
+			Aggregation Aggregation `json:"aggregation"`
 		} `json:"hyperliquid"`
 		Lighter struct {
 			Enabled  bool `json:"enabled"`
@@ -60,6 +68,25 @@ type Settings struct {
 		APIKey     string `json:"apiKey"`
 		DocumentID string `json:"documentId"`
 		BackupPath string `json:"backupPath"`
+
+		// Backups are written next to BackupPath with a timestamp suffix and pruned per retention
+		BackupCompress  bool `json:"backupCompress"`
+		BackupRetention struct {
+			Daily   int `json:"daily"`
+			Weekly  int `json:"weekly"`
+			Monthly int `json:"monthly"`
+		} `json:"backupRetention"`
+		BackupEncryption struct {
+			Enabled    bool   `json:"enabled"`
+			Passphrase string `json:"passphrase"`
+			KeyFile    string `json:"keyFile"`
+		} `json:"backupEncryption"`
+		Webhook struct {
+			Enabled  bool   `json:"enabled"`
+			Address  string `json:"address"`
+			Secret   string `json:"secret"`
+			Debounce int    `json:"debounce"`
+		} `json:"webhook"`
 	} `json:"grist"`
 
 	Settings struct {
@@ -73,9 +100,51 @@ type Settings struct {
 			Interval         int    `json:"interval"`
 			TwelveDataAPIKey string `json:"twelveDataApiKey"`
 		} `json:"stocks"`
+		Snapshots struct {
+			Enabled   bool `json:"enabled"`
+			Interval  int  `json:"interval"`
+			Retention struct {
+				All    int `json:"all"`    // days kept at full resolution
+				Daily  int `json:"daily"`  // days kept at one snapshot per day
+				Weekly int `json:"weekly"` // weeks kept at one snapshot per week, monthly afterwards
+			} `json:"retention"`
+		} `json:"snapshots"`
+		Tax struct {
+			LotMethod   string `json:"lotMethod"`   // FIFO, LIFO, HIFO or SpecificID
+			IncludeFees bool   `json:"includeFees"` // fold fees into cost basis and proceeds
+		} `json:"tax"`
+
+		FX struct {
+			Enabled           bool     `json:"enabled"`
+			Interval          int      `json:"interval"`
+			ReportingCurrency string   `json:"reportingCurrency"` // currency of Book, PnL and position values
+			Currencies        []string `json:"currencies"`        // quote currencies to fetch rates for
+		} `json:"fx"`
+		Valuation struct {
+			Enabled  bool `json:"enabled"`
+			Interval int  `json:"interval"`
+		} `json:"valuation"`
+		Reconciliation struct {
+			Enabled   bool    `json:"enabled"`
+			Interval  int     `json:"interval"`
+			Tolerance float64 `json:"tolerance"` // flagged above this fraction of the position
+			MinValue  float64 `json:"minValue"`  // differences worth less, in USD, are dust
+		} `json:"reconciliation"`
+		Exposure struct {
+			Enabled  bool              `json:"enabled"`
+			Interval int               `json:"interval"`
+			Aliases  map[string]string `json:"aliases"` // wrapped or exchange tickers counted as another asset
+		} `json:"exposure"`
 	} `json:"settings"`
 }
 
+// Aggregation selects how the fills of an exchange are merged into trades
+type Aggregation struct {
+	Strategy  string  `json:"strategy"`  // minute, order, window, price or none
+	Window    int     `json:"window"`    // seconds, for the window strategy
+	Tolerance float64 `json:"tolerance"` // relative price difference, for the price strategy
+}
+
 type UnifiedWallet struct {
 	Label   string `json:"label"`
 	Address string `json:"address"`
@@ -135,9 +204,13 @@ func GetDefaultSettings() Settings {
 	settings.Exchanges.Kraken.Interval = 600 // 10 minutes
 	settings.Exchanges.Kraken.APIKey = ""
 	settings.Exchanges.Kraken.APISecret = ""
+	settings.Exchanges.Kraken.FuturesAPIKey = ""
+	settings.Exchanges.Kraken.FuturesAPISecret = ""
+	settings.Exchanges.Kraken.Aggregation = Aggregation{Strategy: "minute", Window: 60, Tolerance: 0.001}
 
 	settings.Exchanges.Hyperliquid.Enabled = false
 	settings.Exchanges.Hyperliquid.Interval = 300 // 5 minutes
+	settings.Exchanges.Hyperliquid.Aggregation = Aggregation{Strategy: "minute", Window: 60, Tolerance: 0.001}
 
 	settings.Exchanges.Lighter.Enabled = false
 	settings.Exchanges.Lighter.Interval = 300 // 5 minutes
@@ -157,6 +230,14 @@ func GetDefaultSettings() Settings {
 	settings.Grist.APIKey = ""
 	settings.Grist.DocumentID = ""
 	settings.Grist.BackupPath = ""
+	settings.Grist.BackupCompress = true
+	settings.Grist.BackupRetention.Daily = 7
+	settings.Grist.BackupRetention.Weekly = 4
+	settings.Grist.BackupRetention.Monthly = 12
+	settings.Grist.BackupEncryption.Enabled = false
+	settings.Grist.Webhook.Enabled = false
+	settings.Grist.Webhook.Address = "127.0.0.1:8787"
+	settings.Grist.Webhook.Debounce = 5 // seconds
 
 	settings.Settings.Prices.Enabled = false
 	settings.Settings.Prices.Interval = 600 // 10 minutes
@@ -166,6 +247,31 @@ func GetDefaultSettings() Settings {
 	settings.Settings.Stocks.Interval = 600 // 10 minutes
 	settings.Settings.Stocks.TwelveDataAPIKey = ""
 
+	settings.Settings.Snapshots.Enabled = false
+	settings.Settings.Snapshots.Interval = 86400 // 1 day
+	settings.Settings.Snapshots.Retention.All = 7
+	settings.Settings.Snapshots.Retention.Daily = 90
+	settings.Settings.Snapshots.Retention.Weekly = 52
+
+	settings.Settings.Tax.LotMethod = "FIFO"
+
+	settings.Settings.FX.Enabled = false
+	settings.Settings.FX.Interval = 86400 // 1 day
+	settings.Settings.FX.ReportingCurrency = "USD"
+	settings.Settings.FX.Currencies = []string{"EUR", "SGD"}
+
+	settings.Settings.Valuation.Enabled = false
+	settings.Settings.Valuation.Interval = 600 // 10 minutes
+
+	settings.Settings.Reconciliation.Enabled = false
+	settings.Settings.Reconciliation.Interval = 21600 // 6 hours
+	settings.Settings.Reconciliation.Tolerance = 0.001
+	settings.Settings.Reconciliation.MinValue = 1
+
+	settings.Settings.Exposure.Enabled = false
+	settings.Settings.Exposure.Interval = 3600 // 1 hour
+	settings.Settings.Exposure.Aliases = map[string]string{"WETH": "ETH", "WBTC": "BTC", "CBBTC": "BTC", "XBT": "BTC", "WSOL": "SOL"}
+
 	return settings
 }
 
@@ -194,13 +300,32 @@ func LoadSettings() (Settings, error) {
 		return settings, fmt.Errorf("failed to read settings file: %v", err)
 	}
 
-	if err := json.Unmarshal(data, &settings); err != nil {
+	if settings, err = ParseSettings(data); err != nil {
 		return settings, fmt.Errorf("failed to parse settings file: %v", err)
 	}
 
 	return settings, nil
 }
 
+// ParseSettings decodes settings JSON over the defaults, so settings added after the JSON
+// was written keep their default instead of decoding to their zero value. Maps replace the
+// default entries rather than being merged into them.
+func ParseSettings(data []byte) (Settings, error) {
+	settings := GetDefaultSettings()
+	aliases := settings.Settings.Exposure.Aliases
+	settings.Settings.Exposure.Aliases = nil
+
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return settings, err
+	}
+
+	if settings.Settings.Exposure.Aliases == nil {
+		settings.Settings.Exposure.Aliases = aliases
+	}
+
+	return settings, nil
+}
+
 // SaveSettings saves settings to settings.json file
 func SaveSettings(settings Settings) error {
 	settingsFilePath, err := getSettingsFilePath()
@@ -95,3 +95,71 @@ func TestWalletHelpers(t *testing.T) {
 		t.Fatalf("expected two non-evm wallets, got %d", len(nonEvm))
 	}
 }
+
+func TestLoadSettingsFillsAddedDefaults(t *testing.T) {
+	tempHome := t.TempDir()
+	t.Setenv("HOME", tempHome)
+
+	dir := filepath.Join(tempHome, ".portfolio")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("failed to create settings directory: %v", err)
+	}
+	data := []byte(`{"grist": {"enabled": true, "backupPath": "/nas/portfolio.grist"}}`)
+	if err := os.WriteFile(filepath.Join(dir, "settings.json"), data, 0644); err != nil {
+		t.Fatalf("failed to write settings: %v", err)
+	}
+
+	loaded, err := LoadSettings()
+	if err != nil {
+		t.Fatalf("failed to load settings: %v", err)
+	}
+
+	defaults := GetDefaultSettings()
+	if !loaded.Grist.BackupCompress || loaded.Grist.BackupRetention != defaults.Grist.BackupRetention {
+		t.Fatalf("expected backup defaults for a settings file written before they existed, got %+v", loaded.Grist)
+	}
+	if !loaded.Grist.Enabled || loaded.Grist.BackupPath != "/nas/portfolio.grist" {
+		t.Fatalf("expected stored grist settings to be kept")
+	}
+	if loaded.Grist.Webhook != defaults.Grist.Webhook || loaded.Settings.Snapshots.Retention != defaults.Settings.Snapshots.Retention {
+		t.Fatalf("expected webhook and snapshot retention defaults, got %+v and %+v", loaded.Grist.Webhook, loaded.Settings.Snapshots.Retention)
+	}
+	if loaded.Settings.Reconciliation.Tolerance != 0.001 || loaded.Settings.Reconciliation.MinValue != 1 {
+		t.Fatalf("expected reconciliation thresholds defaults, got %+v", loaded.Settings.Reconciliation)
+	}
+	if len(loaded.Settings.FX.Currencies) != 2 || loaded.Settings.Exposure.Aliases["WETH"] != "ETH" {
+		t.Fatalf("expected FX currencies and exposure aliases defaults, got %+v and %+v", loaded.Settings.FX, loaded.Settings.Exposure)
+	}
+	if loaded.Exchanges.Kraken.Aggregation != defaults.Exchanges.Kraken.Aggregation {
+		t.Fatalf("expected aggregation defaults, got %+v", loaded.Exchanges.Kraken.Aggregation)
+	}
+}
+
+func TestLoadSettingsKeepsStoredAddedSettings(t *testing.T) {
+	tempHome := t.TempDir()
+	t.Setenv("HOME", tempHome)
+
+	dir := filepath.Join(tempHome, ".portfolio")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("failed to create settings directory: %v", err)
+	}
+	data := []byte(`{"exchanges": {"kraken": {"aggregation": {"strategy": "window"}}}, "settings": {"exposure": {"aliases": {"STETH": "ETH"}}, "reconciliation": {"minValue": 0}}}`)
+	if err := os.WriteFile(filepath.Join(dir, "settings.json"), data, 0644); err != nil {
+		t.Fatalf("failed to write settings: %v", err)
+	}
+
+	loaded, err := LoadSettings()
+	if err != nil {
+		t.Fatalf("failed to load settings: %v", err)
+	}
+
+	if aggregation := loaded.Exchanges.Kraken.Aggregation; aggregation.Strategy != "window" || aggregation.Window != 60 {
+		t.Fatalf("expected the stored strategy with the default window, got %+v", aggregation)
+	}
+	if aliases := loaded.Settings.Exposure.Aliases; len(aliases) != 1 || aliases["STETH"] != "ETH" {
+		t.Fatalf("expected stored aliases to replace the defaults, got %+v", aliases)
+	}
+	if loaded.Settings.Reconciliation.MinValue != 0 || loaded.Settings.Reconciliation.Tolerance != 0.001 {
+		t.Fatalf("expected a stored zero to be kept, got %+v", loaded.Settings.Reconciliation)
+	}
+}
@@ -0,0 +1,192 @@
+package tax
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+const dateLayout = "2006-01-02"
+
+func amount(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+func quantity(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// WriteCSV writes the disposals, then the totals by asset, then the unmatched sales
+func WriteCSV(w io.Writer, r Report) error {
+	cw := csv.NewWriter(w)
+
+	rows := [][]string{{"Exchange", "Ticker", "Trade_ID", "Lot_ID", "Acquired", "Disposed", "Quantity", "Proceeds", "Cost", "Gain", "Holding_Period"}}
+	for _, d := range r.Disposals {
+		rows = append(rows, []string{
+			d.Exchange, d.Ticker, d.TradeID, d.LotID,
+			d.Acquired.Format(dateLayout), d.Disposed.Format(dateLayout),
+			quantity(d.Quantity), amount(d.Proceeds), amount(d.Cost), amount(d.Gain), d.Term,
+		})
+	}
+
+	rows = append(rows, nil, []string{"Ticker", "Quantity", "Proceeds", "Cost", "Gain", "Short_Term", "Long_Term"})
+	for _, t := range r.Totals {
+		rows = append(rows, []string{
+			t.Ticker, quantity(t.Quantity), amount(t.Proceeds), amount(t.Cost), amount(t.Gain), amount(t.ShortTerm), amount(t.LongTerm),
+		})
+	}
+	rows = append(rows, []string{
+		"Total", "", amount(r.Total.Proceeds), amount(r.Total.Cost), amount(r.Total.Gain), amount(r.Total.ShortTerm), amount(r.Total.LongTerm),
+	})
+
+	if len(r.Unmatched) > 0 {
+		rows = append(rows, nil, []string{"Unmatched_Exchange", "Ticker", "Trade_ID", "Disposed", "Quantity", "Proceeds"})
+		for _, u := range r.Unmatched {
+			rows = append(rows, []string{
+				u.Exchange, u.Ticker, u.TradeID, u.Disposed.Format(dateLayout), quantity(u.Quantity), amount(u.Proceeds),
+			})
+		}
+	}
+
+	if len(r.Futures) > 0 {
+		rows = append(rows, nil, []string{"Futures_Ticker", "Realized_PnL"})
+		for _, ticker := range futuresTickers(r) {
+			rows = append(rows, []string{ticker, amount(r.Futures[ticker])})
+		}
+	}
+
+	for _, row := range rows {
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteUnmatched writes the recorded unmatched disposals with the part their opening balances
+// covered and the proceeds left without a cost
+func WriteUnmatched(w io.Writer, disposals []grist.UnmatchedDisposal) error {
+	cw := csv.NewWriter(w)
+
+	rows := [][]string{{"Exchange", "Ticker", "Trade_ID", "Disposed", "Quantity", "Price", "Proceeds", "Opening_Quantity", "Opening_Cost", "Uncosted_Quantity", "Currency"}}
+	for _, d := range disposals {
+		rows = append(rows, []string{
+			d.Exchange, d.Ticker, d.TradeID, time.UnixMilli(d.Time).UTC().Format(dateLayout),
+			quantity(d.Quantity), quantity(d.Price), amount(d.Proceeds), quantity(d.OpeningQuantity), amount(d.OpeningCost),
+			quantity(d.Quantity - d.OpeningQuantity), d.Currency,
+		})
+	}
+
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+
+	return cw.Error()
+}
+
+func futuresTickers(r Report) []string {
+	tickers := make([]string, 0, len(r.Futures))
+	for ticker := range r.Futures {
+		tickers = append(tickers, ticker)
+	}
+	sort.Strings(tickers)
+
+	return tickers
+}
+
+var printable = template.Must(template.New("report").Funcs(template.FuncMap{
+	"amount":   amount,
+	"quantity": quantity,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Capital gains {{.Report.Year}}</title>
+<style>
+body { font-family: sans-serif; font-size: 11px; margin: 2em; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { border-bottom: 1px solid #ccc; padding: 3px 6px; text-align: left; }
+td.n, th.n { text-align: right; }
+tfoot td { font-weight: bold; border-top: 2px solid #000; }
+@media print { body { margin: 0; } thead { display: table-header-group; } tr { page-break-inside: avoid; } }
+</style>
+</head>
+<body>
+<h1>Capital gains {{.Report.Year}}</h1>
+<p>Amounts in {{.Report.Currency}}{{if .Report.NetOfFees}} net of fees{{end}}, lot matching method: {{.Report.Method}}. Long term disposals were held for more than one year.</p>
+
+<h2>Totals by asset</h2>
+<table>
+<thead><tr><th>Ticker</th><th class="n">Quantity</th><th class="n">Proceeds</th><th class="n">Cost</th><th class="n">Gain</th><th class="n">Short term</th><th class="n">Long term</th></tr></thead>
+<tbody>
+{{range .Report.Totals}}<tr><td>{{.Ticker}}</td><td class="n">{{quantity .Quantity}}</td><td class="n">{{amount .Proceeds}}</td><td class="n">{{amount .Cost}}</td><td class="n">{{amount .Gain}}</td><td class="n">{{amount .ShortTerm}}</td><td class="n">{{amount .LongTerm}}</td></tr>
+{{end}}</tbody>
+<tfoot><tr><td>Total</td><td></td><td class="n">{{amount .Report.Total.Proceeds}}</td><td class="n">{{amount .Report.Total.Cost}}</td><td class="n">{{amount .Report.Total.Gain}}</td><td class="n">{{amount .Report.Total.ShortTerm}}</td><td class="n">{{amount .Report.Total.LongTerm}}</td></tr></tfoot>
+</table>
+
+<h2>Disposals</h2>
+<table>
+<thead><tr><th>Exchange</th><th>Ticker</th><th>Acquired</th><th>Disposed</th><th class="n">Quantity</th><th class="n">Proceeds</th><th class="n">Cost</th><th class="n">Gain</th><th>Term</th></tr></thead>
+<tbody>
+{{range .Report.Disposals}}<tr><td>{{.Exchange}}</td><td>{{.Ticker}}</td><td>{{.Acquired.Format "2006-01-02"}}</td><td>{{.Disposed.Format "2006-01-02"}}</td><td class="n">{{quantity .Quantity}}</td><td class="n">{{amount .Proceeds}}</td><td class="n">{{amount .Cost}}</td><td class="n">{{amount .Gain}}</td><td>{{.Term}}</td></tr>
+{{end}}</tbody>
+</table>
+{{if .Report.Unmatched}}
+<h2>Sales without a matching acquisition</h2>
+<p>These sales are not included in the totals above since no acquisition lot covered them.</p>
+<table>
+<thead><tr><th>Exchange</th><th>Ticker</th><th>Trade</th><th>Disposed</th><th class="n">Quantity</th><th class="n">Proceeds</th></tr></thead>
+<tbody>
+{{range .Report.Unmatched}}<tr><td>{{.Exchange}}</td><td>{{.Ticker}}</td><td>{{.TradeID}}</td><td>{{.Disposed.Format "2006-01-02"}}</td><td class="n">{{quantity .Quantity}}</td><td class="n">{{amount .Proceeds}}</td></tr>
+{{end}}</tbody>
+</table>
+{{end}}{{if .Futures}}
+<h2>Realized futures PnL</h2>
+<table>
+<thead><tr><th>Ticker</th><th class="n">Realized PnL</th></tr></thead>
+<tbody>
+{{range .Futures}}<tr><td>{{.Ticker}}</td><td class="n">{{amount .PnL}}</td></tr>
+{{end}}</tbody>
+</table>
+{{end}}</body>
+</html>
+`))
+
+// WriteHTML writes the report as a standalone page meant to be printed or saved as PDF
+func WriteHTML(w io.Writer, r Report) error {
+	type futures struct {
+		Ticker string
+		PnL    float64
+	}
+
+	data := struct {
+		Report  Report
+		Futures []futures
+	}{Report: r}
+
+	for _, ticker := range futuresTickers(r) {
+		data.Futures = append(data.Futures, futures{Ticker: ticker, PnL: r.Futures[ticker]})
+	}
+
+	return printable.Execute(w, data)
+}
+
+// Write writes the report in format, either csv or html
+func Write(w io.Writer, r Report, format string) error {
+	switch format {
+	case "csv":
+		return WriteCSV(w, r)
+	case "html":
+		return WriteHTML(w, r)
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+}
@@ -0,0 +1,283 @@
+package tax
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/fx"
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+	"github.com/zyriu/portfolio/backend/helpers/trades"
+)
+
+const (
+	ShortTerm = "Short"
+	LongTerm  = "Long"
+)
+
+// Disposal is a sale, or the part of a sale, matched against a single acquisition lot
+type Disposal struct {
+	Exchange string
+	Ticker   string
+	TradeID  string
+	LotID    string
+	Acquired time.Time
+	Disposed time.Time
+	Quantity float64
+	Proceeds float64
+	Cost     float64
+	Gain     float64
+	Term     string
+}
+
+// Total sums the disposals of an asset over the tax year
+type Total struct {
+	Ticker    string
+	Quantity  float64
+	Proceeds  float64
+	Cost      float64
+	Gain      float64
+	ShortTerm float64
+	LongTerm  float64
+}
+
+// Unmatched is the part of a sale no acquisition lot covered, reported without a cost
+type Unmatched struct {
+	Exchange string
+	Ticker   string
+	TradeID  string
+	Disposed time.Time
+	Quantity float64
+	Proceeds float64
+}
+
+// Report lists the realized gains of a tax year
+type Report struct {
+	Year      int
+	Method    trades.Method
+	Currency  string // currency the trades were booked in
+	NetOfFees bool
+	Disposals []Disposal
+	Totals    []Total
+	Total     Total
+	Unmatched []Unmatched
+	Futures   map[string]float64 // realized futures PnL per ticker, not lot matched
+}
+
+// holdingTerm is Long when the asset was held for more than a year
+func holdingTerm(acquired time.Time, disposed time.Time) string {
+	if disposed.After(acquired.AddDate(1, 0, 0)) {
+		return LongTerm
+	}
+
+	return ShortTerm
+}
+
+// Build replays the whole trade history with method and keeps the disposals of year. The
+// history is replayed from the first trade so that lots acquired in earlier years are
+// matched the same way whatever method is stored in the Lots table. Opening lots are booked
+// before the history, and the part of a sell no lot covers is costed from the opening
+// balances held on no exchange, as the Book does. A nil fee reports gains gross of fees.
+func Build(year int, method trades.Method, history []grist.Trade, openings []grist.Trade, uncovered *trades.Openings, selections map[string][]string, fee trades.FeeFunc) (Report, error) {
+	report := Report{Year: year, Method: method, Currency: "USD", NetOfFees: fee != nil, Futures: make(map[string]float64)}
+
+	sorted := append([]grist.Trade(nil), history...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Time == sorted[j].Time {
+			return sorted[i].TradeID < sorted[j].TradeID
+		}
+		return sorted[i].Time < sorted[j].Time
+	})
+
+	lots := trades.NewLots(method, nil)
+	for tradeID, lotIDs := range selections {
+		lots.Select(tradeID, lotIDs)
+	}
+
+	for _, lot := range openings {
+		if _, _, err := lots.Book(lot); err != nil {
+			return report, fmt.Errorf("opening balance: %w", err)
+		}
+	}
+
+	totals := make(map[string]*Total)
+	inYear := func(ms int64) bool { return time.UnixMilli(ms).UTC().Year() == year }
+
+	for _, trade := range sorted {
+		if trade.Market != "Spot" {
+			if inYear(trade.Time) && trade.PnL != 0 {
+				report.Futures[trade.Ticker] += trade.PnL
+			}
+			continue
+		}
+
+		f := 0.0
+		if fee != nil {
+			var err error
+			if f, err = fee(trade); err != nil {
+				return report, err
+			}
+		}
+
+		matches, unmatched, err := lots.BookWithFee(trade, f)
+		if err != nil {
+			return report, fmt.Errorf("trade %s: %w", trade.TradeID, err)
+		}
+
+		if unmatched > 0 && uncovered != nil {
+			var opened []grist.LotMatch
+			if opened, unmatched, err = uncovered.Match(trade, unmatched, f); err != nil {
+				return report, fmt.Errorf("trade %s: %w", trade.TradeID, err)
+			}
+			matches = append(matches, opened...)
+		}
+
+		if !inYear(trade.Time) {
+			continue
+		}
+
+		if trade.Currency != "" {
+			report.Currency = trade.Currency
+		}
+
+		for _, m := range matches {
+			d := Disposal{
+				Exchange: m.Exchange,
+				Ticker:   m.Ticker,
+				TradeID:  m.TradeID,
+				LotID:    m.LotID,
+				Acquired: time.UnixMilli(m.Acquired).UTC(),
+				Disposed: time.UnixMilli(m.Disposed).UTC(),
+				Quantity: m.Quantity,
+				Proceeds: m.Proceeds,
+				Cost:     m.CostBasis,
+				Gain:     m.Gain,
+			}
+			d.Term = holdingTerm(d.Acquired, d.Disposed)
+			report.Disposals = append(report.Disposals, d)
+
+			total, ok := totals[d.Ticker]
+			if !ok {
+				total = &Total{Ticker: d.Ticker}
+				totals[d.Ticker] = total
+			}
+			total.add(d)
+			report.Total.add(d)
+		}
+
+		if unmatched > 0 {
+			report.Unmatched = append(report.Unmatched, Unmatched{
+				Exchange: trade.Exchange,
+				Ticker:   trade.Ticker,
+				TradeID:  trade.TradeID,
+				Disposed: time.UnixMilli(trade.Time).UTC(),
+				Quantity: unmatched,
+				Proceeds: unmatched * trade.Price,
+			})
+		}
+	}
+
+	for _, total := range totals {
+		report.Totals = append(report.Totals, *total)
+	}
+	sort.Slice(report.Totals, func(i, j int) bool { return report.Totals[i].Ticker < report.Totals[j].Ticker })
+
+	return report, nil
+}
+
+func (t *Total) add(d Disposal) {
+	t.Quantity += d.Quantity
+	t.Proceeds += d.Proceeds
+	t.Cost += d.Cost
+	t.Gain += d.Gain
+
+	if d.Term == LongTerm {
+		t.LongTerm += d.Gain
+	} else {
+		t.ShortTerm += d.Gain
+	}
+}
+
+// Load builds the report of year from the trades stored in Grist, reading the lot
+// selections when method is SpecificID and the FX rates when fees are included
+func Load(ctx context.Context, g *grist.Grist, year int, method string, includeFees bool) (Report, error) {
+	m, err := trades.ParseMethod(method)
+	if err != nil {
+		return Report{}, err
+	}
+
+	history, err := g.FetchTrades(ctx)
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to fetch trades: %w", err)
+	}
+
+	var selections map[string][]string
+	if m == trades.SpecificID {
+		selections, err = g.FetchLotSelections(ctx)
+		// A backup without the selections table has nothing to select
+		if err != nil && !errors.Is(err, grist.ErrReadOnly) {
+			return Report{}, fmt.Errorf("failed to fetch lot selections: %w", err)
+		}
+	}
+
+	balances, err := g.FetchOpeningBalances(ctx)
+	// A backup taken before opening balances were entered has none
+	if err != nil && !errors.Is(err, grist.ErrReadOnly) {
+		return Report{}, fmt.Errorf("failed to fetch opening balances: %w", err)
+	}
+
+	// opening lots are booked in the currency of the latest trade, as the Book is
+	currency := fx.Base
+	for _, trade := range history {
+		if trade.Currency != "" {
+			currency = trade.Currency
+		}
+	}
+
+	var rates *fx.Rates
+	loadRates := func() (*fx.Rates, error) {
+		if rates == nil {
+			if rates, err = fx.Load(ctx, g); err != nil {
+				return nil, err
+			}
+		}
+		return rates, nil
+	}
+
+	openings := trades.OpeningLots(balances, currency)
+	for i, lot := range openings {
+		if fx.Reporting(lot.Currency) == fx.Reporting(currency) {
+			continue
+		}
+
+		r, err := loadRates()
+		if err != nil {
+			return Report{}, err
+		}
+		if openings[i], err = fx.Redenominate(lot, currency, r); err != nil {
+			return Report{}, fmt.Errorf("opening balance: %w", err)
+		}
+	}
+
+	rate := func(currency string, trade grist.Trade) (float64, error) {
+		r, err := loadRates()
+		if err != nil {
+			return 0, err
+		}
+		return r.TradeRate(currency, trade)
+	}
+	uncovered := trades.NewOpenings(balances, nil, rate)
+
+	var fee trades.FeeFunc
+	if includeFees {
+		r, err := loadRates()
+		if err != nil {
+			return Report{}, err
+		}
+		fee = func(trade grist.Trade) (float64, error) { return fx.Fee(trade, r) }
+	}
+
+	return Build(year, m, history, openings, uncovered, selections, fee)
+}
@@ -0,0 +1,199 @@
+package tax
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+	"github.com/zyriu/portfolio/backend/helpers/trades"
+)
+
+func ms(year int, month time.Month, day int) int64 {
+	return time.Date(year, month, day, 12, 0, 0, 0, time.UTC).UnixMilli()
+}
+
+func trade(id string, market string, direction string, time int64, ticker string, price, size float64) grist.Trade {
+	return grist.Trade{
+		TradeID: id, Exchange: "Kraken", Market: market, Direction: direction,
+		Time: time, Ticker: ticker, Price: price, OrderSize: size,
+	}
+}
+
+func history() []grist.Trade {
+	// Deliberately out of order, the report sorts by time
+	futures := trade("f1", "Futures", "Sell", ms(2025, 3, 1), "ETH", 3000, 1)
+	futures.PnL = 150
+
+	return []grist.Trade{
+		trade("s1", "Spot", "Sell", ms(2025, 2, 1), "BTC", 50000, 1.5),
+		trade("b1", "Spot", "Buy", ms(2023, 6, 1), "BTC", 20000, 1),
+		trade("b2", "Spot", "Buy", ms(2024, 12, 1), "BTC", 40000, 1),
+		trade("s0", "Spot", "Sell", ms(2024, 1, 1), "ETH", 2000, 1),
+		trade("s2", "Spot", "Sell", ms(2025, 5, 1), "SOL", 100, 2),
+		trade("s3", "Spot", "Sell", ms(2026, 1, 2), "BTC", 60000, 0.5),
+		futures,
+	}
+}
+
+func TestBuild_FiltersTaxYearAndHoldingPeriod(t *testing.T) {
+	report, err := Build(2025, trades.FIFO, history(), nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(report.Disposals) != 2 {
+		t.Fatalf("expected 2 disposals in 2025, got %+v", report.Disposals)
+	}
+
+	long, short := report.Disposals[0], report.Disposals[1]
+	if long.Term != LongTerm || long.Cost != 20000 || long.Gain != 30000 {
+		t.Errorf("expected the 2023 lot to be a long term gain of 30000, got %+v", long)
+	}
+	if short.Term != ShortTerm || short.Quantity != 0.5 || short.Cost != 20000 || short.Gain != 5000 {
+		t.Errorf("expected half of the 2024 lot to be a short term gain of 5000, got %+v", short)
+	}
+
+	if len(report.Totals) != 1 || report.Totals[0].Ticker != "BTC" {
+		t.Fatalf("expected BTC totals only, got %+v", report.Totals)
+	}
+	if total := report.Total; total.Gain != 35000 || total.LongTerm != 30000 || total.ShortTerm != 5000 {
+		t.Errorf("unexpected totals %+v", total)
+	}
+
+	if len(report.Unmatched) != 1 || report.Unmatched[0].Ticker != "SOL" || report.Unmatched[0].Proceeds != 200 {
+		t.Errorf("expected the SOL sale to be reported as unmatched, got %+v", report.Unmatched)
+	}
+
+	if len(report.Futures) != 1 || report.Futures["ETH"] != 150 {
+		t.Errorf("expected the 2025 futures PnL, got %+v", report.Futures)
+	}
+}
+
+func TestBuild_UsesRequestedMethod(t *testing.T) {
+	report, err := Build(2025, trades.LIFO, history(), nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if report.Method != trades.LIFO || report.Disposals[0].Cost != 40000 || report.Disposals[0].Term != ShortTerm {
+		t.Errorf("expected the newest lot to be matched first, got %+v", report.Disposals)
+	}
+	if math.Abs(report.Total.Gain-(10000+15000)) > 1e-9 {
+		t.Errorf("expected a gain of 25000, got %f", report.Total.Gain)
+	}
+}
+
+func TestBuild_MatchesOpeningLots(t *testing.T) {
+	openings := trades.OpeningLots([]grist.OpeningBalance{
+		{Date: ms(2019, 3, 1), Exchange: "Kraken", Ticker: "SOL", Quantity: 5, Cost: 50},
+	}, "USD")
+
+	report, err := Build(2025, trades.FIFO, history(), openings, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(report.Unmatched) != 0 {
+		t.Errorf("expected the opening lot to cover the SOL sale, got %+v", report.Unmatched)
+	}
+
+	for _, d := range report.Disposals {
+		if d.Ticker == "SOL" && (d.Cost != 20 || d.Gain != 180 || d.Term != LongTerm || d.Acquired.Year() != 2019) {
+			t.Errorf("expected 2 SOL acquired in 2019 at 10 each, got %+v", d)
+		}
+	}
+}
+
+func TestBuild_CostsSellsFromBalancesOnNoExchange(t *testing.T) {
+	uncovered := trades.NewOpenings([]grist.OpeningBalance{
+		{Date: ms(2019, 3, 1), Ticker: "SOL", Quantity: 1, Cost: 10},
+		{Date: ms(2019, 3, 1), Ticker: "ETH", Quantity: 1, Cost: 500},
+	}, nil, nil)
+
+	report, err := Build(2025, trades.FIFO, history(), nil, uncovered, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var sol []Disposal
+	for _, d := range report.Disposals {
+		if d.Ticker == "SOL" {
+			sol = append(sol, d)
+		}
+	}
+	if len(sol) != 1 || sol[0].Quantity != 1 || sol[0].Cost != 10 || sol[0].Gain != 90 || sol[0].Term != LongTerm {
+		t.Errorf("expected 1 SOL costed from the opening balance, got %+v", sol)
+	}
+	if len(report.Unmatched) != 1 || report.Unmatched[0].Quantity != 1 || report.Unmatched[0].Proceeds != 100 {
+		t.Errorf("expected the SOL the balance does not cover to stay unmatched, got %+v", report.Unmatched)
+	}
+}
+
+func TestHoldingTerm_MoreThanOneYear(t *testing.T) {
+	acquired := time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)
+	if term := holdingTerm(acquired, acquired.AddDate(1, 0, 0)); term != ShortTerm {
+		t.Errorf("expected exactly one year to be short term, got %s", term)
+	}
+	if term := holdingTerm(acquired, acquired.AddDate(1, 0, 2)); term != LongTerm {
+		t.Errorf("expected more than a year to be long term, got %s", term)
+	}
+}
+
+func TestWrite_Formats(t *testing.T) {
+	report, err := Build(2025, trades.FIFO, history(), nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, report, "csv"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := csv.NewReader(&buf)
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("invalid csv: %v", err)
+	}
+	if rows[1][4] != "2023-06-01" || rows[1][5] != "2025-02-01" || rows[1][9] != "30000.00" || rows[1][10] != LongTerm {
+		t.Errorf("unexpected disposal row %v", rows[1])
+	}
+
+	buf.Reset()
+	if err := Write(&buf, report, "html"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"Capital gains 2025", "35000.00", "Sales without a matching acquisition", "Realized futures PnL"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected the html report to contain %q", want)
+		}
+	}
+
+	if err := Write(&buf, report, "pdf"); err == nil {
+		t.Error("expected an unknown format to fail")
+	}
+}
+
+func TestWriteUnmatched(t *testing.T) {
+	disposals := []grist.UnmatchedDisposal{
+		{TradeID: "s0", Exchange: "Kraken", Ticker: "ETH", Time: ms(2024, 1, 1), Quantity: 1.5, Price: 2000, Proceeds: 3000, OpeningQuantity: 1, OpeningCost: 800, Currency: "USD"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteUnmatched(&buf, disposals); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid csv: %v", err)
+	}
+	if len(rows) != 2 || rows[1][3] != "2024-01-01" || rows[1][8] != "800.00" || rows[1][9] != "0.5" {
+		t.Errorf("unexpected rows %v", rows)
+	}
+}
@@ -1,9 +1,14 @@
 package token
 
-import "strings"
+import (
+	"strings"
+
+	"github.com/zyriu/portfolio/backend/helpers/fx"
+)
 
 func GetAssetType(ticker string) string {
-	if IsStablecoin(ticker) {
+	// Fiat balances are cash, reported with stablecoins
+	if IsStablecoin(ticker) || fx.IsFiat(ticker) {
 		return "Stable"
 	}
 
@@ -14,10 +19,12 @@ func GetAssetType(ticker string) string {
 	return "Volatile"
 }
 
+// IsStablecoin reports whether ticker tracks the US dollar. Other fiat currencies, such as
+// EUR, need an FX rate.
 func IsStablecoin(ticker string) bool {
 	t := strings.ToUpper(ticker)
 	switch t {
-	case "DAI", "EUR", "USX", "ZEUR", "ZUSD":
+	case "DAI", "USX", "ZUSD":
 		return true
 	default:
 		return strings.Contains(t, "USD")
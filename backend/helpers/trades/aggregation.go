@@ -2,142 +2,274 @@ package trades
 
 import (
 	"fmt"
+	"math"
+	"math/big"
+	"sort"
 	"strconv"
+	"strings"
 )
 
+// Strategy selects which fills AggregateTrades merges into one trade
+type Strategy string
+
+const (
+	// ByMinute merges the fills of an asset and direction within the same minute at one price
+	ByMinute Strategy = "minute"
+	// ByOrder merges the fills of an exchange order, falling back to ByMinute without an order id
+	ByOrder Strategy = "order"
+	// ByWindow merges the fills of an asset and direction within Window seconds of the first one
+	ByWindow Strategy = "window"
+	// ByPrice merges the fills of an asset and direction within the same minute and within
+	// Tolerance of the first fill's price
+	ByPrice Strategy = "price"
+	// Disabled keeps every fill as its own trade
+	Disabled Strategy = "none"
+)
+
+// Grouping is the aggregation strategy of an exchange with its parameters
+type Grouping struct {
+	Strategy  Strategy
+	Window    int64   // seconds, for ByWindow
+	Tolerance float64 // relative price difference, for ByPrice
+}
+
+// ParseGrouping validates an aggregation strategy read from settings, defaulting to ByMinute
+func ParseGrouping(strategy string, window int, tolerance float64) (Grouping, error) {
+	switch s := Strategy(strings.TrimSpace(strategy)); s {
+	case "":
+		return Grouping{Strategy: ByMinute}, nil
+	case ByMinute, ByOrder, Disabled:
+		return Grouping{Strategy: s}, nil
+	case ByWindow:
+		if window <= 0 {
+			return Grouping{}, fmt.Errorf("aggregation window must be positive, got %d", window)
+		}
+		return Grouping{Strategy: s, Window: int64(window)}, nil
+	case ByPrice:
+		if tolerance < 0 {
+			return Grouping{}, fmt.Errorf("aggregation price tolerance must not be negative, got %g", tolerance)
+		}
+		return Grouping{Strategy: s, Tolerance: tolerance}, nil
+	default:
+		return Grouping{}, fmt.Errorf("unknown aggregation strategy %q", strategy)
+	}
+}
+
+// AggregationKey describes a fill for grouping: asset, minute, direction, price and the
+// exchange order it belongs to, empty when unknown
 type AggregationKey struct {
 	Asset     string
 	TimeMin   int64
 	Direction string
 	Price     string
+	OrderID   string
+}
+
+// Fill is an exchange execution that can be merged with other fills into one trade
+type Fill interface {
+	AggregationKey() AggregationKey
+	// FillTime is the time of the fill in milliseconds
+	FillTime() int64
+	// Amounts returns the size, fee and cost of the fill as decimal strings, cost being
+	// empty when the exchange does not report it
+	Amounts() (size string, fee string, cost string)
+}
+
+// Aggregate is a trade merged from Count fills. Fill is the earliest of them and carries
+// the time and id of the trade. The sums are exact decimal strings and Price is the size
+// weighted average price of the fills.
+type Aggregate[T Fill] struct {
+	Fill  T
+	Count int
+	Price string
+	Size  string
+	Fee   string
+	Cost  string
 }
 
-type AggregatedTrade struct {
-	Trade           any
-	Count           int
-	AggregatedSize  float64
-	AggregatedFee   float64
-	AggregatedCost  float64
-	EarliestTime    any
-	EarliestTradeID any
+// decimalSum adds decimal strings exactly, keeping the largest number of decimals seen
+type decimalSum struct {
+	sum   big.Rat
+	scale int
 }
 
-type TradeConfig struct {
-	GetAsset      func(any) string
-	GetTimeMin    func(any) int64
-	GetDirection  func(any) string
-	GetPrice      func(any) string
-	GetSize       func(any) string
-	GetFee        func(any) string
-	GetCost       func(any) string
-	GetTime       func(any) any
-	GetTradeID    func(any) any
-	UpdateSize    func(any, string)
-	UpdateFee     func(any, string)
-	UpdateCost    func(any, string)
-	UpdateTime    func(any, any)
-	UpdateTradeID func(any, any)
+func (d *decimalSum) add(s string) error {
+	var v big.Rat
+	if _, ok := v.SetString(s); !ok {
+		return fmt.Errorf("invalid decimal %q", s)
+	}
+	d.sum.Add(&d.sum, &v)
+	d.scale = max(d.scale, scale(s))
+
+	return nil
 }
 
-func AggregateTrades(trades []any, config TradeConfig) map[AggregationKey]*AggregatedTrade {
-	aggMap := make(map[AggregationKey]*AggregatedTrade)
+func (d *decimalSum) String() string {
+	return d.sum.FloatString(d.scale)
+}
 
-	parseF := func(s string) float64 {
-		val, _ := strconv.ParseFloat(s, 64)
-		return val
+// scale is the number of decimals of a valid decimal string, exponents included
+func scale(s string) int {
+	mantissa, exponent := s, 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		mantissa = s[:i]
+		exponent, _ = strconv.Atoi(s[i+1:])
 	}
-	formatF := func(f float64) string {
-		return fmt.Sprintf("%.12g", f)
+
+	decimals := 0
+	if _, fraction, ok := strings.Cut(mantissa, "."); ok {
+		decimals = len(fraction)
 	}
 
-	for _, trade := range trades {
-		key := AggregationKey{
-			Asset:     config.GetAsset(trade),
-			TimeMin:   config.GetTimeMin(trade),
-			Direction: config.GetDirection(trade),
-			Price:     config.GetPrice(trade),
-		}
+	return max(decimals-exponent, 0)
+}
 
-		entry, exists := aggMap[key]
-		if !exists {
-			size := parseF(config.GetSize(trade))
-			fee := parseF(config.GetFee(trade))
-			cost := 0.0
-			if config.GetCost != nil {
-				costStr := config.GetCost(trade)
-				if costStr != "" {
-					cost = parseF(costStr)
-				}
-			}
-			earliestTime := config.GetTime(trade)
-			earliestTradeID := config.GetTradeID(trade)
-
-			aggMap[key] = &AggregatedTrade{
-				Trade:           trade,
-				Count:           1,
-				AggregatedSize:  size,
-				AggregatedFee:   fee,
-				AggregatedCost:  cost,
-				EarliestTime:    earliestTime,
-				EarliestTradeID: earliestTradeID,
-			}
-			continue
-		}
+type aggregation[T Fill] struct {
+	fill            T
+	key             AggregationKey
+	count           int
+	size, fee, cost decimalSum
+	notional        decimalSum
+	hasCost         bool
+	mixedPrices     bool
+}
 
-		newSize := parseF(config.GetSize(trade))
-		newFee := parseF(config.GetFee(trade))
-		newCost := 0.0
-		if config.GetCost != nil {
-			costStr := config.GetCost(trade)
-			if costStr != "" {
-				newCost = parseF(costStr)
-			}
+func (a *aggregation[T]) add(fill T, key AggregationKey) error {
+	size, fee, cost := fill.Amounts()
+	if err := a.size.add(size); err != nil {
+		return fmt.Errorf("size: %w", err)
+	}
+	if err := a.fee.add(fee); err != nil {
+		return fmt.Errorf("fee: %w", err)
+	}
+	if cost != "" {
+		if err := a.cost.add(cost); err != nil {
+			return fmt.Errorf("cost: %w", err)
 		}
+		a.hasCost = true
+	}
+
+	var price, notional big.Rat
+	if _, ok := price.SetString(key.Price); !ok {
+		return fmt.Errorf("price: invalid decimal %q", key.Price)
+	}
+	notional.SetString(size)
+	a.notional.sum.Add(&a.notional.sum, notional.Mul(&notional, &price))
+	a.notional.scale = max(a.notional.scale, scale(key.Price)+scale(size))
 
-		entry.AggregatedSize += newSize
-		entry.AggregatedFee += newFee
-		entry.AggregatedCost += newCost
-		entry.Count++
+	a.mixedPrices = a.mixedPrices || key.Price != a.key.Price
+	a.count++
 
-		currentTime := config.GetTime(trade)
-		currentTradeID := config.GetTradeID(trade)
+	return nil
+}
 
-		if isEarlierTime(currentTime, entry.EarliestTime) {
-			entry.Trade = trade
-			entry.EarliestTime = currentTime
-			entry.EarliestTradeID = currentTradeID
-		}
+// averagePrice is the size weighted price of the fills, at the scale of their notional
+func (a *aggregation[T]) averagePrice() string {
+	if !a.mixedPrices || a.size.sum.Sign() == 0 {
+		return a.key.Price
 	}
 
-	for _, entry := range aggMap {
-		config.UpdateSize(entry.Trade, formatF(entry.AggregatedSize))
-		config.UpdateFee(entry.Trade, formatF(entry.AggregatedFee))
-		if config.UpdateCost != nil {
-			config.UpdateCost(entry.Trade, formatF(entry.AggregatedCost))
+	var price big.Rat
+	price.Quo(&a.notional.sum, &a.size.sum)
+
+	return price.FloatString(a.notional.scale)
+}
+
+// grouper assigns fills, seen oldest first, to the aggregation they join under a grouping
+type grouper[T Fill] struct {
+	grouping Grouping
+	open     map[AggregationKey]*aggregation[T]
+	byPrice  map[AggregationKey][]*aggregation[T]
+}
+
+// assign returns the aggregation the fill joins, and whether it was opened by this fill
+func (g *grouper[T]) assign(fill T, key AggregationKey) (*aggregation[T], bool, error) {
+	created := &aggregation[T]{fill: fill, key: key}
+
+	switch g.grouping.Strategy {
+	case Disabled:
+		return created, true, nil
+
+	case ByWindow:
+		k := AggregationKey{Asset: key.Asset, Direction: key.Direction}
+		if a := g.open[k]; a != nil && fill.FillTime()-a.fill.FillTime() <= g.grouping.Window*1000 {
+			return a, false, nil
+		}
+		g.open[k] = created
+		return created, true, nil
+
+	case ByPrice:
+		price, err := strconv.ParseFloat(key.Price, 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("price: %w", err)
+		}
+
+		k := AggregationKey{Asset: key.Asset, TimeMin: key.TimeMin, Direction: key.Direction}
+		for _, a := range g.byPrice[k] {
+			first, _ := strconv.ParseFloat(a.key.Price, 64)
+			if price == first || first != 0 && math.Abs(price-first)/first <= g.grouping.Tolerance {
+				return a, false, nil
+			}
 		}
-		config.UpdateTime(entry.Trade, entry.EarliestTime)
-		config.UpdateTradeID(entry.Trade, entry.EarliestTradeID)
+		g.byPrice[k] = append(g.byPrice[k], created)
+		return created, true, nil
 	}
 
-	return aggMap
+	k := AggregationKey{Asset: key.Asset, TimeMin: key.TimeMin, Direction: key.Direction, Price: key.Price}
+	if g.grouping.Strategy == ByOrder && key.OrderID != "" {
+		k = AggregationKey{Asset: key.Asset, Direction: key.Direction, OrderID: key.OrderID}
+	}
+	if a := g.open[k]; a != nil {
+		return a, false, nil
+	}
+	g.open[k] = created
+	return created, true, nil
 }
 
-func isEarlierTime(currentTime, earliestTime any) bool {
-	current, ok1 := toComparableTime(currentTime)
-	earliest, ok2 := toComparableTime(earliestTime)
-	if !ok1 || !ok2 {
-		return false
+// AggregateTrades merges fills into trades according to the grouping, returned oldest first.
+// A fill with an amount that is not a decimal fails the whole aggregation.
+func AggregateTrades[T Fill](fills []T, grouping Grouping) ([]Aggregate[T], error) {
+	sorted := make([]T, len(fills))
+	copy(sorted, fills)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].FillTime() < sorted[j].FillTime() })
+
+	g := grouper[T]{
+		grouping: grouping,
+		open:     make(map[AggregationKey]*aggregation[T]),
+		byPrice:  make(map[AggregationKey][]*aggregation[T]),
 	}
-	return current < earliest
-}
 
-func toComparableTime(t any) (int64, bool) {
-	switch v := t.(type) {
-	case float64:
-		return int64(v * 1000), true
-	case int64:
-		return v, true
-	default:
-		return 0, false
+	var order []*aggregation[T]
+	for _, fill := range sorted {
+		key := fill.AggregationKey()
+
+		a, created, err := g.assign(fill, key)
+		if err == nil {
+			err = a.add(fill, key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s fill at %d %w", key.Asset, fill.FillTime(), err)
+		}
+
+		if created {
+			order = append(order, a)
+		}
 	}
+
+	result := make([]Aggregate[T], 0, len(order))
+	for _, a := range order {
+		agg := Aggregate[T]{
+			Fill:  a.fill,
+			Count: a.count,
+			Price: a.averagePrice(),
+			Size:  a.size.String(),
+			Fee:   a.fee.String(),
+		}
+		if a.hasCost {
+			agg.Cost = a.cost.String()
+		}
+		result = append(result, agg)
+	}
+
+	return result, nil
 }
@@ -1,12 +1,13 @@
 package trades
 
 import (
+	"strings"
 	"testing"
 )
 
 // Mock trade types for testing
 type mockKrakenTrade struct {
-	TradeID interface{}
+	TradeID string
 	Pair    string
 	Time    float64
 	Type    string
@@ -14,260 +15,150 @@ type mockKrakenTrade struct {
 	Vol     string
 	Cost    string
 	Fee     string
-	Maker   bool
+}
+
+func (t mockKrakenTrade) AggregationKey() AggregationKey {
+	return AggregationKey{Asset: t.Pair, TimeMin: int64(t.Time) / 60, Direction: t.Type, Price: t.Price}
+}
+
+func (t mockKrakenTrade) FillTime() int64 {
+	return int64(t.Time * 1000)
+}
+
+func (t mockKrakenTrade) Amounts() (string, string, string) {
+	return t.Vol, t.Fee, t.Cost
 }
 
 type mockHyperliquidFill struct {
-	Tid      int64
-	Coin     string
-	Time     int64
-	Side     string
-	Px       string
-	Sz       string
-	Fee      string
-	FeeToken string
+	Tid  int64
+	Coin string
+	Time int64
+	Side string
+	Px   string
+	Sz   string
+	Fee  string
 }
 
-func TestAggregateTrades_SingleTrade(t *testing.T) {
-	trades := []interface{}{
-		&mockKrakenTrade{
-			TradeID: "trade1",
-			Pair:    "XXBTZUSD",
-			Time:    1640000000.0,
-			Type:    "buy",
-			Price:   "50000.0",
-			Vol:     "1.0",
-			Cost:    "50000.0",
-			Fee:     "0.1",
-		},
-	}
-
-	config := TradeConfig{
-		GetAsset: func(t interface{}) string {
-			return t.(*mockKrakenTrade).Pair
-		},
-		GetTimeMin: func(t interface{}) int64 {
-			return int64(t.(*mockKrakenTrade).Time) / 60
-		},
-		GetDirection: func(t interface{}) string {
-			return t.(*mockKrakenTrade).Type
-		},
-		GetPrice: func(t interface{}) string {
-			return t.(*mockKrakenTrade).Price
-		},
-		GetSize: func(t interface{}) string {
-			return t.(*mockKrakenTrade).Vol
-		},
-		GetFee: func(t interface{}) string {
-			return t.(*mockKrakenTrade).Fee
-		},
-		GetCost: func(t interface{}) string {
-			return t.(*mockKrakenTrade).Cost
-		},
-		GetTime: func(t interface{}) interface{} {
-			return t.(*mockKrakenTrade).Time
-		},
-		GetTradeID: func(t interface{}) interface{} {
-			return t.(*mockKrakenTrade).TradeID
-		},
-		UpdateSize: func(t interface{}, v string) {
-			t.(*mockKrakenTrade).Vol = v
-		},
-		UpdateFee: func(t interface{}, v string) {
-			t.(*mockKrakenTrade).Fee = v
-		},
-		UpdateCost: func(t interface{}, v string) {
-			t.(*mockKrakenTrade).Cost = v
-		},
-		UpdateTime: func(t interface{}, v interface{}) {
-			if time, ok := v.(float64); ok {
-				t.(*mockKrakenTrade).Time = time
-			}
-		},
-		UpdateTradeID: func(t interface{}, v interface{}) {
-			t.(*mockKrakenTrade).TradeID = v
-		},
-	}
+func (f mockHyperliquidFill) AggregationKey() AggregationKey {
+	return AggregationKey{Asset: f.Coin, TimeMin: f.Time / 60000, Direction: f.Side, Price: f.Px}
+}
+
+func (f mockHyperliquidFill) FillTime() int64 {
+	return f.Time
+}
+
+func (f mockHyperliquidFill) Amounts() (string, string, string) {
+	return f.Sz, f.Fee, ""
+}
 
-	aggMap := AggregateTrades(trades, config)
+// aggregate groups fills by minute unless another grouping is given
+func aggregate[T Fill](t *testing.T, fills []T, grouping ...Grouping) []Aggregate[T] {
+	t.Helper()
 
-	if len(aggMap) != 1 {
-		t.Fatalf("expected 1 aggregated trade, got %d", len(aggMap))
+	g := Grouping{Strategy: ByMinute}
+	if len(grouping) > 0 {
+		g = grouping[0]
 	}
 
-	var entry *AggregatedTrade
-	for _, e := range aggMap {
-		entry = e
+	result, err := AggregateTrades(fills, g)
+	if err != nil {
+		t.Fatalf("AggregateTrades: %v", err)
 	}
 
+	return result
+}
+
+func TestAggregateTrades_SingleTrade(t *testing.T) {
+	result := aggregate(t, []mockKrakenTrade{
+		{TradeID: "trade1", Pair: "XXBTZUSD", Time: 1640000000.0, Type: "buy", Price: "50000.0", Vol: "1.0", Cost: "50000.0", Fee: "0.1"},
+	})
+
+	if len(result) != 1 {
+		t.Fatalf("expected 1 aggregated trade, got %d", len(result))
+	}
+
+	entry := result[0]
 	if entry.Count != 1 {
 		t.Errorf("expected count 1, got %d", entry.Count)
 	}
-	if entry.AggregatedSize != 1.0 {
-		t.Errorf("expected aggregated size 1.0, got %f", entry.AggregatedSize)
+	if entry.Size != "1.0" {
+		t.Errorf("expected size 1.0, got %s", entry.Size)
+	}
+	if entry.Fee != "0.1" {
+		t.Errorf("expected fee 0.1, got %s", entry.Fee)
 	}
-	if entry.AggregatedFee != 0.1 {
-		t.Errorf("expected aggregated fee 0.1, got %f", entry.AggregatedFee)
+	if entry.Cost != "50000.0" {
+		t.Errorf("expected cost 50000.0, got %s", entry.Cost)
 	}
 }
 
 func TestAggregateTrades_AggregateSameMinute(t *testing.T) {
-	trades := []interface{}{
-		&mockKrakenTrade{
-			TradeID: "trade1",
-			Pair:    "XXBTZUSD",
-			Time:    1640000000.0,
-			Type:    "buy",
-			Price:   "50000.0",
-			Vol:     "0.5",
-			Cost:    "25000.0",
-			Fee:     "0.05",
-		},
-		&mockKrakenTrade{
-			TradeID: "trade2",
-			Pair:    "XXBTZUSD",
-			Time:    1640000020.0, // Same minute
-			Type:    "buy",
-			Price:   "50000.0", // Same price
-			Vol:     "0.5",
-			Cost:    "25000.0",
-			Fee:     "0.05",
-		},
-	}
-
-	config := createKrakenConfig()
-	aggMap := AggregateTrades(trades, config)
-
-	if len(aggMap) != 1 {
-		t.Fatalf("expected 1 aggregated trade, got %d", len(aggMap))
-	}
-
-	var entry *AggregatedTrade
-	for _, e := range aggMap {
-		entry = e
+	result := aggregate(t, []mockKrakenTrade{
+		{TradeID: "trade1", Pair: "XXBTZUSD", Time: 1640000000.0, Type: "buy", Price: "50000.0", Vol: "0.5", Cost: "25000.0", Fee: "0.05"},
+		// Same minute, same price
+		{TradeID: "trade2", Pair: "XXBTZUSD", Time: 1640000020.0, Type: "buy", Price: "50000.0", Vol: "0.5", Cost: "25000.0", Fee: "0.05"},
+	})
+
+	if len(result) != 1 {
+		t.Fatalf("expected 1 aggregated trade, got %d", len(result))
 	}
 
+	entry := result[0]
 	if entry.Count != 2 {
 		t.Errorf("expected count 2, got %d", entry.Count)
 	}
-	if entry.AggregatedSize != 1.0 {
-		t.Errorf("expected aggregated size 1.0, got %f", entry.AggregatedSize)
+	if entry.Size != "1.0" {
+		t.Errorf("expected size 1.0, got %s", entry.Size)
 	}
-	if entry.AggregatedFee != 0.1 {
-		t.Errorf("expected aggregated fee 0.1, got %f", entry.AggregatedFee)
+	if entry.Fee != "0.10" {
+		t.Errorf("expected fee 0.10, got %s", entry.Fee)
 	}
-	if entry.AggregatedCost != 50000.0 {
-		t.Errorf("expected aggregated cost 50000.0, got %f", entry.AggregatedCost)
+	if entry.Cost != "50000.0" {
+		t.Errorf("expected cost 50000.0, got %s", entry.Cost)
 	}
 	// Should use earliest trade ID
-	trade := entry.Trade.(*mockKrakenTrade)
-	if trade.TradeID != "trade1" {
-		t.Errorf("expected earliest trade ID trade1, got %v", trade.TradeID)
+	if entry.Fill.TradeID != "trade1" {
+		t.Errorf("expected earliest trade ID trade1, got %v", entry.Fill.TradeID)
 	}
 }
 
 func TestAggregateTrades_DifferentPrices(t *testing.T) {
-	trades := []interface{}{
-		&mockKrakenTrade{
-			TradeID: "trade1",
-			Pair:    "XXBTZUSD",
-			Time:    1640000000.0,
-			Type:    "buy",
-			Price:   "50000.0",
-			Vol:     "1.0",
-			Cost:    "50000.0",
-			Fee:     "0.1",
-		},
-		&mockKrakenTrade{
-			TradeID: "trade2",
-			Pair:    "XXBTZUSD",
-			Time:    1640000000.0,
-			Type:    "buy",
-			Price:   "50001.0", // Different price
-			Vol:     "1.0",
-			Cost:    "50001.0",
-			Fee:     "0.1",
-		},
-	}
-
-	config := createKrakenConfig()
-	aggMap := AggregateTrades(trades, config)
-
-	if len(aggMap) != 2 {
-		t.Fatalf("expected 2 separate trades (different prices), got %d", len(aggMap))
+	result := aggregate(t, []mockKrakenTrade{
+		{TradeID: "trade1", Pair: "XXBTZUSD", Time: 1640000000.0, Type: "buy", Price: "50000.0", Vol: "1.0", Cost: "50000.0", Fee: "0.1"},
+		{TradeID: "trade2", Pair: "XXBTZUSD", Time: 1640000000.0, Type: "buy", Price: "50001.0", Vol: "1.0", Cost: "50001.0", Fee: "0.1"},
+	})
+
+	if len(result) != 2 {
+		t.Fatalf("expected 2 separate trades (different prices), got %d", len(result))
 	}
 }
 
 func TestAggregateTrades_DifferentDirections(t *testing.T) {
-	trades := []interface{}{
-		&mockKrakenTrade{
-			TradeID: "trade1",
-			Pair:    "XXBTZUSD",
-			Time:    1640000000.0,
-			Type:    "buy",
-			Price:   "50000.0",
-			Vol:     "1.0",
-			Cost:    "50000.0",
-			Fee:     "0.1",
-		},
-		&mockKrakenTrade{
-			TradeID: "trade2",
-			Pair:    "XXBTZUSD",
-			Time:    1640000000.0,
-			Type:    "sell", // Different type
-			Price:   "50000.0",
-			Vol:     "1.0",
-			Cost:    "50000.0",
-			Fee:     "0.1",
-		},
-	}
-
-	config := createKrakenConfig()
-	aggMap := AggregateTrades(trades, config)
-
-	if len(aggMap) != 2 {
-		t.Fatalf("expected 2 separate trades (buy vs sell), got %d", len(aggMap))
+	result := aggregate(t, []mockKrakenTrade{
+		{TradeID: "trade1", Pair: "XXBTZUSD", Time: 1640000000.0, Type: "buy", Price: "50000.0", Vol: "1.0", Cost: "50000.0", Fee: "0.1"},
+		{TradeID: "trade2", Pair: "XXBTZUSD", Time: 1640000000.0, Type: "sell", Price: "50000.0", Vol: "1.0", Cost: "50000.0", Fee: "0.1"},
+	})
+
+	if len(result) != 2 {
+		t.Fatalf("expected 2 separate trades (buy vs sell), got %d", len(result))
+	}
+	if result[0].Fill.TradeID != "trade1" || result[1].Fill.TradeID != "trade2" {
+		t.Errorf("expected trades in input order on equal times, got %s, %s", result[0].Fill.TradeID, result[1].Fill.TradeID)
 	}
 }
 
 func TestAggregateTrades_EarliestTimeAndID(t *testing.T) {
-	trades := []interface{}{
-		&mockKrakenTrade{
-			TradeID: "trade2",
-			Pair:    "XXBTZUSD",
-			Time:    1640000001.0, // Later time
-			Type:    "buy",
-			Price:   "50000.0",
-			Vol:     "1.0",
-			Cost:    "50000.0",
-			Fee:     "0.1",
-		},
-		&mockKrakenTrade{
-			TradeID: "trade1",
-			Pair:    "XXBTZUSD",
-			Time:    1640000000.0, // Earlier time
-			Type:    "buy",
-			Price:   "50000.0",
-			Vol:     "1.0",
-			Cost:    "50000.0",
-			Fee:     "0.1",
-		},
-	}
-
-	config := createKrakenConfig()
-	aggMap := AggregateTrades(trades, config)
-
-	if len(aggMap) != 1 {
-		t.Fatalf("expected 1 aggregated trade, got %d", len(aggMap))
-	}
-
-	var entry *AggregatedTrade
-	for _, e := range aggMap {
-		entry = e
+	result := aggregate(t, []mockKrakenTrade{
+		{TradeID: "trade2", Pair: "XXBTZUSD", Time: 1640000001.0, Type: "buy", Price: "50000.0", Vol: "1.0", Cost: "50000.0", Fee: "0.1"},
+		{TradeID: "trade1", Pair: "XXBTZUSD", Time: 1640000000.0, Type: "buy", Price: "50000.0", Vol: "1.0", Cost: "50000.0", Fee: "0.1"},
+	})
+
+	if len(result) != 1 {
+		t.Fatalf("expected 1 aggregated trade, got %d", len(result))
 	}
 
 	// Should use earliest time and trade ID
-	trade := entry.Trade.(*mockKrakenTrade)
+	trade := result[0].Fill
 	if trade.TradeID != "trade1" {
 		t.Errorf("expected earliest trade ID trade1, got %v", trade.TradeID)
 	}
@@ -277,346 +168,198 @@ func TestAggregateTrades_EarliestTimeAndID(t *testing.T) {
 }
 
 func TestAggregateTrades_HyperliquidFormat(t *testing.T) {
-	fills := []interface{}{
-		&mockHyperliquidFill{
-			Tid:  123456789,
-			Coin: "BTC",
-			Time: 1640000000000, // Milliseconds
-			Side: "B",
-			Px:   "50000.0",
-			Sz:   "1.0",
-			Fee:  "0.1",
-		},
-		&mockHyperliquidFill{
-			Tid:  987654321,
-			Coin: "BTC",
-			Time: 1640000020000, // Same minute
-			Side: "B",
-			Px:   "50000.0",
-			Sz:   "0.5",
-			Fee:  "0.05",
-		},
-	}
-
-	config := TradeConfig{
-		GetAsset: func(t interface{}) string {
-			return t.(*mockHyperliquidFill).Coin
-		},
-		GetTimeMin: func(t interface{}) int64 {
-			return t.(*mockHyperliquidFill).Time / 60000
-		},
-		GetDirection: func(t interface{}) string {
-			return t.(*mockHyperliquidFill).Side
-		},
-		GetPrice: func(t interface{}) string {
-			return t.(*mockHyperliquidFill).Px
-		},
-		GetSize: func(t interface{}) string {
-			return t.(*mockHyperliquidFill).Sz
-		},
-		GetFee: func(t interface{}) string {
-			return t.(*mockHyperliquidFill).Fee
-		},
-		GetCost: nil, // Hyperliquid doesn't have cost
-		GetTime: func(t interface{}) interface{} {
-			return t.(*mockHyperliquidFill).Time
-		},
-		GetTradeID: func(t interface{}) interface{} {
-			return t.(*mockHyperliquidFill).Tid
-		},
-		UpdateSize: func(t interface{}, v string) {
-			t.(*mockHyperliquidFill).Sz = v
-		},
-		UpdateFee: func(t interface{}, v string) {
-			t.(*mockHyperliquidFill).Fee = v
-		},
-		UpdateCost: nil,
-		UpdateTime: func(t interface{}, v interface{}) {
-			if time, ok := v.(int64); ok {
-				t.(*mockHyperliquidFill).Time = time
-			}
-		},
-		UpdateTradeID: func(t interface{}, v interface{}) {
-			if tid, ok := v.(int64); ok {
-				t.(*mockHyperliquidFill).Tid = tid
-			}
-		},
-	}
+	result := aggregate(t, []mockHyperliquidFill{
+		{Tid: 123456789, Coin: "BTC", Time: 1640000000000, Side: "B", Px: "50000.0", Sz: "1.0", Fee: "0.1"},
+		// Same minute
+		{Tid: 987654321, Coin: "BTC", Time: 1640000020000, Side: "B", Px: "50000.0", Sz: "0.5", Fee: "0.05"},
+	})
 
-	aggMap := AggregateTrades(fills, config)
-
-	if len(aggMap) != 1 {
-		t.Fatalf("expected 1 aggregated fill, got %d", len(aggMap))
-	}
-
-	var entry *AggregatedTrade
-	for _, e := range aggMap {
-		entry = e
+	if len(result) != 1 {
+		t.Fatalf("expected 1 aggregated fill, got %d", len(result))
 	}
 
+	entry := result[0]
 	if entry.Count != 2 {
 		t.Errorf("expected count 2, got %d", entry.Count)
 	}
-	if entry.AggregatedSize != 1.5 {
-		t.Errorf("expected aggregated size 1.5, got %f", entry.AggregatedSize)
+	if entry.Size != "1.5" {
+		t.Errorf("expected size 1.5, got %s", entry.Size)
 	}
-	expectedFee := 0.15
-	if entry.AggregatedFee < expectedFee-0.0001 || entry.AggregatedFee > expectedFee+0.0001 {
-		t.Errorf("expected aggregated fee ~0.15, got %f", entry.AggregatedFee)
+	if entry.Fee != "0.15" {
+		t.Errorf("expected fee 0.15, got %s", entry.Fee)
 	}
-
 	// Should use earliest trade ID
-	fill := entry.Trade.(*mockHyperliquidFill)
-	if fill.Tid != 123456789 {
-		t.Errorf("expected earliest trade ID 123456789, got %d", fill.Tid)
+	if entry.Fill.Tid != 123456789 {
+		t.Errorf("expected earliest trade ID 123456789, got %d", entry.Fill.Tid)
 	}
 }
 
 func TestAggregateTrades_EmptyTrades(t *testing.T) {
-	trades := []interface{}{}
-	config := createKrakenConfig()
-	aggMap := AggregateTrades(trades, config)
+	result := aggregate(t, []mockKrakenTrade{})
 
-	if len(aggMap) != 0 {
-		t.Errorf("expected 0 aggregated trades, got %d", len(aggMap))
+	if len(result) != 0 {
+		t.Errorf("expected 0 aggregated trades, got %d", len(result))
 	}
 }
 
 func TestAggregateTrades_InvalidNumericValues(t *testing.T) {
-	trades := []interface{}{
-		&mockKrakenTrade{
-			TradeID: "trade1",
-			Pair:    "XXBTZUSD",
-			Time:    1640000000.0,
-			Type:    "buy",
-			Price:   "50000.0",
-			Vol:     "invalid", // Invalid size
-			Cost:    "50000.0",
-			Fee:     "0.1",
-		},
+	tests := []struct {
+		name  string
+		trade mockKrakenTrade
+		field string
+	}{
+		{"size", mockKrakenTrade{Vol: "invalid", Fee: "0.1", Cost: "50000.0"}, "size"},
+		{"fee", mockKrakenTrade{Vol: "1.0", Fee: "", Cost: "50000.0"}, "fee"},
+		{"cost", mockKrakenTrade{Vol: "1.0", Fee: "0.1", Cost: "1,000"}, "cost"},
 	}
 
-	config := createKrakenConfig()
-	aggMap := AggregateTrades(trades, config)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.trade.Pair, tt.trade.Time = "XXBTZUSD", 1640000000.0
 
-	if len(aggMap) != 1 {
-		t.Fatalf("expected 1 aggregated trade, got %d", len(aggMap))
+			_, err := AggregateTrades([]mockKrakenTrade{tt.trade}, Grouping{Strategy: ByMinute})
+			if err == nil {
+				t.Fatal("expected an error for an invalid value")
+			}
+			if !strings.Contains(err.Error(), "XXBTZUSD") || !strings.Contains(err.Error(), tt.field) {
+				t.Errorf("expected error naming the asset and %s, got %v", tt.field, err)
+			}
+		})
 	}
+}
+
+func TestAggregateTrades_ExactDecimalSums(t *testing.T) {
+	result := aggregate(t, []mockHyperliquidFill{
+		{Tid: 1, Coin: "ETH", Time: 1640000000000, Side: "B", Px: "3000.0", Sz: "0.1", Fee: "0.000001"},
+		{Tid: 2, Coin: "ETH", Time: 1640000001000, Side: "B", Px: "3000.0", Sz: "0.2", Fee: "0.000002"},
+		{Tid: 3, Coin: "ETH", Time: 1640000002000, Side: "B", Px: "3000.0", Sz: "0.00000001", Fee: "1e-7"},
+	})
 
-	var entry *AggregatedTrade
-	for _, e := range aggMap {
-		entry = e
+	if len(result) != 1 {
+		t.Fatalf("expected 1 aggregated fill, got %d", len(result))
 	}
+	if result[0].Size != "0.30000001" {
+		t.Errorf("expected size 0.30000001, got %s", result[0].Size)
+	}
+	if result[0].Fee != "0.0000031" {
+		t.Errorf("expected fee 0.0000031, got %s", result[0].Fee)
+	}
+}
 
-	// Invalid values should parse to 0
-	if entry.AggregatedSize != 0.0 {
-		t.Errorf("expected aggregated size 0.0 for invalid value, got %f", entry.AggregatedSize)
+func TestAggregateTrades_SortedByTime(t *testing.T) {
+	result := aggregate(t, []mockKrakenTrade{
+		{TradeID: "late", Pair: "XETHZUSD", Time: 1640000300.0, Type: "buy", Price: "3000.0", Vol: "1.0", Cost: "3000.0", Fee: "0.1"},
+		{TradeID: "early", Pair: "XXBTZUSD", Time: 1640000000.0, Type: "buy", Price: "50000.0", Vol: "1.0", Cost: "50000.0", Fee: "0.1"},
+		{TradeID: "middle", Pair: "XXBTZUSD", Time: 1640000120.0, Type: "sell", Price: "50000.0", Vol: "1.0", Cost: "50000.0", Fee: "0.1"},
+	})
+
+	want := []string{"early", "middle", "late"}
+	for i, entry := range result {
+		if entry.Fill.TradeID != want[i] {
+			t.Errorf("position %d: expected %s, got %s", i, want[i], entry.Fill.TradeID)
+		}
 	}
 }
 
 func TestAggregateTrades_MultipleAssets(t *testing.T) {
-	trades := []interface{}{
-		&mockKrakenTrade{
-			TradeID: "trade1",
-			Pair:    "XXBTZUSD",
-			Time:    1640000000.0,
-			Type:    "buy",
-			Price:   "50000.0",
-			Vol:     "1.0",
-			Cost:    "50000.0",
-			Fee:     "0.1",
-		},
-		&mockKrakenTrade{
-			TradeID: "trade2",
-			Pair:    "XETHZUSD", // Different asset
-			Time:    1640000000.0,
-			Type:    "buy",
-			Price:   "3000.0",
-			Vol:     "10.0",
-			Cost:    "30000.0",
-			Fee:     "0.1",
-		},
-	}
-
-	config := createKrakenConfig()
-	aggMap := AggregateTrades(trades, config)
-
-	if len(aggMap) != 2 {
-		t.Fatalf("expected 2 separate trades (different assets), got %d", len(aggMap))
+	result := aggregate(t, []mockKrakenTrade{
+		{TradeID: "trade1", Pair: "XXBTZUSD", Time: 1640000000.0, Type: "buy", Price: "50000.0", Vol: "1.0", Cost: "50000.0", Fee: "0.1"},
+		{TradeID: "trade2", Pair: "XETHZUSD", Time: 1640000000.0, Type: "buy", Price: "3000.0", Vol: "10.0", Cost: "30000.0", Fee: "0.1"},
+	})
+
+	if len(result) != 2 {
+		t.Fatalf("expected 2 separate trades (different assets), got %d", len(result))
 	}
 }
 
 func TestAggregateTrades_NoCostField(t *testing.T) {
-	// Test with Hyperliquid-style config (no cost field)
-	fills := []interface{}{
-		&mockHyperliquidFill{
-			Tid:  123456789,
-			Coin: "BTC",
-			Time: 1640000000000,
-			Side: "B",
-			Px:   "50000.0",
-			Sz:   "1.0",
-			Fee:  "0.1",
-		},
-	}
-
-	config := TradeConfig{
-		GetAsset: func(t interface{}) string {
-			return t.(*mockHyperliquidFill).Coin
-		},
-		GetTimeMin: func(t interface{}) int64 {
-			return t.(*mockHyperliquidFill).Time / 60000
-		},
-		GetDirection: func(t interface{}) string {
-			return t.(*mockHyperliquidFill).Side
-		},
-		GetPrice: func(t interface{}) string {
-			return t.(*mockHyperliquidFill).Px
-		},
-		GetSize: func(t interface{}) string {
-			return t.(*mockHyperliquidFill).Sz
-		},
-		GetFee: func(t interface{}) string {
-			return t.(*mockHyperliquidFill).Fee
-		},
-		GetCost: nil, // No cost field
-		GetTime: func(t interface{}) interface{} {
-			return t.(*mockHyperliquidFill).Time
-		},
-		GetTradeID: func(t interface{}) interface{} {
-			return t.(*mockHyperliquidFill).Tid
-		},
-		UpdateSize: func(t interface{}, v string) {
-			t.(*mockHyperliquidFill).Sz = v
-		},
-		UpdateFee: func(t interface{}, v string) {
-			t.(*mockHyperliquidFill).Fee = v
-		},
-		UpdateCost: nil,
-		UpdateTime: func(t interface{}, v interface{}) {
-			if time, ok := v.(int64); ok {
-				t.(*mockHyperliquidFill).Time = time
-			}
-		},
-		UpdateTradeID: func(t interface{}, v interface{}) {
-			if tid, ok := v.(int64); ok {
-				t.(*mockHyperliquidFill).Tid = tid
-			}
-		},
-	}
-
-	aggMap := AggregateTrades(fills, config)
+	result := aggregate(t, []mockHyperliquidFill{
+		{Tid: 123456789, Coin: "BTC", Time: 1640000000000, Side: "B", Px: "50000.0", Sz: "1.0", Fee: "0.1"},
+		{Tid: 987654321, Coin: "BTC", Time: 1640000010000, Side: "B", Px: "50000.0", Sz: "1.0", Fee: "0.1"},
+	})
 
-	if len(aggMap) != 1 {
-		t.Fatalf("expected 1 aggregated fill, got %d", len(aggMap))
+	if len(result) != 1 {
+		t.Fatalf("expected 1 aggregated fill, got %d", len(result))
 	}
-
-	var entry *AggregatedTrade
-	for _, e := range aggMap {
-		entry = e
+	if result[0].Cost != "" {
+		t.Errorf("expected no cost, got %s", result[0].Cost)
 	}
-
-	// Cost should be 0 when not provided
-	if entry.AggregatedCost != 0.0 {
-		t.Errorf("expected aggregated cost 0.0 when no cost field, got %f", entry.AggregatedCost)
+	if result[0].Size != "2.0" {
+		t.Errorf("expected size 2.0, got %s", result[0].Size)
 	}
 }
 
-// Helper function to create Kraken-style config
-func createKrakenConfig() TradeConfig {
-	return TradeConfig{
-		GetAsset: func(t interface{}) string {
-			return t.(*mockKrakenTrade).Pair
-		},
-		GetTimeMin: func(t interface{}) int64 {
-			return int64(t.(*mockKrakenTrade).Time) / 60
-		},
-		GetDirection: func(t interface{}) string {
-			return t.(*mockKrakenTrade).Type
-		},
-		GetPrice: func(t interface{}) string {
-			return t.(*mockKrakenTrade).Price
-		},
-		GetSize: func(t interface{}) string {
-			return t.(*mockKrakenTrade).Vol
-		},
-		GetFee: func(t interface{}) string {
-			return t.(*mockKrakenTrade).Fee
-		},
-		GetCost: func(t interface{}) string {
-			return t.(*mockKrakenTrade).Cost
-		},
-		GetTime: func(t interface{}) interface{} {
-			return t.(*mockKrakenTrade).Time
-		},
-		GetTradeID: func(t interface{}) interface{} {
-			return t.(*mockKrakenTrade).TradeID
-		},
-		UpdateSize: func(t interface{}, v string) {
-			t.(*mockKrakenTrade).Vol = v
-		},
-		UpdateFee: func(t interface{}, v string) {
-			t.(*mockKrakenTrade).Fee = v
-		},
-		UpdateCost: func(t interface{}, v string) {
-			t.(*mockKrakenTrade).Cost = v
-		},
-		UpdateTime: func(t interface{}, v interface{}) {
-			if time, ok := v.(float64); ok {
-				t.(*mockKrakenTrade).Time = time
-			}
-		},
-		UpdateTradeID: func(t interface{}, v interface{}) {
-			t.(*mockKrakenTrade).TradeID = v
-		},
+func TestParseGrouping(t *testing.T) {
+	if g, err := ParseGrouping("", 0, 0); err != nil || g.Strategy != ByMinute {
+		t.Errorf("expected minute grouping by default, got %+v (%v)", g, err)
+	}
+	if g, err := ParseGrouping("window", 30, 0); err != nil || g.Window != 30 {
+		t.Errorf("expected a 30 second window, got %+v (%v)", g, err)
+	}
+	if _, err := ParseGrouping("window", 0, 0); err == nil {
+		t.Error("expected an empty window to fail")
+	}
+	if _, err := ParseGrouping("price", 0, -0.1); err == nil {
+		t.Error("expected a negative tolerance to fail")
+	}
+	if _, err := ParseGrouping("session", 0, 0); err == nil {
+		t.Error("expected unknown strategy to fail")
 	}
 }
 
-func TestIsEarlierTime(t *testing.T) {
+func TestAggregateTrades_Strategies(t *testing.T) {
+	fills := []mockHyperliquidFill{
+		{Tid: 1, Coin: "BTC", Time: 1640000030000, Side: "B", Px: "100.0", Sz: "1", Fee: "0.1"},
+		// Next minute, 20 seconds later, slightly higher price
+		{Tid: 2, Coin: "BTC", Time: 1640000050000, Side: "B", Px: "100.1", Sz: "1", Fee: "0.1"},
+		// Same minute, same price as 2
+		{Tid: 3, Coin: "BTC", Time: 1640000060000, Side: "B", Px: "100.1", Sz: "2", Fee: "0.1"},
+		// Same minute, 5% away
+		{Tid: 4, Coin: "BTC", Time: 1640000070000, Side: "B", Px: "105.0", Sz: "1", Fee: "0.1"},
+	}
+
 	tests := []struct {
-		name         string
-		currentTime  interface{}
-		earliestTime interface{}
-		expected     bool
+		name     string
+		grouping Grouping
+		want     []int64 // trade ids
 	}{
-		{
-			name:         "Kraken: current earlier than earliest",
-			currentTime:  float64(1640000000.0),
-			earliestTime: float64(1640000100.0),
-			expected:     true,
-		},
-		{
-			name:         "Kraken: current later than earliest",
-			currentTime:  float64(1640000100.0),
-			earliestTime: float64(1640000000.0),
-			expected:     false,
-		},
-		{
-			name:         "Hyperliquid: current earlier than earliest",
-			currentTime:  int64(1640000000000),
-			earliestTime: int64(1640000100000),
-			expected:     true,
-		},
-		{
-			name:         "Hyperliquid: current later than earliest",
-			currentTime:  int64(1640000100000),
-			earliestTime: int64(1640000000000),
-			expected:     false,
-		},
-		{
-			name:         "Same time",
-			currentTime:  int64(1640000000000),
-			earliestTime: int64(1640000000000),
-			expected:     false,
-		},
+		{"minute", Grouping{Strategy: ByMinute}, []int64{1, 2, 4}},
+		{"window", Grouping{Strategy: ByWindow, Window: 30}, []int64{1, 4}},
+		{"price", Grouping{Strategy: ByPrice, Tolerance: 0.01}, []int64{1, 2, 4}},
+		{"wide price tolerance", Grouping{Strategy: ByPrice, Tolerance: 0.1}, []int64{1, 2}},
+		{"none", Grouping{Strategy: Disabled}, []int64{1, 2, 3, 4}},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := isEarlierTime(tt.currentTime, tt.earliestTime)
-			if result != tt.expected {
-				t.Errorf("isEarlierTime(%v, %v) = %v, want %v", tt.currentTime, tt.earliestTime, result, tt.expected)
+			result := aggregate(t, fills, tt.grouping)
+
+			var got []int64
+			for _, entry := range result {
+				got = append(got, entry.Fill.Tid)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected trades %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("expected trades %v, got %v", tt.want, got)
+				}
 			}
 		})
 	}
 }
+
+func TestAggregateTrades_AveragePrice(t *testing.T) {
+	result := aggregate(t, []mockHyperliquidFill{
+		{Tid: 1, Coin: "BTC", Time: 1640000000000, Side: "B", Px: "100.0", Sz: "1", Fee: "0"},
+		{Tid: 2, Coin: "BTC", Time: 1640000010000, Side: "B", Px: "100.3", Sz: "2", Fee: "0"},
+	}, Grouping{Strategy: ByWindow, Window: 60})
+
+	if len(result) != 1 {
+		t.Fatalf("expected 1 aggregated fill, got %d", len(result))
+	}
+	if result[0].Price != "100.2" {
+		t.Errorf("expected average price 100.2, got %s", result[0].Price)
+	}
+}
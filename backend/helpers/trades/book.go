@@ -1,153 +1,341 @@
 package trades
 
 import (
+	"fmt"
+	"sort"
+
+	"github.com/shopspring/decimal"
 	"github.com/zyriu/portfolio/backend/helpers/grist"
 )
 
-func UpdateBookEntry(trade grist.Trade, entry grist.BookEntry) (grist.Trade, grist.BookEntry) {
-	if trade.Market == "Spot" {
-		return updateSpot(trade, entry)
+// bookCurrency is the currency of rows written before Book tracked one
+func bookCurrency(currency string) string {
+	if currency == "" {
+		return "USD"
 	}
 
-	return updateFutures(trade, entry)
+	return currency
 }
 
-func updateSpot(trade grist.Trade, entry grist.BookEntry) (grist.Trade, grist.BookEntry) {
-	qty := trade.OrderSize
-	price := trade.Price
+// CheckCurrency refuses to book a trade into an open position held in another currency,
+// which happens when the reporting currency changes after the Book was built
+func CheckCurrency(book grist.Book, trade grist.Trade) error {
+	key := grist.BookKey(trade.Exchange, trade.Wallet, trade.Market, trade.Ticker)
+	entry, ok := book[key]
+	if !ok || entry.PositionSize == 0 {
+		return nil
+	}
+
+	if held, booked := bookCurrency(entry.Currency), bookCurrency(trade.Currency); held != booked {
+		return fmt.Errorf("%s is booked in %s but trade %s is in %s, rebuild the book after changing the reporting currency", key, held, trade.TradeID, booked)
+	}
+
+	return nil
+}
 
-	pos := entry.PositionSize
-	avg := entry.AveragePrice
-	costBasis := entry.CostBasis
+// FeeFunc returns the fee of a trade in the currency of the trade
+type FeeFunc func(trade grist.Trade) (float64, error)
 
-	realizedPnL := 0.0
-	var newPos, newAvg, newCostBasis float64
+// FundingFunc returns a funding payment in the currency of the Book
+type FundingFunc func(payment grist.Funding) (float64, error)
 
-	switch trade.Direction {
-	case "Buy":
-		newPos = pos + qty
-		newCostBasis = costBasis + price*qty
-		if newPos != 0 {
-			newAvg = newCostBasis / newPos
-		} else {
-			newAvg = 0
-			newCostBasis = 0
-		}
-	case "Sell":
-		qty = min(qty, pos)
-		newPos = pos - qty
-		realizedPnL = (price - avg) * qty
-		newCostBasis = avg * newPos
-		if newPos != 0 {
-			newAvg = avg
-		} else {
-			newAvg = 0
-			newCostBasis = 0
+// ReplayOptions selects how Replay books the history
+type ReplayOptions struct {
+	Fee           FeeFunc // nil books gross of fees
+	Funding       []grist.Funding
+	FundingAmount FundingFunc   // nil ignores Funding
+	Currency      string        // currency of the Book
+	Openings      *Openings     // nil leaves unmatched disposals without a cost
+	Seed          []grist.Trade // booked before the history and left out of Trades, see OpeningLots
+	Lots          *Lots         // nil leaves the spot trades unmatched against lots
+}
+
+// Replayed is the Book rebuilt by Replay, with the trades booked into it, the sells no
+// booked position covered and, with Lots, the lot matches of the sells
+type Replayed struct {
+	Book      grist.Book
+	Trades    []grist.Trade
+	Unmatched []grist.UnmatchedDisposal
+	Matches   []grist.LotMatch
+}
+
+// BookTrade books trade into the entry of its exchange, wallet, market and ticker, creating it
+// on the first trade. The part of a spot sell no booked position covers is returned as an
+// unmatched disposal, its PnL booked against openings when they hold the asset.
+func BookTrade(book grist.Book, trade grist.Trade, fee float64, openings *Openings) (grist.Trade, *grist.UnmatchedDisposal, error) {
+	key := grist.BookKey(trade.Exchange, trade.Wallet, trade.Market, trade.Ticker)
+
+	entry, ok := book[key]
+	if !ok {
+		entry = grist.BookEntry{
+			Exchange:  trade.Exchange,
+			Wallet:    trade.Wallet,
+			AssetType: "Token",
+			Ticker:    trade.Ticker,
+			Market:    trade.Market,
 		}
 	}
 
-	if newPos < 0 {
-		newPos = 0
-		newAvg = 0
-		newCostBasis = 0
+	missing := decimal.Zero
+	if trade.Market == "Spot" && trade.Direction == "Sell" {
+		missing = dec(trade.OrderSize).Sub(decimal.Max(dec(entry.PositionSize), decimal.Zero))
+	}
+
+	trade, entry = UpdateBookEntryWithFee(trade, entry, fee)
+	if !missing.IsPositive() {
+		book[key] = entry
+		return trade, nil, nil
+	}
+
+	price := dec(trade.Price)
+	disposal := &grist.UnmatchedDisposal{
+		TradeID:  trade.TradeID,
+		Exchange: trade.Exchange,
+		Ticker:   trade.Ticker,
+		Time:     trade.Time,
+		Quantity: toFloat(missing, quantityPlaces),
+		Price:    trade.Price,
+		Proceeds: toFloat(price.Mul(missing), amountPlaces),
+		Currency: entry.Currency,
 	}
 
-	entry.PositionSize = newPos
-	entry.AveragePrice = newAvg
-	entry.CostBasis = newCostBasis
+	if openings != nil {
+		covered, cost, err := openings.Cost(trade, missing)
+		if err != nil {
+			return trade, nil, err
+		}
 
-	trade.PnL = realizedPnL
-	trade.OrderValue = price * qty
+		pnl := price.Mul(covered).Sub(cost)
+		trade.PnL = toFloat(dec(trade.PnL).Add(pnl), amountPlaces)
+		entry.RealizedPnL = toFloat(dec(entry.RealizedPnL).Add(pnl), amountPlaces)
+		disposal.OpeningQuantity = toFloat(covered, quantityPlaces)
+		disposal.OpeningCost = toFloat(cost, amountPlaces)
+	}
 
-	return trade, entry
+	book[key] = entry
+	return trade, disposal, nil
 }
 
-func updateFutures(trade grist.Trade, entry grist.BookEntry) (grist.Trade, grist.BookEntry) {
-	qty := trade.OrderSize
-	price := trade.Price
+// ApplyFunding folds a funding payment, amount being in the currency of the Book, into the
+// realized PnL of the perpetual position the wallet it was paid to holds
+func ApplyFunding(book grist.Book, payment grist.Funding, currency string, amount float64) {
+	key := grist.BookKey(payment.Exchange, payment.Wallet, "Futures", payment.Ticker)
 
-	pos := entry.PositionSize
-	avg := entry.AveragePrice
-	costBasis := entry.CostBasis
+	entry, ok := book[key]
+	if !ok {
+		entry = grist.BookEntry{
+			Exchange:  payment.Exchange,
+			Wallet:    payment.Wallet,
+			AssetType: "Token",
+			Ticker:    payment.Ticker,
+			Market:    "Futures",
+			Currency:  bookCurrency(currency),
+		}
+	}
 
-	realizedPnL := 0.0
-	var newPos, newAvg, newCostBasis float64
+	entry.Funding = toFloat(dec(entry.Funding).Add(dec(amount)), amountPlaces)
+	entry.RealizedPnL = toFloat(dec(entry.RealizedPnL).Add(dec(amount)), amountPlaces)
+	book[key] = entry
+}
 
-	switch trade.Direction {
-	case "Buy":
-		if pos < 0 {
-			if qty <= -pos {
-				realizedPnL = (avg - price) * qty
-				newPos = pos + qty
-				newCostBasis = avg * newPos
-				newAvg = avg
-				if newPos == 0 {
-					newAvg = 0
-					newCostBasis = 0
-				}
-			} else {
-				realizedPnL = (avg - price) * (-pos)
-				longQty := qty + pos
-				newPos = longQty
-				newCostBasis = price * newPos
-				if newPos != 0 {
-					newAvg = price
-				} else {
-					newAvg = 0
-					newCostBasis = 0
-				}
+// Replay recomputes the Book and the realized PnL of every trade from the full history and
+// funding payments, so that an existing book can be rebuilt after the booking rules change
+func Replay(history []grist.Trade, opts ReplayOptions) (Replayed, error) {
+	sorted := append([]grist.Trade(nil), history...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Time == sorted[j].Time {
+			return sorted[i].TradeID < sorted[j].TradeID
+		}
+		return sorted[i].Time < sorted[j].Time
+	})
+
+	result := Replayed{Book: make(grist.Book), Trades: sorted}
+	for _, trade := range opts.Seed {
+		if err := CheckCurrency(result.Book, trade); err != nil {
+			return Replayed{}, err
+		}
+
+		if _, _, err := BookTrade(result.Book, trade, 0, nil); err != nil {
+			return Replayed{}, err
+		}
+
+		if opts.Lots != nil {
+			if _, _, err := opts.Lots.Book(trade); err != nil {
+				return Replayed{}, err
 			}
-		} else {
-			newPos = pos + qty
-			newCostBasis = costBasis + price*qty
-			if newPos != 0 {
-				newAvg = newCostBasis / newPos
-			} else {
-				newAvg = 0
-				newCostBasis = 0
+		}
+	}
+
+	for i, trade := range sorted {
+		if err := CheckCurrency(result.Book, trade); err != nil {
+			return Replayed{}, err
+		}
+
+		f := 0.0
+		if opts.Fee != nil {
+			var err error
+			if f, err = opts.Fee(trade); err != nil {
+				return Replayed{}, err
 			}
 		}
-	case "Sell":
-		if pos > 0 {
-			if qty <= pos {
-				realizedPnL = (price - avg) * qty
-				newPos = pos - qty
-				newCostBasis = avg * newPos
-				newAvg = avg
-				if newPos == 0 {
-					newAvg = 0
-					newCostBasis = 0
-				}
-			} else {
-				realizedPnL = (price - avg) * pos
-				shortQty := qty - pos
-				newPos = -shortQty
-				newCostBasis = price * newPos
-				if newPos != 0 {
-					newAvg = price
-				} else {
-					newAvg = 0
-					newCostBasis = 0
-				}
+
+		booked, unmatched, err := BookTrade(result.Book, trade, f, opts.Openings)
+		if err != nil {
+			return Replayed{}, err
+		}
+
+		sorted[i] = booked
+		if unmatched != nil {
+			result.Unmatched = append(result.Unmatched, *unmatched)
+		}
+
+		if opts.Lots != nil {
+			matches, _, err := opts.Lots.BookWithFee(booked, f)
+			if err != nil {
+				return Replayed{}, err
 			}
-		} else {
-			newPos = pos - qty
-			newCostBasis = costBasis - price*qty
-			if newPos != 0 {
-				newAvg = newCostBasis / newPos
-			} else {
-				newAvg = 0
-				newCostBasis = 0
+			result.Matches = append(result.Matches, matches...)
+		}
+	}
+
+	if opts.FundingAmount != nil {
+		for _, payment := range opts.Funding {
+			amount, err := opts.FundingAmount(payment)
+			if err != nil {
+				return Replayed{}, err
 			}
+			ApplyFunding(result.Book, payment, opts.Currency, amount)
 		}
 	}
 
-	entry.PositionSize = newPos
-	entry.AveragePrice = newAvg
-	entry.CostBasis = newCostBasis
+	return result, nil
+}
+
+// UpdateBookEntry books trade into entry gross of fees
+func UpdateBookEntry(trade grist.Trade, entry grist.BookEntry) (grist.Trade, grist.BookEntry) {
+	return UpdateBookEntryWithFee(trade, entry, 0)
+}
+
+// UpdateBookEntryWithFee books trade into entry net of fee, expressed in the currency of the
+// trade. Spot fees are folded into the cost basis of buys and deducted from the proceeds of
+// sells, futures fees are deducted from the realized PnL of every trade. The fees paid since
+// the position was opened are kept on the entry.
+func UpdateBookEntryWithFee(trade grist.Trade, entry grist.BookEntry, fee float64) (grist.Trade, grist.BookEntry) {
+	entry.Currency = bookCurrency(trade.Currency)
+	pos := dec(entry.PositionSize)
+
+	if trade.Market == "Spot" {
+		trade, entry = updateSpot(trade, entry, fee)
+	} else {
+		trade, entry = updateFutures(trade, entry, fee)
+	}
+
+	entry.RealizedPnL = toFloat(dec(entry.RealizedPnL).Add(dec(trade.PnL)), amountPlaces)
+
+	newPos := dec(entry.PositionSize)
+	flipped := !pos.IsZero() && pos.IsPositive() != newPos.IsPositive()
+	if newPos.IsZero() || flipped {
+		entry.Fees = 0
+	} else {
+		entry.Fees = toFloat(dec(entry.Fees).Add(dec(fee)), amountPlaces)
+	}
+
+	return trade, entry
+}
+
+// position is a Book entry in decimals while a trade is booked into it
+type position struct {
+	size, avg, costBasis decimal.Decimal
+}
+
+func positionOf(entry grist.BookEntry) position {
+	return position{size: dec(entry.PositionSize), avg: dec(entry.AveragePrice), costBasis: dec(entry.CostBasis)}
+}
+
+// write rounds the position into entry, a closed position keeping no price or cost
+func (p position) write(entry *grist.BookEntry) {
+	if p.size.IsZero() {
+		p.avg, p.costBasis = decimal.Zero, decimal.Zero
+	}
+
+	entry.PositionSize = toFloat(p.size, quantityPlaces)
+	entry.AveragePrice = toFloat(p.avg, pricePlaces)
+	entry.CostBasis = toFloat(p.costBasis, amountPlaces)
+}
+
+// averaged adds qty at price to a position on the same side, or opens it
+func (p position) averaged(qty decimal.Decimal, price decimal.Decimal, fee decimal.Decimal) position {
+	size := p.size.Add(qty)
+	costBasis := p.costBasis.Add(price.Mul(qty)).Add(fee)
+	if size.IsZero() {
+		return position{}
+	}
+
+	return position{size: size, avg: costBasis.Div(size), costBasis: costBasis}
+}
+
+// reduced removes qty from a position at its average price, qty being signed like the position
+func (p position) reduced(qty decimal.Decimal) position {
+	size := p.size.Sub(qty)
+	return position{size: size, avg: p.avg, costBasis: p.avg.Mul(size)}
+}
+
+func updateSpot(trade grist.Trade, entry grist.BookEntry, fee float64) (grist.Trade, grist.BookEntry) {
+	qty, price, f := dec(trade.OrderSize), dec(trade.Price), dec(fee)
+	p := positionOf(entry)
+
+	realizedPnL := decimal.Zero
+	switch trade.Direction {
+	case "Buy":
+		p = p.averaged(qty, price, f)
+	case "Sell":
+		qty = decimal.Min(qty, p.size)
+		realizedPnL = price.Sub(p.avg).Mul(qty).Sub(f)
+		p = p.reduced(qty)
+	}
+
+	if p.size.IsNegative() {
+		p = position{}
+	}
+	p.write(&entry)
+
+	trade.PnL = toFloat(realizedPnL, amountPlaces)
+	trade.OrderValue = toFloat(price.Mul(dec(trade.OrderSize)), amountPlaces)
+
+	return trade, entry
+}
+
+func updateFutures(trade grist.Trade, entry grist.BookEntry, fee float64) (grist.Trade, grist.BookEntry) {
+	qty, price := dec(trade.OrderSize), dec(trade.Price)
+	p := positionOf(entry)
+
+	// a Sell is a negative quantity, reducing a long or growing a short
+	signed := qty
+	if trade.Direction == "Sell" {
+		signed = qty.Neg()
+	} else if trade.Direction != "Buy" {
+		signed = decimal.Zero
+	}
+
+	realizedPnL := decimal.Zero
+	switch {
+	case signed.IsZero():
+	case p.size.IsZero() || p.size.IsPositive() == signed.IsPositive():
+		p = p.averaged(signed, price, decimal.Zero)
+	case signed.Abs().LessThanOrEqual(p.size.Abs()):
+		// closing part or all of the position
+		realizedPnL = price.Sub(p.avg).Mul(signed.Neg())
+		p = p.reduced(signed.Neg())
+	default:
+		// closing the position and opening the other side with the rest
+		realizedPnL = price.Sub(p.avg).Mul(p.size)
+		size := p.size.Add(signed)
+		p = position{size: size, avg: price, costBasis: price.Mul(size)}
+	}
+	p.write(&entry)
 
-	trade.PnL = realizedPnL
-	trade.OrderValue = price * qty
+	trade.PnL = toFloat(realizedPnL.Sub(dec(fee)), amountPlaces)
+	trade.OrderValue = toFloat(price.Mul(qty), amountPlaces)
 
 	return trade, entry
 }
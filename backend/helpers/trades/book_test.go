@@ -84,7 +84,7 @@ func TestUpdateSpot_BuyFromZero(t *testing.T) {
 	trade := spotTrade("Buy", 100, 10)
 	entry := entry("exchange", "BTC", "Spot", 0, 0, 0)
 
-	updatedTrade, updatedEntry := updateSpot(trade, entry)
+	updatedTrade, updatedEntry := updateSpot(trade, entry, 0)
 
 	if updatedEntry.PositionSize != 10 {
 		t.Errorf("expected position size 10, got %f", updatedEntry.PositionSize)
@@ -107,11 +107,11 @@ func TestUpdateSpot_BuyMultipleTimes(t *testing.T) {
 	// First buy
 	entry := entry("exchange", "BTC", "Spot", 0, 0, 0)
 	trade1 := spotTrade("Buy", 100, 10)
-	_, entry = updateSpot(trade1, entry)
+	_, entry = updateSpot(trade1, entry, 0)
 
 	// Second buy at different price
 	trade2 := spotTrade("Buy", 150, 5)
-	_, entry = updateSpot(trade2, entry)
+	_, entry = updateSpot(trade2, entry, 0)
 
 	expectedPos := 15.0
 	expectedCostBasis := 1000.0 + 750.0 // 100*10 + 150*5
@@ -133,7 +133,7 @@ func TestUpdateSpot_SellPartial(t *testing.T) {
 	entry := entry("exchange", "BTC", "Spot", 100, 10, 1000)
 	trade := spotTrade("Sell", 150, 5)
 
-	updatedTrade, updatedEntry := updateSpot(trade, entry)
+	updatedTrade, updatedEntry := updateSpot(trade, entry, 0)
 
 	expectedPos := 5.0
 	expectedPnL := (150.0 - 100.0) * 5.0 // 250
@@ -158,7 +158,7 @@ func TestUpdateSpot_SellAll(t *testing.T) {
 	entry := entry("exchange", "BTC", "Spot", 100, 10, 1000)
 	trade := spotTrade("Sell", 150, 10)
 
-	updatedTrade, updatedEntry := updateSpot(trade, entry)
+	updatedTrade, updatedEntry := updateSpot(trade, entry, 0)
 
 	if !approxEqual(updatedEntry.PositionSize, 0, 0.01) {
 		t.Errorf("expected position size 0, got %f", updatedEntry.PositionSize)
@@ -179,7 +179,7 @@ func TestUpdateSpot_SellMoreThanPosition(t *testing.T) {
 	entry := entry("exchange", "BTC", "Spot", 100, 10, 1000)
 	trade := spotTrade("Sell", 150, 15) // Trying to sell more than owned
 
-	updatedTrade, updatedEntry := updateSpot(trade, entry)
+	updatedTrade, updatedEntry := updateSpot(trade, entry, 0)
 
 	// Should cap at position size
 	if !approxEqual(updatedEntry.PositionSize, 0, 0.01) {
@@ -196,8 +196,8 @@ func TestUpdateSpot_SellMoreThanPosition(t *testing.T) {
 	if !approxEqual(updatedTrade.PnL, expectedPnL, 0.01) {
 		t.Errorf("expected PnL %f, got %f", expectedPnL, updatedTrade.PnL)
 	}
-	// Order value should reflect actual trade size (10 * 150 = 1500)
-	expectedOrderValue := 150.0 * 10.0 // capped to position size
+	// Order value should reflect the full sale, the uncovered 5 being an unmatched disposal
+	expectedOrderValue := 150.0 * 15.0
 	if !approxEqual(updatedTrade.OrderValue, expectedOrderValue, 0.01) {
 		t.Errorf("expected order value %f, got %f", expectedOrderValue, updatedTrade.OrderValue)
 	}
@@ -207,7 +207,7 @@ func TestUpdateSpot_SellFromZero(t *testing.T) {
 	entry := entry("exchange", "BTC", "Spot", 0, 0, 0)
 	trade := spotTrade("Sell", 150, 10)
 
-	updatedTrade, updatedEntry := updateSpot(trade, entry)
+	updatedTrade, updatedEntry := updateSpot(trade, entry, 0)
 
 	if updatedEntry.PositionSize != 0 {
 		t.Errorf("expected position size 0, got %f", updatedEntry.PositionSize)
@@ -228,14 +228,14 @@ func TestUpdateSpot_BuySellSequence(t *testing.T) {
 
 	// Buy 10 at 100
 	trade1 := spotTrade("Buy", 100, 10)
-	_, entry = updateSpot(trade1, entry)
+	_, entry = updateSpot(trade1, entry, 0)
 	if entry.PositionSize != 10 || entry.AveragePrice != 100 {
 		t.Errorf("after first buy: pos=%f avg=%f", entry.PositionSize, entry.AveragePrice)
 	}
 
 	// Sell 3 at 120
 	trade2 := spotTrade("Sell", 120, 3)
-	_, entry = updateSpot(trade2, entry)
+	_, entry = updateSpot(trade2, entry, 0)
 	if !approxEqual(entry.PositionSize, 7, 0.01) {
 		t.Errorf("after sell: expected pos 7, got %f", entry.PositionSize)
 	}
@@ -245,7 +245,7 @@ func TestUpdateSpot_BuySellSequence(t *testing.T) {
 
 	// Buy 5 more at 110
 	trade3 := spotTrade("Buy", 110, 5)
-	_, entry = updateSpot(trade3, entry)
+	_, entry = updateSpot(trade3, entry, 0)
 	expectedPos := 12.0
 	expectedCostBasis := 100.0*7.0 + 110.0*5.0 // 1250
 	expectedAvg := expectedCostBasis / expectedPos
@@ -262,7 +262,7 @@ func TestUpdateSpot_NegativePositionCorrection(t *testing.T) {
 	entry := entry("exchange", "BTC", "Spot", 100, 5, 500)
 	trade := spotTrade("Sell", 150, 10) // Selling more than owned
 
-	_, updatedEntry := updateSpot(trade, entry)
+	_, updatedEntry := updateSpot(trade, entry, 0)
 
 	// Should be capped at 0, not negative
 	if updatedEntry.PositionSize < 0 {
@@ -285,7 +285,7 @@ func TestUpdateFutures_BuyFromZero(t *testing.T) {
 	trade := futuresTrade("Buy", 100, 10)
 	entry := entry("exchange", "BTC", "Futures", 0, 0, 0)
 
-	updatedTrade, updatedEntry := updateFutures(trade, entry)
+	updatedTrade, updatedEntry := updateFutures(trade, entry, 0)
 
 	if updatedEntry.PositionSize != 10 {
 		t.Errorf("expected position size 10, got %f", updatedEntry.PositionSize)
@@ -305,7 +305,7 @@ func TestUpdateFutures_SellFromZero(t *testing.T) {
 	trade := futuresTrade("Sell", 100, 10)
 	entry := entry("exchange", "BTC", "Futures", 0, 0, 0)
 
-	updatedTrade, updatedEntry := updateFutures(trade, entry)
+	updatedTrade, updatedEntry := updateFutures(trade, entry, 0)
 
 	if updatedEntry.PositionSize != -10 {
 		t.Errorf("expected position size -10, got %f", updatedEntry.PositionSize)
@@ -325,7 +325,7 @@ func TestUpdateFutures_BuyMoreLong(t *testing.T) {
 	entry := entry("exchange", "BTC", "Futures", 100, 10, 1000)
 	trade := futuresTrade("Buy", 150, 5)
 
-	updatedTrade, updatedEntry := updateFutures(trade, entry)
+	updatedTrade, updatedEntry := updateFutures(trade, entry, 0)
 
 	expectedPos := 15.0
 	expectedCostBasis := 1000.0 + 750.0 // 1750
@@ -349,7 +349,7 @@ func TestUpdateFutures_SellPartialLong(t *testing.T) {
 	entry := entry("exchange", "BTC", "Futures", 100, 10, 1000)
 	trade := futuresTrade("Sell", 150, 5)
 
-	updatedTrade, updatedEntry := updateFutures(trade, entry)
+	updatedTrade, updatedEntry := updateFutures(trade, entry, 0)
 
 	expectedPos := 5.0
 	expectedPnL := (150.0 - 100.0) * 5.0 // 250
@@ -374,7 +374,7 @@ func TestUpdateFutures_SellMoreLong_FlipsToShort(t *testing.T) {
 	entry := entry("exchange", "BTC", "Futures", 100, 10, 1000)
 	trade := futuresTrade("Sell", 150, 15) // Selling more than long position
 
-	updatedTrade, updatedEntry := updateFutures(trade, entry)
+	updatedTrade, updatedEntry := updateFutures(trade, entry, 0)
 
 	// Should close long and open short
 	expectedPos := -5.0                   // 10 long - 15 sold = -5 short
@@ -400,7 +400,7 @@ func TestUpdateFutures_SellMoreLong_ClosesExactly(t *testing.T) {
 	entry := entry("exchange", "BTC", "Futures", 100, 10, 1000)
 	trade := futuresTrade("Sell", 150, 10) // Selling exactly the position
 
-	updatedTrade, updatedEntry := updateFutures(trade, entry)
+	updatedTrade, updatedEntry := updateFutures(trade, entry, 0)
 
 	expectedPos := 0.0
 	expectedPnL := (150.0 - 100.0) * 10.0 // 500
@@ -425,7 +425,7 @@ func TestUpdateFutures_SellMoreShort(t *testing.T) {
 	entry := entry("exchange", "BTC", "Futures", 100, -10, -1000)
 	trade := futuresTrade("Sell", 120, 5)
 
-	updatedTrade, updatedEntry := updateFutures(trade, entry)
+	updatedTrade, updatedEntry := updateFutures(trade, entry, 0)
 
 	expectedPos := -15.0
 	expectedCostBasis := -1000.0 - 600.0 // -1600
@@ -449,7 +449,7 @@ func TestUpdateFutures_BuyPartialShort(t *testing.T) {
 	entry := entry("exchange", "BTC", "Futures", 100, -10, -1000)
 	trade := futuresTrade("Buy", 80, 5)
 
-	updatedTrade, updatedEntry := updateFutures(trade, entry)
+	updatedTrade, updatedEntry := updateFutures(trade, entry, 0)
 
 	expectedPos := -5.0
 	expectedPnL := (100.0 - 80.0) * 5.0 // 100 (profitable to close short at lower price)
@@ -474,7 +474,7 @@ func TestUpdateFutures_BuyMoreShort_FlipsToLong(t *testing.T) {
 	entry := entry("exchange", "BTC", "Futures", 100, -10, -1000)
 	trade := futuresTrade("Buy", 80, 15) // Buying more than short position
 
-	updatedTrade, updatedEntry := updateFutures(trade, entry)
+	updatedTrade, updatedEntry := updateFutures(trade, entry, 0)
 
 	// Should close short and open long
 	expectedPos := 5.0                   // -10 short + 15 bought = 5 long
@@ -500,7 +500,7 @@ func TestUpdateFutures_BuyMoreShort_ClosesExactly(t *testing.T) {
 	entry := entry("exchange", "BTC", "Futures", 100, -10, -1000)
 	trade := futuresTrade("Buy", 80, 10) // Buying exactly the position
 
-	updatedTrade, updatedEntry := updateFutures(trade, entry)
+	updatedTrade, updatedEntry := updateFutures(trade, entry, 0)
 
 	expectedPos := 0.0
 	expectedPnL := (100.0 - 80.0) * 10.0 // 200
@@ -526,21 +526,21 @@ func TestUpdateFutures_ComplexSequence(t *testing.T) {
 
 	// Buy 10 at 100 (long)
 	trade1 := futuresTrade("Buy", 100, 10)
-	_, entry = updateFutures(trade1, entry)
+	_, entry = updateFutures(trade1, entry, 0)
 	if entry.PositionSize != 10 || entry.AveragePrice != 100 {
 		t.Errorf("after first buy: pos=%f avg=%f", entry.PositionSize, entry.AveragePrice)
 	}
 
 	// Sell 5 at 110 (reduce long)
 	trade2 := futuresTrade("Sell", 110, 5)
-	_, entry = updateFutures(trade2, entry)
+	_, entry = updateFutures(trade2, entry, 0)
 	if !approxEqual(entry.PositionSize, 5, 0.01) {
 		t.Errorf("after first sell: expected pos 5, got %f", entry.PositionSize)
 	}
 
 	// Sell 10 at 120 (flip to short)
 	trade3 := futuresTrade("Sell", 120, 10)
-	_, entry = updateFutures(trade3, entry)
+	_, entry = updateFutures(trade3, entry, 0)
 	expectedPos := -5.0
 	if !approxEqual(entry.PositionSize, expectedPos, 0.01) {
 		t.Errorf("after second sell: expected pos %f, got %f", expectedPos, entry.PositionSize)
@@ -551,7 +551,7 @@ func TestUpdateFutures_ComplexSequence(t *testing.T) {
 
 	// Buy 8 at 105 (flip back to long)
 	trade4 := futuresTrade("Buy", 105, 8)
-	_, entry = updateFutures(trade4, entry)
+	_, entry = updateFutures(trade4, entry, 0)
 	expectedPos = 3.0 // -5 + 8
 	if !approxEqual(entry.PositionSize, expectedPos, 0.01) {
 		t.Errorf("after second buy: expected pos %f, got %f", expectedPos, entry.PositionSize)
@@ -567,7 +567,7 @@ func TestUpdateSpot_ZeroQuantity(t *testing.T) {
 	entry := entry("exchange", "BTC", "Spot", 100, 10, 1000)
 	trade := spotTrade("Buy", 100, 0)
 
-	updatedTrade, updatedEntry := updateSpot(trade, entry)
+	updatedTrade, updatedEntry := updateSpot(trade, entry, 0)
 
 	if updatedEntry.PositionSize != 10 {
 		t.Errorf("position should remain unchanged, got %f", updatedEntry.PositionSize)
@@ -584,7 +584,7 @@ func TestUpdateFutures_ZeroQuantity(t *testing.T) {
 	entry := entry("exchange", "BTC", "Futures", 100, 10, 1000)
 	trade := futuresTrade("Buy", 100, 0)
 
-	updatedTrade, updatedEntry := updateFutures(trade, entry)
+	updatedTrade, updatedEntry := updateFutures(trade, entry, 0)
 
 	if updatedEntry.PositionSize != 10 {
 		t.Errorf("position should remain unchanged, got %f", updatedEntry.PositionSize)
@@ -598,7 +598,7 @@ func TestUpdateSpot_VerySmallQuantities(t *testing.T) {
 	entry := entry("exchange", "BTC", "Spot", 100, 10, 1000)
 	trade := spotTrade("Buy", 100, 0.0001)
 
-	_, updatedEntry := updateSpot(trade, entry)
+	_, updatedEntry := updateSpot(trade, entry, 0)
 
 	expectedPos := 10.0001
 	if !approxEqual(updatedEntry.PositionSize, expectedPos, 0.00001) {
@@ -611,7 +611,7 @@ func TestUpdateFutures_ZeroPositionWithNonZeroAverage(t *testing.T) {
 	entry := entry("exchange", "BTC", "Futures", 100, 0, 0)
 	trade := futuresTrade("Buy", 150, 5)
 
-	_, updatedEntry := updateFutures(trade, entry)
+	_, updatedEntry := updateFutures(trade, entry, 0)
 
 	if !approxEqual(updatedEntry.PositionSize, 5, 0.01) {
 		t.Errorf("expected position size 5, got %f", updatedEntry.PositionSize)
@@ -625,7 +625,7 @@ func TestUpdateFutures_LongToShortExactFlip(t *testing.T) {
 	entry := entry("exchange", "BTC", "Futures", 100, 5, 500)
 	trade := futuresTrade("Sell", 120, 5)
 
-	updatedTrade, updatedEntry := updateFutures(trade, entry)
+	updatedTrade, updatedEntry := updateFutures(trade, entry, 0)
 
 	if updatedEntry.PositionSize != 0 {
 		t.Errorf("expected position size 0, got %f", updatedEntry.PositionSize)
@@ -649,7 +649,7 @@ func TestUpdateSpot_MultipleRapidTrades(t *testing.T) {
 	// Rapid sequence of buys
 	for i := 0; i < 10; i++ {
 		trade := spotTrade("Buy", float64(100+i), 1)
-		_, entry = updateSpot(trade, entry)
+		_, entry = updateSpot(trade, entry, 0)
 	}
 
 	expectedPos := 10.0
@@ -672,7 +672,7 @@ func TestUpdateSpot_MultipleRapidTrades(t *testing.T) {
 	// Now rapid sequence of sells
 	for i := 0; i < 5; i++ {
 		trade := spotTrade("Sell", float64(120+i), 2)
-		_, entry = updateSpot(trade, entry)
+		_, entry = updateSpot(trade, entry, 0)
 	}
 
 	if !approxEqual(entry.PositionSize, 0, 0.01) {
@@ -692,17 +692,17 @@ func TestUpdateFutures_MultipleFlips(t *testing.T) {
 
 	// Long to short
 	trade1 := futuresTrade("Buy", 100, 10)
-	_, entry = updateFutures(trade1, entry)
+	_, entry = updateFutures(trade1, entry, 0)
 
 	trade2 := futuresTrade("Sell", 110, 15)
-	_, entry = updateFutures(trade2, entry)
+	_, entry = updateFutures(trade2, entry, 0)
 	if !approxEqual(entry.PositionSize, -5, 0.01) {
 		t.Errorf("after flip to short: expected pos -5, got %f", entry.PositionSize)
 	}
 
 	// Short to long
 	trade3 := futuresTrade("Buy", 105, 10)
-	_, entry = updateFutures(trade3, entry)
+	_, entry = updateFutures(trade3, entry, 0)
 	if !approxEqual(entry.PositionSize, 5, 0.01) {
 		t.Errorf("after flip to long: expected pos 5, got %f", entry.PositionSize)
 	}
@@ -715,7 +715,7 @@ func TestUpdateSpot_NegativePnL(t *testing.T) {
 	entry := entry("exchange", "BTC", "Spot", 100, 10, 1000)
 	trade := spotTrade("Sell", 80, 5) // Selling at loss
 
-	updatedTrade, updatedEntry := updateSpot(trade, entry)
+	updatedTrade, updatedEntry := updateSpot(trade, entry, 0)
 
 	expectedPnL := (80.0 - 100.0) * 5.0 // -100 (loss)
 	if !approxEqual(updatedTrade.PnL, expectedPnL, 0.01) {
@@ -730,7 +730,7 @@ func TestUpdateFutures_NegativePnL(t *testing.T) {
 	entry1 := entry("exchange", "BTC", "Futures", 100, 10, 1000)
 	trade := futuresTrade("Sell", 80, 5) // Selling long at loss
 
-	updatedTrade, _ := updateFutures(trade, entry1)
+	updatedTrade, _ := updateFutures(trade, entry1, 0)
 
 	expectedPnL := (80.0 - 100.0) * 5.0 // -100 (loss)
 	if !approxEqual(updatedTrade.PnL, expectedPnL, 0.01) {
@@ -741,7 +741,7 @@ func TestUpdateFutures_NegativePnL(t *testing.T) {
 	entry2 := entry("exchange", "BTC", "Futures", 100, -10, -1000)
 	trade2 := futuresTrade("Buy", 120, 5) // Buying short back at higher price (loss)
 
-	updatedTrade2, _ := updateFutures(trade2, entry2)
+	updatedTrade2, _ := updateFutures(trade2, entry2, 0)
 	expectedPnL2 := (100.0 - 120.0) * 5.0 // -100 (loss)
 	if !approxEqual(updatedTrade2.PnL, expectedPnL2, 0.01) {
 		t.Errorf("expected PnL %f, got %f", expectedPnL2, updatedTrade2.PnL)
@@ -755,10 +755,10 @@ func TestUpdateSpot_RepeatedOperations(t *testing.T) {
 	// Same trade applied twice (simulating duplicate processing)
 	trade := spotTrade("Buy", 100, 10)
 
-	_, entry = updateSpot(trade, entry)
+	_, entry = updateSpot(trade, entry, 0)
 
 	// Apply same trade again
-	_, entry = updateSpot(trade, entry)
+	_, entry = updateSpot(trade, entry, 0)
 
 	// Should accumulate, not create duplicate
 	expectedPos := 20.0
@@ -783,7 +783,7 @@ func TestUpdateFutures_RepeatedOperations(t *testing.T) {
 	trade := futuresTrade("Sell", 100, 5)
 
 	for i := 0; i < 3; i++ {
-		_, entry = updateFutures(trade, entry)
+		_, entry = updateFutures(trade, entry, 0)
 	}
 
 	expectedPos := -15.0
@@ -800,3 +800,202 @@ func TestUpdateFutures_RepeatedOperations(t *testing.T) {
 		t.Errorf("repeated trades: expected cost %f, got %f", expectedCost, entry.CostBasis)
 	}
 }
+
+func TestCheckCurrency(t *testing.T) {
+	book := grist.Book{
+		"Kraken-Spot-BTC": {Exchange: "Kraken", Market: "Spot", Ticker: "BTC", PositionSize: 1},
+		"Kraken-Spot-ETH": {Exchange: "Kraken", Market: "Spot", Ticker: "ETH", PositionSize: 0},
+	}
+
+	trade := grist.Trade{Exchange: "Kraken", Market: "Spot", Ticker: "BTC", Currency: "USD"}
+	if err := CheckCurrency(book, trade); err != nil {
+		t.Errorf("expected a legacy entry to be treated as USD, got %v", err)
+	}
+
+	trade.Currency = "EUR"
+	if err := CheckCurrency(book, trade); err == nil {
+		t.Error("expected an open USD position to refuse a EUR trade")
+	}
+
+	trade.Ticker = "ETH"
+	if err := CheckCurrency(book, trade); err != nil {
+		t.Errorf("expected a closed position to accept another currency, got %v", err)
+	}
+
+	_, entry := UpdateBookEntry(spotTrade("Buy", 100, 1), grist.BookEntry{})
+	if entry.Currency != "USD" {
+		t.Errorf("expected the entry to record its currency, got %q", entry.Currency)
+	}
+}
+
+func TestUpdateBookEntryWithFee_Spot(t *testing.T) {
+	entry := grist.BookEntry{}
+
+	_, entry = UpdateBookEntryWithFee(spotTrade("Buy", 100, 2), entry, 4)
+	if entry.CostBasis != 204 || entry.AveragePrice != 102 || entry.Fees != 4 {
+		t.Errorf("expected the buy fee in the cost basis, got %+v", entry)
+	}
+
+	trade, entry := UpdateBookEntryWithFee(spotTrade("Sell", 110, 1), entry, 1)
+	if trade.PnL != 7 {
+		t.Errorf("expected a net PnL of (110 - 102) - 1 = 7, got %f", trade.PnL)
+	}
+	if entry.Fees != 5 {
+		t.Errorf("expected the fees of the open position to add up, got %f", entry.Fees)
+	}
+
+	_, entry = UpdateBookEntryWithFee(spotTrade("Sell", 110, 1), entry, 1)
+	if entry.PositionSize != 0 || entry.Fees != 0 {
+		t.Errorf("expected a closed position to reset its fees, got %+v", entry)
+	}
+}
+
+func TestUpdateBookEntryWithFee_Futures(t *testing.T) {
+	open := spotTrade("Buy", 100, 1)
+	open.Market = "Futures"
+
+	trade, entry := UpdateBookEntryWithFee(open, grist.BookEntry{}, 2)
+	if trade.PnL != -2 || entry.CostBasis != 100 || entry.Fees != 2 {
+		t.Errorf("expected the opening fee as a realized loss outside the cost basis, got PnL %f and %+v", trade.PnL, entry)
+	}
+
+	flip := spotTrade("Sell", 120, 3)
+	flip.Market = "Futures"
+
+	trade, entry = UpdateBookEntryWithFee(flip, entry, 3)
+	if trade.PnL != 17 || entry.PositionSize != -2 || entry.Fees != 0 {
+		t.Errorf("expected a net PnL of 20 - 3 and fees reset on the flip, got PnL %f and %+v", trade.PnL, entry)
+	}
+}
+
+func TestReplay_RebuildsBookFromHistory(t *testing.T) {
+	history := []grist.Trade{
+		{TradeID: "2", Time: 2, Exchange: "Kraken", Market: "Spot", Ticker: "BTC", Direction: "Sell", Price: 150, OrderSize: 1, FeeUSD: 1},
+		{TradeID: "1", Time: 1, Exchange: "Kraken", Market: "Spot", Ticker: "BTC", Direction: "Buy", Price: 100, OrderSize: 2, FeeUSD: 2},
+	}
+
+	fee := func(trade grist.Trade) (float64, error) { return trade.FeeUSD, nil }
+
+	result, err := Replay(history, ReplayOptions{Fee: fee})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	replayed := result.Trades
+	entry := result.Book["Kraken-Spot-BTC"]
+	if entry.PositionSize != 1 || entry.CostBasis != 101 || entry.AssetType != "Token" {
+		t.Errorf("unexpected rebuilt entry %+v", entry)
+	}
+	if replayed[0].TradeID != "1" || replayed[1].PnL != 48 {
+		t.Errorf("expected the sale to realize (150 - 101) - 1 = 48, got %+v", replayed)
+	}
+
+	gross, _ := Replay(history, ReplayOptions{})
+	if gross.Trades[1].PnL != 50 {
+		t.Errorf("expected a gross PnL of 50 without fees, got %f", gross.Trades[1].PnL)
+	}
+}
+
+func TestApplyFunding_AddsToRealizedPnL(t *testing.T) {
+	history := []grist.Trade{
+		{TradeID: "1", Time: 1, Exchange: "Hyperliquid", Market: "Futures", Ticker: "ETH", Direction: "Buy", Price: 100, OrderSize: 1, Currency: "EUR"},
+		{TradeID: "2", Time: 3, Exchange: "Hyperliquid", Market: "Futures", Ticker: "ETH", Direction: "Sell", Price: 110, OrderSize: 1, Currency: "EUR"},
+	}
+	funding := []grist.Funding{
+		{Time: 2, Exchange: "Hyperliquid", Ticker: "ETH", Amount: -2},
+		{Time: 2, Exchange: "Hyperliquid", Ticker: "SOL", Amount: 1},
+	}
+
+	result, err := Replay(history, ReplayOptions{
+		Funding:       funding,
+		FundingAmount: func(p grist.Funding) (float64, error) { return p.Amount / 2, nil },
+		Currency:      "EUR",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	book := result.Book
+	eth := book["Hyperliquid-Futures-ETH"]
+	if eth.Funding != -1 || eth.RealizedPnL != 9 || eth.PositionSize != 0 {
+		t.Errorf("expected 10 of trading PnL less 1 of funding, got %+v", eth)
+	}
+
+	sol, ok := book["Hyperliquid-Futures-SOL"]
+	if !ok || sol.Funding != 0.5 || sol.Currency != "EUR" || sol.Market != "Futures" {
+		t.Errorf("expected funding alone to open an entry, got %+v", sol)
+	}
+}
+
+func TestReplay_KeepsWalletsApart(t *testing.T) {
+	history := []grist.Trade{
+		{TradeID: "1", Time: 1, Exchange: "Hyperliquid", Wallet: "0xa", Market: "Futures", Ticker: "ETH", Direction: "Buy", Price: 100, OrderSize: 1},
+		{TradeID: "2", Time: 2, Exchange: "Hyperliquid", Wallet: "0xb", Market: "Futures", Ticker: "ETH", Direction: "Sell", Price: 110, OrderSize: 1},
+	}
+	funding := []grist.Funding{{Time: 3, Exchange: "Hyperliquid", Wallet: "0xb", Ticker: "ETH", Amount: 1}}
+
+	result, err := Replay(history, ReplayOptions{
+		Funding:       funding,
+		FundingAmount: func(p grist.Funding) (float64, error) { return p.Amount, nil },
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	long := result.Book["Hyperliquid-0xa-Futures-ETH"]
+	if long.Wallet != "0xa" || long.PositionSize != 1 || long.RealizedPnL != 0 {
+		t.Errorf("expected the first wallet to hold the long, got %+v", long)
+	}
+
+	short := result.Book["Hyperliquid-0xb-Futures-ETH"]
+	if short.Wallet != "0xb" || short.PositionSize != -1 || short.Funding != 1 {
+		t.Errorf("expected the second wallet to hold the short and its funding, got %+v", short)
+	}
+
+	if len(result.Book) != 2 {
+		t.Errorf("expected one entry per wallet, got %v", result.Book)
+	}
+}
+
+func TestUpdateBookEntry_NoDriftOverManyTrades(t *testing.T) {
+	spot := entry("exchange", "BTC", "Spot", 0, 0, 0)
+	futures := entry("exchange", "BTC", "Futures", 0, 0, 0)
+
+	// 3000 buys of 0.1 at drifting prices, closed by 0.3 sells, leave nothing behind
+	for i := 0; i < 3000; i++ {
+		price := 100 + float64(i%7)*0.01
+		_, spot = UpdateBookEntry(spotTrade("Buy", price, 0.1), spot)
+		_, futures = UpdateBookEntry(futuresTrade("Sell", price, 0.1), futures)
+	}
+	for i := 0; i < 1000; i++ {
+		_, spot = UpdateBookEntry(spotTrade("Sell", 101, 0.3), spot)
+		_, futures = UpdateBookEntry(futuresTrade("Buy", 99, 0.3), futures)
+	}
+
+	for _, e := range []grist.BookEntry{spot, futures} {
+		if e.PositionSize != 0 || e.AveragePrice != 0 || e.CostBasis != 0 {
+			t.Errorf("expected a closed %s position, got size %g, average %g, cost %g", e.Market, e.PositionSize, e.AveragePrice, e.CostBasis)
+		}
+	}
+}
+
+func TestUpdateSpot_ExactDecimals(t *testing.T) {
+	e := entry("exchange", "ETH", "Spot", 0, 0, 0)
+	_, e = updateSpot(spotTrade("Buy", 0.1, 0.1), e, 0)
+	_, e = updateSpot(spotTrade("Buy", 0.2, 0.2), e, 0)
+
+	if e.PositionSize != 0.3 {
+		t.Errorf("expected position 0.3, got %v", e.PositionSize)
+	}
+	if e.CostBasis != 0.05 {
+		t.Errorf("expected cost basis 0.05, got %v", e.CostBasis)
+	}
+
+	trade, e := updateSpot(spotTrade("Sell", 0.3, 0.3), e, 0)
+	if trade.PnL != 0.04 {
+		t.Errorf("expected PnL 0.04, got %v", trade.PnL)
+	}
+	if e.PositionSize != 0 {
+		t.Errorf("expected a closed position, got %v", e.PositionSize)
+	}
+}
@@ -0,0 +1,22 @@
+package trades
+
+import "github.com/shopspring/decimal"
+
+// Decimals kept when a booked value leaves the engine for Grist. Values read back are exact
+// decimals again, so rounding never accumulates across trades.
+const (
+	quantityPlaces int32 = 12 // sizes and lot quantities
+	pricePlaces    int32 = 12 // average and lot prices
+	amountPlaces   int32 = 8  // cost basis, order values, fees and PnL
+)
+
+// dec reads a float from a Grist row or an exchange as the shortest decimal that represents it
+func dec(v float64) decimal.Decimal {
+	return decimal.NewFromFloat(v)
+}
+
+// toFloat rounds d to places for a Grist column
+func toFloat(d decimal.Decimal, places int32) float64 {
+	f, _ := d.Round(places).Float64()
+	return f
+}
@@ -0,0 +1,282 @@
+package trades
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+// Method selects which open lots a disposal consumes first
+type Method string
+
+const (
+	FIFO       Method = "FIFO"
+	LIFO       Method = "LIFO"
+	HIFO       Method = "HIFO"
+	SpecificID Method = "SpecificID"
+)
+
+// ParseMethod validates a lot matching method read from settings, defaulting to FIFO
+func ParseMethod(s string) (Method, error) {
+	switch m := Method(strings.TrimSpace(s)); m {
+	case "":
+		return FIFO, nil
+	case FIFO, LIFO, HIFO, SpecificID:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown lot matching method %q", s)
+	}
+}
+
+// Lots tracks spot acquisition lots per exchange, wallet, market and ticker, like the Book,
+// and matches disposals against them. Futures positions are left to the average-cost Book.
+type Lots struct {
+	method     Method
+	open       map[string][]*grist.Lot
+	byID       map[string]*grist.Lot
+	selections map[string][]string
+	changed    map[string]bool
+}
+
+func lotKey(exchange string, wallet string, market string, ticker string) string {
+	return grist.BookKey(exchange, wallet, market, ticker)
+}
+
+// NewLots returns an engine seeded with the lots persisted by previous runs
+func NewLots(method Method, lots []grist.Lot) *Lots {
+	l := &Lots{
+		method:     method,
+		open:       make(map[string][]*grist.Lot),
+		byID:       make(map[string]*grist.Lot),
+		selections: make(map[string][]string),
+		changed:    make(map[string]bool),
+	}
+
+	sorted := append([]grist.Lot(nil), lots...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Acquired < sorted[j].Acquired })
+
+	for i := range sorted {
+		lot := &sorted[i]
+		l.byID[lot.LotID] = lot
+		if holds(lot) {
+			key := lotKey(lot.Exchange, lot.Wallet, lot.Market, lot.Ticker)
+			l.open[key] = append(l.open[key], lot)
+		}
+	}
+
+	return l
+}
+
+// Select records the lots a disposal consumes under SpecificID, in order. Quantity left
+// once the selected lots are exhausted falls back to FIFO.
+func (l *Lots) Select(tradeID string, lotIDs []string) {
+	l.selections[tradeID] = lotIDs
+}
+
+// Book records a Buy as a new lot and matches a Sell against open lots. It returns the
+// matches of a Sell and the quantity that no open lot covered.
+func (l *Lots) Book(trade grist.Trade) ([]grist.LotMatch, float64, error) {
+	return l.BookWithFee(trade, 0)
+}
+
+// BookWithFee books trade like Book, adding fee to the cost of a bought lot and deducting
+// it from the proceeds of a sale, pro rata of the quantity each lot covers
+func (l *Lots) BookWithFee(trade grist.Trade, fee float64) ([]grist.LotMatch, float64, error) {
+	if trade.Market != "Spot" {
+		return nil, 0, nil
+	}
+
+	key := lotKey(trade.Exchange, trade.Wallet, trade.Market, trade.Ticker)
+
+	switch trade.Direction {
+	case "Buy":
+		id := fmt.Sprintf("%s-%s", key, trade.TradeID)
+		if _, exists := l.byID[id]; exists {
+			return nil, 0, nil
+		}
+
+		lot := &grist.Lot{
+			LotID:     id,
+			Wallet:    trade.Wallet,
+			Exchange:  trade.Exchange,
+			Market:    trade.Market,
+			Ticker:    trade.Ticker,
+			TradeID:   trade.TradeID,
+			Acquired:  trade.Time,
+			Quantity:  trade.OrderSize,
+			Remaining: trade.OrderSize,
+			Price:     trade.Price,
+		}
+		if trade.OrderSize > 0 {
+			lot.Price = toFloat(dec(trade.Price).Add(dec(fee).Div(dec(trade.OrderSize))), pricePlaces)
+		}
+		l.byID[id] = lot
+		l.open[key] = append(l.open[key], lot)
+		l.changed[id] = true
+		return nil, 0, nil
+	case "Sell":
+		order, err := l.order(key, trade.TradeID)
+		if err != nil {
+			return nil, 0, err
+		}
+		matches, unmatched := l.dispose(trade, order, fee)
+		return matches, unmatched, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown trade direction %q", trade.Direction)
+	}
+}
+
+// order returns the open lots of key in the order the method consumes them
+func (l *Lots) order(key string, tradeID string) ([]*grist.Lot, error) {
+	open := append([]*grist.Lot(nil), l.open[key]...)
+
+	switch l.method {
+	case LIFO:
+		sort.SliceStable(open, func(i, j int) bool { return open[i].Acquired > open[j].Acquired })
+	case HIFO:
+		sort.SliceStable(open, func(i, j int) bool { return open[i].Price > open[j].Price })
+	case SpecificID:
+		selected := l.selections[tradeID]
+		if len(selected) == 0 {
+			break
+		}
+
+		var ordered []*grist.Lot
+		picked := make(map[string]bool)
+		for _, id := range selected {
+			lot, ok := l.byID[id]
+			if !ok || lotKey(lot.Exchange, lot.Wallet, lot.Market, lot.Ticker) != key {
+				return nil, fmt.Errorf("trade %s selects unknown lot %s", tradeID, id)
+			}
+			if !holds(lot) {
+				return nil, fmt.Errorf("trade %s selects exhausted lot %s", tradeID, id)
+			}
+			ordered = append(ordered, lot)
+			picked[id] = true
+		}
+
+		for _, lot := range open {
+			if !picked[lot.LotID] {
+				ordered = append(ordered, lot)
+			}
+		}
+		open = ordered
+	}
+
+	return open, nil
+}
+
+func (l *Lots) dispose(trade grist.Trade, order []*grist.Lot, fee float64) ([]grist.LotMatch, float64) {
+	key := lotKey(trade.Exchange, trade.Wallet, trade.Market, trade.Ticker)
+	size, price := dec(trade.OrderSize), dec(trade.Price)
+	remaining := size
+
+	var matches []grist.LotMatch
+	for _, lot := range order {
+		if !remaining.IsPositive() {
+			break
+		}
+
+		qty := decimal.Min(remaining, dec(lot.Remaining))
+		lot.Remaining = toFloat(dec(lot.Remaining).Sub(qty), quantityPlaces)
+		remaining = remaining.Sub(qty)
+		l.changed[lot.LotID] = true
+
+		cost := qty.Mul(dec(lot.Price))
+		proceeds := qty.Mul(price)
+		if size.IsPositive() {
+			proceeds = proceeds.Sub(dec(fee).Mul(qty).Div(size))
+		}
+		matches = append(matches, grist.LotMatch{
+			MatchID:   fmt.Sprintf("%s-%s-%s", key, trade.TradeID, lot.LotID),
+			LotID:     lot.LotID,
+			TradeID:   trade.TradeID,
+			Exchange:  trade.Exchange,
+			Market:    trade.Market,
+			Ticker:    trade.Ticker,
+			Method:    string(l.method),
+			Acquired:  lot.Acquired,
+			Disposed:  trade.Time,
+			Quantity:  toFloat(qty, quantityPlaces),
+			CostBasis: toFloat(cost, amountPlaces),
+			Proceeds:  toFloat(proceeds, amountPlaces),
+			Gain:      toFloat(proceeds.Sub(cost), amountPlaces),
+		})
+	}
+
+	open := l.open[key][:0]
+	for _, lot := range l.open[key] {
+		if holds(lot) {
+			open = append(open, lot)
+		}
+	}
+	l.open[key] = open
+
+	return matches, toFloat(remaining, quantityPlaces)
+}
+
+// holds reports whether a lot has a quantity left at the precision the Book keeps, lots
+// stored before the engine used decimals may hold float dust
+func holds(lot *grist.Lot) bool {
+	return dec(lot.Remaining).Round(quantityPlaces).IsPositive()
+}
+
+// Open returns the lots of exchange, wallet, market and ticker that still hold a quantity
+func (l *Lots) Open(exchange string, wallet string, market string, ticker string) []grist.Lot {
+	var lots []grist.Lot
+	for _, lot := range l.open[lotKey(exchange, wallet, market, ticker)] {
+		lots = append(lots, *lot)
+	}
+
+	return lots
+}
+
+// Changed returns the lots created or consumed since the engine was built, to persist them
+func (l *Lots) Changed() []grist.Lot {
+	var lots []grist.Lot
+	for id := range l.changed {
+		lots = append(lots, *l.byID[id])
+	}
+
+	sort.Slice(lots, func(i, j int) bool {
+		if lots[i].Acquired == lots[j].Acquired {
+			return lots[i].LotID < lots[j].LotID
+		}
+		return lots[i].Acquired < lots[j].Acquired
+	})
+
+	return lots
+}
+
+// LoadLots builds an engine from the lots and, for SpecificID, the selections stored in Grist
+func LoadLots(ctx context.Context, g *grist.Grist, method string) (*Lots, error) {
+	m, err := ParseMethod(method)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := g.FetchLots(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lots := NewLots(m, stored)
+	if m != SpecificID {
+		return lots, nil
+	}
+
+	selections, err := g.FetchLotSelections(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for tradeID, lotIDs := range selections {
+		lots.Select(tradeID, lotIDs)
+	}
+
+	return lots, nil
+}
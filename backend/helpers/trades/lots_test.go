@@ -0,0 +1,224 @@
+package trades
+
+import (
+	"testing"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+func lotTrade(id string, direction string, time int64, price, size float64) grist.Trade {
+	return grist.Trade{
+		TradeID:   id,
+		Exchange:  "Kraken",
+		Market:    "Spot",
+		Ticker:    "BTC",
+		Direction: direction,
+		Time:      time,
+		Price:     price,
+		OrderSize: size,
+	}
+}
+
+// bookAll books three buys at 100, 300 and 200, then sells sell at 400
+func bookAll(t *testing.T, l *Lots, sell float64) ([]grist.LotMatch, float64) {
+	t.Helper()
+	for _, trade := range []grist.Trade{
+		lotTrade("b1", "Buy", 1, 100, 1),
+		lotTrade("b2", "Buy", 2, 300, 1),
+		lotTrade("b3", "Buy", 3, 200, 1),
+	} {
+		if _, _, err := l.Book(trade); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	matches, unmatched, err := l.Book(lotTrade("s1", "Sell", 4, 400, sell))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return matches, unmatched
+}
+
+func TestLots_Methods(t *testing.T) {
+	tests := []struct {
+		method    Method
+		wantLots  []string
+		wantBasis float64
+	}{
+		{FIFO, []string{"b1", "b2"}, 100 + 150},
+		{LIFO, []string{"b3", "b2"}, 200 + 150},
+		{HIFO, []string{"b2", "b3"}, 300 + 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.method), func(t *testing.T) {
+			l := NewLots(tt.method, nil)
+			matches, unmatched := bookAll(t, l, 1.5)
+
+			if unmatched != 0 {
+				t.Errorf("expected the sell to be fully matched, got %f unmatched", unmatched)
+			}
+			if len(matches) != len(tt.wantLots) {
+				t.Fatalf("expected %d matches, got %+v", len(tt.wantLots), matches)
+			}
+
+			basis := 0.0
+			for i, m := range matches {
+				if m.LotID != "Kraken-Spot-BTC-"+tt.wantLots[i] {
+					t.Errorf("match %d: expected lot %s, got %s", i, tt.wantLots[i], m.LotID)
+				}
+				if m.Method != string(tt.method) {
+					t.Errorf("expected method %s, got %s", tt.method, m.Method)
+				}
+				basis += m.CostBasis
+			}
+
+			if !approxEqual(basis, tt.wantBasis, 1e-9) {
+				t.Errorf("expected cost basis %f, got %f", tt.wantBasis, basis)
+			}
+			if !approxEqual(matches[1].Quantity, 0.5, 1e-9) || !approxEqual(matches[1].Proceeds, 200, 1e-9) {
+				t.Errorf("expected a partial match of 0.5 for 200, got %+v", matches[1])
+			}
+		})
+	}
+}
+
+func TestLots_SpecificID(t *testing.T) {
+	l := NewLots(SpecificID, nil)
+	l.Select("s1", []string{"Kraken-Spot-BTC-b3"})
+
+	matches, _ := bookAll(t, l, 1.5)
+	if len(matches) != 2 || matches[0].LotID != "Kraken-Spot-BTC-b3" || matches[1].LotID != "Kraken-Spot-BTC-b1" {
+		t.Fatalf("expected the selected lot first then FIFO, got %+v", matches)
+	}
+
+	l = NewLots(SpecificID, nil)
+	l.Select("s1", []string{"Kraken-Spot-ETH-b1"})
+	l.Book(lotTrade("b1", "Buy", 1, 100, 1))
+	if _, _, err := l.Book(lotTrade("s1", "Sell", 2, 100, 1)); err == nil {
+		t.Error("expected selecting a lot of another ticker to fail")
+	}
+}
+
+func TestLots_UnmatchedQuantityAndPersistence(t *testing.T) {
+	l := NewLots(FIFO, nil)
+	matches, unmatched := bookAll(t, l, 4)
+
+	if len(matches) != 3 || !approxEqual(unmatched, 1, 1e-9) {
+		t.Fatalf("expected 3 matches and 1 unmatched, got %d and %f", len(matches), unmatched)
+	}
+
+	changed := l.Changed()
+	if len(changed) != 3 {
+		t.Fatalf("expected 3 changed lots, got %d", len(changed))
+	}
+	for _, lot := range changed {
+		if lot.Remaining != 0 {
+			t.Errorf("expected lot %s to be exhausted, got %f", lot.LotID, lot.Remaining)
+		}
+	}
+
+	// A new engine seeded from storage continues where the previous one stopped
+	stored := []grist.Lot{
+		{LotID: "Kraken-Spot-BTC-b1", Exchange: "Kraken", Market: "Spot", Ticker: "BTC", Acquired: 1, Quantity: 1, Remaining: 0.25, Price: 100},
+		{LotID: "Kraken-Spot-BTC-b0", Exchange: "Kraken", Market: "Spot", Ticker: "BTC", Acquired: 0, Quantity: 1, Remaining: 0, Price: 50},
+	}
+	l = NewLots(FIFO, stored)
+	if open := l.Open("Kraken", "", "Spot", "BTC"); len(open) != 1 || open[0].Remaining != 0.25 {
+		t.Fatalf("expected only the partially consumed lot to be open, got %+v", open)
+	}
+
+	matches, _, err := l.Book(lotTrade("s2", "Sell", 5, 120, 0.25))
+	if err != nil || len(matches) != 1 || !approxEqual(matches[0].Gain, 5, 1e-9) {
+		t.Errorf("expected a gain of 5 on the stored lot, got %+v (%v)", matches, err)
+	}
+}
+
+func TestLots_IgnoresFuturesAndDuplicateBuys(t *testing.T) {
+	l := NewLots(FIFO, nil)
+
+	futures := lotTrade("f1", "Buy", 1, 100, 1)
+	futures.Market = "Futures"
+	l.Book(futures)
+	if len(l.Changed()) != 0 {
+		t.Error("expected futures trades to be ignored")
+	}
+
+	l.Book(lotTrade("b1", "Buy", 1, 100, 1))
+	l.Book(lotTrade("b1", "Buy", 1, 100, 1))
+	if open := l.Open("Kraken", "", "Spot", "BTC"); len(open) != 1 {
+		t.Errorf("expected a rebooked buy not to open a second lot, got %d", len(open))
+	}
+}
+
+func TestParseMethod(t *testing.T) {
+	if m, err := ParseMethod(""); err != nil || m != FIFO {
+		t.Errorf("expected FIFO by default, got %s (%v)", m, err)
+	}
+	if _, err := ParseMethod("AVCO"); err == nil {
+		t.Error("expected unknown method to fail")
+	}
+}
+
+func TestLots_BookWithFee(t *testing.T) {
+	l := NewLots(FIFO, nil)
+	l.BookWithFee(lotTrade("b1", "Buy", 1, 100, 2), 4)
+
+	matches, _, err := l.BookWithFee(lotTrade("s1", "Sell", 2, 150, 1), 2)
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("unexpected result %+v (%v)", matches, err)
+	}
+
+	if m := matches[0]; m.CostBasis != 102 || m.Proceeds != 148 || m.Gain != 46 {
+		t.Errorf("expected the fees in the cost and proceeds, got %+v", m)
+	}
+}
+
+func TestLots_ExactDecimals(t *testing.T) {
+	l := NewLots(FIFO, nil)
+	l.Book(lotTrade("b1", "Buy", 1, 100, 0.1))
+	l.Book(lotTrade("b2", "Buy", 2, 100, 0.2))
+
+	_, unmatched, err := l.Book(lotTrade("s1", "Sell", 3, 100, 0.3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unmatched != 0 {
+		t.Errorf("expected the sell fully matched, got %v unmatched", unmatched)
+	}
+	if open := l.Open("Kraken", "", "Spot", "BTC"); len(open) != 0 {
+		t.Errorf("expected no open lot left, got %+v", open)
+	}
+}
+
+func TestNewLots_IgnoresFloatDust(t *testing.T) {
+	l := NewLots(FIFO, []grist.Lot{{LotID: "dust", Exchange: "Kraken", Market: "Spot", Ticker: "BTC", Remaining: 5.551115123125783e-17}})
+
+	if open := l.Open("Kraken", "", "Spot", "BTC"); len(open) != 0 {
+		t.Errorf("expected a lot with float dust to be exhausted, got %+v", open)
+	}
+}
+
+func TestLots_KeepsWalletsApart(t *testing.T) {
+	trade := func(id string, wallet string, direction string, size float64, price float64) grist.Trade {
+		return grist.Trade{TradeID: id, Exchange: "Hyperliquid", Wallet: wallet, Market: "Spot", Ticker: "HYPE", Direction: direction, OrderSize: size, Price: price}
+	}
+
+	l := NewLots(FIFO, []grist.Lot{{LotID: "a0", Wallet: "0xa", Exchange: "Hyperliquid", Market: "Spot", Ticker: "HYPE", Quantity: 1, Remaining: 1, Price: 10}})
+	l.Book(trade("b1", "0xb", "Buy", 1, 30))
+
+	matches, unmatched, err := l.Book(trade("s1", "0xb", "Sell", 2, 40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].CostBasis != 30 || unmatched != 1 {
+		t.Errorf("expected the sell of 0xb to consume its own lot only, got %+v and %v unmatched", matches, unmatched)
+	}
+	if open := l.Open("Hyperliquid", "0xa", "Spot", "HYPE"); len(open) != 1 || open[0].Remaining != 1 {
+		t.Errorf("expected the lot of 0xa untouched, got %+v", open)
+	}
+	if lots := l.Changed(); len(lots) != 1 || lots[0].Wallet != "0xb" {
+		t.Errorf("expected the new lot to carry its wallet, got %+v", lots)
+	}
+}
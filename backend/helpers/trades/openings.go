@@ -0,0 +1,313 @@
+package trades
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+// RateFunc returns the value of one unit of currency in the currency of trade, at its time
+type RateFunc func(currency string, trade grist.Trade) (float64, error)
+
+type opening struct {
+	trancheID string
+	date      int64
+	ticker    string
+	currency  string
+	remaining decimal.Decimal
+	unitCost  decimal.Decimal
+}
+
+// Openings costs the part of spot sells no booked position covers from the opening balances
+// held on no exchange, consumed in the order they were entered. Balances held on an exchange
+// are booked there by OpeningLots instead.
+type Openings struct {
+	balances []*opening
+	recorded map[string]grist.UnmatchedDisposal
+	rate     RateFunc
+}
+
+// NewOpenings returns the opening balances left once the recorded disposals took their share.
+// Sells already recorded are costed as recorded instead of consuming the balances again. A
+// nil rate can only cost balances held in the currency of the trade.
+func NewOpenings(balances []grist.OpeningBalance, recorded []grist.UnmatchedDisposal, rate RateFunc) *Openings {
+	o := &Openings{recorded: make(map[string]grist.UnmatchedDisposal), rate: rate}
+
+	balances = append([]grist.OpeningBalance(nil), balances...)
+	grist.FillTrancheIDs(balances)
+
+	for _, b := range balances {
+		if strings.TrimSpace(b.Exchange) != "" || b.Ticker == "" || b.Quantity <= 0 {
+			continue
+		}
+
+		qty := dec(b.Quantity)
+		o.balances = append(o.balances, &opening{
+			trancheID: b.TrancheID,
+			date:      b.Date,
+			ticker:    b.Ticker,
+			currency:  b.Currency,
+			remaining: qty,
+			unitCost:  dec(b.Cost).Div(qty),
+		})
+	}
+
+	sorted := append([]grist.UnmatchedDisposal(nil), recorded...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time < sorted[j].Time })
+
+	for _, d := range sorted {
+		o.recorded[d.Exchange+"-"+d.TradeID] = d
+		missing := dec(d.OpeningQuantity)
+		for _, b := range o.candidates(d.Ticker) {
+			qty := decimal.Min(missing, b.remaining)
+			b.remaining = b.remaining.Sub(qty)
+			missing = missing.Sub(qty)
+		}
+	}
+
+	return o
+}
+
+// candidates are the balances a sell of ticker may consume, in consumption order
+func (o *Openings) candidates(ticker string) []*opening {
+	var open []*opening
+	for _, b := range o.balances {
+		if b.ticker == ticker && b.remaining.IsPositive() {
+			open = append(open, b)
+		}
+	}
+
+	return open
+}
+
+// taken is the part of a sell costed from one opening balance, cost in the currency of the trade
+type taken struct {
+	balance  *opening
+	quantity decimal.Decimal
+	cost     decimal.Decimal
+}
+
+// Cost consumes up to qty of the asset sold by trade and returns the quantity covered and its
+// cost in the currency of the trade
+func (o *Openings) Cost(trade grist.Trade, qty decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
+	if d, ok := o.recorded[trade.Exchange+"-"+trade.TradeID]; ok {
+		return dec(d.OpeningQuantity), dec(d.OpeningCost), nil
+	}
+
+	covered, cost := decimal.Zero, decimal.Zero
+	parts, err := o.take(trade, qty)
+	for _, part := range parts {
+		covered = covered.Add(part.quantity)
+		cost = cost.Add(part.cost)
+	}
+
+	return covered, cost, err
+}
+
+// Match consumes up to qty of the asset sold by trade like Cost, and returns a match per
+// opening balance it drew from, acquired at the date of the balance, and the quantity left
+// uncovered. The fee is deducted from the proceeds pro rata of the quantity each balance
+// covers, as BookWithFee does.
+func (o *Openings) Match(trade grist.Trade, qty float64, fee float64) ([]grist.LotMatch, float64, error) {
+	remaining := dec(qty)
+	parts, err := o.take(trade, remaining)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	size, price := dec(trade.OrderSize), dec(trade.Price)
+
+	var matches []grist.LotMatch
+	for _, part := range parts {
+		remaining = remaining.Sub(part.quantity)
+		lotID := "Opening-" + part.balance.trancheID
+		proceeds := part.quantity.Mul(price)
+		if size.IsPositive() {
+			proceeds = proceeds.Sub(dec(fee).Mul(part.quantity).Div(size))
+		}
+
+		matches = append(matches, grist.LotMatch{
+			MatchID:   fmt.Sprintf("%s-%s-%s", trade.Exchange, trade.TradeID, lotID),
+			LotID:     lotID,
+			TradeID:   trade.TradeID,
+			Exchange:  trade.Exchange,
+			Market:    trade.Market,
+			Ticker:    trade.Ticker,
+			Acquired:  part.balance.date,
+			Disposed:  trade.Time,
+			Quantity:  toFloat(part.quantity, quantityPlaces),
+			CostBasis: toFloat(part.cost, amountPlaces),
+			Proceeds:  toFloat(proceeds, amountPlaces),
+			Gain:      toFloat(proceeds.Sub(part.cost), amountPlaces),
+		})
+	}
+
+	return matches, toFloat(remaining, quantityPlaces), nil
+}
+
+// take consumes up to qty of the asset sold by trade from the balances, in the order they were entered
+func (o *Openings) take(trade grist.Trade, qty decimal.Decimal) ([]taken, error) {
+	var parts []taken
+	for _, b := range o.candidates(trade.Ticker) {
+		if !qty.IsPositive() {
+			break
+		}
+
+		rate := decimal.NewFromInt(1)
+		if b.currency != "" && !strings.EqualFold(b.currency, bookCurrency(trade.Currency)) {
+			if o.rate == nil {
+				return parts, fmt.Errorf("opening balance of %s is in %s but trade %s is in %s", b.ticker, b.currency, trade.TradeID, bookCurrency(trade.Currency))
+			}
+
+			r, err := o.rate(b.currency, trade)
+			if err != nil {
+				return parts, fmt.Errorf("opening balance of %s: %w", b.ticker, err)
+			}
+			rate = dec(r)
+		}
+
+		take := decimal.Min(qty, b.remaining)
+		b.remaining = b.remaining.Sub(take)
+		qty = qty.Sub(take)
+
+		parts = append(parts, taken{balance: b, quantity: take, cost: take.Mul(b.unitCost).Mul(rate)})
+	}
+
+	return parts, nil
+}
+
+// OpeningLots returns the opening balances held on an exchange as spot buys at their date, in
+// currency when the balance has none. They are booked before the trade history and never
+// stored in Trades. Their trade ID is derived from the tranche ID, so tranches sharing a date
+// each open a lot, which keeps its ID when other balances are edited.
+func OpeningLots(balances []grist.OpeningBalance, currency string) []grist.Trade {
+	balances = append([]grist.OpeningBalance(nil), balances...)
+	grist.FillTrancheIDs(balances)
+
+	var lots []grist.Trade
+	for _, b := range balances {
+		exchange := strings.TrimSpace(b.Exchange)
+		if exchange == "" || b.Ticker == "" || b.Quantity <= 0 {
+			continue
+		}
+
+		lot := grist.Trade{
+			TradeID:    "Opening-" + b.TrancheID,
+			Time:       b.Date,
+			Exchange:   exchange,
+			Market:     "Spot",
+			Ticker:     b.Ticker,
+			Direction:  "Buy",
+			Price:      toFloat(dec(b.Cost).Div(dec(b.Quantity)), pricePlaces),
+			OrderSize:  b.Quantity,
+			OrderValue: b.Cost,
+			Currency:   b.Currency,
+		}
+		if lot.Currency == "" {
+			lot.Currency = currency
+		}
+		lots = append(lots, lot)
+	}
+
+	sort.SliceStable(lots, func(i, j int) bool { return lots[i].Time < lots[j].Time })
+
+	return lots
+}
+
+// ReadOpeningBalances parses a CSV of opening balances with a header row naming the Date
+// (YYYY-MM-DD), Exchange, Ticker or Asset, Quantity, Cost, Currency and Tranche_ID columns.
+// Exchange, Currency and Tranche_ID are optional, tranches without an ID being numbered in
+// order per date, exchange and ticker.
+func ReadOpeningBalances(r io.Reader) ([]grist.OpeningBalance, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, errors.New("missing header row")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["ticker"]; !ok {
+		if i, ok := columns["asset"]; ok {
+			columns["ticker"] = i
+		}
+	}
+
+	for _, name := range []string{"date", "ticker", "quantity", "cost"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing %s column", name)
+		}
+	}
+
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	balances := make([]grist.OpeningBalance, 0, len(rows)-1)
+	for n, row := range rows[1:] {
+		date, err := time.Parse("2006-01-02", field(row, "date"))
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid date: %w", n+2, err)
+		}
+
+		quantity, err := strconv.ParseFloat(field(row, "quantity"), 64)
+		if err != nil || quantity <= 0 {
+			return nil, fmt.Errorf("row %d: invalid quantity %q", n+2, field(row, "quantity"))
+		}
+
+		cost, err := strconv.ParseFloat(field(row, "cost"), 64)
+		if err != nil || cost < 0 {
+			return nil, fmt.Errorf("row %d: invalid cost %q", n+2, field(row, "cost"))
+		}
+
+		ticker := strings.ToUpper(field(row, "ticker"))
+		if ticker == "" {
+			return nil, fmt.Errorf("row %d: missing ticker", n+2)
+		}
+
+		balances = append(balances, grist.OpeningBalance{
+			TrancheID: field(row, "tranche_id"),
+			Date:      date.UTC().UnixMilli(),
+			Exchange:  field(row, "exchange"),
+			Ticker:    ticker,
+			Quantity:  quantity,
+			Cost:      cost,
+			Currency:  strings.ToUpper(field(row, "currency")),
+		})
+	}
+
+	grist.FillTrancheIDs(balances)
+	return balances, nil
+}
+
+// LoadOpenings reads the opening balances and the disposals already costed from them
+func LoadOpenings(ctx context.Context, g *grist.Grist, rate RateFunc) (*Openings, error) {
+	balances, err := g.FetchOpeningBalances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded, err := g.FetchUnmatchedDisposals(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewOpenings(balances, recorded, rate), nil
+}
@@ -0,0 +1,230 @@
+package trades
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+func TestBookTrade_RecordsUnmatchedDisposal(t *testing.T) {
+	book := grist.Book{}
+	buy := grist.Trade{TradeID: "1", Time: 1, Exchange: "Kraken", Market: "Spot", Ticker: "BTC", Direction: "Buy", Price: 100, OrderSize: 2}
+	sell := grist.Trade{TradeID: "2", Time: 2, Exchange: "Kraken", Market: "Spot", Ticker: "BTC", Direction: "Sell", Price: 150, OrderSize: 5}
+
+	if _, d, err := BookTrade(book, buy, 0, nil); err != nil || d != nil {
+		t.Fatalf("expected a buy to book cleanly, got %+v, %v", d, err)
+	}
+
+	booked, d, err := BookTrade(book, sell, 0, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if d == nil || d.Quantity != 3 || d.Proceeds != 450 || d.OpeningQuantity != 0 || d.TradeID != "2" || d.Currency != "USD" {
+		t.Fatalf("expected 3 BTC sold without a position, got %+v", d)
+	}
+	if booked.PnL != 100 || booked.OrderValue != 750 {
+		t.Errorf("expected the PnL of the booked 2 BTC on the full order value, got %+v", booked)
+	}
+	if book["Kraken-Spot-BTC"].PositionSize != 0 {
+		t.Errorf("expected the position to be closed, got %+v", book["Kraken-Spot-BTC"])
+	}
+}
+
+func TestBookTrade_CostsUnmatchedFromOpenings(t *testing.T) {
+	balances := []grist.OpeningBalance{
+		{Ticker: "BTC", Quantity: 1, Cost: 50},
+		{Exchange: "Kraken", Ticker: "BTC", Quantity: 10, Cost: 0}, // an opening lot, booked by Replay
+		{Ticker: "BTC", Quantity: 4, Cost: 160},
+	}
+	openings := NewOpenings(balances, nil, nil)
+
+	sell := grist.Trade{TradeID: "1", Time: 1, Exchange: "Kraken", Market: "Spot", Ticker: "BTC", Direction: "Sell", Price: 100, OrderSize: 3}
+	book := grist.Book{}
+	booked, d, err := BookTrade(book, sell, 0, openings)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 1 at 50, then 2 at 40 from the next balance
+	if d.OpeningQuantity != 3 || d.OpeningCost != 130 {
+		t.Errorf("expected 3 BTC costing 130 from the openings, got %+v", d)
+	}
+	if booked.PnL != 170 || book["Kraken-Spot-BTC"].RealizedPnL != 170 {
+		t.Errorf("expected 300 of proceeds less 130 of cost, got %+v and %+v", booked, book["Kraken-Spot-BTC"])
+	}
+
+	sell.TradeID, sell.OrderSize = "2", 5
+	_, d, err = BookTrade(book, sell, 0, openings)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.OpeningQuantity != 2 || d.OpeningCost != 80 || d.Quantity != 5 {
+		t.Errorf("expected the 2 BTC left in the balances without an exchange only, got %+v", d)
+	}
+}
+
+func TestNewOpenings_ReplaysRecordedDisposals(t *testing.T) {
+	balances := []grist.OpeningBalance{{Ticker: "ETH", Quantity: 3, Cost: 300}}
+	recorded := []grist.UnmatchedDisposal{
+		{TradeID: "1", Exchange: "Kraken", Ticker: "ETH", Time: 1, Quantity: 2, OpeningQuantity: 2, OpeningCost: 200},
+	}
+	openings := NewOpenings(balances, recorded, nil)
+
+	sell := grist.Trade{TradeID: "1", Time: 1, Exchange: "Kraken", Market: "Spot", Ticker: "ETH", Direction: "Sell", Price: 150, OrderSize: 2}
+	_, d, err := BookTrade(grist.Book{}, sell, 0, openings)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.OpeningQuantity != 2 || d.OpeningCost != 200 {
+		t.Errorf("expected a recorded sell to keep its cost, got %+v", d)
+	}
+
+	sell.TradeID = "2"
+	_, d, err = BookTrade(grist.Book{}, sell, 0, openings)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.OpeningQuantity != 1 || d.OpeningCost != 100 {
+		t.Errorf("expected only the ETH the recorded sell left, got %+v", d)
+	}
+}
+
+func TestOpenings_ConvertsCurrency(t *testing.T) {
+	balances := []grist.OpeningBalance{{Ticker: "BTC", Quantity: 1, Cost: 100, Currency: "EUR"}}
+	sell := grist.Trade{TradeID: "1", Time: 1, Exchange: "Kraken", Market: "Spot", Ticker: "BTC", Direction: "Sell", Price: 200, OrderSize: 1, Currency: "USD"}
+
+	if _, _, err := BookTrade(grist.Book{}, sell, 0, NewOpenings(balances, nil, nil)); err == nil {
+		t.Error("expected an error without rates for a balance in another currency")
+	}
+
+	rate := func(currency string, trade grist.Trade) (float64, error) {
+		if currency != "EUR" {
+			return 0, errors.New("unexpected currency")
+		}
+		return 1.1, nil
+	}
+
+	_, d, err := BookTrade(grist.Book{}, sell, 0, NewOpenings(balances, nil, rate))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.OpeningCost != 110 {
+		t.Errorf("expected the EUR cost in USD, got %+v", d)
+	}
+}
+
+func TestReplay_BooksOpeningLotsFirst(t *testing.T) {
+	balances := []grist.OpeningBalance{
+		{Date: 5, Exchange: "Kraken", Ticker: "BTC", Quantity: 3, Cost: 30000},
+		{Date: 1, Ticker: "ETH", Quantity: 1, Cost: 100},
+	}
+	history := []grist.Trade{
+		{TradeID: "1", Time: 2, Exchange: "Kraken", Market: "Spot", Ticker: "BTC", Direction: "Sell", Price: 40000, OrderSize: 2, Currency: "EUR"},
+	}
+
+	lots := OpeningLots(balances, "EUR")
+	if len(lots) != 1 || lots[0].Price != 10000 || lots[0].Currency != "EUR" || lots[0].TradeID != "Opening-1970-01-01-Kraken-BTC-1" {
+		t.Fatalf("expected the Kraken balance only as a buy, got %+v", lots)
+	}
+
+	result, err := Replay(history, ReplayOptions{Seed: lots})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result.Trades) != 1 || result.Trades[0].PnL != 60000 || len(result.Unmatched) != 0 {
+		t.Errorf("expected the sell to realize 2 * (40000 - 10000), got %+v", result)
+	}
+	if entry := result.Book["Kraken-Spot-BTC"]; entry.PositionSize != 1 || entry.CostBasis != 10000 {
+		t.Errorf("expected 1 BTC left at its opening cost, got %+v", entry)
+	}
+}
+
+func TestOpeningLots_TranchesOnTheSameDate(t *testing.T) {
+	balances := []grist.OpeningBalance{
+		{Date: 5, Exchange: "Kraken", Ticker: "BTC", Quantity: 1, Cost: 10000},
+		{Date: 5, Exchange: "Kraken", Ticker: "BTC", Quantity: 2, Cost: 30000},
+	}
+
+	lots := OpeningLots(balances, "EUR")
+	if len(lots) != 2 || lots[0].TradeID == lots[1].TradeID {
+		t.Fatalf("expected a distinct trade ID per tranche, got %+v", lots)
+	}
+
+	l := NewLots(FIFO, nil)
+	for _, lot := range lots {
+		if _, _, err := l.Book(lot); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	result, err := Replay(nil, ReplayOptions{Seed: lots})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	open := l.Open("Kraken", "", "Spot", "BTC")
+	var held float64
+	for _, lot := range open {
+		held += lot.Remaining
+	}
+	if len(open) != 2 || held != result.Book["Kraken-Spot-BTC"].PositionSize || held != 3 {
+		t.Errorf("expected both tranches in the lots and the book, got %+v and %+v", open, result.Book["Kraken-Spot-BTC"])
+	}
+
+	// removing the first tranche leaves the lot of the second one under its ID
+	if again := OpeningLots([]grist.OpeningBalance{{TrancheID: lots[1].TradeID[len("Opening-"):], Date: 5, Exchange: "Kraken", Ticker: "BTC", Quantity: 2, Cost: 30000}}, "EUR"); again[0].TradeID != lots[1].TradeID {
+		t.Errorf("expected the lot to keep its trade ID, got %s and %s", again[0].TradeID, lots[1].TradeID)
+	}
+}
+
+func TestReadOpeningBalances_Tranches(t *testing.T) {
+	in := "Date,Ticker,Quantity,Cost,Exchange,Tranche_ID\n2019-03-01,BTC,1,4000,Kraken,\n2019-03-01,BTC,2,8000,Kraken,\n2019-03-01,BTC,1,4100,Kraken,otc\n"
+
+	balances, err := ReadOpeningBalances(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var ids []string
+	for _, b := range balances {
+		ids = append(ids, b.TrancheID)
+	}
+	if want := "[2019-03-01-Kraken-BTC-1 2019-03-01-Kraken-BTC-2 otc]"; fmt.Sprint(ids) != want {
+		t.Errorf("expected tranche IDs %s, got %v", want, ids)
+	}
+}
+
+func TestReadOpeningBalances(t *testing.T) {
+	in := "Date,Asset,Quantity,Cost,Exchange,Currency\n2019-03-01,btc,3,12000,Kraken,usd\n2020-01-15,ETH,1.5,300,,\n"
+
+	balances, err := ReadOpeningBalances(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(balances) != 2 {
+		t.Fatalf("expected 2 balances, got %+v", balances)
+	}
+	want := grist.OpeningBalance{TrancheID: "2019-03-01-Kraken-BTC-1", Date: 1551398400000, Exchange: "Kraken", Ticker: "BTC", Quantity: 3, Cost: 12000, Currency: "USD"}
+	if balances[0] != want {
+		t.Errorf("expected %+v, got %+v", want, balances[0])
+	}
+	if balances[1].Exchange != "" || balances[1].Currency != "" || balances[1].Quantity != 1.5 {
+		t.Errorf("unexpected balance %+v", balances[1])
+	}
+
+	for _, bad := range []string{
+		"Asset,Quantity,Cost\nBTC,1,1\n",
+		"Date,Ticker,Quantity,Cost\n01/03/2019,BTC,1,1\n",
+		"Date,Ticker,Quantity,Cost\n2019-03-01,BTC,-1,1\n",
+	} {
+		if _, err := ReadOpeningBalances(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
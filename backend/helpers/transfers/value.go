@@ -0,0 +1,107 @@
+package transfers
+
+import (
+	"sort"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/fx"
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+const (
+	// maxTradeDistance is how far from a transfer the trade pricing it may be
+	maxTradeDistance = 7 * 24 * time.Hour
+	// currentPriceAge is how old a transfer may be to be priced at the current price
+	currentPriceAge = 24 * time.Hour
+)
+
+type point struct {
+	time  int64 // milliseconds
+	price float64
+}
+
+// Valuer prices transfers in USD at their time. Fiat and stablecoins use the FX rates, other
+// assets the nearest trade in the history, and recent transfers fall back to Prices.
+type Valuer struct {
+	trades map[string][]point
+	prices grist.Prices
+	rates  *fx.Rates
+	now    time.Time
+}
+
+func NewValuer(history []grist.Trade, prices grist.Prices, rates *fx.Rates, now time.Time) *Valuer {
+	v := &Valuer{trades: make(map[string][]point), prices: prices, rates: rates, now: now}
+
+	for _, trade := range history {
+		if trade.Price <= 0 || !fx.IsFiat(fx.Reporting(trade.Currency)) {
+			continue
+		}
+
+		rate, err := rates.ToBase(fx.Reporting(trade.Currency), time.UnixMilli(trade.Time))
+		if err != nil {
+			continue
+		}
+		v.trades[trade.Ticker] = append(v.trades[trade.Ticker], point{time: trade.Time, price: trade.Price * rate})
+	}
+
+	for _, points := range v.trades {
+		sort.Slice(points, func(i, j int) bool { return points[i].time < points[j].time })
+	}
+
+	return v
+}
+
+// USDValue returns the USD value of amount of ticker at t, false when it cannot be priced
+func (v *Valuer) USDValue(ticker string, amount float64, t time.Time) (float64, bool) {
+	if fx.IsFiat(ticker) {
+		rate, err := v.rates.ToBase(ticker, t)
+		if err != nil {
+			return 0, false
+		}
+		return amount * rate, true
+	}
+
+	if price, ok := v.nearestTrade(ticker, t); ok {
+		return amount * price, true
+	}
+
+	if price, ok := v.prices[ticker]; ok && price > 0 && v.now.Sub(t) <= currentPriceAge {
+		return amount * price, true
+	}
+
+	return 0, false
+}
+
+func (v *Valuer) nearestTrade(ticker string, t time.Time) (float64, bool) {
+	points := v.trades[ticker]
+	if len(points) == 0 {
+		return 0, false
+	}
+
+	at := t.UnixMilli()
+	i := sort.Search(len(points), func(i int) bool { return points[i].time >= at })
+
+	best, found := point{}, false
+	for _, j := range []int{i - 1, i} {
+		if j < 0 || j >= len(points) {
+			continue
+		}
+		if !found || abs(points[j].time-at) < abs(best.time-at) {
+			best, found = points[j], true
+		}
+	}
+
+	if !found || time.Duration(abs(best.time-at))*time.Millisecond > maxTradeDistance {
+		return 0, false
+	}
+
+	return best.price, true
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+
+	return v
+}
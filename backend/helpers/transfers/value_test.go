@@ -0,0 +1,48 @@
+package transfers
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/fx"
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+func TestValuer_USDValue(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 12, 0, 0, 0, time.UTC) }
+
+	rates := fx.NewRates([]grist.FXRate{{Date: day(1).Truncate(24 * time.Hour).Unix(), Currency: "EUR", Rate: 1.1}})
+	history := []grist.Trade{
+		{Ticker: "BTC", Time: day(2).UnixMilli(), Price: 60000, Currency: "USD"},
+		{Ticker: "BTC", Time: day(10).UnixMilli(), Price: 50000, Currency: "EUR"},
+		{Ticker: "ETH", Time: day(2).UnixMilli(), Price: 0.05, Currency: "BTC"},
+	}
+	prices := grist.Prices{"BTC": 70000, "ETH": 3500}
+	v := NewValuer(history, prices, rates, day(31))
+
+	tests := []struct {
+		name   string
+		ticker string
+		at     time.Time
+		want   float64
+		ok     bool
+	}{
+		{"fiat at its rate", "EUR", day(5), 110, true},
+		{"stablecoin at par", "USDC", day(5), 100, true},
+		{"nearest trade before", "BTC", day(4), 6000000, true},
+		{"nearest trade after in EUR", "BTC", day(9), 5500000, true},
+		{"no trade within a week", "BTC", day(20), 0, false},
+		{"recent transfer at the current price", "ETH", day(31).Add(-time.Hour), 350000, true},
+		{"crypto quoted trades are not used", "ETH", day(2), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := v.USDValue(tt.ticker, 100, tt.at)
+			if ok != tt.ok || math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("USDValue(%s) = %f, %v, want %f, %v", tt.ticker, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package webhook
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PathPrefix is where Grist webhooks must point, followed by the table name, e.g.
+// http://127.0.0.1:8787/webhooks/grist/Prices. Grist payloads do not name the table.
+const PathPrefix = "/webhooks/grist/"
+
+// Route maps edits to the watched columns of a Grist table to the jobs reading them
+type Route struct {
+	Table   string
+	Columns []string
+	Jobs    []string
+}
+
+// DefaultRoutes covers the tables users edit by hand to feed the price and Pendle jobs.
+// Only the input columns are watched so the jobs' own writes do not trigger them again.
+var DefaultRoutes = []Route{
+	{Table: "Prices", Columns: []string{"Ticker", "Coingecko_ID"}, Jobs: []string{"prices_cryptocurrencies"}},
+	{Table: "Positions_TradFi_", Columns: []string{"Ticker"}, Jobs: []string{"prices_stocks"}},
+	{Table: "Tokens", Columns: []string{"Chain", "Address", "Ticker", "Decimal"}, Jobs: []string{"pendle_markets", "pendle_user_positions"}},
+}
+
+type Receiver struct {
+	routes   map[string]Route
+	secret   string
+	debounce time.Duration
+	trigger  func(job string) error
+
+	mu      sync.Mutex
+	seen    map[string]map[int64]string // table -> record id -> watched columns fingerprint
+	pending map[string]*time.Timer      // job -> debounce timer
+	closed  bool
+}
+
+// NewReceiver returns a handler that triggers the jobs routed to a table once no further
+// relevant edit has arrived for debounce. An empty secret disables authentication.
+func NewReceiver(routes []Route, secret string, debounce time.Duration, trigger func(job string) error) *Receiver {
+	r := &Receiver{
+		routes:   make(map[string]Route, len(routes)),
+		secret:   secret,
+		debounce: debounce,
+		trigger:  trigger,
+		seen:     make(map[string]map[int64]string),
+		pending:  make(map[string]*time.Timer),
+	}
+
+	for _, route := range routes {
+		r.routes[route.Table] = route
+	}
+
+	return r
+}
+
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !r.authorized(req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	table := strings.TrimPrefix(req.URL.Path, PathPrefix)
+	route, ok := r.routes[table]
+	if !ok {
+		http.Error(w, fmt.Sprintf("no jobs watch table %q", table), http.StatusNotFound)
+		return
+	}
+
+	var records []map[string]any
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 10<<20)).Decode(&records); err != nil {
+		http.Error(w, fmt.Sprintf("invalid grist payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if r.changed(route, records) {
+		for _, job := range route.Jobs {
+			r.schedule(job)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (r *Receiver) authorized(req *http.Request) bool {
+	if r.secret == "" {
+		return true
+	}
+
+	provided := req.URL.Query().Get("secret")
+	if bearer, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok {
+		provided = bearer
+	}
+
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(r.secret)) == 1
+}
+
+// changed records the watched columns of each record and reports whether any of them
+// differs from what was last seen. Records seen for the first time count as changed.
+func (r *Receiver) changed(route Route, records []map[string]any) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seen, ok := r.seen[route.Table]
+	if !ok {
+		seen = make(map[int64]string)
+		r.seen[route.Table] = seen
+	}
+
+	changed := false
+	for _, record := range records {
+		values := make([]any, len(route.Columns))
+		for i, c := range route.Columns {
+			values[i] = record[c]
+		}
+		fingerprint, _ := json.Marshal(values)
+
+		id, ok := record["id"].(float64)
+		if !ok {
+			changed = true
+			continue
+		}
+
+		if seen[int64(id)] != string(fingerprint) {
+			seen[int64(id)] = string(fingerprint)
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+func (r *Receiver) schedule(job string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+
+	if t, ok := r.pending[job]; ok {
+		t.Reset(r.debounce)
+		return
+	}
+
+	r.pending[job] = time.AfterFunc(r.debounce, func() {
+		r.mu.Lock()
+		delete(r.pending, job)
+		r.mu.Unlock()
+
+		if err := r.trigger(job); err != nil {
+			fmt.Printf("Warning: webhook could not trigger job %q: %v\n", job, err)
+		}
+	})
+}
+
+// Close cancels the triggers still waiting for their debounce delay
+func (r *Receiver) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	for job, t := range r.pending {
+		t.Stop()
+		delete(r.pending, job)
+	}
+}
@@ -0,0 +1,130 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type triggerRecorder struct {
+	mu   sync.Mutex
+	jobs []string
+}
+
+func (r *triggerRecorder) trigger(job string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.jobs = append(r.jobs, job)
+	return nil
+}
+
+func (r *triggerRecorder) triggered() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.jobs...)
+}
+
+func post(t *testing.T, h http.Handler, path string, body string) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestReceiver_DebouncesTriggers(t *testing.T) {
+	rec := &triggerRecorder{}
+	r := NewReceiver(DefaultRoutes, "", 50*time.Millisecond, rec.trigger)
+	defer r.Close()
+
+	if code := post(t, r, "/webhooks/grist/Prices", `[{"id":1,"Ticker":"BTC","Coingecko_ID":"bitcoin"}]`); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	post(t, r, "/webhooks/grist/Prices", `[{"id":2,"Ticker":"ETH","Coingecko_ID":"ethereum"}]`)
+
+	if got := rec.triggered(); len(got) != 0 {
+		t.Fatalf("expected no trigger before the debounce delay, got %v", got)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	got := rec.triggered()
+	if len(got) != 1 || got[0] != "prices_cryptocurrencies" {
+		t.Errorf("expected a single prices_cryptocurrencies trigger, got %v", got)
+	}
+}
+
+func TestReceiver_IgnoresUnwatchedColumns(t *testing.T) {
+	rec := &triggerRecorder{}
+	r := NewReceiver(DefaultRoutes, "", 10*time.Millisecond, rec.trigger)
+	defer r.Close()
+
+	post(t, r, "/webhooks/grist/Prices", `[{"id":1,"Ticker":"BTC","Coingecko_ID":"bitcoin","Price":1}]`)
+	time.Sleep(50 * time.Millisecond)
+
+	// The prices job writing back the Price column must not trigger itself again
+	post(t, r, "/webhooks/grist/Prices", `[{"id":1,"Ticker":"BTC","Coingecko_ID":"bitcoin","Price":2}]`)
+	time.Sleep(50 * time.Millisecond)
+
+	if got := rec.triggered(); len(got) != 1 {
+		t.Errorf("expected exactly one trigger, got %v", got)
+	}
+}
+
+func TestReceiver_RoutesTableToJobs(t *testing.T) {
+	rec := &triggerRecorder{}
+	r := NewReceiver(DefaultRoutes, "", 10*time.Millisecond, rec.trigger)
+	defer r.Close()
+
+	post(t, r, "/webhooks/grist/Tokens", `[{"id":1,"Chain":"Ethereum","Address":"0x1"}]`)
+	time.Sleep(50 * time.Millisecond)
+
+	got := rec.triggered()
+	if len(got) != 2 {
+		t.Fatalf("expected both Pendle jobs to be triggered, got %v", got)
+	}
+
+	if code := post(t, r, "/webhooks/grist/Unknown", `[]`); code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unrouted table, got %d", code)
+	}
+	if code := post(t, r, "/webhooks/grist/Prices", `{"not":"a list"}`); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid payload, got %d", code)
+	}
+}
+
+func TestReceiver_RequiresSecret(t *testing.T) {
+	rec := &triggerRecorder{}
+	r := NewReceiver(DefaultRoutes, "s3cret", time.Millisecond, rec.trigger)
+	defer r.Close()
+
+	if code := post(t, r, "/webhooks/grist/Prices", `[]`); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without secret, got %d", code)
+	}
+	if code := post(t, r, "/webhooks/grist/Prices?secret=s3cret", `[]`); code != http.StatusOK {
+		t.Errorf("expected 200 with the secret in the query, got %d", code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/grist/Prices", strings.NewReader(`[]`))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 with a bearer token, got %d", w.Code)
+	}
+}
+
+func TestReceiver_CloseDropsPendingTriggers(t *testing.T) {
+	rec := &triggerRecorder{}
+	r := NewReceiver(DefaultRoutes, "", 20*time.Millisecond, rec.trigger)
+
+	post(t, r, "/webhooks/grist/Positions_TradFi_", `[{"id":1,"Ticker":"AAPL"}]`)
+	r.Close()
+	time.Sleep(60 * time.Millisecond)
+
+	if got := rec.triggered(); len(got) != 0 {
+		t.Errorf("expected no trigger after Close, got %v", got)
+	}
+}
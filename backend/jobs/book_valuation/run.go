@@ -0,0 +1,88 @@
+package book_valuation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/fx"
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+	"github.com/zyriu/portfolio/backend/helpers/hyperliquid"
+	"github.com/zyriu/portfolio/backend/helpers/jobstatus"
+	"github.com/zyriu/portfolio/backend/helpers/misc"
+	"golang.org/x/sync/errgroup"
+)
+
+func Run(ctx context.Context, _ ...any) error {
+	updateStatus := jobstatus.GetStatusUpdater(ctx)
+
+	updateStatus("Initializing Grist client...")
+	g, err := grist.InitiateClient()
+	if err != nil {
+		return err
+	}
+
+	h, err := hyperliquid.InitiateClient()
+	if err != nil {
+		return err
+	}
+
+	var (
+		book   grist.Book
+		prices grist.Prices
+		rates  *fx.Rates
+	)
+
+	updateStatus("Fetching book, prices and FX rates...")
+	errGroup, c := errgroup.WithContext(ctx)
+	misc.Go(errGroup, c, g.FetchBook, &book)
+	misc.Go(errGroup, c, g.FetchPrices, &prices)
+	misc.Go(errGroup, c, func(ctx context.Context) (*fx.Rates, error) {
+		return fx.Load(ctx, &g)
+	}, &rates)
+
+	if err := errGroup.Wait(); err != nil {
+		return err
+	}
+
+	updateStatus("Fetching perpetual marks from Hyperliquid...")
+	marks, err := h.GetPerpMarks(ctx)
+	if err != nil {
+		updateStatus(fmt.Sprintf("⚠️ Perpetual marks unavailable, valuing futures from Prices: %v", err))
+	}
+
+	now := time.Now()
+	usdPerUnit := func(currency string) (float64, error) {
+		currency = fx.Reporting(currency)
+		if fx.IsFiat(currency) {
+			return rates.ToBase(currency, now)
+		}
+
+		// Trades quoted in another asset are booked in that asset
+		if price, ok := prices[currency]; ok && price > 0 {
+			return price, nil
+		}
+
+		return 0, fmt.Errorf("no price for %s", currency)
+	}
+
+	valuations, missing := valueBook(book, prices, marks, usdPerUnit)
+
+	if err := grist.EnsureTable[grist.Valuation](ctx, &g, "Book"); err != nil {
+		return err
+	}
+
+	updateStatus(fmt.Sprintf("Upserting %d valuation(s) to Book...", len(valuations)))
+	if err := grist.UpsertTable(ctx, &g, "Book", valuations, grist.UpsertOpts{}); err != nil {
+		return err
+	}
+
+	if len(missing) > 0 {
+		updateStatus(fmt.Sprintf("⚠️ Valued %d entries, no price for %s", len(valuations), strings.Join(missing, ", ")))
+		return nil
+	}
+
+	updateStatus(fmt.Sprintf("✓ Valued %d book entries", len(valuations)))
+	return nil
+}
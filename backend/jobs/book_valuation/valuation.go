@@ -0,0 +1,87 @@
+package book_valuation
+
+import (
+	"math"
+	"sort"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+// usdPrice returns the USD price of the asset of entry, preferring perpetual marks for
+// futures and falling back to them for spot assets missing from Prices
+func usdPrice(entry grist.BookEntry, prices grist.Prices, marks map[string]float64) (float64, bool) {
+	if entry.Market == "Futures" {
+		if mark, ok := marks[entry.Ticker]; ok && mark > 0 {
+			return mark, true
+		}
+	}
+
+	if price, ok := prices[entry.Ticker]; ok && price > 0 {
+		return price, true
+	}
+
+	if mark, ok := marks[entry.Ticker]; ok && mark > 0 {
+		return mark, true
+	}
+
+	return 0, false
+}
+
+// value prices entry at price, expressed in the currency of the entry. The cost basis of a
+// short is negative, so the unrealized PnL of both sides is the market value less the cost.
+func value(entry grist.BookEntry, price float64) grist.Valuation {
+	v := grist.Valuation{
+		Exchange:  entry.Exchange,
+		Wallet:    entry.Wallet,
+		Ticker:    entry.Ticker,
+		Market:    entry.Market,
+		MarkPrice: price,
+	}
+
+	if entry.PositionSize == 0 {
+		return v
+	}
+
+	v.MarketValue = entry.PositionSize * price
+	v.UnrealizedPnL = v.MarketValue - entry.CostBasis
+	if entry.CostBasis != 0 {
+		v.UnrealizedPnLPct = v.UnrealizedPnL / math.Abs(entry.CostBasis)
+	}
+
+	return v
+}
+
+// valueBook prices every entry of book, usdPerUnit returning the USD value of one unit of
+// the currency an entry is booked in. Entries without a price or rate are returned apart.
+func valueBook(book grist.Book, prices grist.Prices, marks map[string]float64, usdPerUnit func(currency string) (float64, error)) ([]grist.Valuation, []string) {
+	keys := make([]string, 0, len(book))
+	for key := range book {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var valuations []grist.Valuation
+	var missing []string
+	for _, key := range keys {
+		entry := book[key]
+
+		price, ok := usdPrice(entry, prices, marks)
+		if !ok && entry.PositionSize != 0 {
+			missing = append(missing, key)
+			continue
+		}
+
+		if price != 0 {
+			rate, err := usdPerUnit(entry.Currency)
+			if err != nil || rate == 0 {
+				missing = append(missing, key)
+				continue
+			}
+			price /= rate
+		}
+
+		valuations = append(valuations, value(entry, price))
+	}
+
+	return valuations, missing
+}
@@ -0,0 +1,74 @@
+package book_valuation
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestValue_LongAndShort(t *testing.T) {
+	long := value(grist.BookEntry{Market: "Spot", PositionSize: 2, CostBasis: 200}, 150)
+	if long.MarketValue != 300 || long.UnrealizedPnL != 100 || !approx(long.UnrealizedPnLPct, 0.5) {
+		t.Errorf("unexpected long valuation %+v", long)
+	}
+
+	short := value(grist.BookEntry{Market: "Futures", PositionSize: -2, CostBasis: -200}, 150)
+	if short.MarketValue != -300 || short.UnrealizedPnL != -100 || !approx(short.UnrealizedPnLPct, -0.5) {
+		t.Errorf("expected a short to lose when the price rises, got %+v", short)
+	}
+
+	closed := value(grist.BookEntry{Market: "Spot"}, 150)
+	if closed.MarkPrice != 150 || closed.MarketValue != 0 || closed.UnrealizedPnL != 0 {
+		t.Errorf("expected a closed entry to be worth nothing, got %+v", closed)
+	}
+}
+
+func TestValueBook_PricesAndCurrencies(t *testing.T) {
+	book := grist.Book{
+		"Hyperliquid-Futures-ETH": {Exchange: "Hyperliquid", Market: "Futures", Ticker: "ETH", PositionSize: 1, CostBasis: 2000, Currency: "USD"},
+		"Kraken-Spot-BTC":         {Exchange: "Kraken", Market: "Spot", Ticker: "BTC", PositionSize: 1, CostBasis: 40000, Currency: "EUR"},
+		"Kraken-Spot-HYPE":        {Exchange: "Kraken", Market: "Spot", Ticker: "HYPE", PositionSize: 10, CostBasis: 100},
+		"Kraken-Spot-DOT":         {Exchange: "Kraken", Market: "Spot", Ticker: "DOT", PositionSize: 5, CostBasis: 50},
+		"Kraken-Spot-SOL":         {Exchange: "Kraken", Market: "Spot", Ticker: "SOL", PositionSize: 1, CostBasis: 100, Currency: "GBP"},
+	}
+	prices := grist.Prices{"ETH": 2100, "BTC": 50000, "SOL": 150}
+	marks := map[string]float64{"ETH": 2200, "HYPE": 30}
+
+	usdPerUnit := func(currency string) (float64, error) {
+		switch currency {
+		case "", "USD":
+			return 1, nil
+		case "EUR":
+			return 1.25, nil
+		default:
+			return 0, errors.New("no rate")
+		}
+	}
+
+	valuations, missing := valueBook(book, prices, marks, usdPerUnit)
+
+	if len(missing) != 2 || missing[0] != "Kraken-Spot-DOT" || missing[1] != "Kraken-Spot-SOL" {
+		t.Errorf("expected DOT without price and SOL without rate to be missing, got %v", missing)
+	}
+
+	byTicker := make(map[string]grist.Valuation)
+	for _, v := range valuations {
+		byTicker[v.Ticker] = v
+	}
+
+	if eth := byTicker["ETH"]; eth.MarkPrice != 2200 || eth.UnrealizedPnL != 200 {
+		t.Errorf("expected the perpetual to be valued at its mark, got %+v", eth)
+	}
+	if btc := byTicker["BTC"]; btc.MarketValue != 40000 || btc.UnrealizedPnL != 0 {
+		t.Errorf("expected BTC valued in EUR, got %+v", btc)
+	}
+	if hype := byTicker["HYPE"]; hype.MarketValue != 300 {
+		t.Errorf("expected the mark as a fallback for spot, got %+v", hype)
+	}
+}
@@ -0,0 +1,112 @@
+package exchange_hyperliquid
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+	"github.com/zyriu/portfolio/backend/helpers/hyperliquid"
+	"github.com/zyriu/portfolio/backend/helpers/jobstatus"
+	"github.com/zyriu/portfolio/backend/helpers/misc"
+)
+
+// fundingPageSize is the maximum number of payments returned by a userFunding request
+const fundingPageSize = 500
+
+func processFunding(h hyperliquid.Hyperliquid, wallet string, userFunding []hyperliquid.UserFunding) ([]grist.Funding, error) {
+	payments := make([]grist.Funding, 0, len(userFunding))
+	for _, f := range userFunding {
+		if f.Delta.Type != "funding" {
+			continue
+		}
+
+		amount, err := strconv.ParseFloat(f.Delta.USDC, 64)
+		if err != nil {
+			return payments, fmt.Errorf("parse funding amount %q: %w", f.Delta.USDC, err)
+		}
+
+		rate, _ := strconv.ParseFloat(f.Delta.FundingRate, 64)
+		size, _ := strconv.ParseFloat(f.Delta.Szi, 64)
+
+		payments = append(payments, grist.Funding{
+			Time:         f.Time,
+			Exchange:     "Hyperliquid",
+			Wallet:       wallet,
+			Ticker:       h.NormalizeTicker(f.Delta.Coin),
+			Amount:       amount,
+			FundingRate:  rate,
+			PositionSize: size,
+		})
+	}
+
+	return payments, nil
+}
+
+// fundingPage returns the funding updates of a wallet from start on, oldest first
+type fundingPage func(ctx context.Context, start int64) ([]hyperliquid.UserFunding, error)
+
+// walkFunding returns the funding updates from start on, page by page. Every coin is paid at the
+// same hourly timestamp, so a full page may end partway through an hour: the next page resumes
+// at the last time rather than after it, and the updates already seen are dropped.
+func walkFunding(ctx context.Context, page fundingPage, start int64, updateStatus func(string)) ([]hyperliquid.UserFunding, error) {
+	type key struct {
+		time int64
+		coin string
+	}
+	seen := make(map[key]bool)
+
+	var updates []hyperliquid.UserFunding
+	for {
+		updateStatus(fmt.Sprintf("Fetching funding from timestamp %d...", start))
+		userFunding, err := page(ctx, start)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range userFunding {
+			k := key{f.Time, f.Delta.Coin}
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			updates = append(updates, f)
+		}
+
+		if len(userFunding) < fundingPageSize {
+			break
+		}
+
+		// A page holding a single timestamp cannot be resumed within it
+		next := userFunding[len(userFunding)-1].Time
+		if next <= start {
+			next = start + 1
+		}
+		start = next
+	}
+
+	return updates, nil
+}
+
+// fetchFunding returns the funding payments of the wallet newer than the latest one stored
+func fetchFunding(ctx context.Context, h hyperliquid.Hyperliquid, g grist.Grist, wallet misc.Wallet) ([]grist.Funding, error) {
+	updateStatus := jobstatus.GetStatusUpdater(ctx)
+
+	updateStatus(fmt.Sprintf("[%s] Checking for latest funding in Grist...", wallet.Label))
+	latest, err := g.GetLatestFundingTime(ctx, "Hyperliquid", wallet.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	page := func(ctx context.Context, start int64) ([]hyperliquid.UserFunding, error) {
+		return h.GetUserFunding(ctx, wallet.Address, start)
+	}
+	userFunding, err := walkFunding(ctx, page, latest+1, func(msg string) {
+		updateStatus(fmt.Sprintf("[%s] %s", wallet.Label, msg))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return processFunding(h, wallet.Address, userFunding)
+}
@@ -0,0 +1,64 @@
+package exchange_hyperliquid
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/zyriu/portfolio/backend/helpers/hyperliquid"
+)
+
+// hourlyFunding serves coins payments at each of hours hourly timestamps, at most
+// fundingPageSize per page from start on
+func hourlyFunding(hours int, coins int) fundingPage {
+	return func(_ context.Context, start int64) ([]hyperliquid.UserFunding, error) {
+		var page []hyperliquid.UserFunding
+		for h := 1; h <= hours; h++ {
+			for c := 0; c < coins && len(page) < fundingPageSize; c++ {
+				f := hyperliquid.UserFunding{Time: int64(h) * 3_600_000}
+				if f.Time < start {
+					continue
+				}
+				f.Delta.Type = "funding"
+				f.Delta.Coin = fmt.Sprintf("C%d", c)
+				f.Delta.USDC = "1"
+				page = append(page, f)
+			}
+		}
+
+		return page, nil
+	}
+}
+
+func TestWalkFunding_ResumesWithinATimestamp(t *testing.T) {
+	// 300 coins an hour, the first two pages ending partway through an hour
+	updates, err := walkFunding(context.Background(), hourlyFunding(3, 300), 1, func(string) {})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(updates) != 900 {
+		t.Fatalf("expected every coin of every hour once, got %d updates", len(updates))
+	}
+
+	seen := make(map[string]bool)
+	for _, f := range updates {
+		key := fmt.Sprint(f.Time, f.Delta.Coin)
+		if seen[key] {
+			t.Fatalf("duplicate update %s", key)
+		}
+		seen[key] = true
+	}
+}
+
+func TestWalkFunding_SingleTimestampPage(t *testing.T) {
+	// a full page of one hour cannot be resumed within it, the walk moves on rather than loop
+	updates, err := walkFunding(context.Background(), hourlyFunding(2, fundingPageSize), 1, func(string) {})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(updates) != 2*fundingPageSize {
+		t.Errorf("expected both hours, got %d updates", len(updates))
+	}
+}
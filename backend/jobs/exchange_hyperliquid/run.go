@@ -48,7 +48,7 @@ func Run(ctx context.Context, args ...any) error {
 		updateStatus(fmt.Sprintf("Processing wallet %d/%d: %s", i+1, len(wallets), wallet.Label))
 		// Execute updates concurrently for this wallet
 		var wg sync.WaitGroup
-		errChan := make(chan error, 2)
+		errChan := make(chan error, 3)
 		statusChan := make(chan string, 10)
 
 		// Status updater goroutine
@@ -58,7 +58,7 @@ func Run(ctx context.Context, args ...any) error {
 			}
 		}()
 
-		wg.Add(2)
+		wg.Add(3)
 
 		// Convert settings.UnifiedWallet to misc.Wallet
 		miscWallet := misc.Wallet{
@@ -89,6 +89,17 @@ func Run(ctx context.Context, args ...any) error {
 			statusChan <- fmt.Sprintf("[%s] ✓ Trades synced", wallet.Label)
 		}()
 
+		// Update transfers concurrently
+		go func() {
+			defer wg.Done()
+			statusChan <- fmt.Sprintf("[%s] Fetching transfers...", wallet.Label)
+			if err := updateTransfers(ctx, h, g, miscWallet); err != nil {
+				errChan <- fmt.Errorf("update transfers for %s: %w", wallet.Label, err)
+				return
+			}
+			statusChan <- fmt.Sprintf("[%s] ✓ Transfers synced", wallet.Label)
+		}()
+
 		wg.Wait()
 		close(errChan)
 		close(statusChan)
@@ -7,98 +7,31 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/zyriu/portfolio/backend/helpers/fx"
 	"github.com/zyriu/portfolio/backend/helpers/grist"
 	"github.com/zyriu/portfolio/backend/helpers/hyperliquid"
 	"github.com/zyriu/portfolio/backend/helpers/jobstatus"
 	"github.com/zyriu/portfolio/backend/helpers/misc"
+	"github.com/zyriu/portfolio/backend/helpers/settings"
 	"github.com/zyriu/portfolio/backend/helpers/token"
 	"github.com/zyriu/portfolio/backend/helpers/trades"
 )
 
-func bookTrade(book *grist.Book, trade grist.Trade) grist.Trade {
-	key := fmt.Sprintf("Hyperliquid-%s-%s", trade.Market, trade.Ticker)
-
-	entry := grist.BookEntry{}
-	if e, ok := (*book)[key]; ok {
-		entry = e
-	} else {
-		entry.Exchange = trade.Exchange
-		entry.AssetType = "Token"
-		entry.Ticker = trade.Ticker
-		entry.Market = trade.Market
-	}
-
-	trade, entry = trades.UpdateBookEntry(trade, entry)
-	(*book)[key] = entry
-
-	return trade
-}
-
-func generateConfigForAggregation() trades.TradeConfig {
-	return trades.TradeConfig{
-		GetAsset: func(t any) string {
-			return t.(*hyperliquid.UserFill).Coin
-		},
-		GetTimeMin: func(t any) int64 {
-			return t.(*hyperliquid.UserFill).Time / 60000
-		},
-		GetDirection: func(t any) string {
-			return t.(*hyperliquid.UserFill).Side
-		},
-		GetPrice: func(t any) string {
-			return t.(*hyperliquid.UserFill).Px
-		},
-		GetSize: func(t any) string {
-			return t.(*hyperliquid.UserFill).Sz
-		},
-		GetFee: func(t any) string {
-			return t.(*hyperliquid.UserFill).Fee
-		},
-		GetCost: nil,
-		GetTime: func(t any) any {
-			return t.(*hyperliquid.UserFill).Time
-		},
-		GetTradeID: func(t any) any {
-			return t.(*hyperliquid.UserFill).Tid
-		},
-		UpdateSize: func(t any, v string) {
-			t.(*hyperliquid.UserFill).Sz = v
-		},
-		UpdateFee: func(t any, v string) {
-			t.(*hyperliquid.UserFill).Fee = v
-		},
-		UpdateCost: nil,
-		UpdateTime: func(t any, v any) {
-			if time, ok := v.(int64); ok {
-				t.(*hyperliquid.UserFill).Time = time
-			}
-		},
-		UpdateTradeID: func(t any, v any) {
-			if tid, ok := v.(int64); ok {
-				t.(*hyperliquid.UserFill).Tid = tid
-			}
-		},
-	}
-}
-
-func processFills(h hyperliquid.Hyperliquid, userFills []hyperliquid.UserFill) ([]grist.Trade, error) {
-	fillsInterface := make([]any, len(userFills))
-	for i := range userFills {
-		fillsInterface[i] = &userFills[i]
+func processFills(h hyperliquid.Hyperliquid, userFills []hyperliquid.UserFill, grouping trades.Grouping) ([]grist.Trade, error) {
+	aggregated, err := trades.AggregateTrades(userFills, grouping)
+	if err != nil {
+		return nil, err
 	}
 
-	config := generateConfigForAggregation()
-	aggMap := trades.AggregateTrades(fillsInterface, config)
-
-	tradesSlice := make([]grist.Trade, 0, len(aggMap))
-	for _, entry := range aggMap {
-		f := entry.Trade.(*hyperliquid.UserFill)
-		price, err := strconv.ParseFloat(f.Px, 64)
+	tradesSlice := make([]grist.Trade, 0, len(aggregated))
+	for _, entry := range aggregated {
+		f := entry.Fill
+		price, err := strconv.ParseFloat(entry.Price, 64)
 		if err != nil {
 			return tradesSlice, err
 		}
 
-		size, err := strconv.ParseFloat(f.Sz, 64)
+		size, err := strconv.ParseFloat(entry.Size, 64)
 		if err != nil {
 			return tradesSlice, err
 		}
@@ -116,7 +49,11 @@ func processFills(h hyperliquid.Hyperliquid, userFills []hyperliquid.UserFill) (
 		base := h.NormalizeTicker(f.Coin)
 
 		feeCurrency := h.NormalizeTicker(f.FeeToken)
-		fee, _ := strconv.ParseFloat(f.Fee, 64)
+		fee, err := strconv.ParseFloat(entry.Fee, 64)
+		if err != nil {
+			return tradesSlice, err
+		}
+
 		feeUSD := fee
 		if !token.IsStablecoin(feeCurrency) {
 			feeUSD *= price
@@ -144,66 +81,173 @@ func processFills(h hyperliquid.Hyperliquid, userFills []hyperliquid.UserFill) (
 	return tradesSlice, nil
 }
 
+// tradesStream is the sync cursor stream of the fills of a wallet
+const tradesStream = "trades"
+
+// bookWallet is the wallet the trades and funding of address are booked under. A Book built
+// before entries were kept per wallet merges every wallet until it is rebuilt.
+func bookWallet(book grist.Book, address string) string {
+	for _, entry := range book {
+		if entry.Exchange == "Hyperliquid" && entry.Wallet == "" {
+			return ""
+		}
+	}
+
+	return address
+}
+
+// fillsPage returns up to fillsPageSize fills of a wallet from seed on, or its latest fills
+// when seed is 0
+type fillsPage func(ctx context.Context, seed int64) ([]hyperliquid.UserFill, error)
+
+// fillsPageSize is the maximum number of fills returned by a userFills request
+const fillsPageSize = 2000
+
+// walkFills passes the fills from start on to process, page by page, and returns the cursor
+// past the newest fill, start when there was none, with the number of fills processed
+func walkFills(ctx context.Context, page fillsPage, start int64, process func([]hyperliquid.UserFill) error, updateStatus func(string)) (int64, int, error) {
+	cursor, total := start, 0
+	for seed := start; ; {
+		if seed > 0 {
+			updateStatus(fmt.Sprintf("Fetching user fills from timestamp %d...", seed))
+		} else {
+			updateStatus("Fetching all user fills from Hyperliquid...")
+		}
+
+		fills, err := page(ctx, seed)
+		if err != nil {
+			return cursor, total, err
+		}
+
+		if len(fills) == 0 {
+			updateStatus("No new fills found")
+			break
+		}
+
+		updateStatus(fmt.Sprintf("Processing %d fills...", len(fills)))
+		total += len(fills)
+		for _, f := range fills {
+			cursor = max(cursor, f.Time+1)
+		}
+
+		if err := process(fills); err != nil {
+			return cursor, total, err
+		}
+
+		if len(fills) < fillsPageSize {
+			break
+		}
+
+		seed = fills[fillsPageSize-1].Time + 1
+		updateStatus("More fills available, continuing with next batch...")
+	}
+
+	return cursor, total, nil
+}
+
 func updateTrades(ctx context.Context, h hyperliquid.Hyperliquid, g grist.Grist, wallet misc.Wallet) error {
 	updateStatus := jobstatus.GetStatusUpdater(ctx)
 
-	updateStatus(fmt.Sprintf("[%s] Checking for latest trades in Grist...", wallet.Label))
-	latestTrades, err := g.GetExchangeLatestTrades(ctx, "Hyperliquid", 1)
+	updateStatus(fmt.Sprintf("[%s] Checking trade sync cursor...", wallet.Label))
+	start, synced, err := g.GetSyncCursor(ctx, "Hyperliquid", wallet.Address, tradesStream)
 	if err != nil {
 		return err
 	}
 
+	if !synced {
+		// wallets synced before cursors were stored resume after their newest trade
+		latest, err := g.GetLatestTradeTime(ctx, "Hyperliquid", wallet.Address)
+		if err != nil {
+			return err
+		}
+		if latest > 0 {
+			start = latest + 1
+		}
+	}
+
 	updateStatus(fmt.Sprintf("[%s] Loading trade book...", wallet.Label))
 	book, err := g.FetchBook(ctx)
 	if err != nil {
 		return err
 	}
 
-	var seed int64
-	if len(latestTrades) > 0 {
-		seed = latestTrades[0].Time + 1
-		updateStatus(fmt.Sprintf("[%s] Fetching trades after timestamp %d...", wallet.Label, seed))
+	settingsData, err := settings.GetCurrentSettings()
+	if err != nil {
+		return err
+	}
+
+	aggregation := settingsData.Exchanges.Hyperliquid.Aggregation
+	grouping, err := trades.ParseGrouping(aggregation.Strategy, aggregation.Window, aggregation.Tolerance)
+	if err != nil {
+		return err
+	}
+
+	updateStatus(fmt.Sprintf("[%s] Loading tax lots...", wallet.Label))
+	lots, err := trades.LoadLots(ctx, &g, settingsData.Settings.Tax.LotMethod)
+	if err != nil {
+		return err
+	}
+
+	updateStatus(fmt.Sprintf("[%s] Loading FX rates...", wallet.Label))
+	rates, err := fx.Load(ctx, &g)
+	if err != nil {
+		return err
+	}
+
+	updateStatus(fmt.Sprintf("[%s] Loading opening balances...", wallet.Label))
+	openings, err := trades.LoadOpenings(ctx, &g, rates.TradeRate)
+	if err != nil {
+		return err
+	}
+
+	// trades stored before they carried a wallet are tagged instead of booked again
+	stored := make(map[string]string)
+	if start > 0 {
+		updateStatus(fmt.Sprintf("[%s] Fetching trades after timestamp %d...", wallet.Label, start))
 	} else {
-		seed = 0
-		updateStatus(fmt.Sprintf("[%s] No existing trades found, fetching all trades...", wallet.Label))
+		updateStatus(fmt.Sprintf("[%s] No trades synced for this wallet, fetching all trades...", wallet.Label))
+		existing, err := g.FetchExchangeTrades(ctx, "Hyperliquid")
+		if err != nil {
+			return err
+		}
+		for _, t := range existing {
+			stored[t.TradeID] = t.Wallet
+		}
 	}
 
-	var trades []grist.Upsert
-	totalFills := 0
+	bookedAs := bookWallet(book, wallet.Address)
+	if bookedAs == "" {
+		updateStatus(fmt.Sprintf("[%s] ⚠️ Book merges Hyperliquid wallets, run portfolio rebuild book to split it", wallet.Label))
+	}
 
-	for {
-		var fills []hyperliquid.UserFill
-		if seed == 0 {
-			updateStatus(fmt.Sprintf("[%s] Fetching all user fills from Hyperliquid...", wallet.Label))
-			fills, err = h.GetUserFills(ctx, wallet.Address)
-			if err != nil {
-				return err
-			}
+	var upserts []grist.Upsert
+	var tagged []grist.Upsert
+	var matches []grist.LotMatch
+	var unmatched []grist.UnmatchedDisposal
 
-			// edge case to fix missing first entry from hyperliquid api and get lifetime accurate records
-			for i, f := range fills {
-				if f.Tid == 814936528749872 {
-					fills[i].Sz = "10900"
-					break
-				}
-			}
-		} else {
-			updateStatus(fmt.Sprintf("[%s] Fetching user fills from timestamp %d...", wallet.Label, seed))
-			fills, err = h.GetUserFillsByTime(ctx, wallet.Address, seed)
-			if err != nil {
-				return err
-			}
+	page := func(ctx context.Context, seed int64) ([]hyperliquid.UserFill, error) {
+		if seed > 0 {
+			return h.GetUserFillsByTime(ctx, wallet.Address, seed)
 		}
 
-		if len(fills) == 0 {
-			updateStatus(fmt.Sprintf("[%s] No new fills found", wallet.Label))
-			return nil
+		fills, err := h.GetUserFills(ctx, wallet.Address)
+		if err != nil {
+			return nil, err
 		}
 
-		updateStatus(fmt.Sprintf("[%s] Processing %d fills...", wallet.Label, len(fills)))
-		totalFills += len(fills)
+		// edge case to fix missing first entry from hyperliquid api and get lifetime accurate records
+		for i, f := range fills {
+			if f.Tid == 814936528749872 {
+				fills[i].Sz = "10900"
+				break
+			}
+		}
+
+		return fills, nil
+	}
 
-		tradesSlice, err := processFills(h, fills)
+	process := func(fills []hyperliquid.UserFill) error {
+		tradesSlice, err := processFills(h, fills, grouping)
 		if err != nil {
 			return fmt.Errorf("generate upserts: %w", err)
 		}
@@ -219,33 +263,134 @@ func updateTrades(ctx context.Context, h hyperliquid.Hyperliquid, g grist.Grist,
 
 		updateStatus(fmt.Sprintf("[%s] Booking %d trades...", wallet.Label, len(tradesSlice)))
 		for _, trade := range tradesSlice {
-			trade = bookTrade(&book, trade)
-			trades = append(trades, g.CreateRecordFromTrade(trade))
+			if owner, ok := stored[trade.TradeID]; ok {
+				if owner == "" {
+					tagged = append(tagged, grist.Upsert{
+						Require: map[string]any{"Exchange": trade.Exchange, "Trade_ID": trade.TradeID},
+						Fields:  map[string]any{"Wallet": wallet.Address},
+					})
+				}
+				continue
+			}
+
+			// Hyperliquid settles in USDC
+			trade, err = fx.Denominate(trade, fx.Base, settingsData.Settings.FX.ReportingCurrency, rates)
+			if err != nil {
+				return err
+			}
+
+			trade.Wallet = bookedAs
+			if err := trades.CheckCurrency(book, trade); err != nil {
+				return err
+			}
+
+			fee := 0.0
+			if settingsData.Settings.Tax.IncludeFees {
+				if fee, err = fx.Fee(trade, rates); err != nil {
+					return err
+				}
+			}
+
+			var disposal *grist.UnmatchedDisposal
+			trade, disposal, err = trades.BookTrade(book, trade, fee, openings)
+			if err != nil {
+				return err
+			}
+			if disposal != nil {
+				unmatched = append(unmatched, *disposal)
+			}
+
+			// lots follow the Book entry the trade was booked on
+			m, _, err := lots.BookWithFee(trade, fee)
+			if err != nil {
+				return err
+			}
+			matches = append(matches, m...)
+
+			trade.Wallet = wallet.Address
+			upserts = append(upserts, g.CreateRecordFromTrade(trade))
 		}
 
-		if len(fills) < 2000 {
-			break
+		return nil
+	}
+
+	cursor, totalFills, err := walkFills(ctx, page, start, process, func(msg string) {
+		updateStatus(fmt.Sprintf("[%s] %s", wallet.Label, msg))
+	})
+	if err != nil {
+		return err
+	}
+
+	payments, err := fetchFunding(ctx, h, g, wallet)
+	if err != nil {
+		return fmt.Errorf("fetch funding: %w", err)
+	}
+
+	for _, payment := range payments {
+		amount, err := fx.FundingAmount(payment, settingsData.Settings.FX.ReportingCurrency, rates)
+		if err != nil {
+			return err
 		}
+		payment.Wallet = bookedAs
+		trades.ApplyFunding(book, payment, fx.Reporting(settingsData.Settings.FX.ReportingCurrency), amount)
+	}
 
-		seed = fills[2000-1].Time + 1
-		updateStatus(fmt.Sprintf("[%s] More fills available, continuing with next batch...", wallet.Label))
+	if len(upserts) == 0 && len(tagged) == 0 && len(payments) == 0 {
+		updateStatus(fmt.Sprintf("[%s] No new trades to sync", wallet.Label))
+		return nil
+	}
+
+	if err := g.EnsureTradeColumns(ctx); err != nil {
+		return err
 	}
 
-	if len(trades) > 0 {
-		updateStatus(fmt.Sprintf("[%s] Upserting %d trades to Grist...", wallet.Label, len(trades)))
-		if err := g.UpsertRecords(ctx, "Trades", trades, grist.UpsertOpts{}); err != nil {
+	if len(upserts) > 0 {
+		updateStatus(fmt.Sprintf("[%s] Upserting %d trades to Grist...", wallet.Label, len(upserts)))
+		if err := g.UpsertRecords(ctx, "Trades", upserts, grist.UpsertOpts{}); err != nil {
 			return err
 		}
+	}
 
-		updateStatus(fmt.Sprintf("[%s] Updating trade book...", wallet.Label))
-		book := g.CreateRecordsFromBook(book)
-		if err := g.UpsertRecords(ctx, "Book", book, grist.UpsertOpts{}); err != nil {
+	if len(tagged) > 0 {
+		updateStatus(fmt.Sprintf("[%s] Tagging %d stored trades with the wallet...", wallet.Label, len(tagged)))
+		if err := g.UpsertRecords(ctx, "Trades", tagged, grist.UpsertOpts{}); err != nil {
+			return err
+		}
+	}
+
+	if len(payments) > 0 {
+		updateStatus(fmt.Sprintf("[%s] Upserting %d funding payments to Grist...", wallet.Label, len(payments)))
+		if err := grist.UpsertTable(ctx, &g, "Funding", payments, grist.UpsertOpts{}); err != nil {
+			return err
+		}
+	}
+
+	updateStatus(fmt.Sprintf("[%s] Updating trade book...", wallet.Label))
+	if err := g.UpsertRecords(ctx, "Book", g.CreateRecordsFromBook(book), grist.UpsertOpts{}); err != nil {
+		return err
+	}
+
+	if len(upserts) > 0 {
+		updateStatus(fmt.Sprintf("[%s] Updating tax lots...", wallet.Label))
+		if err := g.SaveLots(ctx, lots.Changed(), matches); err != nil {
 			return err
 		}
-		updateStatus(fmt.Sprintf("[%s] ✓ Successfully synced %d trades from %d fills", wallet.Label, len(trades), totalFills))
-	} else {
-		updateStatus(fmt.Sprintf("[%s] No new trades to sync", wallet.Label))
 	}
 
+	if len(unmatched) > 0 {
+		updateStatus(fmt.Sprintf("[%s] ⚠️ %d sells exceed the booked position, recording unmatched disposals...", wallet.Label, len(unmatched)))
+		if err := g.SaveUnmatchedDisposals(ctx, unmatched); err != nil {
+			return err
+		}
+	}
+
+	if cursor > start {
+		if err := g.SetSyncCursor(ctx, "Hyperliquid", wallet.Address, tradesStream, cursor); err != nil {
+			return err
+		}
+	}
+
+	updateStatus(fmt.Sprintf("[%s] ✓ Successfully synced %d trades from %d fills and %d funding payments", wallet.Label, len(upserts), totalFills, len(payments)))
+
 	return nil
 }
@@ -1,12 +1,18 @@
 package exchange_hyperliquid
 
 import (
+	"context"
+	"fmt"
 	"testing"
 
 	"github.com/zyriu/portfolio/backend/helpers/grist"
 	"github.com/zyriu/portfolio/backend/helpers/hyperliquid"
+	"github.com/zyriu/portfolio/backend/helpers/trades"
 )
 
+// byMinute is the default aggregation of the settings
+var byMinute = trades.Grouping{Strategy: trades.ByMinute}
+
 // Helper to create a test Hyperliquid instance
 func createTestHyperliquid() hyperliquid.Hyperliquid {
 	return hyperliquid.Hyperliquid{}
@@ -29,7 +35,7 @@ func TestProcessFills_SingleFill(t *testing.T) {
 		},
 	}
 
-	processed, err := processFills(h, fills)
+	processed, err := processFills(h, fills, byMinute)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -94,7 +100,7 @@ func TestProcessFills_SellSide(t *testing.T) {
 		},
 	}
 
-	processed, err := processFills(h, fills)
+	processed, err := processFills(h, fills, byMinute)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -137,7 +143,7 @@ func TestProcessFills_AggregateSameMinute(t *testing.T) {
 		},
 	}
 
-	processed, err := processFills(h, fills)
+	processed, err := processFills(h, fills, byMinute)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -194,7 +200,7 @@ func TestProcessFills_DifferentPrices(t *testing.T) {
 		},
 	}
 
-	processed, err := processFills(h, fills)
+	processed, err := processFills(h, fills, byMinute)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -204,6 +210,34 @@ func TestProcessFills_DifferentPrices(t *testing.T) {
 	}
 }
 
+func TestProcessFills_ByOrder(t *testing.T) {
+	h := createTestHyperliquid()
+
+	// One order filled at two prices across a minute boundary
+	fills := []hyperliquid.UserFill{
+		{Coin: "BTC", Tid: 1, Oid: 42, Time: 1640000030000, Side: "B", Px: "50000.0", Sz: "1.0", Fee: "0.1", FeeToken: "USDC"},
+		{Coin: "BTC", Tid: 2, Oid: 42, Time: 1640000050000, Side: "B", Px: "50003.0", Sz: "2.0", Fee: "0.2", FeeToken: "USDC"},
+		{Coin: "BTC", Tid: 3, Oid: 43, Time: 1640000050000, Side: "B", Px: "50003.0", Sz: "1.0", Fee: "0.1", FeeToken: "USDC"},
+	}
+
+	processed, err := processFills(h, fills, trades.Grouping{Strategy: trades.ByOrder})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(processed) != 2 {
+		t.Fatalf("expected one trade per order, got %d", len(processed))
+	}
+
+	trade := processed[0]
+	if trade.TradeID != "1" || trade.AggregatedTrades != 2 || trade.OrderSize != 3 {
+		t.Errorf("expected order 42 merged into trade 1 of size 3, got %+v", trade)
+	}
+	if trade.Price != 50002 {
+		t.Errorf("expected the size weighted price 50002, got %f", trade.Price)
+	}
+}
+
 func TestProcessFills_DifferentSides(t *testing.T) {
 	h := createTestHyperliquid()
 
@@ -233,7 +267,7 @@ func TestProcessFills_DifferentSides(t *testing.T) {
 		},
 	}
 
-	processed, err := processFills(h, fills)
+	processed, err := processFills(h, fills, byMinute)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -267,7 +301,7 @@ func TestProcessFills_SpotMarket(t *testing.T) {
 		},
 	}
 
-	processed, err := processFills(h, fills)
+	processed, err := processFills(h, fills, byMinute)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -298,7 +332,7 @@ func TestProcessFills_SpecialTokens(t *testing.T) {
 		},
 	}
 
-	processed, err := processFills(h, fills)
+	processed, err := processFills(h, fills, byMinute)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -332,7 +366,7 @@ func TestProcessFills_NonStablecoinFee(t *testing.T) {
 		},
 	}
 
-	processed, err := processFills(h, fills)
+	processed, err := processFills(h, fills, byMinute)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -379,7 +413,7 @@ func TestProcessFills_SameTimeDifferentTradeIDs(t *testing.T) {
 		},
 	}
 
-	processed, err := processFills(h, fills)
+	processed, err := processFills(h, fills, byMinute)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -422,7 +456,7 @@ func TestProcessFills_MultipleCoins(t *testing.T) {
 		},
 	}
 
-	processed, err := processFills(h, fills)
+	processed, err := processFills(h, fills, byMinute)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -467,7 +501,7 @@ func TestBookTrade_NewEntry(t *testing.T) {
 		OrderSize: 1.0,
 	}
 
-	result := bookTrade(&book, trade)
+	result, _, _ := trades.BookTrade(book, trade, 0, nil)
 
 	key := "Hyperliquid-Futures-BTC"
 	entry, exists := book[key]
@@ -518,7 +552,7 @@ func TestBookTrade_ExistingEntry_FuturesLong(t *testing.T) {
 		OrderSize: 2.0,
 	}
 
-	result := bookTrade(&book, trade)
+	result, _, _ := trades.BookTrade(book, trade, 0, nil)
 
 	entry := book[key]
 	// After buying 2 more at 50000, average should be updated
@@ -566,7 +600,7 @@ func TestBookTrade_FuturesShort(t *testing.T) {
 		OrderSize: 3.0,
 	}
 
-	result := bookTrade(&book, trade)
+	result, _, _ := trades.BookTrade(book, trade, 0, nil)
 
 	entry := book[key]
 	expectedPos := -7.0                      // -10 + 3
@@ -584,7 +618,7 @@ func TestProcessFills_EdgeCase_EmptyFills(t *testing.T) {
 	h := createTestHyperliquid()
 
 	fills := []hyperliquid.UserFill{}
-	processed, err := processFills(h, fills)
+	processed, err := processFills(h, fills, byMinute)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -611,7 +645,7 @@ func TestProcessFills_EdgeCase_InvalidPrice(t *testing.T) {
 		},
 	}
 
-	processed, err := processFills(h, fills)
+	processed, err := processFills(h, fills, byMinute)
 
 	// Should return error for invalid price
 	if err == nil {
@@ -639,7 +673,7 @@ func TestProcessFills_EdgeCase_VerySmallSizes(t *testing.T) {
 		},
 	}
 
-	processed, err := processFills(h, fills)
+	processed, err := processFills(h, fills, byMinute)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -653,3 +687,162 @@ func TestProcessFills_EdgeCase_VerySmallSizes(t *testing.T) {
 		t.Errorf("expected positive order size, got %f", processed[0].OrderSize)
 	}
 }
+
+func TestProcessFunding(t *testing.T) {
+	h := createTestHyperliquid()
+
+	raw := make([]hyperliquid.UserFunding, 2)
+	raw[0].Time = 1700000000000
+	raw[0].Delta.Type = "funding"
+	raw[0].Delta.Coin = "ETH"
+	raw[0].Delta.USDC = "-1.25"
+	raw[0].Delta.Szi = "2.5"
+	raw[0].Delta.FundingRate = "0.0000125"
+	raw[1].Delta.Type = "deposit"
+
+	payments, err := processFunding(h, "0xabc", raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(payments) != 1 {
+		t.Fatalf("expected only the funding delta, got %+v", payments)
+	}
+
+	p := payments[0]
+	if p.Exchange != "Hyperliquid" || p.Wallet != "0xabc" || p.Ticker != "ETH" || p.Amount != -1.25 || p.PositionSize != 2.5 || p.FundingRate != 0.0000125 {
+		t.Errorf("unexpected payment %+v", p)
+	}
+
+	raw[0].Delta.USDC = "n/a"
+	if _, err := processFunding(h, "0xabc", raw); err == nil {
+		t.Error("expected an invalid amount to fail")
+	}
+}
+
+func TestProcessLedger(t *testing.T) {
+	h := createTestHyperliquid()
+	wallet := "0xabc"
+
+	raw := make([]hyperliquid.LedgerUpdate, 5)
+	raw[0].Hash, raw[0].Time, raw[0].Delta.Type, raw[0].Delta.USDC = "0x1", 1, "deposit", "1000.0"
+	raw[1].Hash, raw[1].Delta.Type, raw[1].Delta.USDC, raw[1].Delta.Fee = "0x2", "withdraw", "250.5", "1.0"
+	raw[2].Hash, raw[2].Delta.Type, raw[2].Delta.USDC = "0x3", "internalTransfer", "40"
+	raw[2].Delta.User, raw[2].Delta.Destination = "0xdef", "0xABC"
+	raw[3].Hash, raw[3].Delta.Type, raw[3].Delta.Token, raw[3].Delta.Amount, raw[3].Delta.USDCValue = "0x4", "spotTransfer", "UBTC", "0.1", "6000"
+	raw[3].Delta.User, raw[3].Delta.Destination = wallet, "0xdef"
+	raw[4].Hash, raw[4].Delta.Type, raw[4].Delta.USDC = "0x5", "accountClassTransfer", "10"
+
+	result, err := processLedger(h, wallet, raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result) != 4 {
+		t.Fatalf("expected the spot to perp move to be skipped, got %+v", result)
+	}
+
+	if d := result[0]; d.Type != "Deposit" || d.Direction != "In" || d.Amount != 1000 || d.USDValue != 1000 || d.Wallet != wallet {
+		t.Errorf("unexpected deposit %+v", d)
+	}
+	if w := result[1]; w.Type != "Withdrawal" || w.Direction != "Out" || w.Fee != 1 {
+		t.Errorf("unexpected withdrawal %+v", w)
+	}
+	if in := result[2]; in.Direction != "In" || in.Counterparty != "0xdef" {
+		t.Errorf("expected a transfer to the wallet to come in, got %+v", in)
+	}
+	if out := result[3]; out.Direction != "Out" || out.Ticker != "BTC" || out.Amount != 0.1 || out.USDValue != 6000 || out.Counterparty != "0xdef" {
+		t.Errorf("unexpected spot transfer %+v", out)
+	}
+}
+
+func TestWalkLedger_ResumesWithoutDuplicates(t *testing.T) {
+	// two updates a millisecond, every full page ends partway through a timestamp
+	page := func(_ context.Context, start int64) ([]hyperliquid.LedgerUpdate, error) {
+		var ledger []hyperliquid.LedgerUpdate
+		for i := 0; i < 2*ledgerPageSize && len(ledger) < ledgerPageSize; i++ {
+			u := hyperliquid.LedgerUpdate{Time: 1 + int64(i)/2, Hash: fmt.Sprintf("0x%d", i)}
+			if u.Time >= start {
+				ledger = append(ledger, u)
+			}
+		}
+		return ledger, nil
+	}
+
+	updates, err := walkLedger(context.Background(), page, 1, func(string) {})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(updates) != 2*ledgerPageSize {
+		t.Errorf("expected every update once, got %d", len(updates))
+	}
+}
+
+func TestWalkLedger_SingleTimestampPage(t *testing.T) {
+	// a full page of one timestamp cannot be resumed within it, the walk moves on rather than loop
+	calls := 0
+	page := func(_ context.Context, start int64) ([]hyperliquid.LedgerUpdate, error) {
+		calls++
+		if start > 5 || calls > 3 {
+			return nil, nil
+		}
+		ledger := make([]hyperliquid.LedgerUpdate, ledgerPageSize)
+		for i := range ledger {
+			ledger[i] = hyperliquid.LedgerUpdate{Time: 5, Hash: fmt.Sprintf("0x%d", i)}
+		}
+		return ledger, nil
+	}
+
+	updates, err := walkLedger(context.Background(), page, 5, func(string) {})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 2 || len(updates) != ledgerPageSize {
+		t.Errorf("expected the page once and a move past its timestamp, got %d calls and %d updates", calls, len(updates))
+	}
+}
+
+func TestBookWallet(t *testing.T) {
+	book := grist.Book{
+		"Kraken-Spot-BTC":             {Exchange: "Kraken", Market: "Spot", Ticker: "BTC"},
+		"Hyperliquid-0xa-Futures-ETH": {Exchange: "Hyperliquid", Wallet: "0xa", Market: "Futures", Ticker: "ETH"},
+	}
+	if got := bookWallet(book, "0xb"); got != "0xb" {
+		t.Errorf("expected a Book kept per wallet to book under the address, got %q", got)
+	}
+
+	book["Hyperliquid-Futures-SOL"] = grist.BookEntry{Exchange: "Hyperliquid", Market: "Futures", Ticker: "SOL"}
+	if got := bookWallet(book, "0xb"); got != "" {
+		t.Errorf("expected a merged Book to keep booking without a wallet, got %q", got)
+	}
+}
+
+func TestWalkFills_FullPageThenEmptyPageAdvancesTheCursor(t *testing.T) {
+	page := func(_ context.Context, seed int64) ([]hyperliquid.UserFill, error) {
+		if seed > 1000 {
+			return nil, nil
+		}
+
+		fills := make([]hyperliquid.UserFill, fillsPageSize)
+		for i := range fills {
+			fills[i] = hyperliquid.UserFill{Tid: int64(i), Time: 501 + int64(i)/4}
+		}
+		return fills, nil
+	}
+
+	processed := 0
+	cursor, total, err := walkFills(context.Background(), page, 500, func(fills []hyperliquid.UserFill) error {
+		processed += len(fills)
+		return nil
+	}, func(string) {})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if processed != fillsPageSize || total != fillsPageSize {
+		t.Errorf("expected the full page once, got %d processed and %d total", processed, total)
+	}
+	if cursor != 1001 {
+		t.Errorf("expected the cursor past the newest fill of the full page, got %d", cursor)
+	}
+}
@@ -0,0 +1,144 @@
+package exchange_hyperliquid
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+	"github.com/zyriu/portfolio/backend/helpers/hyperliquid"
+	"github.com/zyriu/portfolio/backend/helpers/jobstatus"
+	"github.com/zyriu/portfolio/backend/helpers/misc"
+)
+
+// ledgerPageSize is the maximum number of updates returned by a userNonFundingLedgerUpdates request
+const ledgerPageSize = 500
+
+// processLedger keeps the updates moving funds in or out of the wallet. Spot to perp moves
+// within the account and other updates such as liquidations are skipped.
+func processLedger(h hyperliquid.Hyperliquid, wallet string, updates []hyperliquid.LedgerUpdate) ([]grist.Transfer, error) {
+	result := make([]grist.Transfer, 0, len(updates))
+	for _, u := range updates {
+		d := u.Delta
+		t := grist.Transfer{
+			TransferID: u.Hash,
+			Exchange:   "Hyperliquid",
+			Wallet:     wallet,
+			Time:       u.Time,
+			Ticker:     "USDC",
+		}
+
+		amount := d.USDC
+		switch d.Type {
+		case "deposit":
+			t.Type, t.Direction, t.Counterparty = "Deposit", "In", "Bridge"
+		case "withdraw":
+			t.Type, t.Direction, t.Counterparty = "Withdrawal", "Out", "Bridge"
+		case "vaultDeposit":
+			t.Type, t.Direction, t.Counterparty = "Vault Deposit", "Out", d.Vault
+		case "vaultWithdraw":
+			t.Type, t.Direction, t.Counterparty = "Vault Withdrawal", "In", d.Vault
+		case "internalTransfer", "subAccountTransfer", "spotTransfer":
+			t.Type, t.Direction, t.Counterparty = "Transfer", "Out", d.Destination
+			if strings.EqualFold(d.Destination, wallet) {
+				t.Direction, t.Counterparty = "In", d.User
+			}
+			if d.Type == "spotTransfer" {
+				t.Ticker = h.NormalizeTicker(d.Token)
+				amount = d.Amount
+			}
+		default:
+			continue
+		}
+
+		value, err := strconv.ParseFloat(amount, 64)
+		if err != nil {
+			return result, fmt.Errorf("parse %s amount %q: %w", d.Type, amount, err)
+		}
+
+		t.Amount = value
+		t.USDValue = value
+		if d.Type == "spotTransfer" {
+			t.USDValue, _ = strconv.ParseFloat(d.USDCValue, 64)
+		}
+		t.Fee, _ = strconv.ParseFloat(d.Fee, 64)
+
+		result = append(result, t)
+	}
+
+	return result, nil
+}
+
+// ledgerPage returns the ledger updates of a wallet from start on, oldest first
+type ledgerPage func(ctx context.Context, start int64) ([]hyperliquid.LedgerUpdate, error)
+
+// walkLedger returns the ledger updates from start on, page by page. A full page may end
+// partway through a timestamp: the next page resumes at the last time rather than after it,
+// and the updates already seen are dropped by hash.
+func walkLedger(ctx context.Context, page ledgerPage, start int64, updateStatus func(string)) ([]hyperliquid.LedgerUpdate, error) {
+	seen := make(map[string]bool)
+
+	var updates []hyperliquid.LedgerUpdate
+	for {
+		updateStatus(fmt.Sprintf("Fetching ledger updates from timestamp %d...", start))
+		ledger, err := page(ctx, start)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, u := range ledger {
+			if seen[u.Hash] {
+				continue
+			}
+			seen[u.Hash] = true
+			updates = append(updates, u)
+		}
+
+		if len(ledger) < ledgerPageSize {
+			break
+		}
+
+		// A page holding a single timestamp cannot be resumed within it
+		next := ledger[len(ledger)-1].Time
+		if next <= start {
+			next = start + 1
+		}
+		start = next
+	}
+
+	return updates, nil
+}
+
+func updateTransfers(ctx context.Context, h hyperliquid.Hyperliquid, g grist.Grist, wallet misc.Wallet) error {
+	updateStatus := jobstatus.GetStatusUpdater(ctx)
+
+	updateStatus(fmt.Sprintf("[%s] Checking for latest transfers in Grist...", wallet.Label))
+	seed, err := g.GetLatestTransferTime(ctx, "Hyperliquid", wallet.Address)
+	if err != nil {
+		return err
+	}
+
+	page := func(ctx context.Context, start int64) ([]hyperliquid.LedgerUpdate, error) {
+		return h.GetLedgerUpdates(ctx, wallet.Address, start)
+	}
+	updates, err := walkLedger(ctx, page, seed, func(msg string) {
+		updateStatus(fmt.Sprintf("[%s] %s", wallet.Label, msg))
+	})
+	if err != nil {
+		return err
+	}
+
+	result, err := processLedger(h, wallet.Address, updates)
+	if err != nil {
+		return err
+	}
+
+	if len(result) == 0 {
+		updateStatus(fmt.Sprintf("[%s] No new transfers found", wallet.Label))
+		return nil
+	}
+
+	updateStatus(fmt.Sprintf("[%s] Upserting %d transfers to Grist...", wallet.Label, len(result)))
+	return grist.UpsertTable(ctx, &g, "Transfers", result, grist.UpsertOpts{})
+}
@@ -0,0 +1,505 @@
+package exchange_kraken
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/fx"
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+	"github.com/zyriu/portfolio/backend/helpers/jobstatus"
+	"github.com/zyriu/portfolio/backend/helpers/kraken"
+	"github.com/zyriu/portfolio/backend/helpers/misc"
+	"github.com/zyriu/portfolio/backend/helpers/settings"
+	"github.com/zyriu/portfolio/backend/helpers/token"
+	"github.com/zyriu/portfolio/backend/helpers/trades"
+)
+
+// Sync cursor streams of Kraken Futures, the time of the newest booked fill in ms and the id
+// of the next account log entry
+const (
+	futuresFillsStream = "futures_fills"
+	futuresLogStream   = "futures_log"
+)
+
+// futuresWallet is the Positions_Crypto_ wallet of the Kraken Futures account balances, their
+// chain being Kraken so that they count as held on the exchange
+const futuresWallet = grist.KrakenFuturesWallet
+
+// fillsPage returns the fills preceding before, newest first
+type fillsPage func(ctx context.Context, before time.Time) ([]kraken.FuturesFill, error)
+
+// fillTime returns the time of a fill or log entry in ms
+func fillTime(date string) (int64, error) {
+	t, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.UnixMilli(), nil
+}
+
+// fetchFuturesFills walks the fills back from the newest until since, in ms, and returns those
+// after since and before until with the time of the newest. Fills from until on are left to
+// the next sync.
+func fetchFuturesFills(ctx context.Context, page fillsPage, since int64, until int64) ([]kraken.FuturesFill, int64, error) {
+	var fills []kraken.FuturesFill
+	seen := make(map[string]bool)
+	newest := since
+
+	var before time.Time
+	for {
+		batch, err := page(ctx, before)
+		if err != nil {
+			return nil, since, err
+		}
+
+		added, oldest := 0, int64(math.MaxInt64)
+		for _, f := range batch {
+			t, err := fillTime(f.FillTime)
+			if err != nil {
+				return nil, since, fmt.Errorf("fill %s time: %w", f.FillID, err)
+			}
+			oldest = min(oldest, t)
+
+			if t <= since || t >= until || seen[f.FillID] {
+				continue
+			}
+			seen[f.FillID] = true
+			fills = append(fills, f)
+			newest = max(newest, t)
+			added++
+		}
+
+		// fills of the same ms as the oldest one may continue on the next page, which
+		// starts just after it so none is skipped
+		if len(batch) < 100 || oldest <= since || (added == 0 && oldest < until) {
+			break
+		}
+		before = time.UnixMilli(oldest + 1)
+	}
+
+	return fills, newest, nil
+}
+
+// processFuturesFills turns the fills of linear contracts into Futures trades denominated in
+// currency, oldest first, fees being the USD fee of each fill. Fills of inverse contracts,
+// sized in USD, are counted apart and left out.
+func processFuturesFills(f kraken.Futures, fills []kraken.FuturesFill, fees map[string]float64, rates *fx.Rates, currency string) ([]grist.Trade, int, error) {
+	processed := make([]grist.Trade, 0, len(fills))
+	skipped := 0
+	for _, fill := range fills {
+		base, linear := f.ParseSymbol(fill.Symbol)
+		if !linear {
+			skipped++
+			continue
+		}
+
+		t, err := fillTime(fill.FillTime)
+		if err != nil {
+			return nil, skipped, fmt.Errorf("fill %s time: %w", fill.FillID, err)
+		}
+
+		orderType := "Market"
+		if fill.FillType == "maker" {
+			orderType = "Limit"
+		}
+
+		fee := fees[fill.FillID]
+		trade := grist.Trade{
+			Ticker:           base,
+			TradeID:          fill.FillID,
+			Time:             t,
+			Exchange:         "Kraken",
+			Direction:        misc.Capitalize(fill.Side),
+			Fee:              fee,
+			FeeCurrency:      fx.Base,
+			FeeUSD:           fee,
+			OrderValue:       fill.Price * fill.Size,
+			OrderType:        orderType,
+			OrderSize:        fill.Size,
+			Market:           "Futures",
+			Price:            fill.Price,
+			AggregatedTrades: 1,
+		}
+
+		trade, err = fx.Denominate(trade, fx.Base, currency, rates)
+		if err != nil {
+			return nil, skipped, err
+		}
+		processed = append(processed, trade)
+	}
+
+	sort.Slice(processed, func(i, j int) bool {
+		if processed[i].Time == processed[j].Time {
+			return processed[i].TradeID < processed[j].TradeID
+		}
+
+		return processed[i].Time < processed[j].Time
+	})
+
+	return processed, skipped, nil
+}
+
+// logUntil keeps the account log entries older than cutoff, in ms, and returns the id the next
+// sync resumes from. The fills were fetched at cutoff, so the entries kept hold the fee of every
+// fill fetched and none of a fill left to the next sync.
+func logUntil(entries []kraken.FuturesLogEntry, from int64, cutoff int64) ([]kraken.FuturesLogEntry, int64, error) {
+	for i, e := range entries {
+		t, err := fillTime(e.Date)
+		if err != nil {
+			return nil, from, fmt.Errorf("log entry %d time: %w", e.ID, err)
+		}
+
+		if t >= cutoff {
+			return entries[:i], e.ID, nil
+		}
+	}
+
+	if len(entries) > 0 {
+		from = entries[len(entries)-1].ID + 1
+	}
+
+	return entries, from, nil
+}
+
+// processFuturesLog returns the fee of every fill and the funding payments of linear contracts
+// found in the account log. The log reports fees paid as negative amounts, so a maker rebate
+// comes out as a negative fee.
+func processFuturesLog(f kraken.Futures, entries []kraken.FuturesLogEntry) (map[string]float64, []grist.Funding, error) {
+	fees := make(map[string]float64)
+	var payments []grist.Funding
+	for _, e := range entries {
+		switch {
+		case e.Info == "futures trade" && e.Execution != "":
+			fees[e.Execution] -= e.Fee
+		case e.Info == "funding rate change" && e.RealizedFunding != 0:
+			base, linear := f.ParseSymbol(e.Contract)
+			if !linear {
+				continue
+			}
+
+			t, err := fillTime(e.Date)
+			if err != nil {
+				return nil, nil, fmt.Errorf("log entry %d time: %w", e.ID, err)
+			}
+
+			payments = append(payments, grist.Funding{
+				Time:        t,
+				Exchange:    "Kraken",
+				Ticker:      base,
+				Amount:      e.RealizedFunding,
+				FundingRate: e.FundingRate,
+			})
+		}
+	}
+
+	return fees, payments, nil
+}
+
+// comparePositions lists the contracts whose open position on Kraken Futures differs from the
+// Futures position booked for Kraken
+func comparePositions(f kraken.Futures, book grist.Book, positions []kraken.FuturesPosition) []string {
+	held := make(map[string]float64)
+	for _, p := range positions {
+		base, linear := f.ParseSymbol(p.Symbol)
+		if !linear {
+			continue
+		}
+
+		size := p.Size
+		if strings.EqualFold(p.Side, "short") {
+			size = -size
+		}
+		held[base] += size
+	}
+
+	booked := make(map[string]float64)
+	for _, entry := range book {
+		if entry.Exchange == "Kraken" && entry.Market == "Futures" {
+			booked[entry.Ticker] += entry.PositionSize
+		}
+	}
+
+	var mismatches []string
+	for ticker, size := range held {
+		if math.Abs(size-booked[ticker]) > 1e-9 {
+			mismatches = append(mismatches, fmt.Sprintf("%s booked %g, held %g", ticker, booked[ticker], size))
+		}
+	}
+	for ticker, size := range booked {
+		if _, ok := held[ticker]; !ok && size != 0 {
+			mismatches = append(mismatches, fmt.Sprintf("%s booked %g, held 0", ticker, size))
+		}
+	}
+	sort.Strings(mismatches)
+
+	return mismatches
+}
+
+// futuresBalances totals the currencies of every Kraken Futures account by ticker, leaving out
+// the contract positions margin accounts list next to their collateral
+func futuresBalances(f kraken.Futures, accounts map[string]kraken.FuturesAccount) map[string]float64 {
+	totals := make(map[string]float64)
+	for _, account := range accounts {
+		for asset, amount := range account.Balances {
+			if !strings.Contains(asset, "_") {
+				totals[f.NormalizeAsset(asset)] += amount
+			}
+		}
+		for asset, c := range account.Currencies {
+			totals[f.NormalizeAsset(asset)] += c.Quantity
+		}
+	}
+
+	for ticker, total := range totals {
+		if total == 0 {
+			delete(totals, ticker)
+		}
+	}
+
+	return totals
+}
+
+func updateFutures(ctx context.Context, f kraken.Futures, g grist.Grist) error {
+	updateStatus := jobstatus.GetStatusUpdater(ctx)
+
+	updateStatus("Checking futures sync cursors...")
+	since, _, err := g.GetSyncCursor(ctx, "Kraken", "", futuresFillsStream)
+	if err != nil {
+		return err
+	}
+
+	from, _, err := g.GetSyncCursor(ctx, "Kraken", "", futuresLogStream)
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().UnixMilli()
+	updateStatus(fmt.Sprintf("Fetching futures fills after timestamp %d...", since))
+	fills, newest, err := fetchFuturesFills(ctx, f.GetFills, since, cutoff)
+	if err != nil {
+		return err
+	}
+
+	var entries []kraken.FuturesLogEntry
+	for next := from; ; {
+		updateStatus(fmt.Sprintf("Fetching futures account log from entry %d...", next))
+		page, err := f.GetAccountLog(ctx, 0, next)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, page...)
+
+		if len(page) < kraken.FuturesLogPageSize {
+			break
+		}
+		next = page[len(page)-1].ID + 1
+	}
+
+	entries, from, err = logUntil(entries, from, cutoff)
+	if err != nil {
+		return err
+	}
+
+	fees, payments, err := processFuturesLog(f, entries)
+	if err != nil {
+		return err
+	}
+
+	settingsData, err := settings.GetCurrentSettings()
+	if err != nil {
+		return err
+	}
+
+	updateStatus("Loading FX rates...")
+	rates, err := fx.Load(ctx, &g)
+	if err != nil {
+		return err
+	}
+
+	processed, skipped, err := processFuturesFills(f, fills, fees, rates, settingsData.Settings.FX.ReportingCurrency)
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		updateStatus(fmt.Sprintf("⚠️ Skipped %d fills of inverse contracts, only linear contracts are booked", skipped))
+	}
+
+	updateStatus("Fetching trade book from Grist...")
+	book, err := g.FetchBook(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(processed) > 0 || len(payments) > 0 {
+		if err := bookFutures(ctx, g, book, processed, payments, rates, settingsData); err != nil {
+			return err
+		}
+	}
+
+	if newest > since {
+		if err := g.SetSyncCursor(ctx, "Kraken", "", futuresFillsStream, newest); err != nil {
+			return err
+		}
+	}
+	if len(entries) > 0 {
+		if err := g.SetSyncCursor(ctx, "Kraken", "", futuresLogStream, from); err != nil {
+			return err
+		}
+	}
+
+	updateStatus("Fetching futures open positions...")
+	positions, err := f.GetOpenPositions(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, mismatch := range comparePositions(f, book, positions) {
+		updateStatus(fmt.Sprintf("⚠️ Kraken Futures position differs from the Book: %s", mismatch))
+	}
+
+	updateStatus("Fetching futures account balances...")
+	accounts, err := f.GetAccounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	return saveFuturesBalances(ctx, g, futuresBalances(f, accounts))
+}
+
+// bookFutures books the new fills and funding payments and writes them with the Book
+func bookFutures(ctx context.Context, g grist.Grist, book grist.Book, processed []grist.Trade, payments []grist.Funding, rates *fx.Rates, settingsData settings.Settings) error {
+	updateStatus := jobstatus.GetStatusUpdater(ctx)
+
+	updateStatus("Loading tax lots...")
+	lots, err := trades.LoadLots(ctx, &g, settingsData.Settings.Tax.LotMethod)
+	if err != nil {
+		return err
+	}
+
+	var upsert []grist.Upsert
+	var matches []grist.LotMatch
+	for _, trade := range processed {
+		if err := trades.CheckCurrency(book, trade); err != nil {
+			return err
+		}
+
+		fee := 0.0
+		if settingsData.Settings.Tax.IncludeFees {
+			if fee, err = fx.Fee(trade, rates); err != nil {
+				return err
+			}
+		}
+
+		// futures sells never dispose of a spot holding, so no openings are consumed
+		trade, _, err = trades.BookTrade(book, trade, fee, nil)
+		if err != nil {
+			return err
+		}
+		upsert = append(upsert, g.CreateRecordFromTrade(trade))
+
+		m, _, err := lots.BookWithFee(trade, fee)
+		if err != nil {
+			return err
+		}
+		matches = append(matches, m...)
+	}
+
+	for _, payment := range payments {
+		amount, err := fx.FundingAmount(payment, settingsData.Settings.FX.ReportingCurrency, rates)
+		if err != nil {
+			return err
+		}
+		trades.ApplyFunding(book, payment, fx.Reporting(settingsData.Settings.FX.ReportingCurrency), amount)
+	}
+
+	if err := g.EnsureTradeColumns(ctx); err != nil {
+		return err
+	}
+
+	if len(upsert) > 0 {
+		updateStatus(fmt.Sprintf("Upserting %d futures trades to Grist...", len(upsert)))
+		if err := g.UpsertRecords(ctx, "Trades", upsert, grist.UpsertOpts{}); err != nil {
+			return err
+		}
+	}
+
+	if len(payments) > 0 {
+		updateStatus(fmt.Sprintf("Upserting %d futures funding payments to Grist...", len(payments)))
+		if err := grist.EnsureTable[grist.Funding](ctx, &g, "Funding"); err != nil {
+			return err
+		}
+		if err := grist.UpsertTable(ctx, &g, "Funding", payments, grist.UpsertOpts{}); err != nil {
+			return err
+		}
+	}
+
+	updateStatus("Updating trade book...")
+	if err := g.UpsertRecords(ctx, "Book", g.CreateRecordsFromBook(book), grist.UpsertOpts{}); err != nil {
+		return err
+	}
+
+	if len(upsert) > 0 {
+		updateStatus("Updating tax lots...")
+		if err := g.SaveLots(ctx, lots.Changed(), matches); err != nil {
+			return err
+		}
+	}
+
+	updateStatus(fmt.Sprintf("✓ Successfully synced %d futures trades and %d funding payments", len(upsert), len(payments)))
+	return nil
+}
+
+// futuresUpserts returns the Positions_Crypto_ rows of the Kraken Futures account totals
+func futuresUpserts(totals map[string]float64) []grist.Upsert {
+	var upserts []grist.Upsert
+	for ticker, total := range totals {
+		upserts = append(upserts, grist.Upsert{
+			Require: map[string]any{
+				"Ticker": ticker,
+				"Wallet": futuresWallet,
+			},
+			Fields: map[string]any{
+				"Chain":      "Kraken",
+				"Amount":     total,
+				"Asset_Type": token.GetAssetType(ticker),
+			},
+		})
+	}
+
+	return upserts
+}
+
+// saveFuturesBalances replaces the Kraken Futures rows of Positions_Crypto_ with totals
+func saveFuturesBalances(ctx context.Context, g grist.Grist, totals map[string]float64) error {
+	updateStatus := jobstatus.GetStatusUpdater(ctx)
+
+	records, err := g.GetRecords(ctx, "Positions_Crypto_", fmt.Sprintf("filter={\"Wallet\":[\"%s\"]}", futuresWallet))
+	if err != nil {
+		return err
+	}
+
+	var stale []int64
+	for _, record := range records.Records {
+		stale = append(stale, record.RecordID)
+	}
+
+	if len(stale) > 0 {
+		if err := g.DeleteRecords(ctx, "Positions_Crypto_", stale); err != nil {
+			return err
+		}
+	}
+
+	upserts := futuresUpserts(totals)
+	if len(upserts) == 0 {
+		updateStatus("No futures balances to sync")
+		return nil
+	}
+
+	updateStatus(fmt.Sprintf("Upserting %d futures balances to Grist...", len(upserts)))
+	return g.UpsertRecords(ctx, "Positions_Crypto_", upserts, grist.UpsertOpts{})
+}
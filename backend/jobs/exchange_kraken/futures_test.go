@@ -0,0 +1,201 @@
+package exchange_kraken
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+	"github.com/zyriu/portfolio/backend/helpers/kraken"
+	"github.com/zyriu/portfolio/backend/helpers/trades"
+)
+
+func isoMilli(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// fillPages serves n fills a second apart, 100 per page, newest first before a time
+func fillPages(n int) fillsPage {
+	return func(_ context.Context, before time.Time) ([]kraken.FuturesFill, error) {
+		var page []kraken.FuturesFill
+		for i := n; i >= 1 && len(page) < 100; i-- {
+			ms := int64(i) * 1000
+			if !before.IsZero() && ms >= before.UnixMilli() {
+				continue
+			}
+			page = append(page, kraken.FuturesFill{FillID: fmt.Sprintf("F%d", i), Symbol: "PF_XBTUSD", Side: "buy", Size: 1, Price: 100, FillTime: isoMilli(ms)})
+		}
+
+		return page, nil
+	}
+}
+
+func TestFetchFuturesFills_PagesBackToTheCursor(t *testing.T) {
+	fills, newest, err := fetchFuturesFills(context.Background(), fillPages(250), 20_000, 240_000)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// seconds 21 to 239, the fills from the cutoff on are left to the next sync
+	if len(fills) != 219 {
+		t.Errorf("expected 219 fills, got %d", len(fills))
+	}
+	if newest != 239_000 {
+		t.Errorf("expected the cursor to follow the newest fill kept, got %d", newest)
+	}
+}
+
+func TestProcessFuturesFills(t *testing.T) {
+	fills := []kraken.FuturesFill{
+		{FillID: "b", Symbol: "PF_ETHUSD", Side: "sell", Size: 2, Price: 3000, FillTime: isoMilli(2000), FillType: "maker"},
+		{FillID: "a", Symbol: "PF_XBTUSD", Side: "buy", Size: 0.5, Price: 60000, FillTime: isoMilli(1000), FillType: "taker"},
+		{FillID: "c", Symbol: "PI_XBTUSD", Side: "buy", Size: 1000, Price: 60000, FillTime: isoMilli(3000)},
+	}
+
+	processed, skipped, err := processFuturesFills(kraken.Futures{}, fills, map[string]float64{"a": 7.5}, nil, "USD")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if skipped != 1 || len(processed) != 2 {
+		t.Fatalf("expected the inverse fill to be skipped, got %d trades and %d skipped", len(processed), skipped)
+	}
+
+	btc := processed[0]
+	if btc.TradeID != "a" || btc.Ticker != "BTC" || btc.Market != "Futures" || btc.Exchange != "Kraken" || btc.Direction != "Buy" || btc.OrderValue != 30000 {
+		t.Errorf("unexpected trade %+v", btc)
+	}
+	if btc.Fee != 7.5 || btc.FeeUSD != 7.5 || btc.OrderType != "Market" || btc.Currency != "USD" {
+		t.Errorf("unexpected fee or currency %+v", btc)
+	}
+
+	if eth := processed[1]; eth.Ticker != "ETH" || eth.Direction != "Sell" || eth.OrderType != "Limit" || eth.Fee != 0 {
+		t.Errorf("unexpected trade %+v", eth)
+	}
+}
+
+func TestProcessFuturesLog(t *testing.T) {
+	entries := []kraken.FuturesLogEntry{
+		{ID: 1, Date: isoMilli(1000), Info: "futures trade", Execution: "a", Fee: -7.5},
+		{ID: 2, Date: isoMilli(2000), Info: "funding rate change", Contract: "pf_xbtusd", RealizedFunding: -1.25, FundingRate: 0.0001},
+		{ID: 3, Date: isoMilli(3000), Info: "funding rate change", Contract: "pi_xbtusd", RealizedFunding: -0.0001},
+		{ID: 4, Date: isoMilli(4000), Info: "cross-exchange transfer", Fee: 1},
+		{ID: 5, Date: isoMilli(5000), Info: "futures trade", Execution: "b", Fee: 0.5},
+	}
+
+	fees, payments, err := processFuturesLog(kraken.Futures{}, entries)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(fees) != 2 || fees["a"] != 7.5 || fees["b"] != -0.5 {
+		t.Errorf("expected the fee of fill a and the rebate of fill b, got %v", fees)
+	}
+
+	if len(payments) != 1 {
+		t.Fatalf("expected only the linear funding payment, got %+v", payments)
+	}
+	if p := payments[0]; p.Time != 2000 || p.Exchange != "Kraken" || p.Wallet != "" || p.Ticker != "BTC" || p.Amount != -1.25 {
+		t.Errorf("unexpected payment %+v", p)
+	}
+}
+
+func TestLogUntil(t *testing.T) {
+	entries := []kraken.FuturesLogEntry{
+		{ID: 7, Date: isoMilli(1000)},
+		{ID: 8, Date: isoMilli(2000)},
+		{ID: 9, Date: isoMilli(3000)},
+	}
+
+	kept, next, err := logUntil(entries, 7, 2500)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(kept) != 2 || next != 9 {
+		t.Errorf("expected to resume at the first entry after the cutoff, got %d entries and %d", len(kept), next)
+	}
+
+	kept, next, _ = logUntil(entries, 7, 5000)
+	if len(kept) != 3 || next != 10 {
+		t.Errorf("expected to resume after the last entry, got %d entries and %d", len(kept), next)
+	}
+
+	if _, next, _ = logUntil(nil, 7, 5000); next != 7 {
+		t.Errorf("expected an empty log to keep the cursor, got %d", next)
+	}
+}
+
+func TestBookFuturesFills(t *testing.T) {
+	book := make(grist.Book)
+	processed, _, err := processFuturesFills(kraken.Futures{}, []kraken.FuturesFill{
+		{FillID: "a", Symbol: "PF_ETHUSD", Side: "sell", Size: 2, Price: 3000, FillTime: isoMilli(1000)},
+		{FillID: "b", Symbol: "PF_ETHUSD", Side: "buy", Size: 1, Price: 2800, FillTime: isoMilli(2000)},
+	}, nil, nil, "USD")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var closing grist.Trade
+	for _, trade := range processed {
+		if closing, _, err = trades.BookTrade(book, trade, 0, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	entry := book["Kraken-Futures-ETH"]
+	if entry.PositionSize != -1 || entry.AveragePrice != 3000 || entry.RealizedPnL != 200 || closing.PnL != 200 {
+		t.Errorf("expected a short of 1 with 200 realized, got %+v", entry)
+	}
+	if _, ok := book["Kraken-Spot-ETH"]; ok {
+		t.Error("expected futures fills to stay out of the spot entry")
+	}
+}
+
+func TestComparePositions(t *testing.T) {
+	book := grist.Book{
+		"Kraken-Futures-ETH": {Exchange: "Kraken", Market: "Futures", Ticker: "ETH", PositionSize: -1},
+		"Kraken-Futures-SOL": {Exchange: "Kraken", Market: "Futures", Ticker: "SOL", PositionSize: 10},
+		"Kraken-Spot-BTC":    {Exchange: "Kraken", Market: "Spot", Ticker: "BTC", PositionSize: 1},
+	}
+	positions := []kraken.FuturesPosition{
+		{Side: "short", Symbol: "PF_ETHUSD", Size: 1},
+		{Side: "long", Symbol: "PF_XBTUSD", Size: 0.1},
+		{Side: "long", Symbol: "PI_XBTUSD", Size: 5000},
+	}
+
+	got := comparePositions(kraken.Futures{}, book, positions)
+	want := []string{"BTC booked 0, held 0.1", "SOL booked 10, held 0"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestFuturesBalances(t *testing.T) {
+	accounts := map[string]kraken.FuturesAccount{
+		"cash":      {Type: "cashAccount", Balances: map[string]float64{"xbt": 0.1, "usd": 0}},
+		"fi_xbtusd": {Type: "marginAccount", Balances: map[string]float64{"xbt": 0.05, "fi_xbtusd_240628": -1000}},
+		"flex": {Type: "multiCollateralMarginAccount", Currencies: map[string]struct {
+			Quantity float64 `json:"quantity"`
+		}{"USD": {Quantity: 2500}, "XBT": {Quantity: 0.01}}},
+	}
+
+	totals := futuresBalances(kraken.Futures{}, accounts)
+	if len(totals) != 2 || totals["USD"] != 2500 || totals["BTC"] < 0.16-1e-12 || totals["BTC"] > 0.16+1e-12 {
+		t.Errorf("unexpected totals %v", totals)
+	}
+}
+
+func TestFuturesUpserts_ClassifyAsKraken(t *testing.T) {
+	upserts := futuresUpserts(map[string]float64{"USD": 2500})
+	if len(upserts) != 1 {
+		t.Fatalf("expected one row, got %+v", upserts)
+	}
+
+	row := upserts[0]
+	wallet, _ := row.Require["Wallet"].(string)
+	chain, _ := row.Fields["Chain"].(string)
+	if exchange, ok := grist.PositionExchange(wallet, chain); !ok || exchange != "Kraken" {
+		t.Errorf("expected the futures balance to be held on Kraken, got %q for %+v", exchange, row)
+	}
+}
@@ -4,103 +4,50 @@ import (
 	"fmt"
 	"sort"
 	"strconv"
+	"time"
 
+	"github.com/zyriu/portfolio/backend/helpers/fx"
 	"github.com/zyriu/portfolio/backend/helpers/grist"
 	"github.com/zyriu/portfolio/backend/helpers/kraken"
 	"github.com/zyriu/portfolio/backend/helpers/misc"
-	"github.com/zyriu/portfolio/backend/helpers/token"
 	"github.com/zyriu/portfolio/backend/helpers/trades"
 )
 
-func bookTrade(book *grist.Book, trade grist.Trade) grist.Trade {
-	key := fmt.Sprintf("Kraken-%s-%s", trade.Market, trade.Ticker)
-
-	entry := grist.BookEntry{}
-	if e, ok := (*book)[key]; ok {
-		entry = e
-	} else {
-		entry.Exchange = trade.Exchange
-		entry.AssetType = "Token"
-		entry.Ticker = trade.Ticker
-		entry.Market = trade.Market
+// processTrades aggregates raw trades per grouping and denominates them in currency, converting
+// fees charged in a fiat quote to USD at the rate of the trade date
+func processTrades(tradesList []kraken.Trade, k kraken.Kraken, grouping trades.Grouping, rates *fx.Rates, currency string) ([]grist.Trade, error) {
+	aggregated, err := trades.AggregateTrades(tradesList, grouping)
+	if err != nil {
+		return nil, err
 	}
 
-	trade, entry = trades.UpdateBookEntry(trade, entry)
-	(*book)[key] = entry
-
-	return trade
-}
-
-func generateConfigForAggregation() trades.TradeConfig {
-	return trades.TradeConfig{
-		GetAsset: func(t any) string {
-			return t.(*kraken.Trade).Pair
-		},
-		GetTimeMin: func(t any) int64 {
-			return int64(t.(*kraken.Trade).Time) / 60
-		},
-		GetDirection: func(t any) string {
-			return t.(*kraken.Trade).Type
-		},
-		GetPrice: func(t any) string {
-			return t.(*kraken.Trade).Price
-		},
-		GetSize: func(t any) string {
-			return t.(*kraken.Trade).Vol
-		},
-		GetFee: func(t any) string {
-			return t.(*kraken.Trade).Fee
-		},
-		GetCost: func(t any) string {
-			return t.(*kraken.Trade).Cost
-		},
-		GetTime: func(t any) any {
-			return t.(*kraken.Trade).Time
-		},
-		GetTradeID: func(t any) any {
-			return t.(*kraken.Trade).TradeID
-		},
-		UpdateSize: func(t any, v string) {
-			t.(*kraken.Trade).Vol = v
-		},
-		UpdateFee: func(t any, v string) {
-			t.(*kraken.Trade).Fee = v
-		},
-		UpdateCost: func(t any, v string) {
-			t.(*kraken.Trade).Cost = v
-		},
-		UpdateTime: func(t any, v any) {
-			if time, ok := v.(float64); ok {
-				t.(*kraken.Trade).Time = time
-			}
-		},
-		UpdateTradeID: func(t any, v any) {
-			t.(*kraken.Trade).TradeID = v
-		},
-	}
-}
-
-func processTrades(tradesList []kraken.Trade, k kraken.Kraken) []grist.Trade {
-	tradesInterface := make([]any, len(tradesList))
-	for i := range tradesList {
-		tradesInterface[i] = &tradesList[i]
-	}
+	processedTrades := make([]grist.Trade, 0, len(aggregated))
+	for _, entry := range aggregated {
+		trade := entry.Fill
+		base, quote := k.GetBaseAndQuote(trade.Pair)
 
-	config := generateConfigForAggregation()
-	aggMap := trades.AggregateTrades(tradesInterface, config)
+		price, err := strconv.ParseFloat(entry.Price, 64)
+		if err != nil {
+			return nil, fmt.Errorf("trade %v price: %w", trade.TradeID, err)
+		}
 
-	processedTrades := make([]grist.Trade, 0, len(aggMap))
-	for _, entry := range aggMap {
-		trade := entry.Trade.(*kraken.Trade)
-		base, quote := k.GetBaseAndQuote(trade.Pair)
+		size, err := strconv.ParseFloat(entry.Size, 64)
+		if err != nil {
+			return nil, fmt.Errorf("trade %v size: %w", trade.TradeID, err)
+		}
 
-		price, _ := strconv.ParseFloat(trade.Price, 64)
-		size, _ := strconv.ParseFloat(trade.Vol, 64)
+		fee, err := strconv.ParseFloat(entry.Fee, 64)
+		if err != nil {
+			return nil, fmt.Errorf("trade %v fee: %w", trade.TradeID, err)
+		}
 
-		fee, _ := strconv.ParseFloat(trade.Fee, 64)
-		feeUSD := fee
-		if !token.IsStablecoin(quote) {
-			feeUSD *= price
+		feeUSD := fee * price
+		if fx.IsFiat(quote) {
+			rate, err := rates.ToBase(quote, time.UnixMilli(trade.FillTime()))
+			if err != nil {
+				return nil, fmt.Errorf("trade %v: %w", trade.TradeID, err)
+			}
+			feeUSD = fee * rate
 		}
 
 		orderType := "Market"
@@ -108,10 +55,10 @@ func processTrades(tradesList []kraken.Trade, k kraken.Kraken) []grist.Trade {
 			orderType = "Limit"
 		}
 
-		processedTrades = append(processedTrades, grist.Trade{
+		processed := grist.Trade{
 			Ticker:           base,
 			TradeID:          trade.TradeID.(string),
-			Time:             int64(trade.Time * 1000),
+			Time:             trade.FillTime(),
 			Exchange:         "Kraken",
 			Direction:        misc.Capitalize(trade.Type),
 			Fee:              fee,
@@ -123,7 +70,13 @@ func processTrades(tradesList []kraken.Trade, k kraken.Kraken) []grist.Trade {
 			Market:           "Spot",
 			Price:            price,
 			AggregatedTrades: entry.Count,
-		})
+		}
+
+		processed, err = fx.Denominate(processed, quote, currency, rates)
+		if err != nil {
+			return nil, err
+		}
+		processedTrades = append(processedTrades, processed)
 	}
 
 	sort.Slice(processedTrades, func(i, j int) bool {
@@ -134,5 +87,5 @@ func processTrades(tradesList []kraken.Trade, k kraken.Kraken) []grist.Trade {
 		return processedTrades[i].Time < processedTrades[j].Time
 	})
 
-	return processedTrades
+	return processedTrades, nil
 }
@@ -36,6 +36,25 @@ func Run(ctx context.Context, _ ...any) error {
 	}
 	updateStatus("Trades updated")
 
+	f, ok, err := kraken.InitiateFuturesClient()
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		updateStatus("Fetching futures...")
+		if err := updateFutures(ctx, f, g); err != nil {
+			return fmt.Errorf("update futures: %w", err)
+		}
+		updateStatus("Futures updated")
+	}
+
+	updateStatus("Fetching transfers...")
+	if err := updateTransfers(ctx, k, g); err != nil {
+		return fmt.Errorf("update transfers: %w", err)
+	}
+	updateStatus("Transfers updated")
+
 	updateStatus("Kraken sync completed")
 	return nil
 }
@@ -2,82 +2,240 @@ package exchange_kraken
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strings"
+	"time"
 
+	"github.com/zyriu/portfolio/backend/helpers/fx"
 	"github.com/zyriu/portfolio/backend/helpers/grist"
 	"github.com/zyriu/portfolio/backend/helpers/jobstatus"
 	"github.com/zyriu/portfolio/backend/helpers/kraken"
+	"github.com/zyriu/portfolio/backend/helpers/settings"
+	"github.com/zyriu/portfolio/backend/helpers/trades"
 )
 
+// tradesStream is the sync cursor stream of the Kraken trade history
+const tradesStream = "trades"
+
+const tradesPageSize = 50
+
+// tradesPage returns the trades page at offset of a fixed window, newest first
+type tradesPage func(ctx context.Context, offset int64) (kraken.TradesHistory, error)
+
+// fetchTrades returns the trades of a window newer than since, in ms, walking its pages from
+// the oldest. When the rate limit stops the walk, the trades fetched so far are returned with
+// complete unset, less those sharing the newest time with a trade not fetched yet, so that
+// booking them never skips an older trade.
+func fetchTrades(ctx context.Context, page tradesPage, since int64, updateStatus func(string)) ([]kraken.Trade, bool, error) {
+	newest, err := page(ctx, 0)
+	if err != nil {
+		return nil, false, err
+	}
+
+	count := newest.Result.Count
+	if count == 0 {
+		return nil, true, nil
+	}
+
+	var rawTrades []kraken.Trade
+	lookup := make(map[string]bool)
+	complete := true
+	for offset := (count - 1) / tradesPageSize * tradesPageSize; offset >= 0; offset -= tradesPageSize {
+		t := newest
+		if offset > 0 {
+			updateStatus(fmt.Sprintf("Fetching trades at offset %d of %d...", offset, count))
+			if t, err = page(ctx, offset); err != nil {
+				if errors.Is(err, kraken.ErrRateLimited) {
+					complete = false
+					break
+				}
+
+				return nil, false, err
+			}
+		}
+
+		for id, t := range t.Result.Trades {
+			if lookup[id] {
+				continue
+			}
+
+			lookup[id] = true
+			trade := t
+			trade.TradeID = id
+			rawTrades = append(rawTrades, trade)
+		}
+	}
+
+	latest := int64(0)
+	fetched := rawTrades[:0]
+	for _, t := range rawTrades {
+		if t.FillTime() > since {
+			fetched = append(fetched, t)
+			latest = max(latest, t.FillTime())
+		}
+	}
+
+	if !complete {
+		kept := fetched[:0]
+		for _, t := range fetched {
+			if t.FillTime() < latest {
+				kept = append(kept, t)
+			}
+		}
+		fetched = kept
+	}
+
+	return fetched, complete, nil
+}
+
+// migratedCursor returns the cursor of a history synced before cursors were stored. Stored
+// trades carry the time of their first fill, so the cursor moves past every fill the newest
+// one may have merged, which would otherwise be fetched again and booked twice.
+func migratedCursor(latest int64, grouping trades.Grouping) int64 {
+	if latest == 0 {
+		return 0
+	}
+
+	switch grouping.Strategy {
+	case trades.Disabled:
+		return latest
+	case trades.ByWindow:
+		return latest + grouping.Window*1000
+	}
+
+	// the last millisecond of the minute, orders spanning longer are not told apart
+	return (latest/60_000+1)*60_000 - 1
+}
+
 func updateTrades(ctx context.Context, k kraken.Kraken, g grist.Grist) error {
 	updateStatus := jobstatus.GetStatusUpdater(ctx)
 
-	updateStatus("Fetching aggregated trade count from Grist...")
-	count, err := g.GetAggregatedTradesCount(ctx, "Kraken")
+	settingsData, err := settings.GetCurrentSettings()
 	if err != nil {
 		return err
 	}
 
-	updateStatus("Fetching total trade count from Kraken...")
-	t, err := k.GetTradesHistory(ctx, 0)
+	aggregation := settingsData.Exchanges.Kraken.Aggregation
+	grouping, err := trades.ParseGrouping(aggregation.Strategy, aggregation.Window, aggregation.Tolerance)
 	if err != nil {
 		return err
 	}
 
-	if count == t.Result.Count {
+	updateStatus("Checking trade sync cursor...")
+	since, synced, err := g.GetSyncCursor(ctx, "Kraken", "", tradesStream)
+	if err != nil {
+		return err
+	}
+
+	if !synced {
+		// trades synced before cursors were stored resume after the newest one, kept as the
+		// cursor before futures trades are stored next to them
+		latest, err := g.GetLatestTradeTime(ctx, "Kraken", "")
+		if err != nil {
+			return err
+		}
+		since = migratedCursor(latest, grouping)
+		if err := g.SetSyncCursor(ctx, "Kraken", "", tradesStream, since); err != nil {
+			return err
+		}
+	}
+
+	// Kraken's start is exclusive and in seconds, trades of the same second are fetched again
+	// and skipped. The window ends now so that new trades don't shift the pages.
+	start, end := since/1000-1, time.Now().Unix()
+	page := func(ctx context.Context, offset int64) (kraken.TradesHistory, error) {
+		return k.GetTradesHistory(ctx, start, end, offset)
+	}
+
+	updateStatus(fmt.Sprintf("Fetching trades after timestamp %d from Kraken...", since))
+	rawTrades, complete, err := fetchTrades(ctx, page, since, updateStatus)
+	if err != nil {
+		return err
+	}
+
+	if !complete {
+		updateStatus(fmt.Sprintf("⚠️ Rate limit hit, syncing the %d oldest new trades and resuming on the next run...", len(rawTrades)))
+	}
+
+	if len(rawTrades) == 0 {
 		updateStatus("No new trades found")
 		return nil
 	}
 
+	// aggregated trades carry the time of their first fill, the cursor follows the last one
+	cursor := since
+	for _, t := range rawTrades {
+		cursor = max(cursor, t.FillTime())
+	}
+
 	updateStatus("Fetching trade book from Grist...")
 	book, err := g.FetchBook(ctx)
 	if err != nil {
 		return err
 	}
 
-	offset := t.Result.Count - count - 50
-	updateStatus(fmt.Sprintf("Starting at offset %d", offset))
+	updateStatus("Loading tax lots...")
+	lots, err := trades.LoadLots(ctx, &g, settingsData.Settings.Tax.LotMethod)
+	if err != nil {
+		return err
+	}
 
-	rawTrades := make([]kraken.Trade, 0)
-	lookup := make(map[string]bool)
-	for ; offset != 0; offset -= 50 {
-		if offset < 0 {
-			offset = 0
-		}
+	var upsert []grist.Upsert
+	var matches []grist.LotMatch
+	var unmatched []grist.UnmatchedDisposal
+	updateStatus("Loading FX rates...")
+	rates, err := fx.Load(ctx, &g)
+	if err != nil {
+		return err
+	}
 
-		t, err = k.GetTradesHistory(ctx, offset)
-		if err != nil {
-			if strings.Contains(err.Error(), "Rate") || strings.Contains(err.Error(), "EAPI:Rate limit exceeded") {
-				updateStatus(fmt.Sprintf("⚠️ Rate limit hit, processing %d trades from Kraken...", len(rawTrades)))
-				break
-			}
+	updateStatus("Loading opening balances...")
+	openings, err := trades.LoadOpenings(ctx, &g, rates.TradeRate)
+	if err != nil {
+		return err
+	}
 
+	processed, err := processTrades(rawTrades, k, grouping, rates, settingsData.Settings.FX.ReportingCurrency)
+	if err != nil {
+		return err
+	}
+
+	for _, trade := range processed {
+		if err := trades.CheckCurrency(book, trade); err != nil {
 			return err
 		}
 
-		for id, t := range t.Result.Trades {
-			if lookup[id] {
-				continue
+		fee := 0.0
+		if settingsData.Settings.Tax.IncludeFees {
+			if fee, err = fx.Fee(trade, rates); err != nil {
+				return err
 			}
-
-			lookup[id] = true
-			trade := t
-			trade.TradeID = id
-			rawTrades = append(rawTrades, trade)
 		}
-	}
 
-	trades := processTrades(rawTrades, k)
+		var disposal *grist.UnmatchedDisposal
+		trade, disposal, err = trades.BookTrade(book, trade, fee, openings)
+		if err != nil {
+			return err
+		}
+		if disposal != nil {
+			unmatched = append(unmatched, *disposal)
+		}
 
-	var upsert []grist.Upsert
-	for _, trade := range trades {
-		trade = bookTrade(&book, trade)
 		upsert = append(upsert, g.CreateRecordFromTrade(trade))
+
+		m, _, err := lots.BookWithFee(trade, fee)
+		if err != nil {
+			return err
+		}
+		matches = append(matches, m...)
 	}
 
 	if len(upsert) > 0 {
 		updateStatus(fmt.Sprintf("Upserting %d trades to Grist...", len(upsert)))
+		if err := g.EnsureTradeColumns(ctx); err != nil {
+			return err
+		}
+
 		if err := g.UpsertRecords(ctx, "Trades", upsert, grist.UpsertOpts{}); err != nil {
 			return err
 		}
@@ -87,6 +245,22 @@ func updateTrades(ctx context.Context, k kraken.Kraken, g grist.Grist) error {
 		if err := g.UpsertRecords(ctx, "Book", book, grist.UpsertOpts{}); err != nil {
 			return err
 		}
+
+		updateStatus("Updating tax lots...")
+		if err := g.SaveLots(ctx, lots.Changed(), matches); err != nil {
+			return err
+		}
+
+		if len(unmatched) > 0 {
+			updateStatus(fmt.Sprintf("⚠️ %d sells exceed the booked position, recording unmatched disposals...", len(unmatched)))
+			if err := g.SaveUnmatchedDisposals(ctx, unmatched); err != nil {
+				return err
+			}
+		}
+
+		if err := g.SetSyncCursor(ctx, "Kraken", "", tradesStream, cursor); err != nil {
+			return err
+		}
 		updateStatus(fmt.Sprintf("✓ Successfully synced %d trades", len(upsert)))
 	}
 
@@ -1,12 +1,35 @@
 package exchange_kraken
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
 	"testing"
+	"time"
 
+	"github.com/zyriu/portfolio/backend/helpers/fx"
 	"github.com/zyriu/portfolio/backend/helpers/grist"
 	"github.com/zyriu/portfolio/backend/helpers/kraken"
+	"github.com/zyriu/portfolio/backend/helpers/trades"
+	"github.com/zyriu/portfolio/backend/helpers/transfers"
 )
 
+// byMinute is the default aggregation of the settings
+var byMinute = trades.Grouping{Strategy: trades.ByMinute}
+
+// mustProcessTrades processes USD quoted trades, which need no FX rates
+func mustProcessTrades(t *testing.T, trades []kraken.Trade, k kraken.Kraken) []grist.Trade {
+	t.Helper()
+
+	processed, err := processTrades(trades, k, byMinute, nil, "USD")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return processed
+}
+
 // Helper to create a test Kraken instance
 func createTestKraken() kraken.Kraken {
 	return kraken.Kraken{
@@ -33,7 +56,7 @@ func TestProcessTrades_SingleTrade(t *testing.T) {
 		},
 	}
 
-	processed := processTrades(trades, k)
+	processed := mustProcessTrades(t, trades, k)
 
 	if len(processed) != 1 {
 		t.Fatalf("expected 1 processed trade, got %d", len(processed))
@@ -103,7 +126,7 @@ func TestProcessTrades_AggregateSameMinute(t *testing.T) {
 		},
 	}
 
-	processed := processTrades(trades, k)
+	processed := mustProcessTrades(t, trades, k)
 
 	if len(processed) != 1 {
 		t.Fatalf("expected 1 aggregated trade, got %d", len(processed))
@@ -159,7 +182,7 @@ func TestProcessTrades_DifferentPrices(t *testing.T) {
 		},
 	}
 
-	processed := processTrades(trades, k)
+	processed := mustProcessTrades(t, trades, k)
 
 	if len(processed) != 2 {
 		t.Fatalf("expected 2 separate trades (different prices), got %d", len(processed))
@@ -197,7 +220,7 @@ func TestProcessTrades_DifferentTypes(t *testing.T) {
 		},
 	}
 
-	processed := processTrades(trades, k)
+	processed := mustProcessTrades(t, trades, k)
 
 	if len(processed) != 2 {
 		t.Fatalf("expected 2 separate trades (buy vs sell), got %d", len(processed))
@@ -229,7 +252,7 @@ func TestProcessTrades_MakerOrderType(t *testing.T) {
 		},
 	}
 
-	processed := processTrades(trades, k)
+	processed := mustProcessTrades(t, trades, k)
 
 	if len(processed) != 1 {
 		t.Fatalf("expected 1 processed trade, got %d", len(processed))
@@ -283,7 +306,7 @@ func TestProcessTrades_Sorting(t *testing.T) {
 		},
 	}
 
-	processed := processTrades(trades, k)
+	processed := mustProcessTrades(t, trades, k)
 
 	if len(processed) != 3 {
 		t.Fatalf("expected 3 processed trades, got %d", len(processed))
@@ -337,7 +360,7 @@ func TestProcessTrades_SameTimeDifferentTradeIDs(t *testing.T) {
 		},
 	}
 
-	processed := processTrades(trades, k)
+	processed := mustProcessTrades(t, trades, k)
 
 	if len(processed) != 1 { // Should aggregate since same price, type, and minute
 		t.Fatalf("expected 1 aggregated trade, got %d", len(processed))
@@ -379,7 +402,7 @@ func TestProcessTrades_MultiplePairs(t *testing.T) {
 		},
 	}
 
-	processed := processTrades(trades, k)
+	processed := mustProcessTrades(t, trades, k)
 
 	if len(processed) != 2 {
 		t.Fatalf("expected 2 processed trades (different pairs), got %d", len(processed))
@@ -421,7 +444,7 @@ func TestBookTrade_NewEntry(t *testing.T) {
 		OrderSize: 1.0,
 	}
 
-	result := bookTrade(&book, trade)
+	result, _, _ := trades.BookTrade(book, trade, 0, nil)
 
 	key := "Kraken-Spot-BTC"
 	entry, exists := book[key]
@@ -472,7 +495,7 @@ func TestBookTrade_ExistingEntry(t *testing.T) {
 		OrderSize: 2.0,
 	}
 
-	result := bookTrade(&book, trade)
+	result, _, _ := trades.BookTrade(book, trade, 0, nil)
 
 	entry := book[key]
 	// After buying 2 more at 50000, average should be updated
@@ -520,7 +543,7 @@ func TestBookTrade_SpotSell(t *testing.T) {
 		OrderSize: 3.0,
 	}
 
-	result := bookTrade(&book, trade)
+	result, _, _ := trades.BookTrade(book, trade, 0, nil)
 
 	entry := book[key]
 	expectedPos := 7.0
@@ -548,7 +571,7 @@ func TestBookTrade_FuturesMarket(t *testing.T) {
 		OrderSize: 1.0,
 	}
 
-	result := bookTrade(&book, trade)
+	result, _, _ := trades.BookTrade(book, trade, 0, nil)
 
 	key := "Kraken-Futures-BTC"
 	entry, exists := book[key]
@@ -574,7 +597,7 @@ func TestProcessTrades_EdgeCase_EmptyTrades(t *testing.T) {
 	k := createTestKraken()
 
 	trades := []kraken.Trade{}
-	processed := processTrades(trades, k)
+	processed := mustProcessTrades(t, trades, k)
 
 	if len(processed) != 0 {
 		t.Errorf("expected 0 processed trades, got %d", len(processed))
@@ -599,15 +622,8 @@ func TestProcessTrades_EdgeCase_InvalidPrice(t *testing.T) {
 		},
 	}
 
-	processed := processTrades(trades, k)
-
-	if len(processed) != 1 {
-		t.Fatalf("expected 1 processed trade, got %d", len(processed))
-	}
-
-	// Price should be 0 for invalid parse
-	if processed[0].Price != 0.0 {
-		t.Errorf("expected price 0.0 for invalid parse, got %f", processed[0].Price)
+	if _, err := processTrades(trades, k, byMinute, nil, "USD"); err == nil {
+		t.Fatal("expected an error for an invalid price")
 	}
 }
 
@@ -629,7 +645,7 @@ func TestProcessTrades_EdgeCase_VerySmallVolumes(t *testing.T) {
 		},
 	}
 
-	processed := processTrades(trades, k)
+	processed := mustProcessTrades(t, trades, k)
 
 	if len(processed) != 1 {
 		t.Fatalf("expected 1 processed trade, got %d", len(processed))
@@ -640,3 +656,175 @@ func TestProcessTrades_EdgeCase_VerySmallVolumes(t *testing.T) {
 		t.Errorf("expected positive order size, got %f", processed[0].OrderSize)
 	}
 }
+
+func TestProcessTrades_ConvertsFiatQuotes(t *testing.T) {
+	k := createTestKraken()
+	rates := fx.NewRates([]grist.FXRate{{Date: 1639958400, Currency: "EUR", Rate: 1.2}})
+
+	trades := []kraken.Trade{
+		{
+			TradeID: "trade1",
+			Pair:    "XXBTZEUR",
+			Time:    1640000000.0,
+			Type:    "buy",
+			Price:   "40000.0",
+			Vol:     "1.0",
+			Cost:    "40000.0",
+			Fee:     "10.0",
+		},
+	}
+
+	processed, err := processTrades(trades, k, byMinute, rates, "USD")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	trade := processed[0]
+	if trade.Price != 48000 || trade.OrderValue != 48000 || trade.Currency != "USD" || trade.QuoteCurrency != "EUR" {
+		t.Errorf("expected the EUR trade converted to USD, got %+v", trade)
+	}
+	if trade.Fee != 10 || trade.FeeUSD != 12 {
+		t.Errorf("expected the EUR fee converted at the FX rate, got %f USD", trade.FeeUSD)
+	}
+
+	if _, err := processTrades(trades, k, byMinute, nil, "USD"); err == nil {
+		t.Error("expected a EUR trade without rates to fail")
+	}
+}
+
+func TestProcessLedger(t *testing.T) {
+	k := createTestKraken()
+	valuer := transfers.NewValuer(nil, grist.Prices{}, fx.NewRates([]grist.FXRate{{Date: 1704067200, Currency: "EUR", Rate: 1.1}}), time.Unix(1710000000, 0))
+
+	entries := []kraken.LedgerEntry{
+		{LedgerID: "L1", Time: 1705000000.5, Type: "deposit", Asset: "ZEUR", Amount: "1000.0000", Fee: "0.0000"},
+		{LedgerID: "L2", Time: 1706000000, Type: "withdrawal", Asset: "XXBT", Amount: "-0.5000000000", Fee: "0.0001"},
+		{LedgerID: "L3", Time: 1707000000, Type: "transfer", Subtype: "spottostaking", Asset: "DOT", Amount: "-10"},
+	}
+
+	result, unpriced, err := processLedger(k, entries, valuer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result) != 3 || unpriced != 2 {
+		t.Fatalf("expected 3 transfers with 2 unpriced crypto amounts, got %d and %d", len(result), unpriced)
+	}
+
+	deposit := result[0]
+	if deposit.TransferID != "L1" || deposit.Type != "Deposit" || deposit.Direction != "In" || deposit.Ticker != "EUR" || deposit.Time != 1705000000500 || math.Abs(deposit.USDValue-1100) > 1e-9 {
+		t.Errorf("unexpected deposit %+v", deposit)
+	}
+
+	if w := result[1]; w.Direction != "Out" || w.Ticker != "BTC" || w.Amount != 0.5 || w.Fee != 0.0001 {
+		t.Errorf("unexpected withdrawal %+v", w)
+	}
+
+	if tr := result[2]; tr.Type != "Transfer" || tr.Counterparty != "spottostaking" {
+		t.Errorf("unexpected transfer %+v", tr)
+	}
+
+	if _, _, err := processLedger(k, []kraken.LedgerEntry{{Type: "deposit", Amount: "x"}}, valuer); err == nil {
+		t.Error("expected an invalid amount to fail")
+	}
+}
+
+// windowPages serves n trades a second apart, newest first, failing at the offsets in limited
+func windowPages(n int, limited map[int64]bool) tradesPage {
+	return func(_ context.Context, offset int64) (kraken.TradesHistory, error) {
+		var page kraken.TradesHistory
+		if limited[offset] {
+			return page, kraken.ErrRateLimited
+		}
+
+		page.Result.Count = int64(n)
+		page.Result.Trades = make(map[string]kraken.Trade)
+		for i := offset; i < offset+tradesPageSize && i < int64(n); i++ {
+			second := int64(n) - i
+			page.Result.Trades[fmt.Sprintf("T%d", second)] = kraken.Trade{Time: float64(second)}
+		}
+
+		return page, nil
+	}
+}
+
+func TestFetchTrades_WalksTheWindow(t *testing.T) {
+	fetched, complete, err := fetchTrades(context.Background(), windowPages(120, nil), 10_000, func(string) {})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !complete || len(fetched) != 110 {
+		t.Errorf("expected the 110 trades after the cursor, got %d (complete %v)", len(fetched), complete)
+	}
+}
+
+func TestFetchTrades_RateLimitKeepsTheOldestTrades(t *testing.T) {
+	fetched, complete, err := fetchTrades(context.Background(), windowPages(120, map[int64]bool{50: true}), 0, func(string) {})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if complete {
+		t.Error("expected a partial fetch")
+	}
+
+	// the oldest page holds seconds 1 to 20, the trade of the newest second may have company
+	// on the page not fetched
+	if len(fetched) != 19 {
+		t.Fatalf("expected 19 trades, got %d", len(fetched))
+	}
+	for _, trade := range fetched {
+		if trade.FillTime() >= 20_000 {
+			t.Errorf("expected only trades older than the unfetched pages, got %v", trade.TradeID)
+		}
+	}
+}
+
+func TestFetchTrades_FailsWhenTheFirstPageFails(t *testing.T) {
+	if _, _, err := fetchTrades(context.Background(), windowPages(10, map[int64]bool{0: true}), 0, func(string) {}); !errors.Is(err, kraken.ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited, got %v", err)
+	}
+}
+
+func TestMigratedCursor_SkipsTheFillsOfTheLastAggregate(t *testing.T) {
+	k := createTestKraken()
+	fill := func(id string, second float64) kraken.Trade {
+		return kraken.Trade{TradeID: id, Pair: "XXBTZUSD", Time: second, Type: "buy", Price: "50000.0", Vol: "0.1", Cost: "5000.0", Fee: "0.01", OrderType: "market"}
+	}
+
+	// synced before cursors were stored, the three fills of the minute were merged into one
+	// trade at the time of the first
+	history := []kraken.Trade{fill("a", 1640000040), fill("b", 1640000045), fill("c", 1640000050)}
+	stored := mustProcessTrades(t, history, k)
+	if len(stored) != 1 {
+		t.Fatalf("expected one aggregated trade, got %d", len(stored))
+	}
+
+	history = append(history, fill("d", 1640000130))
+	page := func(_ context.Context, _ int64) (kraken.TradesHistory, error) {
+		var page kraken.TradesHistory
+		page.Result.Count = int64(len(history))
+		page.Result.Trades = make(map[string]kraken.Trade)
+		for _, trade := range history {
+			page.Result.Trades[fmt.Sprint(trade.TradeID)] = trade
+		}
+		return page, nil
+	}
+
+	fetched, _, err := fetchTrades(context.Background(), page, migratedCursor(stored[0].Time, byMinute), func(string) {})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(fetched) != 1 || fetched[0].TradeID != "d" {
+		t.Errorf("expected only the fill after the stored aggregate, got %+v", fetched)
+	}
+
+	window := trades.Grouping{Strategy: trades.ByWindow, Window: 30}
+	if got := migratedCursor(1_000, window); got != 31_000 {
+		t.Errorf("expected the cursor past the aggregation window, got %d", got)
+	}
+	if got := migratedCursor(0, byMinute); got != 0 {
+		t.Errorf("expected an empty history to sync from the start, got %d", got)
+	}
+}
@@ -0,0 +1,145 @@
+package exchange_kraken
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/fx"
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+	"github.com/zyriu/portfolio/backend/helpers/jobstatus"
+	"github.com/zyriu/portfolio/backend/helpers/kraken"
+	"github.com/zyriu/portfolio/backend/helpers/misc"
+	"github.com/zyriu/portfolio/backend/helpers/transfers"
+)
+
+// ledgerTypes are the Kraken ledger entries moving funds in or out of the account, or between
+// its spot, staking and futures wallets
+var ledgerTypes = []string{"deposit", "withdrawal", "transfer"}
+
+func processLedger(k kraken.Kraken, entries []kraken.LedgerEntry, valuer *transfers.Valuer) ([]grist.Transfer, int, error) {
+	result := make([]grist.Transfer, 0, len(entries))
+	unpriced := 0
+
+	for _, e := range entries {
+		amount, err := strconv.ParseFloat(e.Amount, 64)
+		if err != nil {
+			return result, unpriced, fmt.Errorf("parse ledger %s amount %q: %w", e.LedgerID, e.Amount, err)
+		}
+
+		fee, _ := strconv.ParseFloat(e.Fee, 64)
+
+		direction := "In"
+		if amount < 0 {
+			direction = "Out"
+		}
+
+		ticker := k.NormalizeTicker(e.Asset)
+		at := time.UnixMilli(int64(e.Time * 1000))
+
+		value, ok := valuer.USDValue(ticker, math.Abs(amount), at)
+		if !ok {
+			unpriced++
+		}
+
+		result = append(result, grist.Transfer{
+			TransferID:   e.LedgerID,
+			Exchange:     "Kraken",
+			Wallet:       "Kraken",
+			Time:         at.UnixMilli(),
+			Type:         misc.Capitalize(e.Type),
+			Direction:    direction,
+			Ticker:       ticker,
+			Amount:       math.Abs(amount),
+			Fee:          fee,
+			USDValue:     value,
+			Counterparty: e.Subtype,
+		})
+	}
+
+	return result, unpriced, nil
+}
+
+func updateTransfers(ctx context.Context, k kraken.Kraken, g grist.Grist) error {
+	updateStatus := jobstatus.GetStatusUpdater(ctx)
+
+	updateStatus("Checking for latest transfers in Grist...")
+	latest, err := g.GetLatestTransferTime(ctx, "Kraken", "Kraken")
+	if err != nil {
+		return err
+	}
+
+	// Kraken's start is exclusive and in seconds, entries of the same second are upserted again
+	start := latest/1000 - 1
+
+	var entries []kraken.LedgerEntry
+	for _, ledgerType := range ledgerTypes {
+		seen := make(map[string]bool)
+		for offset := int64(0); ; offset += 50 {
+			updateStatus(fmt.Sprintf("Fetching %s ledger at offset %d...", ledgerType, offset))
+			page, err := k.GetLedgers(ctx, ledgerType, start, offset)
+			if err != nil {
+				return err
+			}
+
+			for id, e := range page.Result.Ledger {
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+
+				e.LedgerID = id
+				entries = append(entries, e)
+			}
+
+			if len(page.Result.Ledger) == 0 || int64(len(seen)) >= page.Result.Count {
+				break
+			}
+		}
+	}
+
+	if len(entries) == 0 {
+		updateStatus("No new transfers found")
+		return nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Time < entries[j].Time })
+
+	var (
+		history []grist.Trade
+		prices  grist.Prices
+		rates   *fx.Rates
+	)
+
+	updateStatus("Loading trades, prices and FX rates to value transfers...")
+	if history, err = g.FetchTrades(ctx); err != nil {
+		return err
+	}
+	if prices, err = g.FetchPrices(ctx); err != nil {
+		return err
+	}
+	if rates, err = fx.Load(ctx, &g); err != nil {
+		return err
+	}
+
+	result, unpriced, err := processLedger(k, entries, transfers.NewValuer(history, prices, rates, time.Now()))
+	if err != nil {
+		return err
+	}
+
+	updateStatus(fmt.Sprintf("Upserting %d transfers to Grist...", len(result)))
+	if err := grist.UpsertTable(ctx, &g, "Transfers", result, grist.UpsertOpts{}); err != nil {
+		return err
+	}
+
+	if unpriced > 0 {
+		updateStatus(fmt.Sprintf("⚠️ Synced %d transfers, %d without a USD value", len(result), unpriced))
+		return nil
+	}
+
+	updateStatus(fmt.Sprintf("✓ Successfully synced %d transfers", len(result)))
+	return nil
+}
@@ -0,0 +1,95 @@
+package exposure
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/zyriu/portfolio/backend/helpers/fx"
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+const table = "Exposure"
+
+type yieldPosition struct {
+	Protocol     string  `json:"Protocol"`
+	Underlying   string  `json:"Underlying"`
+	CurrentValue float64 `json:"Current_Value"`
+	ClosedDate   float64 `json:"Closed_Date"`
+}
+
+// consolidate nets the holdings of every asset. Fiat and stablecoins are skipped, tickers are
+// mapped through aliases, and Pendle positions are counted in their underlying at its price.
+func consolidate(positions []grist.Position, book grist.Book, yield []yieldPosition, prices grist.Prices, aliases map[string]string, updated int64) []grist.Exposure {
+	rows := make(map[string]*grist.Exposure)
+	row := func(ticker string) *grist.Exposure {
+		t := strings.ToUpper(strings.TrimSpace(ticker))
+		if alias, ok := aliases[t]; ok {
+			t = alias
+		}
+
+		if t == "" || fx.IsFiat(t) {
+			return nil
+		}
+
+		if r, ok := rows[t]; ok {
+			return r
+		}
+		r := &grist.Exposure{Ticker: t, Updated: updated}
+		rows[t] = r
+		return r
+	}
+
+	for _, p := range positions {
+		r := row(p.Ticker)
+		if r == nil {
+			continue
+		}
+
+		if _, ok := grist.PositionExchange(p.Wallet, p.Chain); ok {
+			r.ExchangeSpot += p.Amount
+		} else {
+			r.OnChain += p.Amount
+		}
+	}
+
+	for _, entry := range book {
+		if entry.Market != "Futures" || entry.PositionSize == 0 {
+			continue
+		}
+
+		if r := row(entry.Ticker); r != nil {
+			r.Perps += entry.PositionSize
+		}
+	}
+
+	for _, y := range yield {
+		if y.Protocol != "Pendle" || y.ClosedDate != 0 || y.CurrentValue == 0 {
+			continue
+		}
+
+		r := row(y.Underlying)
+		if r == nil {
+			continue
+		}
+
+		if price := prices[r.Ticker]; price > 0 {
+			r.Pendle += y.CurrentValue / price
+		}
+	}
+
+	result := make([]grist.Exposure, 0, len(rows))
+	for _, r := range rows {
+		if r.OnChain == 0 && r.ExchangeSpot == 0 && r.Perps == 0 && r.Pendle == 0 {
+			continue
+		}
+
+		r.Net = r.OnChain + r.ExchangeSpot + r.Perps + r.Pendle
+		r.Price = prices[r.Ticker]
+		r.NetUSD = r.Net * r.Price
+		result = append(result, *r)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Ticker < result[j].Ticker })
+
+	return result
+}
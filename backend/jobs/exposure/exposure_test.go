@@ -0,0 +1,63 @@
+package exposure
+
+import (
+	"testing"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+func TestConsolidate(t *testing.T) {
+	positions := []grist.Position{
+		{Wallet: "Main", Chain: "Ethereum", Ticker: "ETH", Amount: 2},
+		{Wallet: "Main", Chain: "Arbitrum", Ticker: "WETH", Amount: 1},
+		{Wallet: "Kraken", Ticker: "ETH", Amount: 3},
+		{Wallet: "Kraken", Ticker: "ZUSD", Amount: 1000},
+		{Wallet: "Main", Chain: "Hyperliquid", Ticker: "HYPE", Amount: 10},
+		{Wallet: "Main", Chain: "Ethereum", Ticker: "USDC", Amount: 500},
+	}
+	book := grist.Book{
+		"Hyperliquid-Futures-ETH": {Exchange: "Hyperliquid", Market: "Futures", Ticker: "ETH", PositionSize: -4},
+		"Kraken-Spot-ETH":         {Exchange: "Kraken", Market: "Spot", Ticker: "ETH", PositionSize: 3},
+		"Hyperliquid-Futures-BTC": {Exchange: "Hyperliquid", Market: "Futures", Ticker: "BTC", PositionSize: 0.5},
+		"Hyperliquid-Futures-SOL": {Exchange: "Hyperliquid", Market: "Futures", Ticker: "SOL"},
+	}
+	yield := []yieldPosition{
+		{Protocol: "Pendle", Underlying: "ETH", CurrentValue: 3000},
+		{Protocol: "Pendle", Underlying: "ETH", CurrentValue: 9000, ClosedDate: 1},
+		{Protocol: "Pendle", Underlying: "USDe", CurrentValue: 1000},
+	}
+	prices := grist.Prices{"ETH": 3000, "BTC": 100000}
+
+	rows := consolidate(positions, book, yield, prices, map[string]string{"WETH": "ETH"}, 42)
+
+	byTicker := make(map[string]grist.Exposure)
+	for _, r := range rows {
+		byTicker[r.Ticker] = r
+	}
+
+	if len(rows) != 3 {
+		t.Fatalf("expected ETH, BTC and HYPE without stablecoins or closed perps, got %+v", rows)
+	}
+
+	eth := byTicker["ETH"]
+	if eth.OnChain != 3 || eth.ExchangeSpot != 3 || eth.Perps != -4 || eth.Pendle != 1 || eth.Net != 3 || eth.NetUSD != 9000 || eth.Updated != 42 {
+		t.Errorf("expected 3 on-chain, 3 on Kraken, 4 short and 1 in Pendle, got %+v", eth)
+	}
+	if btc := byTicker["BTC"]; btc.Perps != 0.5 || btc.Net != 0.5 || btc.NetUSD != 50000 {
+		t.Errorf("expected the BTC perp alone, got %+v", btc)
+	}
+	if hype := byTicker["HYPE"]; hype.ExchangeSpot != 10 || hype.OnChain != 0 || hype.Price != 0 {
+		t.Errorf("expected the Hyperliquid balance as exchange spot, got %+v", hype)
+	}
+}
+
+func TestConsolidate_KrakenFuturesOnExchange(t *testing.T) {
+	positions := []grist.Position{
+		{Wallet: "Kraken Futures", Chain: "Kraken", Ticker: "BTC", Amount: 0.25},
+	}
+
+	rows := consolidate(positions, grist.Book{}, nil, grist.Prices{}, nil, 42)
+	if len(rows) != 1 || rows[0].ExchangeSpot != 0.25 || rows[0].OnChain != 0 {
+		t.Errorf("expected the Futures collateral as exchange spot, got %+v", rows)
+	}
+}
@@ -0,0 +1,88 @@
+package exposure
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+	"github.com/zyriu/portfolio/backend/helpers/jobstatus"
+	"github.com/zyriu/portfolio/backend/helpers/misc"
+	"github.com/zyriu/portfolio/backend/helpers/settings"
+	"golang.org/x/sync/errgroup"
+)
+
+func Run(ctx context.Context, _ ...any) error {
+	updateStatus := jobstatus.GetStatusUpdater(ctx)
+
+	updateStatus("Loading settings...")
+	settingsData, err := settings.GetCurrentSettings()
+	if err != nil {
+		return err
+	}
+
+	updateStatus("Initializing Grist client...")
+	g, err := grist.InitiateClient()
+	if err != nil {
+		return err
+	}
+
+	if err := grist.EnsureTable[grist.Exposure](ctx, &g, table); err != nil {
+		return err
+	}
+
+	var (
+		book      grist.Book
+		prices    grist.Prices
+		positions []grist.Position
+		yield     []yieldPosition
+		existing  []grist.Row[grist.Exposure]
+	)
+
+	updateStatus("Fetching balances, book, yield positions and prices...")
+	errGroup, c := errgroup.WithContext(ctx)
+	misc.Go(errGroup, c, g.FetchBook, &book)
+	misc.Go(errGroup, c, g.FetchPrices, &prices)
+	misc.Go(errGroup, c, func(ctx context.Context) ([]grist.Position, error) {
+		return grist.FetchTable[grist.Position](ctx, &g, "Positions_Crypto_", "")
+	}, &positions)
+	misc.Go(errGroup, c, func(ctx context.Context) ([]yieldPosition, error) {
+		return grist.FetchTable[yieldPosition](ctx, &g, "Yield", "")
+	}, &yield)
+	misc.Go(errGroup, c, func(ctx context.Context) ([]grist.Row[grist.Exposure], error) {
+		return grist.FetchRows[grist.Exposure](ctx, &g, table, "")
+	}, &existing)
+
+	if err := errGroup.Wait(); err != nil {
+		return err
+	}
+
+	rows := consolidate(positions, book, yield, prices, settingsData.Settings.Exposure.Aliases, time.Now().UTC().Unix())
+
+	current := make(map[string]bool, len(rows))
+	for _, r := range rows {
+		current[r.Ticker] = true
+	}
+
+	updateStatus(fmt.Sprintf("Upserting %d exposure row(s)...", len(rows)))
+	if err := grist.UpsertTable(ctx, &g, table, rows, grist.UpsertOpts{}); err != nil {
+		return err
+	}
+
+	var stale []int64
+	for _, row := range existing {
+		if !current[row.Value.Ticker] {
+			stale = append(stale, row.ID)
+		}
+	}
+
+	if len(stale) > 0 {
+		updateStatus(fmt.Sprintf("Deleting %d asset(s) no longer held...", len(stale)))
+		if err := g.DeleteRecords(ctx, table, stale); err != nil {
+			return err
+		}
+	}
+
+	updateStatus(fmt.Sprintf("✓ Consolidated the exposure of %d assets", len(rows)))
+	return nil
+}
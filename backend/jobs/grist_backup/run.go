@@ -3,7 +3,11 @@ package grist_backup
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/zyriu/portfolio/backend/helpers/backup"
 	"github.com/zyriu/portfolio/backend/helpers/grist"
 	"github.com/zyriu/portfolio/backend/helpers/jobstatus"
 	"github.com/zyriu/portfolio/backend/helpers/settings"
@@ -23,16 +27,92 @@ func Run(ctx context.Context, _ ...any) error {
 		return fmt.Errorf("grist backup path not configured in settings")
 	}
 
+	dir, stem := backup.Location(settingsData.Grist.BackupPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %v", err)
+	}
+
 	updateStatus("Initializing Grist client...")
 	g, err := grist.InitiateClient()
 	if err != nil {
 		return err
 	}
 
-	updateStatus("Creating Grist document backup...")
-	if err := g.BackupDocument(ctx, settingsData.Grist.BackupPath); err != nil {
+	compress := settingsData.Grist.BackupCompress
+	encryption := settingsData.Grist.BackupEncryption
+
+	// Download next to the final backup so a failed or corrupted run never replaces a good one.
+	// When encrypting, the plaintext is staged in a private local directory instead and only
+	// the ciphertext reaches the backup directory.
+	staging := dir
+	if encryption.Enabled {
+		staging, err = os.MkdirTemp("", "portfolio-backup-")
+		if err != nil {
+			return fmt.Errorf("failed to create staging directory: %v", err)
+		}
+		defer os.RemoveAll(staging)
+	}
+
+	partial := filepath.Join(staging, "."+stem+".partial")
+	defer os.Remove(partial)
+
+	// Created private up front, the download truncating it in place and Compress copying its permissions
+	if encryption.Enabled {
+		if err := os.WriteFile(partial, nil, 0600); err != nil {
+			return err
+		}
+	}
+
+	updateStatus("Downloading Grist document...")
+	if err := g.BackupDocument(ctx, partial); err != nil {
+		return err
+	}
+
+	updateStatus("Verifying document integrity...")
+	if err := backup.Verify(partial); err != nil {
+		return fmt.Errorf("downloaded backup is invalid: %w", err)
+	}
+
+	name := backup.FileName(stem, time.Now(), compress, encryption.Enabled)
+	path := filepath.Join(dir, name)
+
+	staged := partial
+	if compress {
+		updateStatus("Compressing backup...")
+		compressed := partial + ".gz"
+		defer os.Remove(compressed)
+
+		if err := backup.Compress(staged, compressed); err != nil {
+			return fmt.Errorf("compress backup: %w", err)
+		}
+		staged = compressed
+	}
+
+	if encryption.Enabled {
+		updateStatus("Encrypting backup...")
+		secret := backup.Secret{Passphrase: encryption.Passphrase, KeyFile: encryption.KeyFile}
+		if err := backup.Encrypt(staged, path, secret); err != nil {
+			os.Remove(path)
+			return fmt.Errorf("encrypt backup: %w", err)
+		}
+	} else if err := os.Rename(staged, path); err != nil {
 		return err
 	}
+	updateStatus(fmt.Sprintf("✓ Backup written to %s", path))
+
+	retention := settingsData.Grist.BackupRetention
+	expired, err := backup.Prune(dir, stem, backup.Retention{
+		Daily:   retention.Daily,
+		Weekly:  retention.Weekly,
+		Monthly: retention.Monthly,
+	})
+	if err != nil {
+		return fmt.Errorf("prune backups: %w", err)
+	}
+
+	if len(expired) > 0 {
+		updateStatus(fmt.Sprintf("Removed %d expired backup(s)", len(expired)))
+	}
 
 	updateStatus("✓ Backup completed successfully")
 	return nil
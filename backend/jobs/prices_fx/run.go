@@ -0,0 +1,117 @@
+package prices_fx
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/fx"
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+	"github.com/zyriu/portfolio/backend/helpers/jobstatus"
+	"github.com/zyriu/portfolio/backend/helpers/settings"
+)
+
+// historyStart is where the backfill of a newly configured currency begins, before the
+// first trade of any supported exchange history
+var historyStart = time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// currencies returns the fiat currencies to fetch, other than the base
+func currencies(configured []string, reporting string) []string {
+	seen := make(map[string]bool)
+	var list []string
+	for _, c := range append(configured, reporting) {
+		c = fx.Normalize(c)
+		if c == "" || c == fx.Base || !fx.IsFiat(c) || seen[c] {
+			continue
+		}
+		seen[c] = true
+		list = append(list, c)
+	}
+
+	return list
+}
+
+func Run(ctx context.Context, _ ...any) error {
+	updateStatus := jobstatus.GetStatusUpdater(ctx)
+
+	settingsData, err := settings.GetCurrentSettings()
+	if err != nil {
+		return err
+	}
+
+	cfg := settingsData.Settings.FX
+	list := currencies(cfg.Currencies, cfg.ReportingCurrency)
+	if len(list) == 0 {
+		updateStatus("No currency other than USD configured")
+		return nil
+	}
+
+	updateStatus("Initializing Grist client...")
+	g, err := grist.InitiateClient()
+	if err != nil {
+		return err
+	}
+
+	updateStatus("Loading stored FX rates...")
+	rates, err := fx.Load(ctx, &g)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	var fetched []grist.FXRate
+	for _, c := range list {
+		start := historyStart
+		if latest, ok := rates.Latest(c); ok {
+			start = time.Unix(latest, 0).UTC().AddDate(0, 0, 1)
+		}
+
+		// One year per request keeps the daily resolution of the series
+		for from := start; !from.After(now); from = from.AddDate(1, 0, 0) {
+			to := from.AddDate(1, 0, -1)
+			if to.After(now) {
+				to = now
+			}
+
+			updateStatus(fmt.Sprintf("Fetching %s rates from %s to %s...", c, from.Format(time.DateOnly), to.Format(time.DateOnly)))
+			rows, err := fx.FetchRates(ctx, from, to, []string{c})
+			if err != nil {
+				return err
+			}
+			fetched = append(fetched, rows...)
+		}
+	}
+
+	if len(fetched) == 0 {
+		updateStatus("✓ FX rates are up to date")
+		return nil
+	}
+
+	updateStatus(fmt.Sprintf("Upserting %d FX rates to Grist...", len(fetched)))
+	if err := grist.UpsertTable(ctx, &g, "FX_Rates", fetched, grist.UpsertOpts{}); err != nil {
+		return err
+	}
+
+	// The latest rates also go to Prices so that fiat balances are valued like any asset
+	var prices []grist.Upsert
+	for _, row := range fetched {
+		rates.Add(row.Currency, row.Date, row.Rate)
+	}
+	for _, c := range list {
+		rate, err := rates.ToBase(c, now)
+		if err != nil {
+			continue
+		}
+		prices = append(prices, grist.Upsert{
+			Require: map[string]any{"Ticker": c},
+			Fields:  map[string]any{"Price": rate},
+		})
+	}
+
+	if err := g.UpsertRecords(ctx, "Prices", prices, grist.UpsertOpts{}); err != nil {
+		return err
+	}
+
+	updateStatus(fmt.Sprintf("✓ Successfully updated %d FX rates for %v", len(fetched), list))
+	return nil
+}
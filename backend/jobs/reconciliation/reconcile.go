@@ -0,0 +1,82 @@
+package reconciliation
+
+import (
+	"math"
+	"sort"
+
+	"github.com/zyriu/portfolio/backend/helpers/fx"
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+const table = "Reconciliation"
+
+type Tolerance struct {
+	Relative float64 // fraction of the larger of the two sizes
+	MinValue float64 // USD value below which a difference is dust
+}
+
+func key(exchange string, ticker string) string {
+	return exchange + "-" + ticker
+}
+
+// reconcile compares the spot Book of every exchange with the balances it reports. Fiat and
+// stablecoins are skipped as trades never book the quote currency.
+func reconcile(book grist.Book, positions []grist.Position, prices grist.Prices, tolerance Tolerance, checked int64) []grist.Reconciliation {
+	rows := make(map[string]*grist.Reconciliation)
+	row := func(exchange string, ticker string) *grist.Reconciliation {
+		k := key(exchange, ticker)
+		if r, ok := rows[k]; ok {
+			return r
+		}
+		r := &grist.Reconciliation{Exchange: exchange, Ticker: ticker, Checked: checked}
+		rows[k] = r
+		return r
+	}
+
+	exchanges := make(map[string]bool)
+	for _, entry := range book {
+		if entry.Market != "Spot" || fx.IsFiat(entry.Ticker) {
+			continue
+		}
+
+		exchanges[entry.Exchange] = true
+		row(entry.Exchange, entry.Ticker).BookSize += entry.PositionSize
+	}
+
+	for _, p := range positions {
+		// futures collateral is not held against the spot Book
+		if p.Wallet == grist.KrakenFuturesWallet {
+			continue
+		}
+
+		exchange, ok := grist.PositionExchange(p.Wallet, p.Chain)
+		if !ok || !exchanges[exchange] || fx.IsFiat(p.Ticker) {
+			continue
+		}
+
+		row(exchange, p.Ticker).Balance += p.Amount
+	}
+
+	result := make([]grist.Reconciliation, 0, len(rows))
+	for _, r := range rows {
+		r.Difference = r.Balance - r.BookSize
+
+		if larger := math.Max(math.Abs(r.Balance), math.Abs(r.BookSize)); larger != 0 {
+			r.DifferencePct = r.Difference / larger
+		}
+
+		price, priced := prices[r.Ticker]
+		r.DifferenceUSD = r.Difference * price
+
+		dust := priced && price > 0 && math.Abs(r.DifferenceUSD) < tolerance.MinValue
+		r.Flagged = math.Abs(r.DifferencePct) > tolerance.Relative && !dust
+
+		result = append(result, *r)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return key(result[i].Exchange, result[i].Ticker) < key(result[j].Exchange, result[j].Ticker)
+	})
+
+	return result
+}
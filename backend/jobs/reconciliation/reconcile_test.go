@@ -0,0 +1,72 @@
+package reconciliation
+
+import (
+	"testing"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+func TestReconcile(t *testing.T) {
+	book := grist.Book{
+		"Kraken-Spot-BTC":         {Exchange: "Kraken", Market: "Spot", Ticker: "BTC", PositionSize: 1},
+		"Kraken-Spot-ETH":         {Exchange: "Kraken", Market: "Spot", Ticker: "ETH", PositionSize: 2},
+		"Kraken-Spot-DOGE":        {Exchange: "Kraken", Market: "Spot", Ticker: "DOGE", PositionSize: 100},
+		"Hyperliquid-Spot-HYPE":   {Exchange: "Hyperliquid", Market: "Spot", Ticker: "HYPE", PositionSize: 10},
+		"Hyperliquid-Futures-ETH": {Exchange: "Hyperliquid", Market: "Futures", Ticker: "ETH", PositionSize: 5},
+	}
+	positions := []grist.Position{
+		{Wallet: "Kraken", Ticker: "BTC", Amount: 1.0000001},
+		{Wallet: "Kraken", Ticker: "ETH", Amount: 1.5},
+		{Wallet: "Kraken", Ticker: "DOGE", Amount: 100.5},
+		{Wallet: "Kraken", Ticker: "ZUSD", Amount: 1000},
+		{Wallet: "Kraken", Ticker: "SOL", Amount: 3},
+		{Wallet: "Main", Chain: "Hyperliquid", Ticker: "HYPE", Amount: 6},
+		{Wallet: "Cold", Chain: "Hyperliquid", Ticker: "HYPE", Amount: 4},
+		{Wallet: "Main", Chain: "Hyperliquid", Ticker: "USDC", Amount: 500},
+		{Wallet: "Main", Chain: "Ethereum", Ticker: "ETH", Amount: 7},
+	}
+	prices := grist.Prices{"BTC": 100000, "ETH": 3000, "DOGE": 0.1}
+
+	rows := reconcile(book, positions, prices, Tolerance{Relative: 0.001, MinValue: 1}, 42)
+
+	byKey := make(map[string]grist.Reconciliation)
+	for _, r := range rows {
+		byKey[key(r.Exchange, r.Ticker)] = r
+	}
+
+	if len(rows) != 5 {
+		t.Fatalf("expected spot positions of Kraken and Hyperliquid without quote currencies, got %+v", rows)
+	}
+
+	if r := byKey["Kraken-BTC"]; r.Flagged {
+		t.Errorf("expected a rounding difference to pass, got %+v", r)
+	}
+	if r := byKey["Kraken-ETH"]; !r.Flagged || r.Difference != -0.5 || r.DifferencePct != -0.25 || r.DifferenceUSD != -1500 || r.Checked != 42 {
+		t.Errorf("expected the missing ETH to be flagged, got %+v", r)
+	}
+	if r := byKey["Kraken-DOGE"]; r.Flagged {
+		t.Errorf("expected a difference worth less than the minimum to be dust, got %+v", r)
+	}
+	if r := byKey["Kraken-SOL"]; !r.Flagged || r.BookSize != 0 || r.Balance != 3 {
+		t.Errorf("expected a balance without trades to be flagged, got %+v", r)
+	}
+	if r := byKey["Hyperliquid-HYPE"]; r.Flagged || r.Balance != 10 {
+		t.Errorf("expected the balances of every wallet to add up, got %+v", r)
+	}
+}
+
+func TestReconcile_SkipsKrakenFuturesCollateral(t *testing.T) {
+	book := grist.Book{
+		"Kraken-Spot-BTC": {Exchange: "Kraken", Market: "Spot", Ticker: "BTC", PositionSize: 1},
+	}
+	positions := []grist.Position{
+		{Wallet: "Kraken", Ticker: "BTC", Amount: 1},
+		{Wallet: grist.KrakenFuturesWallet, Chain: "Kraken", Ticker: "BTC", Amount: 0.25},
+		{Wallet: grist.KrakenFuturesWallet, Chain: "Kraken", Ticker: "ETH", Amount: 2},
+	}
+
+	rows := reconcile(book, positions, grist.Prices{"BTC": 100000, "ETH": 3000}, Tolerance{Relative: 0.001, MinValue: 1}, 42)
+	if len(rows) != 1 || rows[0].Balance != 1 || rows[0].Flagged {
+		t.Errorf("expected the Futures collateral left out of the spot reconciliation, got %+v", rows)
+	}
+}
@@ -0,0 +1,97 @@
+package reconciliation
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+	"github.com/zyriu/portfolio/backend/helpers/jobstatus"
+	"github.com/zyriu/portfolio/backend/helpers/misc"
+	"github.com/zyriu/portfolio/backend/helpers/settings"
+	"golang.org/x/sync/errgroup"
+)
+
+func Run(ctx context.Context, _ ...any) error {
+	updateStatus := jobstatus.GetStatusUpdater(ctx)
+
+	updateStatus("Loading settings...")
+	settingsData, err := settings.GetCurrentSettings()
+	if err != nil {
+		return err
+	}
+
+	cfg := settingsData.Settings.Reconciliation
+	tolerance := Tolerance{Relative: cfg.Tolerance, MinValue: cfg.MinValue}
+
+	updateStatus("Initializing Grist client...")
+	g, err := grist.InitiateClient()
+	if err != nil {
+		return err
+	}
+
+	if err := grist.EnsureTable[grist.Reconciliation](ctx, &g, table); err != nil {
+		return err
+	}
+
+	var (
+		book      grist.Book
+		prices    grist.Prices
+		positions []grist.Position
+		existing  []grist.Row[grist.Reconciliation]
+	)
+
+	updateStatus("Fetching book, balances and prices...")
+	errGroup, c := errgroup.WithContext(ctx)
+	misc.Go(errGroup, c, g.FetchBook, &book)
+	misc.Go(errGroup, c, g.FetchPrices, &prices)
+	misc.Go(errGroup, c, func(ctx context.Context) ([]grist.Position, error) {
+		return grist.FetchTable[grist.Position](ctx, &g, "Positions_Crypto_", "")
+	}, &positions)
+	misc.Go(errGroup, c, func(ctx context.Context) ([]grist.Row[grist.Reconciliation], error) {
+		return grist.FetchRows[grist.Reconciliation](ctx, &g, table, "")
+	}, &existing)
+
+	if err := errGroup.Wait(); err != nil {
+		return err
+	}
+
+	rows := reconcile(book, positions, prices, tolerance, time.Now().UTC().Unix())
+
+	current := make(map[string]bool, len(rows))
+	flagged := 0
+	for _, r := range rows {
+		current[key(r.Exchange, r.Ticker)] = true
+		if r.Flagged {
+			flagged++
+			updateStatus(fmt.Sprintf("  %s %s: book %.8f, balance %.8f", r.Exchange, r.Ticker, r.BookSize, r.Balance))
+		}
+	}
+
+	updateStatus(fmt.Sprintf("Upserting %d reconciliation row(s)...", len(rows)))
+	if err := grist.UpsertTable(ctx, &g, table, rows, grist.UpsertOpts{}); err != nil {
+		return err
+	}
+
+	var stale []int64
+	for _, row := range existing {
+		if !current[key(row.Value.Exchange, row.Value.Ticker)] {
+			stale = append(stale, row.ID)
+		}
+	}
+
+	if len(stale) > 0 {
+		updateStatus(fmt.Sprintf("Deleting %d reconciled row(s) no longer held...", len(stale)))
+		if err := g.DeleteRecords(ctx, table, stale); err != nil {
+			return err
+		}
+	}
+
+	if flagged > 0 {
+		updateStatus(fmt.Sprintf("⚠️ %d of %d positions differ from the exchange balances", flagged, len(rows)))
+		return nil
+	}
+
+	updateStatus(fmt.Sprintf("✓ %d positions match the exchange balances", len(rows)))
+	return nil
+}
@@ -0,0 +1,136 @@
+package snapshots
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/fx"
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+	"github.com/zyriu/portfolio/backend/helpers/jobstatus"
+	"github.com/zyriu/portfolio/backend/helpers/misc"
+	"github.com/zyriu/portfolio/backend/helpers/settings"
+	"golang.org/x/sync/errgroup"
+)
+
+func Run(ctx context.Context, _ ...any) error {
+	updateStatus := jobstatus.GetStatusUpdater(ctx)
+
+	updateStatus("Loading settings...")
+	settingsData, err := settings.GetCurrentSettings()
+	if err != nil {
+		return err
+	}
+
+	cfg := settingsData.Settings.Snapshots
+	if cfg.Interval <= 0 {
+		return fmt.Errorf("snapshot interval must be positive")
+	}
+
+	updateStatus("Initializing Grist client...")
+	g, err := grist.InitiateClient()
+	if err != nil {
+		return err
+	}
+
+	if err := grist.EnsureTable[grist.Snapshot](ctx, &g, table); err != nil {
+		return err
+	}
+
+	var (
+		prices  grist.Prices
+		crypto  []cryptoPosition
+		tradFi  []tradFiPosition
+		yield   []yieldPosition
+		history []grist.Row[grist.Snapshot]
+	)
+
+	updateStatus("Fetching positions, prices and snapshot history...")
+	errGroup, c := errgroup.WithContext(ctx)
+	misc.Go(errGroup, c, g.FetchPrices, &prices)
+	misc.Go(errGroup, c, func(ctx context.Context) ([]cryptoPosition, error) {
+		return grist.FetchTable[cryptoPosition](ctx, &g, "Positions_Crypto_", "")
+	}, &crypto)
+	misc.Go(errGroup, c, func(ctx context.Context) ([]tradFiPosition, error) {
+		return grist.FetchTable[tradFiPosition](ctx, &g, "Positions_TradFi_", "")
+	}, &tradFi)
+	misc.Go(errGroup, c, func(ctx context.Context) ([]yieldPosition, error) {
+		return grist.FetchTable[yieldPosition](ctx, &g, "Yield", "")
+	}, &yield)
+	misc.Go(errGroup, c, func(ctx context.Context) ([]grist.Row[grist.Snapshot], error) {
+		return grist.FetchRows[grist.Snapshot](ctx, &g, table, "")
+	}, &history)
+
+	if err := errGroup.Wait(); err != nil {
+		return err
+	}
+
+	// Snapshots are bucketed by interval so that re-running within a bucket replaces it
+	now := time.Now().UTC()
+	date := now.Truncate(time.Duration(cfg.Interval) * time.Second).Unix()
+
+	snapshots := collect(date, prices, crypto, tradFi, yield)
+	if len(snapshots) == 0 {
+		updateStatus("No positions to snapshot")
+		return nil
+	}
+
+	currency := fx.Reporting(settingsData.Settings.FX.ReportingCurrency)
+	usdPerUnit := 1.0
+	if currency != fx.Base {
+		rates, err := fx.Load(ctx, &g)
+		if err != nil {
+			return err
+		}
+
+		usdPerUnit, err = rates.ToBase(currency, now)
+		if err != nil {
+			return err
+		}
+	}
+	denominate(snapshots, currency, usdPerUnit)
+
+	netWorth := 0.0
+	current := make(map[string]bool, len(snapshots))
+	for _, s := range snapshots {
+		netWorth += s.Value
+		current[key(s)] = true
+	}
+
+	updateStatus(fmt.Sprintf("Upserting %d position(s) for %s...", len(snapshots), time.Unix(date, 0).UTC().Format(time.RFC3339)))
+	if err := grist.UpsertTable(ctx, &g, table, snapshots, grist.UpsertOpts{}); err != nil {
+		return err
+	}
+	updateStatus(fmt.Sprintf("✓ Snapshot stored, net worth %.2f %s", netWorth, currency))
+
+	retention := Retention{All: cfg.Retention.All, Daily: cfg.Retention.Daily, Weekly: cfg.Retention.Weekly}
+
+	dates := []int64{date}
+	seenDates := map[int64]bool{date: true}
+	for _, row := range history {
+		if !seenDates[row.Value.Date] {
+			seenDates[row.Value.Date] = true
+			dates = append(dates, row.Value.Date)
+		}
+	}
+	drop := expired(dates, now, retention)
+
+	var stale []int64
+	for _, row := range history {
+		s := row.Value
+		closed := s.Date == date && !current[key(s)]
+		if drop[s.Date] || closed {
+			stale = append(stale, row.ID)
+		}
+	}
+
+	if len(stale) > 0 {
+		updateStatus(fmt.Sprintf("Removing %d downsampled or closed snapshot row(s)...", len(stale)))
+		if err := g.DeleteRecords(ctx, table, stale); err != nil {
+			return err
+		}
+	}
+
+	updateStatus("✓ Snapshots job completed successfully")
+	return nil
+}
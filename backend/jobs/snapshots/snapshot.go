@@ -0,0 +1,171 @@
+package snapshots
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+const table = "Snapshots"
+
+type cryptoPosition struct {
+	Wallet    string  `json:"Wallet"`
+	Chain     string  `json:"Chain"`
+	Ticker    string  `json:"Ticker"`
+	AssetType string  `json:"Asset_Type"`
+	Amount    float64 `json:"Amount"`
+	Price     float64 `json:"Price"`
+	USDValue  float64 `json:"USD_Value"`
+}
+
+type tradFiPosition struct {
+	Ticker    string  `json:"Ticker"`
+	AssetType string  `json:"Asset_Type"`
+	Amount    float64 `json:"Amount"`
+	Price     float64 `json:"Price"`
+	USDValue  float64 `json:"USD_Value"`
+}
+
+type yieldPosition struct {
+	Wallet       string  `json:"Wallet"`
+	Chain        string  `json:"Chain"`
+	Name         string  `json:"Name"`
+	Underlying   string  `json:"Underlying"`
+	AssetType    string  `json:"Asset_Type"`
+	Deposit      float64 `json:"Deposit"`
+	CurrentValue float64 `json:"Current_Value"`
+	Claimable    float64 `json:"Claimable_USD_"`
+	ClosedDate   float64 `json:"Closed_Date"`
+}
+
+type Retention struct {
+	All    int // days kept at full resolution
+	Daily  int // days kept at one snapshot per day
+	Weekly int // weeks kept at one snapshot per week
+}
+
+// key identifies a position within a snapshot
+func key(s grist.Snapshot) string {
+	return fmt.Sprintf("%s-%s-%s-%s", s.Source, s.Wallet, s.Chain, s.Ticker)
+}
+
+// value prices amount with Prices, falling back to the price and value stored on the row
+func value(prices grist.Prices, ticker string, amount float64, price float64, usdValue float64) (float64, float64) {
+	if p, ok := prices[ticker]; ok && p > 0 {
+		return p, amount * p
+	}
+
+	if price > 0 {
+		return price, amount * price
+	}
+
+	return price, usdValue
+}
+
+// collect values the current positions at date and merges rows sharing the same key
+func collect(date int64, prices grist.Prices, crypto []cryptoPosition, tradFi []tradFiPosition, yield []yieldPosition) []grist.Snapshot {
+	merged := make(map[string]*grist.Snapshot)
+	var keys []string
+
+	add := func(s grist.Snapshot) {
+		k := key(s)
+		if existing, ok := merged[k]; ok {
+			existing.Amount += s.Amount
+			existing.USDValue += s.USDValue
+			return
+		}
+		merged[k] = &s
+		keys = append(keys, k)
+	}
+
+	for _, p := range crypto {
+		price, usd := value(prices, p.Ticker, p.Amount, p.Price, p.USDValue)
+		add(grist.Snapshot{
+			Date: date, Source: "Positions_Crypto_", Wallet: p.Wallet, Chain: p.Chain, Ticker: p.Ticker,
+			AssetType: p.AssetType, Amount: p.Amount, Price: price, USDValue: usd,
+		})
+	}
+
+	for _, p := range tradFi {
+		price, usd := value(prices, p.Ticker, p.Amount, p.Price, p.USDValue)
+		add(grist.Snapshot{
+			Date: date, Source: "Positions_TradFi_", Ticker: p.Ticker,
+			AssetType: p.AssetType, Amount: p.Amount, Price: price, USDValue: usd,
+		})
+	}
+
+	for _, p := range yield {
+		if p.ClosedDate != 0 {
+			continue
+		}
+
+		ticker := p.Name
+		if ticker == "" {
+			ticker = p.Underlying
+		}
+
+		add(grist.Snapshot{
+			Date: date, Source: "Yield", Wallet: p.Wallet, Chain: p.Chain, Ticker: ticker,
+			AssetType: p.AssetType, Amount: p.Deposit, USDValue: p.CurrentValue + p.Claimable,
+		})
+	}
+
+	snapshots := make([]grist.Snapshot, 0, len(keys))
+	for _, k := range keys {
+		s := merged[k]
+		if s.Amount != 0 && s.Price == 0 && s.Source != "Yield" {
+			s.Price = s.USDValue / s.Amount
+		}
+		snapshots = append(snapshots, *s)
+	}
+
+	return snapshots
+}
+
+// denominate adds the value of each snapshot in the reporting currency, given the USD
+// value of one unit of it
+func denominate(snapshots []grist.Snapshot, currency string, usdPerUnit float64) {
+	for i := range snapshots {
+		snapshots[i].Currency = currency
+		snapshots[i].Value = snapshots[i].USDValue / usdPerUnit
+	}
+}
+
+// expired returns the snapshot dates to drop when downsampling. Snapshots younger than
+// All days are all kept, then the newest of each day for Daily days, of each ISO week for
+// Weekly weeks, and of each month forever, which keeps month-end statements available.
+func expired(dates []int64, now time.Time, policy Retention) map[int64]bool {
+	sorted := append([]int64(nil), dates...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+
+	kept := make(map[string]bool)
+	drop := make(map[int64]bool)
+
+	for _, date := range sorted {
+		t := time.Unix(date, 0).UTC()
+		age := now.Sub(t)
+
+		var bucket string
+		switch {
+		case age < time.Duration(policy.All)*24*time.Hour:
+			continue
+		case age < time.Duration(policy.Daily)*24*time.Hour:
+			bucket = t.Format("2006-01-02")
+		case age < time.Duration(policy.Weekly)*7*24*time.Hour:
+			year, week := t.ISOWeek()
+			bucket = fmt.Sprintf("%d-W%02d", year, week)
+		default:
+			bucket = t.Format("2006-01")
+		}
+
+		if kept[bucket] {
+			drop[date] = true
+			continue
+		}
+		kept[bucket] = true
+	}
+
+	return drop
+}
@@ -0,0 +1,97 @@
+package snapshots
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zyriu/portfolio/backend/helpers/grist"
+)
+
+func TestCollect_ValuesWithPricesAndMergesDuplicates(t *testing.T) {
+	prices := grist.Prices{"ETH": 2000}
+	crypto := []cryptoPosition{
+		{Wallet: "Main", Chain: "Ethereum", Ticker: "ETH", Amount: 1, Price: 1500, USDValue: 1500},
+		{Wallet: "Main", Chain: "Ethereum", Ticker: "ETH", Amount: 0.5},
+		{Wallet: "Main", Chain: "Ethereum", Ticker: "FOO", Amount: 10, USDValue: 30},
+	}
+	tradFi := []tradFiPosition{{Ticker: "AAPL", Amount: 2, Price: 200}}
+	yield := []yieldPosition{
+		{Wallet: "Main", Chain: "Arbitrum", Name: "PT-sUSDe", Deposit: 1000, CurrentValue: 1010, Claimable: 5},
+		{Wallet: "Main", Chain: "Arbitrum", Name: "PT-old", CurrentValue: 99, ClosedDate: 1700000000},
+	}
+
+	snapshots := collect(100, prices, crypto, tradFi, yield)
+	if len(snapshots) != 4 {
+		t.Fatalf("expected 4 snapshot rows, got %d: %+v", len(snapshots), snapshots)
+	}
+
+	byTicker := make(map[string]grist.Snapshot)
+	for _, s := range snapshots {
+		if s.Date != 100 {
+			t.Errorf("expected every row dated 100, got %d", s.Date)
+		}
+		byTicker[s.Ticker] = s
+	}
+
+	if eth := byTicker["ETH"]; eth.Amount != 1.5 || eth.USDValue != 3000 || eth.Price != 2000 {
+		t.Errorf("expected merged ETH valued with Prices, got %+v", eth)
+	}
+	if foo := byTicker["FOO"]; foo.USDValue != 30 || foo.Price != 3 {
+		t.Errorf("expected FOO to fall back to the row value, got %+v", foo)
+	}
+	if aapl := byTicker["AAPL"]; aapl.USDValue != 400 {
+		t.Errorf("expected AAPL valued with the row price, got %+v", aapl)
+	}
+	if pt := byTicker["PT-sUSDe"]; pt.USDValue != 1015 || pt.Source != "Yield" {
+		t.Errorf("expected open yield position with claimable rewards, got %+v", pt)
+	}
+	if _, ok := byTicker["PT-old"]; ok {
+		t.Error("expected closed yield position to be skipped")
+	}
+}
+
+func TestExpired_Downsamples(t *testing.T) {
+	now := time.Date(2026, 6, 30, 12, 0, 0, 0, time.UTC)
+	at := func(days int, hour int) int64 {
+		return time.Date(2026, 6, 30, hour, 0, 0, 0, time.UTC).AddDate(0, 0, -days).Unix()
+	}
+
+	dates := []int64{
+		at(1, 6), at(1, 0), // recent, all kept
+		at(20, 6), at(20, 0), // daily tier, only the newest of the day kept
+		at(150, 0), at(152, 0), // weekly tier, same ISO week
+		at(400, 0), at(410, 0), // monthly tier, same month
+	}
+
+	drop := expired(dates, now, Retention{All: 7, Daily: 90, Weekly: 52})
+
+	want := map[int64]bool{at(20, 0): true, at(152, 0): true, at(410, 0): true}
+	for _, d := range dates {
+		if drop[d] != want[d] {
+			t.Errorf("%s: expected dropped=%v, got %v", time.Unix(d, 0).UTC(), want[d], drop[d])
+		}
+	}
+}
+
+func TestExpired_ZeroPolicyKeepsMonthly(t *testing.T) {
+	now := time.Date(2026, 6, 30, 0, 0, 0, 0, time.UTC)
+	dates := []int64{
+		time.Date(2026, 6, 29, 0, 0, 0, 0, time.UTC).Unix(),
+		time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC).Unix(),
+		time.Date(2026, 5, 31, 0, 0, 0, 0, time.UTC).Unix(),
+	}
+
+	drop := expired(dates, now, Retention{})
+	if len(drop) != 1 || !drop[dates[1]] {
+		t.Errorf("expected only the older June snapshot to be dropped, got %v", drop)
+	}
+}
+
+func TestDenominate_ConvertsToReportingCurrency(t *testing.T) {
+	snapshots := []grist.Snapshot{{Ticker: "ETH", USDValue: 3000}}
+
+	denominate(snapshots, "EUR", 1.2)
+	if snapshots[0].Currency != "EUR" || snapshots[0].Value != 2500 || snapshots[0].USDValue != 3000 {
+		t.Errorf("expected the value in EUR next to the USD one, got %+v", snapshots[0])
+	}
+}
@@ -5,22 +5,34 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"github.com/zyriu/portfolio/backend/helpers/backup"
 	"github.com/zyriu/portfolio/backend/helpers/grist"
 	"github.com/zyriu/portfolio/backend/helpers/settings"
+	"github.com/zyriu/portfolio/backend/helpers/tax"
+	"github.com/zyriu/portfolio/backend/helpers/webhook"
 	"github.com/zyriu/portfolio/backend/jobs/balances_evm_chains"
 	"github.com/zyriu/portfolio/backend/jobs/balances_other_chains"
+	"github.com/zyriu/portfolio/backend/jobs/book_valuation"
 	"github.com/zyriu/portfolio/backend/jobs/exchange_hyperliquid"
 	"github.com/zyriu/portfolio/backend/jobs/exchange_kraken"
 	"github.com/zyriu/portfolio/backend/jobs/exchange_lighter"
+	"github.com/zyriu/portfolio/backend/jobs/exposure"
 	"github.com/zyriu/portfolio/backend/jobs/grist_backup"
 	"github.com/zyriu/portfolio/backend/jobs/pendle_markets"
 	"github.com/zyriu/portfolio/backend/jobs/pendle_user_positions"
 	"github.com/zyriu/portfolio/backend/jobs/prices_cryptocurrencies"
+	"github.com/zyriu/portfolio/backend/jobs/prices_fx"
 	"github.com/zyriu/portfolio/backend/jobs/prices_stocks"
+	"github.com/zyriu/portfolio/backend/jobs/reconciliation"
+	"github.com/zyriu/portfolio/backend/jobs/snapshots"
 )
 
 type JobLog struct {
@@ -47,6 +59,9 @@ type Manager struct {
 	executionsMu  sync.RWMutex
 	executions    []JobExecution
 	maxExecutions int // Maximum number of executions to keep in history
+
+	webhookServer   *http.Server
+	webhookReceiver *webhook.Receiver
 }
 
 func NewManager() *Manager {
@@ -290,8 +305,8 @@ func (m *Manager) LoadSettings() (string, error) {
 }
 
 func (m *Manager) SaveSettings(settingsJSON string) error {
-	var settingsData settings.Settings
-	if err := json.Unmarshal([]byte(settingsJSON), &settingsData); err != nil {
+	settingsData, err := settings.ParseSettings([]byte(settingsJSON))
+	if err != nil {
 		return fmt.Errorf("failed to parse settings JSON: %v", err)
 	}
 
@@ -367,6 +382,13 @@ func (m *Manager) checkJobSettingsAndCreate(name string, lastRun time.Time, crea
 			jobFunc = balances_other_chains.Run
 			args = []any{"solana"}
 		}
+	case "book_valuation":
+		isEnabled = settingsData.Settings.Valuation.Enabled
+		if isEnabled && createIfEnabled {
+			interval = time.Duration(settingsData.Settings.Valuation.Interval) * time.Second
+			jobFunc = book_valuation.Run
+			args = []any{}
+		}
 	case "exchange_hyperliquid":
 		isEnabled = settingsData.Exchanges.Hyperliquid.Enabled
 		if isEnabled && createIfEnabled {
@@ -388,6 +410,13 @@ func (m *Manager) checkJobSettingsAndCreate(name string, lastRun time.Time, crea
 			jobFunc = exchange_lighter.Run
 			args = []any{}
 		}
+	case "exposure":
+		isEnabled = settingsData.Settings.Exposure.Enabled
+		if isEnabled && createIfEnabled {
+			interval = time.Duration(settingsData.Settings.Exposure.Interval) * time.Second
+			jobFunc = exposure.Run
+			args = []any{}
+		}
 	case "grist_backup":
 		isEnabled = settingsData.Grist.Enabled
 		if isEnabled && createIfEnabled {
@@ -416,6 +445,13 @@ func (m *Manager) checkJobSettingsAndCreate(name string, lastRun time.Time, crea
 			jobFunc = prices_cryptocurrencies.Run
 			args = []any{}
 		}
+	case "prices_fx":
+		isEnabled = settingsData.Settings.FX.Enabled
+		if isEnabled && createIfEnabled {
+			interval = time.Duration(settingsData.Settings.FX.Interval) * time.Second
+			jobFunc = prices_fx.Run
+			args = []any{}
+		}
 	case "prices_stocks":
 		isEnabled = settingsData.Settings.Stocks.Enabled
 		if isEnabled && createIfEnabled {
@@ -423,6 +459,20 @@ func (m *Manager) checkJobSettingsAndCreate(name string, lastRun time.Time, crea
 			jobFunc = prices_stocks.Run
 			args = []any{}
 		}
+	case "reconciliation":
+		isEnabled = settingsData.Settings.Reconciliation.Enabled
+		if isEnabled && createIfEnabled {
+			interval = time.Duration(settingsData.Settings.Reconciliation.Interval) * time.Second
+			jobFunc = reconciliation.Run
+			args = []any{}
+		}
+	case "snapshots":
+		isEnabled = settingsData.Settings.Snapshots.Enabled
+		if isEnabled && createIfEnabled {
+			interval = time.Duration(settingsData.Settings.Snapshots.Interval) * time.Second
+			jobFunc = snapshots.Run
+			args = []any{}
+		}
 	default:
 		if createIfEnabled {
 			return false, fmt.Errorf("unknown job name: %s", name)
@@ -479,14 +529,19 @@ func (m *Manager) SyncJobsWithSettings() error {
 		"balances_bitcoin",
 		"balances_evm_chains",
 		"balances_solana",
+		"book_valuation",
 		"exchange_kraken",
 		"exchange_hyperliquid",
 		"exchange_lighter",
+		"exposure",
 		"grist_backup",
 		"pendle_markets",
 		"pendle_user_positions",
 		"prices_cryptocurrencies",
+		"prices_fx",
 		"prices_stocks",
+		"reconciliation",
+		"snapshots",
 	}
 
 	// Stop and remove disabled jobs
@@ -528,6 +583,10 @@ func (m *Manager) SyncJobsWithSettings() error {
 		}
 	}
 
+	if _, err := m.StartWebhookReceiver(); err != nil {
+		fmt.Printf("Warning: Could not start webhook receiver: %v\n", err)
+	}
+
 	return nil
 }
 
@@ -579,3 +638,123 @@ func (m *Manager) GetExecutions() []JobExecution {
 	copy(executions, m.executions)
 	return executions
 }
+
+// ListBackups returns the Grist backups available for restore, newest first
+func (m *Manager) ListBackups() ([]backup.Backup, error) {
+	settingsData, err := settings.LoadSettings()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load settings: %v", err)
+	}
+
+	if settingsData.Grist.BackupPath == "" {
+		return nil, errors.New("grist backup path not configured in settings")
+	}
+
+	return backup.List(backup.Location(settingsData.Grist.BackupPath))
+}
+
+// RestoreBackup decrypts and decompresses the named backup into a Grist document at outputPath
+func (m *Manager) RestoreBackup(name string, outputPath string) error {
+	settingsData, err := settings.LoadSettings()
+	if err != nil {
+		return fmt.Errorf("failed to load settings: %v", err)
+	}
+
+	if settingsData.Grist.BackupPath == "" {
+		return errors.New("grist backup path not configured in settings")
+	}
+
+	dir, _ := backup.Location(settingsData.Grist.BackupPath)
+	encryption := settingsData.Grist.BackupEncryption
+
+	return backup.Restore(filepath.Join(dir, filepath.Base(name)), outputPath, backup.Secret{
+		Passphrase: encryption.Passphrase,
+		KeyFile:    encryption.KeyFile,
+	})
+}
+
+// GenerateGainsReport writes the realized gains of year, matched with method and net of fees
+// when enabled in settings, to outputPath as csv or html
+func (m *Manager) GenerateGainsReport(year int, method string, format string, outputPath string) error {
+	settingsData, err := settings.LoadSettings()
+	if err != nil {
+		return fmt.Errorf("failed to load settings: %v", err)
+	}
+
+	g, err := grist.InitiateClient()
+	if err != nil {
+		return err
+	}
+
+	report, err := tax.Load(m.ctx, &g, year, method, settingsData.Settings.Tax.IncludeFees)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+
+	if err := tax.Write(f, report, format); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// StartWebhookReceiver (re)starts listening for Grist webhooks and triggers the jobs reading
+// the edited tables. It stops the receiver when disabled in settings and returns the
+// address it listens on otherwise.
+func (m *Manager) StartWebhookReceiver() (string, error) {
+	settingsData, err := settings.LoadSettings()
+	if err != nil {
+		return "", fmt.Errorf("failed to load settings: %v", err)
+	}
+
+	m.StopWebhookReceiver()
+
+	cfg := settingsData.Grist.Webhook
+	if !cfg.Enabled {
+		return "", nil
+	}
+
+	listener, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return "", fmt.Errorf("failed to listen for webhooks: %v", err)
+	}
+
+	receiver := webhook.NewReceiver(webhook.DefaultRoutes, cfg.Secret, time.Duration(cfg.Debounce)*time.Second, m.Trigger)
+	mux := http.NewServeMux()
+	mux.Handle(webhook.PathPrefix, receiver)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	m.mu.Lock()
+	m.webhookServer = server
+	m.webhookReceiver = receiver
+	m.mu.Unlock()
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("Warning: webhook receiver stopped: %v\n", err)
+		}
+	}()
+
+	return listener.Addr().String(), nil
+}
+
+// StopWebhookReceiver shuts the webhook receiver down and drops pending triggers
+func (m *Manager) StopWebhookReceiver() {
+	m.mu.Lock()
+	server, receiver := m.webhookServer, m.webhookReceiver
+	m.webhookServer, m.webhookReceiver = nil, nil
+	m.mu.Unlock()
+
+	if server == nil {
+		return
+	}
+
+	receiver.Close()
+	server.Close()
+}
@@ -0,0 +1,70 @@
+import React from "react";
+import { Input } from "./common";
+import { Aggregation } from "../types";
+
+interface AggregationInputProps {
+    value: Aggregation;
+    onChange: (aggregation: Aggregation) => void;
+    disabled?: boolean;
+}
+
+const selectStyle: React.CSSProperties = {
+    padding: '0.5rem 0.625rem',
+    backgroundColor: 'var(--bg-secondary)',
+    border: '1px solid var(--border)',
+    borderRadius: '4px',
+    color: 'var(--text-primary)',
+    fontSize: '0.875rem',
+    cursor: 'pointer'
+};
+
+const labelStyle: React.CSSProperties = { fontSize: '0.8125rem', fontWeight: '500', color: 'var(--text-secondary)' };
+
+export function AggregationInput({ value, onChange, disabled = false }: AggregationInputProps) {
+    return (
+        <div style={{ display: 'flex', gap: '8px', alignItems: 'flex-end' }}>
+            <div style={{ display: 'flex', flexDirection: 'column', gap: '0.25rem' }}>
+                <span style={labelStyle}>Aggregate fills</span>
+                <select
+                    value={value.strategy}
+                    onChange={e => onChange({ ...value, strategy: e.target.value as Aggregation['strategy'] })}
+                    style={selectStyle}
+                    disabled={disabled}
+                >
+                    <option value="minute">Same minute and price</option>
+                    <option value="order">By order</option>
+                    <option value="window">Time window</option>
+                    <option value="price">Price tolerance</option>
+                    <option value="none">Disabled</option>
+                </select>
+            </div>
+            {value.strategy === 'window' && (
+                <div style={{ display: 'flex', flexDirection: 'column', gap: '0.25rem' }}>
+                    <span style={labelStyle}>Window (s)</span>
+                    <Input
+                        type="number"
+                        min={1}
+                        value={value.window}
+                        onChange={e => onChange({ ...value, window: Number(e.target.value) })}
+                        style={{ width: '70px' }}
+                        disabled={disabled}
+                    />
+                </div>
+            )}
+            {value.strategy === 'price' && (
+                <div style={{ display: 'flex', flexDirection: 'column', gap: '0.25rem' }}>
+                    <span style={labelStyle}>Tolerance (%)</span>
+                    <Input
+                        type="number"
+                        min={0}
+                        step={0.01}
+                        value={value.tolerance * 100}
+                        onChange={e => onChange({ ...value, tolerance: Number(e.target.value) / 100 })}
+                        style={{ width: '70px' }}
+                        disabled={disabled}
+                    />
+                </div>
+            )}
+        </div>
+    );
+}
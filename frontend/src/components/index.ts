@@ -1,5 +1,6 @@
 export * from "./common";
 export { IntervalInput } from "./IntervalInput";
+export { AggregationInput } from "./AggregationInput";
 export { SettingRow } from "./SettingRow";
 export { InputField } from "./InputField";
 export { ErrorMessage } from "./ErrorMessage";
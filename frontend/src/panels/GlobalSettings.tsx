@@ -1,7 +1,7 @@
 import React, { useEffect, useState } from "react";
 import { loadSettings, saveSettings } from "../backend";
 import { Settings, UnifiedWallet } from "../types";
-import { Card, Input, Button, SettingRow, InputField, ErrorMessage, WalletRow, SaveButton, Switch, IntervalInput } from "../components";
+import { Card, Input, Button, SettingRow, InputField, ErrorMessage, WalletRow, SaveButton, Switch, IntervalInput, AggregationInput } from "../components";
 
 export default function GlobalSettings() {
   const [settings, setSettings] = useState<Settings | null>(null);
@@ -243,6 +243,28 @@ export default function GlobalSettings() {
             type="password"
             style={{ height: '35px', flex: 1 }}
           />
+          <AggregationInput
+            value={settings.exchanges.kraken.aggregation}
+            onChange={(aggregation) => setSettings({ ...settings, exchanges: { ...settings.exchanges, kraken: { ...settings.exchanges.kraken, aggregation } } })}
+          />
+        </SettingRow>
+
+        <SettingRow>
+          <InputField
+            label="Futures API Key"
+            value={settings.exchanges.kraken.futuresApiKey}
+            onChange={(value) => setSettings({ ...settings, exchanges: { ...settings.exchanges, kraken: { ...settings.exchanges.kraken, futuresApiKey: value } } })}
+            placeholder="Kraken Futures API Key (optional)"
+            style={{ height: '35px', flex: 1 }}
+          />
+          <InputField
+            label="Futures API Secret"
+            value={settings.exchanges.kraken.futuresApiSecret}
+            onChange={(value) => setSettings({ ...settings, exchanges: { ...settings.exchanges, kraken: { ...settings.exchanges.kraken, futuresApiSecret: value } } })}
+            placeholder="Kraken Futures API Secret (optional)"
+            type="password"
+            style={{ height: '35px', flex: 1 }}
+          />
         </SettingRow>
 
         <ErrorMessage
@@ -260,6 +282,10 @@ export default function GlobalSettings() {
             value={settings.exchanges.hyperliquid.interval}
             onChange={(interval) => updateInterval('exchanges', 'hyperliquid', interval)}
           />
+          <AggregationInput
+            value={settings.exchanges.hyperliquid.aggregation}
+            onChange={(aggregation) => setSettings({ ...settings, exchanges: { ...settings.exchanges, hyperliquid: { ...settings.exchanges.hyperliquid, aggregation } } })}
+          />
         </SettingRow>
 
         <SettingRow>
@@ -422,6 +448,109 @@ export default function GlobalSettings() {
           message="Enter TwelveData API Key to enable stocks"
           show={showStocksMessage}
         />
+
+        <SettingRow>
+          <Switch
+            checked={settings.settings.fx.enabled}
+            onChange={(enabled) => toggleEnabled('settings', 'fx', enabled)}
+            label="FX Rates"
+          />
+          <IntervalInput
+            value={settings.settings.fx.interval}
+            onChange={(interval) => updateInterval('settings', 'fx', interval)}
+          />
+          <InputField
+            label="Reporting Currency"
+            value={settings.settings.fx.reportingCurrency}
+            onChange={(value) => setSettings({ ...settings, settings: { ...settings.settings, fx: { ...settings.settings.fx, reportingCurrency: value.toUpperCase() } } })}
+            placeholder="USD"
+            style={{ height: '35px', flex: 1 }}
+          />
+          <InputField
+            label="Quote Currencies"
+            value={(settings.settings.fx.currencies ?? []).join(", ")}
+            onChange={(value) => setSettings({ ...settings, settings: { ...settings.settings, fx: { ...settings.settings.fx, currencies: value.toUpperCase().split(",").map((c) => c.trim()) } } })}
+            placeholder="EUR, SGD"
+            style={{ height: '35px', flex: 2 }}
+          />
+        </SettingRow>
+
+        <SettingRow>
+          <Switch
+            checked={settings.settings.valuation.enabled}
+            onChange={(enabled) => toggleEnabled('settings', 'valuation', enabled)}
+            label="Book Valuation"
+          />
+          <IntervalInput
+            value={settings.settings.valuation.interval}
+            onChange={(interval) => updateInterval('settings', 'valuation', interval)}
+          />
+        </SettingRow>
+      </Card>
+
+      <Card title="History">
+        <SettingRow>
+          <Switch
+            checked={settings.settings.snapshots.enabled}
+            onChange={(enabled) => toggleEnabled('settings', 'snapshots', enabled)}
+            label="Snapshots"
+          />
+          <IntervalInput
+            value={settings.settings.snapshots.interval}
+            onChange={(interval) => updateInterval('settings', 'snapshots', interval)}
+          />
+        </SettingRow>
+
+        <SettingRow>
+          <Switch
+            checked={settings.settings.reconciliation.enabled}
+            onChange={(enabled) => toggleEnabled('settings', 'reconciliation', enabled)}
+            label="Reconciliation"
+          />
+          <IntervalInput
+            value={settings.settings.reconciliation.interval}
+            onChange={(interval) => updateInterval('settings', 'reconciliation', interval)}
+          />
+        </SettingRow>
+
+        <SettingRow>
+          <Switch
+            checked={settings.settings.exposure.enabled}
+            onChange={(enabled) => toggleEnabled('settings', 'exposure', enabled)}
+            label="Consolidated exposure"
+          />
+          <IntervalInput
+            value={settings.settings.exposure.interval}
+            onChange={(interval) => updateInterval('settings', 'exposure', interval)}
+          />
+        </SettingRow>
+
+        <SettingRow>
+          <label style={{ color: 'var(--text-primary)', fontSize: '0.875rem' }}>Tax lot matching</label>
+          <select
+            value={settings.settings.tax.lotMethod}
+            onChange={(e) => setSettings({ ...settings, settings: { ...settings.settings, tax: { ...settings.settings.tax, lotMethod: e.target.value as Settings['settings']['tax']['lotMethod'] } } })}
+            style={{
+              padding: '0.5rem 0.625rem',
+              backgroundColor: 'var(--bg-secondary)',
+              border: '1px solid var(--border)',
+              borderRadius: '4px',
+              color: 'var(--text-primary)',
+              fontSize: '0.875rem',
+              cursor: 'pointer'
+            }}
+          >
+            <option value="FIFO">FIFO</option>
+            <option value="LIFO">LIFO</option>
+            <option value="HIFO">HIFO</option>
+            <option value="SpecificID">Specific ID</option>
+          </select>
+          <Switch
+            checked={settings.settings.tax.includeFees}
+            onChange={(includeFees) => setSettings({ ...settings, settings: { ...settings.settings, tax: { ...settings.settings.tax, includeFees } } })}
+            label="Net of fees"
+          />
+        </SettingRow>
       </Card>
 
 
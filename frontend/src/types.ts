@@ -9,6 +9,13 @@ export type UnifiedWallet = {
   };
 };
 
+// how the fills of an exchange are merged into trades
+export type Aggregation = {
+  strategy: 'minute' | 'order' | 'window' | 'price' | 'none';
+  window: number; // seconds
+  tolerance: number; // relative price difference
+};
+
 export type Settings = {
   wallets: UnifiedWallet[];
 
@@ -18,8 +25,8 @@ export type Settings = {
   };
 
   exchanges: {
-    kraken: { enabled: boolean; interval: number; apiKey: string; apiSecret: string };
-    hyperliquid: { enabled: boolean; interval: number };
+    kraken: { enabled: boolean; interval: number; apiKey: string; apiSecret: string; futuresApiKey: string; futuresApiSecret: string; aggregation: Aggregation };
+    hyperliquid: { enabled: boolean; interval: number; aggregation: Aggregation };
     lighter: { enabled: boolean; interval: number };
   };
 
@@ -36,11 +43,21 @@ export type Settings = {
     apiKey: string;
     documentId: string;
     backupPath: string;
+    backupCompress: boolean;
+    backupRetention: { daily: number; weekly: number; monthly: number };
+    backupEncryption: { enabled: boolean; passphrase: string; keyFile: string };
+    webhook: { enabled: boolean; address: string; secret: string; debounce: number };
   };
 
   settings: {
     prices: { enabled: boolean; interval: number; coingeckoApiKey: string };
     stocks: { enabled: boolean; interval: number; twelveDataApiKey: string };
+    snapshots: { enabled: boolean; interval: number; retention: { all: number; daily: number; weekly: number } };
+    tax: { lotMethod: "FIFO" | "LIFO" | "HIFO" | "SpecificID"; includeFees: boolean };
+    fx: { enabled: boolean; interval: number; reportingCurrency: string; currencies: string[] };
+    valuation: { enabled: boolean; interval: number };
+    reconciliation: { enabled: boolean; interval: number; tolerance: number; minValue: number };
+    exposure: { enabled: boolean; interval: number; aliases: Record<string, string> };
   };
 };
 
@@ -54,8 +71,8 @@ export const defaultSettings: Settings = {
   },
 
   exchanges: {
-    kraken: { enabled: false, interval: 600, apiKey: "", apiSecret: "" }, // 10 minutes
-    hyperliquid: { enabled: false, interval: 300 }, // 5 minutes
+    kraken: { enabled: false, interval: 600, apiKey: "", apiSecret: "", futuresApiKey: "", futuresApiSecret: "", aggregation: { strategy: "minute", window: 60, tolerance: 0.001 } }, // 10 minutes
+    hyperliquid: { enabled: false, interval: 300, aggregation: { strategy: "minute", window: 60, tolerance: 0.001 } }, // 5 minutes
     lighter: { enabled: false, interval: 300 }, // 5 minutes
   },
 
@@ -72,11 +89,21 @@ export const defaultSettings: Settings = {
     apiKey: "",
     documentId: "",
     backupPath: "",
+    backupCompress: true,
+    backupRetention: { daily: 7, weekly: 4, monthly: 12 },
+    backupEncryption: { enabled: false, passphrase: "", keyFile: "" },
+    webhook: { enabled: false, address: "127.0.0.1:8787", secret: "", debounce: 5 },
   },
 
   settings: {
     prices: { enabled: false, interval: 600, coingeckoApiKey: "" }, // 10 minutes
     stocks: { enabled: false, interval: 600, twelveDataApiKey: "" }, // 10 minutes
+    snapshots: { enabled: false, interval: 86400, retention: { all: 7, daily: 90, weekly: 52 } }, // 1 day
+    tax: { lotMethod: "FIFO", includeFees: false },
+    fx: { enabled: false, interval: 86400, reportingCurrency: "USD", currencies: ["EUR", "SGD"] }, // 1 day
+    valuation: { enabled: false, interval: 600 }, // 10 minutes
+    reconciliation: { enabled: false, interval: 21600, tolerance: 0.001, minValue: 1 }, // 6 hours
+    exposure: { enabled: false, interval: 3600, aliases: { WETH: "ETH", WBTC: "BTC", CBBTC: "BTC", XBT: "BTC", WSOL: "SOL" } }, // 1 hour
   },
 };
 
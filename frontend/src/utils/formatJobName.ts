@@ -4,14 +4,19 @@ export function formatJobName(name: string): string {
     'balances_bitcoin': 'Bitcoin Balances',
     'balances_evm_chains': 'EVM Balances',
     'balances_solana': 'Solana Balances',
+    'book_valuation': 'Book Valuation',
     'exchange_hyperliquid': 'Hyperliquid',
     'exchange_kraken': 'Kraken',
     'exchange_lighter': 'Lighter',
+    'exposure': 'Exposure',
     'grist_backup': 'Grist Backup',
     'pendle_markets': 'Pendle Markets',
     'pendle_user_positions': 'Pendle User Positions',
     'prices_cryptocurrencies': 'Cryptocurrencies Prices',
-    'prices_stocks': 'Stocks Prices'
+    'prices_fx': 'FX Rates',
+    'prices_stocks': 'Stocks Prices',
+    'reconciliation': 'Reconciliation',
+    'snapshots': 'Snapshots'
   };
 
   // Return custom name if available, otherwise use default formatting
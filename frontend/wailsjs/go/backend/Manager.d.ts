@@ -3,6 +3,7 @@
 import {time} from '../models';
 import {backend} from '../models';
 import {context} from '../models';
+import {backup} from '../models';
 
 export function AddAndStart(arg1:string,arg2:time.Duration,arg3:backend.JobRunnerFunc,arg4:Array<any>):Promise<void>;
 
@@ -10,14 +11,20 @@ export function AddAndStartWithLastRun(arg1:string,arg2:time.Duration,arg3:backe
 
 export function ClearError(arg1:string):Promise<void>;
 
+export function GenerateGainsReport(arg1:number,arg2:string,arg3:string,arg4:string):Promise<void>;
+
 export function GetExecutions():Promise<Array<backend.JobExecution>>;
 
 export function Jobs():Promise<Array<backend.JobState>>;
 
+export function ListBackups():Promise<Array<backup.Backup>>;
+
 export function LoadSettings():Promise<string>;
 
 export function Pause(arg1:string):Promise<void>;
 
+export function RestoreBackup(arg1:string,arg2:string):Promise<void>;
+
 export function Resume(arg1:string):Promise<void>;
 
 export function SaveSettings(arg1:string):Promise<void>;
@@ -26,10 +33,14 @@ export function SetEventEmissions(arg1:boolean):Promise<void>;
 
 export function SetInterval(arg1:string,arg2:number):Promise<void>;
 
+export function StartWebhookReceiver():Promise<string>;
+
 export function Startup(arg1:context.Context):Promise<void>;
 
 export function StopAndRemove(arg1:string):Promise<void>;
 
+export function StopWebhookReceiver():Promise<void>;
+
 export function SyncJobsWithSettings():Promise<void>;
 
 export function SyncJobsWithSettingsPublic():Promise<void>;
@@ -14,6 +14,10 @@ export function ClearError(arg1) {
   return window['go']['backend']['Manager']['ClearError'](arg1);
 }
 
+export function GenerateGainsReport(arg1, arg2, arg3, arg4) {
+  return window['go']['backend']['Manager']['GenerateGainsReport'](arg1, arg2, arg3, arg4);
+}
+
 export function GetExecutions() {
   return window['go']['backend']['Manager']['GetExecutions']();
 }
@@ -22,6 +26,10 @@ export function Jobs() {
   return window['go']['backend']['Manager']['Jobs']();
 }
 
+export function ListBackups() {
+  return window['go']['backend']['Manager']['ListBackups']();
+}
+
 export function LoadSettings() {
   return window['go']['backend']['Manager']['LoadSettings']();
 }
@@ -30,6 +38,10 @@ export function Pause(arg1) {
   return window['go']['backend']['Manager']['Pause'](arg1);
 }
 
+export function RestoreBackup(arg1, arg2) {
+  return window['go']['backend']['Manager']['RestoreBackup'](arg1, arg2);
+}
+
 export function Resume(arg1) {
   return window['go']['backend']['Manager']['Resume'](arg1);
 }
@@ -46,6 +58,10 @@ export function SetInterval(arg1, arg2) {
   return window['go']['backend']['Manager']['SetInterval'](arg1, arg2);
 }
 
+export function StartWebhookReceiver() {
+  return window['go']['backend']['Manager']['StartWebhookReceiver']();
+}
+
 export function Startup(arg1) {
   return window['go']['backend']['Manager']['Startup'](arg1);
 }
@@ -54,6 +70,10 @@ export function StopAndRemove(arg1) {
   return window['go']['backend']['Manager']['StopAndRemove'](arg1);
 }
 
+export function StopWebhookReceiver() {
+  return window['go']['backend']['Manager']['StopWebhookReceiver']();
+}
+
 export function SyncJobsWithSettings() {
   return window['go']['backend']['Manager']['SyncJobsWithSettings']();
 }
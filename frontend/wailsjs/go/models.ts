@@ -106,6 +106,33 @@ export namespace backend {
 
 }
 
+export namespace backup {
+	
+	export class Backup {
+	    name: string;
+	    path: string;
+	    time: number;
+	    size: number;
+	    compressed: boolean;
+	    encrypted: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new Backup(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.path = source["path"];
+	        this.time = source["time"];
+	        this.size = source["size"];
+	        this.compressed = source["compressed"];
+	        this.encrypted = source["encrypted"];
+	    }
+	}
+
+}
+
 export namespace time {
 	
 	export class Time {